@@ -0,0 +1,181 @@
+// Package auth 服务端的认证和鉴权, gRPC 和 rpc 共用一套 Authenticator 和 Rules
+// 认证: 静态 token, 本地验证的 HMAC JWT, mTLS 的对端证书
+// 鉴权: 按照服务和方法配置允许的角色或者调用方
+// 认证通过之后的 Principal 放在 ctx 里面, 服务的方法用 FromContext 取出来
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUnauthenticated 认证失败, gRPC 对应 codes.Unauthenticated, rpc 对应 rpc.CodeUnauthenticated
+	ErrUnauthenticated = errors.New("micro: 认证失败")
+	// ErrPermissionDenied 认证通过了, 但是没有权限调用这个方法
+	ErrPermissionDenied = errors.New("micro: 没有权限")
+
+	errNoCredentials = errors.New("micro: 没有认证信息")
+)
+
+// MetaAuthorization 传递 token 的元数据的 key, 值的格式是 "Bearer <token>"
+const MetaAuthorization = "authorization"
+
+const bearerPrefix = "bearer "
+
+// Principal 认证通过的调用方
+type Principal struct {
+	// Subject 调用方的身份, 比如说用户 ID, 或者证书里面的服务名
+	Subject string
+	// Roles 鉴权的时候按照角色匹配
+	Roles []string
+	// Method 认证方式, 比如说 token, jwt, mtls
+	Method string
+	// Claims 认证方式提供的其它信息, 比如说 JWT 里面的全部字段
+	Claims map[string]any
+}
+
+func (p *Principal) hasRole(roles []string) bool {
+	for _, want := range roles {
+		for _, role := range p.Roles {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Credentials 拦截器从请求里面取出来的认证信息
+type Credentials struct {
+	// Token 去掉了 Bearer 前缀的 token
+	Token string
+	// PeerCerts 验证过的对端证书链, 第一个是对端自己的证书
+	// 只有 TLS 握手的时候验证了客户端证书才有, 没有验证过的证书不会放进来
+	PeerCerts []*x509.Certificate
+}
+
+// Authenticator 认证失败返回 error, 拦截器会把它包装成 ErrUnauthenticated
+type Authenticator interface {
+	Authenticate(ctx context.Context, cred Credentials) (*Principal, error)
+}
+
+type AuthenticatorFunc func(ctx context.Context, cred Credentials) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, cred Credentials) (*Principal, error) {
+	return f(ctx, cred)
+}
+
+// Any 按照顺序尝试, 第一个成功的结果就是调用方, 都失败了返回最后一个错误
+// 比如说服务之间用 mTLS, 用户用 JWT: Any(mtls, jwt)
+func Any(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, cred Credentials) (*Principal, error) {
+		err := errNoCredentials
+		for _, a := range authenticators {
+			var p *Principal
+			p, err = a.Authenticate(ctx, cred)
+			if err == nil {
+				return p, nil
+			}
+		}
+		return nil, err
+	})
+}
+
+// Rules 按照服务和方法配置鉴权规则
+type Rules struct {
+	// Default 没有单独配置的方法使用的规则, 为 nil 就是只要认证通过就可以调用
+	Default *Rule
+	// Rules key 是服务名, 或者服务名/方法名, 方法的配置优先
+	Rules map[string]*Rule
+}
+
+// Rule 找到方法的鉴权规则, 可能返回 nil
+func (r Rules) Rule(service, method string) *Rule {
+	if rule, ok := r.Rules[service+"/"+method]; ok {
+		return rule
+	}
+	if rule, ok := r.Rules[service]; ok {
+		return rule
+	}
+	return r.Default
+}
+
+// Rule 满足 Roles 和 Subjects 里面的一个就可以调用, 两个都为空代表只要认证通过就可以
+type Rule struct {
+	// Public 不需要认证, 比如说健康检查, 带了认证信息的话调用方还是会放到 ctx 里面
+	Public   bool
+	Roles    []string
+	Subjects []string
+}
+
+func (r *Rule) allow(p *Principal) bool {
+	if r == nil || r.Public || (len(r.Roles) == 0 && len(r.Subjects) == 0) {
+		return true
+	}
+	if p.hasRole(r.Roles) {
+		return true
+	}
+	for _, subject := range r.Subjects {
+		if subject == p.Subject {
+			return true
+		}
+	}
+	return false
+}
+
+// check 认证和鉴权, 返回带上调用方的 ctx
+// 返回的 error 是 ErrUnauthenticated 或者 ErrPermissionDenied, 拦截器据此转成错误码
+func check(ctx context.Context, a Authenticator, rules Rules, service, method string, cred Credentials) (context.Context, error) {
+	rule := rules.Rule(service, method)
+	var p *Principal
+	var err error
+	if cred.Token == "" && len(cred.PeerCerts) == 0 {
+		err = errNoCredentials
+	} else {
+		p, err = a.Authenticate(ctx, cred)
+	}
+	if err != nil {
+		if rule != nil && rule.Public {
+			return ctx, nil
+		}
+		return ctx, fmt.Errorf("%w: %s", ErrUnauthenticated, err.Error())
+	}
+	if !rule.allow(p) {
+		return ctx, fmt.Errorf("%w: %s 不能调用 %s/%s", ErrPermissionDenied, p.Subject, service, method)
+	}
+	return NewContext(ctx, p), nil
+}
+
+// parseBearer 取出 "Bearer <token>" 里面的 token, 格式不对就返回空字符串
+func parseBearer(val string) string {
+	if len(val) < len(bearerPrefix) || !strings.EqualFold(val[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(val[len(bearerPrefix):])
+}
+
+type principalKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext 取出认证通过的调用方, Rule.Public 的方法可能没有
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// TokenSource 客户端拦截器每次调用之前获取 token
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken 一直使用同一个 token
+func StaticToken(token string) TokenSource {
+	return func(ctx context.Context) (string, error) {
+		return token, nil
+	}
+}
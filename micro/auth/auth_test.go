@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_check(t *testing.T) {
+	a := NewTokenAuthenticator(map[string]*Principal{
+		"admin-token": {Subject: "tom", Roles: []string{"admin"}},
+		"user-token":  {Subject: "jerry", Roles: []string{"user"}},
+	})
+	rules := Rules{
+		Rules: map[string]*Rule{
+			"user-service":             {Roles: []string{"admin", "user"}},
+			"user-service/Delete":      {Roles: []string{"admin"}},
+			"user-service/GetByID":     {Subjects: []string{"jerry"}},
+			"grpc.health.v1.Health":    {Public: true},
+			"order-service/CreateTest": {Roles: []string{"tester"}, Subjects: []string{"tom"}},
+		},
+	}
+	cases := []struct {
+		name    string
+		service string
+		method  string
+		token   string
+
+		wantSubject string
+		wantErr     error
+	}{
+		{
+			name:        "default",
+			service:     "order-service",
+			method:      "Get",
+			token:       "user-token",
+			wantSubject: "jerry",
+		},
+		{
+			name:    "no token",
+			service: "order-service",
+			method:  "Get",
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "invalid token",
+			service: "order-service",
+			method:  "Get",
+			token:   "bad-token",
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:        "service rule",
+			service:     "user-service",
+			method:      "Update",
+			token:       "user-token",
+			wantSubject: "jerry",
+		},
+		{
+			// 方法的配置优先
+			name:    "method rule",
+			service: "user-service",
+			method:  "Delete",
+			token:   "user-token",
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name:        "subject",
+			service:     "user-service",
+			method:      "GetByID",
+			token:       "user-token",
+			wantSubject: "jerry",
+		},
+		{
+			name:    "subject denied",
+			service: "user-service",
+			method:  "GetByID",
+			token:   "admin-token",
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name:        "roles or subjects",
+			service:     "order-service",
+			method:      "CreateTest",
+			token:       "admin-token",
+			wantSubject: "tom",
+		},
+		{
+			name:    "public",
+			service: "grpc.health.v1.Health",
+			method:  "Check",
+		},
+		{
+			// 公开的方法带了正确的 token 也能拿到调用方
+			name:        "public with token",
+			service:     "grpc.health.v1.Health",
+			method:      "Check",
+			token:       "admin-token",
+			wantSubject: "tom",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, err := check(context.Background(), a, rules, c.service, c.method, Credentials{Token: c.token})
+			assert.ErrorIs(t, err, c.wantErr)
+			if err != nil {
+				return
+			}
+			p, ok := FromContext(ctx)
+			if c.wantSubject == "" {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, c.wantSubject, p.Subject)
+			assert.Equal(t, "token", p.Method)
+		})
+	}
+}
+
+func TestAny(t *testing.T) {
+	mtls := NewMTLSAuthenticator()
+	token := NewTokenAuthenticator(map[string]*Principal{"token": {Subject: "tom"}})
+	cases := []struct {
+		name string
+		cred Credentials
+
+		wantMethod string
+		wantErr    error
+	}{
+		{
+			name:       "first",
+			cred:       Credentials{Token: "token", PeerCerts: []*x509.Certificate{{}}},
+			wantMethod: "mtls",
+		},
+		{
+			name:       "second",
+			cred:       Credentials{Token: "token"},
+			wantMethod: "token",
+		},
+		{
+			name:    "all failed",
+			cred:    Credentials{Token: "bad"},
+			wantErr: errInvalidToken,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := Any(mtls, token).Authenticate(context.Background(), c.cred)
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.wantMethod, p.Method)
+		})
+	}
+	_, err := Any().Authenticate(context.Background(), Credentials{})
+	assert.True(t, errors.Is(err, errNoCredentials))
+}
+
+func Test_parseBearer(t *testing.T) {
+	cases := []struct {
+		name string
+		val  string
+		want string
+	}{
+		{name: "bearer", val: "Bearer abc", want: "abc"},
+		{name: "lower case", val: "bearer abc", want: "abc"},
+		{name: "basic", val: "Basic abc"},
+		{name: "empty", val: ""},
+		{name: "no token", val: "Bearer"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, parseBearer(c.val))
+		})
+	}
+}
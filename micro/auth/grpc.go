@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/startdusk/go-libs/micro/retry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor gRPC 的认证和鉴权, 服务名是 proto 里面的全名, 比如 package.UserService
+// 比如说 micro.ServerWithGRPCOptions(grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(a, rules)))
+// 认证失败返回 codes.Unauthenticated, 没有权限返回 codes.PermissionDenied
+func UnaryServerInterceptor(a Authenticator, rules Rules) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := checkGRPC(ctx, a, rules, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 和 UnaryServerInterceptor 一样, 只在流开始的时候检查一次
+func StreamServerInterceptor(a Authenticator, rules Rules) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		ctx, err := checkGRPC(ss.Context(), a, rules, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func checkGRPC(ctx context.Context, a Authenticator, rules Rules, fullMethod string) (context.Context, error) {
+	var cred Credentials
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(MetaAuthorization); len(vals) > 0 {
+			cred.Token = parseBearer(vals[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			cred.PeerCerts = info.State.VerifiedChains[0]
+		}
+	}
+	service, method := retry.SplitMethod(fullMethod)
+	ctx, err := check(ctx, a, rules, service, method, cred)
+	if err == nil {
+		return ctx, nil
+	}
+	if errors.Is(err, ErrPermissionDenied) {
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return ctx, status.Error(codes.Unauthenticated, err.Error())
+}
+
+// serverStream 替换掉 Context, 服务的方法才能拿到调用方
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryClientInterceptor 每次调用之前从 source 获取 token, 放到 authorization 元数据里面
+func UnaryClientInterceptor(source TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := outgoingGRPC(ctx, source)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func StreamClientInterceptor(source TokenSource) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := outgoingGRPC(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func outgoingGRPC(ctx context.Context, source TokenSource) (context.Context, error) {
+	token, err := source(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetaAuthorization, "Bearer "+token), nil
+}
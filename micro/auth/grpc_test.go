@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/example/proto/gen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCInterceptors(t *testing.T) {
+	ca := newTestCA(t)
+	jwtAuth := NewJWTAuthenticator([]byte("secret"))
+	userToken, err := jwtAuth.Sign(map[string]any{"sub": "tom", "roles": "user", "exp": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	guestToken, err := jwtAuth.Sign(map[string]any{"sub": "jerry", "roles": "guest", "exp": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+
+	a := Any(NewMTLSAuthenticator(), jwtAuth)
+	rules := Rules{Rules: map[string]*Rule{
+		"test.UserService":            {Roles: []string{"internal", "user"}},
+		"grpc.health.v1.Health/Check": {Public: true},
+		"grpc.health.v1.Health/Watch": {Roles: []string{"internal"}},
+	}}
+	serverCfg := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "user-service", false)},
+		ClientCAs:    ca.pool,
+		// 用 token 的客户端没有证书
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverCfg)),
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(a, rules)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(a, rules)))
+	us := &principalUserService{}
+	gen.RegisterUserServiceServer(server, us)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	clientCert := ca.issue(t, "order-service", true, "internal")
+	cases := []struct {
+		name string
+		cert *tls.Certificate
+		opts []grpc.DialOption
+
+		wantSubject   string
+		wantCode      codes.Code
+		wantWatchCode codes.Code
+	}{
+		{
+			name:          "mtls",
+			cert:          &clientCert,
+			wantSubject:   "order-service",
+			wantWatchCode: codes.OK,
+		},
+		{
+			name: "jwt",
+			opts: []grpc.DialOption{
+				grpc.WithUnaryInterceptor(UnaryClientInterceptor(StaticToken(userToken))),
+				grpc.WithStreamInterceptor(StreamClientInterceptor(StaticToken(userToken))),
+			},
+			wantSubject:   "tom",
+			wantWatchCode: codes.PermissionDenied,
+		},
+		{
+			name: "permission denied",
+			opts: []grpc.DialOption{
+				grpc.WithUnaryInterceptor(UnaryClientInterceptor(StaticToken(guestToken))),
+				grpc.WithStreamInterceptor(StreamClientInterceptor(StaticToken(guestToken))),
+			},
+			wantCode:      codes.PermissionDenied,
+			wantWatchCode: codes.PermissionDenied,
+		},
+		{
+			name:          "no credentials",
+			wantCode:      codes.Unauthenticated,
+			wantWatchCode: codes.Unauthenticated,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientCfg := &tls.Config{RootCAs: ca.pool, ServerName: "user-service", MinVersion: tls.VersionTLS12}
+			if c.cert != nil {
+				clientCfg.Certificates = []tls.Certificate{*c.cert}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			conn, err := grpc.DialContext(ctx, lis.Addr().String(),
+				append(c.opts, grpc.WithTransportCredentials(credentials.NewTLS(clientCfg)))...)
+			require.NoError(t, err)
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			_, err = gen.NewUserServiceClient(conn).GetById(ctx, &gen.GetByIdReq{Id: 12})
+			assert.Equal(t, c.wantCode, status.Code(err), "%v", err)
+			if err == nil {
+				assert.Equal(t, c.wantSubject, us.last())
+			}
+
+			// 公开的方法不需要认证
+			resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+			// 流式调用也要认证
+			stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			assert.Equal(t, c.wantWatchCode, status.Code(err), "%v", err)
+		})
+	}
+}
+
+// principalUserService 记录最后一个调用方
+type principalUserService struct {
+	gen.UnimplementedUserServiceServer
+	mutex   sync.Mutex
+	subject string
+}
+
+func (u *principalUserService) GetById(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error) {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Internal, "no principal")
+	}
+	u.mutex.Lock()
+	u.subject = p.Subject
+	u.mutex.Unlock()
+	return &gen.GetByIdResp{User: &gen.User{Id: req.Id}}, nil
+}
+
+func (u *principalUserService) last() string {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.subject
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	errTokenExpired = errors.New("micro: token 已经过期或者还没有生效")
+	errTokenClaims  = errors.New("micro: token 的 iss 或者 aud 不正确")
+	errNoSubject    = errors.New("micro: token 没有 sub")
+)
+
+// JWTAuthenticator 用 HMAC 签名的 JWT 认证, 在本地验证, 不需要访问认证中心
+// token 必须有 sub 和 exp, sub 就是调用方的身份
+type JWTAuthenticator struct {
+	secret []byte
+	method *jwt.SigningMethodHMAC
+	// issuer 和 audience 为空的时候不检查
+	issuer   string
+	audience string
+	// leeway 容忍服务器之间的时钟误差
+	leeway time.Duration
+	// rolesClaim 存放角色的字段, 可以是字符串数组, 也可以是空格分隔的字符串
+	rolesClaim string
+	now        func() time.Time
+}
+
+type JWTOption func(a *JWTAuthenticator)
+
+// JWTWithMethod 签名算法, 默认是 jwt.SigningMethodHS256, 只接受这一种算法, 防止被换成 none 之类的算法
+func JWTWithMethod(method *jwt.SigningMethodHMAC) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.method = method
+	}
+}
+
+// JWTWithIssuer 要求 token 的 iss 是 issuer
+func JWTWithIssuer(issuer string) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.issuer = issuer
+	}
+}
+
+// JWTWithAudience 要求 token 的 aud 包含 audience
+func JWTWithAudience(audience string) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.audience = audience
+	}
+}
+
+// JWTWithLeeway 检查 exp 和 nbf 的时候容忍的时钟误差, 默认是 0
+func JWTWithLeeway(leeway time.Duration) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.leeway = leeway
+	}
+}
+
+// JWTWithRolesClaim 存放角色的字段, 默认是 roles
+func JWTWithRolesClaim(claim string) JWTOption {
+	return func(a *JWTAuthenticator) {
+		a.rolesClaim = claim
+	}
+}
+
+func NewJWTAuthenticator(secret []byte, opts ...JWTOption) *JWTAuthenticator {
+	a := &JWTAuthenticator{
+		secret:     secret,
+		method:     jwt.SigningMethodHS256,
+		rolesClaim: "roles",
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Sign 用同一个密钥签发 token, 签发方和验证方是同一个团队的时候用
+func (a *JWTAuthenticator) Sign(claims map[string]any) (string, error) {
+	return jwt.NewWithClaims(a.method, jwt.MapClaims(claims)).SignedString(a.secret)
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, cred Credentials) (*Principal, error) {
+	if cred.Token == "" {
+		return nil, errNoCredentials
+	}
+	// 时间相关的检查自己做, 这样才能加上 leeway
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{a.method.Alg()}), jwt.WithoutClaimsValidation())
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(cred.Token, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	if !claims.VerifyExpiresAt(now.Add(-a.leeway).Unix(), true) ||
+		!claims.VerifyNotBefore(now.Add(a.leeway).Unix(), false) {
+		return nil, errTokenExpired
+	}
+	if (a.issuer != "" && !claims.VerifyIssuer(a.issuer, true)) ||
+		(a.audience != "" && !claims.VerifyAudience(a.audience, true)) {
+		return nil, errTokenClaims
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errNoSubject
+	}
+	return &Principal{
+		Subject: subject,
+		Roles:   a.roles(claims[a.rolesClaim]),
+		Method:  "jwt",
+		Claims:  claims,
+	}, nil
+}
+
+func (a *JWTAuthenticator) roles(val any) []string {
+	switch v := val.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		roles := make([]string, 0, len(v))
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
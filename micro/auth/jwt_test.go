@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTAuthenticator_Authenticate(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	a := NewJWTAuthenticator(secret,
+		JWTWithIssuer("auth-service"),
+		JWTWithAudience("user-service"),
+		JWTWithLeeway(time.Minute))
+	a.now = func() time.Time {
+		return now
+	}
+	claims := func(modify func(claims map[string]any)) map[string]any {
+		res := map[string]any{
+			"sub":   "tom",
+			"iss":   "auth-service",
+			"aud":   []string{"order-service", "user-service"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"roles": []string{"admin", "user"},
+		}
+		if modify != nil {
+			modify(res)
+		}
+		return res
+	}
+	sign := func(method jwt.SigningMethod, key any, claims map[string]any) string {
+		token, err := jwt.NewWithClaims(method, jwt.MapClaims(claims)).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+
+	cases := []struct {
+		name  string
+		token string
+
+		wantRoles []string
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			token:     sign(jwt.SigningMethodHS256, secret, claims(nil)),
+			wantRoles: []string{"admin", "user"},
+		},
+		{
+			name: "space separated roles",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				claims["roles"] = "admin user"
+			})),
+			wantRoles: []string{"admin", "user"},
+		},
+		{
+			// 在时钟误差的范围内
+			name: "leeway",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				claims["exp"] = now.Add(-30 * time.Second).Unix()
+			})),
+			wantRoles: []string{"admin", "user"},
+		},
+		{
+			name: "expired",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				claims["exp"] = now.Add(-2 * time.Minute).Unix()
+			})),
+			wantErr: true,
+		},
+		{
+			name: "no exp",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				delete(claims, "exp")
+			})),
+			wantErr: true,
+		},
+		{
+			name: "not before",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				claims["nbf"] = now.Add(2 * time.Minute).Unix()
+			})),
+			wantErr: true,
+		},
+		{
+			name: "issuer",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				claims["iss"] = "evil"
+			})),
+			wantErr: true,
+		},
+		{
+			name: "audience",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				claims["aud"] = "order-service"
+			})),
+			wantErr: true,
+		},
+		{
+			name: "no subject",
+			token: sign(jwt.SigningMethodHS256, secret, claims(func(claims map[string]any) {
+				delete(claims, "sub")
+			})),
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			token:   sign(jwt.SigningMethodHS256, []byte("guess"), claims(nil)),
+			wantErr: true,
+		},
+		{
+			// 只接受配置的算法
+			name:    "wrong method",
+			token:   sign(jwt.SigningMethodHS512, secret, claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "none",
+			token:   sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil)),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "abc.def",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := a.Authenticate(context.Background(), Credentials{Token: c.token})
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "tom", p.Subject)
+			assert.Equal(t, "jwt", p.Method)
+			assert.Equal(t, c.wantRoles, p.Roles)
+			assert.Equal(t, "auth-service", p.Claims["iss"])
+		})
+	}
+}
+
+func TestJWTAuthenticator_Sign(t *testing.T) {
+	a := NewJWTAuthenticator([]byte("secret"))
+	token, err := a.Sign(map[string]any{"sub": "tom", "exp": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	p, err := a.Authenticate(context.Background(), Credentials{Token: token})
+	require.NoError(t, err)
+	assert.Equal(t, "tom", p.Subject)
+	assert.Empty(t, p.Roles)
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+)
+
+var errNoPeerCert = errors.New("micro: 没有验证过的客户端证书")
+
+// MTLSAuthenticator 用 mTLS 的客户端证书认证, 证书本身在 TLS 握手的时候已经验证过了, 这里只是取出身份
+// 服务端的 TLS 配置要设置 ClientAuth 为 tls.RequireAndVerifyClientCert 或者 tls.VerifyClientCertIfGiven
+type MTLSAuthenticator struct {
+	identity func(cert *x509.Certificate) (*Principal, error)
+}
+
+type MTLSOption func(a *MTLSAuthenticator)
+
+// MTLSWithIdentity 自定义从证书里面取出身份的方式, 比如说只信任某几个 CommonName
+func MTLSWithIdentity(identity func(cert *x509.Certificate) (*Principal, error)) MTLSOption {
+	return func(a *MTLSAuthenticator) {
+		a.identity = identity
+	}
+}
+
+func NewMTLSAuthenticator(opts ...MTLSOption) *MTLSAuthenticator {
+	a := &MTLSAuthenticator{identity: defaultIdentity}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// defaultIdentity 有 URI SAN 的话用第一个, 比如说 spiffe://cluster/ns/default/sa/user-service
+// 否则用 CommonName, 证书的 OU 当成角色
+func defaultIdentity(cert *x509.Certificate) (*Principal, error) {
+	subject := cert.Subject.CommonName
+	if len(cert.URIs) > 0 {
+		subject = cert.URIs[0].String()
+	}
+	return &Principal{Subject: subject, Roles: cert.Subject.OrganizationalUnit}, nil
+}
+
+func (a *MTLSAuthenticator) Authenticate(ctx context.Context, cred Credentials) (*Principal, error) {
+	if len(cred.PeerCerts) == 0 {
+		return nil, errNoPeerCert
+	}
+	p, err := a.identity(cred.PeerCerts[0])
+	if err != nil {
+		return nil, err
+	}
+	p.Method = "mtls"
+	return p, nil
+}
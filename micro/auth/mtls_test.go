@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMTLSAuthenticator(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://cluster/ns/default/sa/order-service")
+	require.NoError(t, err)
+	cases := []struct {
+		name string
+		a    *MTLSAuthenticator
+		cert *x509.Certificate
+
+		wantPrincipal *Principal
+		wantErr       bool
+	}{
+		{
+			name: "common name",
+			a:    NewMTLSAuthenticator(),
+			cert: &x509.Certificate{Subject: pkix.Name{CommonName: "order-service", OrganizationalUnit: []string{"internal"}}},
+			wantPrincipal: &Principal{
+				Subject: "order-service",
+				Roles:   []string{"internal"},
+				Method:  "mtls",
+			},
+		},
+		{
+			name: "uri",
+			a:    NewMTLSAuthenticator(),
+			cert: &x509.Certificate{Subject: pkix.Name{CommonName: "order-service"}, URIs: []*url.URL{spiffe}},
+			wantPrincipal: &Principal{
+				Subject: "spiffe://cluster/ns/default/sa/order-service",
+				Method:  "mtls",
+			},
+		},
+		{
+			name: "custom identity",
+			a: NewMTLSAuthenticator(MTLSWithIdentity(func(cert *x509.Certificate) (*Principal, error) {
+				if cert.Subject.CommonName != "order-service" {
+					return nil, errNoPeerCert
+				}
+				return &Principal{Subject: "order"}, nil
+			})),
+			cert:    &x509.Certificate{Subject: pkix.Name{CommonName: "pay-service"}},
+			wantErr: true,
+		},
+		{
+			name:    "no cert",
+			a:       NewMTLSAuthenticator(),
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var cred Credentials
+			if c.cert != nil {
+				cred.PeerCerts = []*x509.Certificate{c.cert}
+			}
+			p, err := c.a.Authenticate(context.Background(), cred)
+			assert.Equal(t, c.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.wantPrincipal, p)
+		})
+	}
+}
+
+// testCA 测试用的证书, 每次运行都重新生成
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue client 为 true 的时候签发客户端证书, ous 会被当成角色
+func (ca *testCA) issue(t *testing.T, name string, client bool, ous ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, OrganizationalUnit: ous},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if client {
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+// ServerInterceptor rpc 的认证和鉴权, token 放在 MetaAuthorization 元数据里面, 证书来自 mTLS 的连接
+// 认证失败返回 CodeUnauthenticated, 没有权限返回 CodePermissionDenied
+// 流式调用要同时设置 ServerStreamInterceptor:
+//
+//	rpc.ServerWithInterceptors(auth.ServerInterceptor(a, rules)),
+//	rpc.ServerWithStreamInterceptors(auth.ServerStreamInterceptor(a, rules))
+func ServerInterceptor(a Authenticator, rules Rules) rpc.Interceptor {
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			ctx, err := checkRPC(ctx, a, rules, req)
+			if err != nil {
+				return nil, err
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// ServerStreamInterceptor 和 ServerInterceptor 一样, 只在流开始的时候检查一次
+func ServerStreamInterceptor(a Authenticator, rules Rules) rpc.StreamInterceptor {
+	return func(next rpc.StreamHandler) rpc.StreamHandler {
+		return func(ctx context.Context, open *message.Request) error {
+			ctx, err := checkRPC(ctx, a, rules, open)
+			if err != nil {
+				return err
+			}
+			return next(ctx, open)
+		}
+	}
+}
+
+func checkRPC(ctx context.Context, a Authenticator, rules Rules, req *message.Request) (context.Context, error) {
+	cred := Credentials{Token: parseBearer(req.Meta[MetaAuthorization])}
+	if p, ok := rpc.PeerFromContext(ctx); ok && p.TLS != nil && len(p.TLS.VerifiedChains) > 0 {
+		cred.PeerCerts = p.TLS.VerifiedChains[0]
+	}
+	ctx, err := check(ctx, a, rules, req.ServiceName, req.MethodName, cred)
+	if err == nil {
+		return ctx, nil
+	}
+	if errors.Is(err, ErrPermissionDenied) {
+		return ctx, rpc.NewStatus(rpc.CodePermissionDenied, err.Error())
+	}
+	return ctx, rpc.NewStatus(rpc.CodeUnauthenticated, err.Error())
+}
+
+// ClientInterceptor 每次调用之前从 source 获取 token, 放到 MetaAuthorization 元数据里面
+// 客户端的拦截器不会经过流式调用, 流式调用用 OutgoingToken
+func ClientInterceptor(source TokenSource) rpc.Interceptor {
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			token, err := source(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if req.Meta == nil {
+				req.Meta = make(map[string]string, 1)
+			}
+			req.Meta[MetaAuthorization] = "Bearer " + token
+			return next(ctx, req)
+		}
+	}
+}
+
+// OutgoingToken 把 token 放到 rpc 调用的元数据里面, 普通调用和流式调用都可以用
+func OutgoingToken(ctx context.Context, token string) context.Context {
+	return rpc.OutgoingMeta(ctx, MetaAuthorization, "Bearer "+token)
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPCInterceptors(t *testing.T) {
+	ca := newTestCA(t)
+	a := Any(NewMTLSAuthenticator(), NewTokenAuthenticator(map[string]*Principal{
+		"tom-token": {Subject: "tom", Roles: []string{"user"}},
+	}))
+	rules := Rules{
+		Default: &Rule{Roles: []string{"internal", "user"}},
+		Rules: map[string]*Rule{
+			"principal-service/Watch": {Roles: []string{"internal"}},
+		},
+	}
+	serverCfg := transport.ServerTLSConfig(ca.issue(t, "user-service", false), ca.pool)
+	serverCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	lis, err := transport.NewTLS(serverCfg).Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := rpc.NewServer(
+		rpc.ServerWithInterceptors(ServerInterceptor(a, rules)),
+		rpc.ServerWithStreamInterceptors(ServerStreamInterceptor(a, rules)))
+	server.RegisterService(&principalServiceServer{})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	clientCert := ca.issue(t, "order-service", true, "internal")
+	unauthenticated := rpc.CodeUnauthenticated
+	denied := rpc.CodePermissionDenied
+	cases := []struct {
+		name  string
+		cert  *tls.Certificate
+		opts  []rpc.ClientOption
+		token string
+
+		wantSubject   string
+		wantCode      *rpc.Code
+		wantWatchCode *rpc.Code
+	}{
+		{
+			name:        "mtls",
+			cert:        &clientCert,
+			wantSubject: "order-service",
+		},
+		{
+			name:          "token",
+			opts:          []rpc.ClientOption{rpc.ClientWithInterceptors(ClientInterceptor(StaticToken("tom-token")))},
+			token:         "tom-token",
+			wantSubject:   "tom",
+			wantWatchCode: &denied,
+		},
+		{
+			name:          "invalid token",
+			opts:          []rpc.ClientOption{rpc.ClientWithInterceptors(ClientInterceptor(StaticToken("bad-token")))},
+			token:         "bad-token",
+			wantCode:      &unauthenticated,
+			wantWatchCode: &unauthenticated,
+		},
+		{
+			name:          "no credentials",
+			wantCode:      &unauthenticated,
+			wantWatchCode: &unauthenticated,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clientCfg := transport.ClientTLSConfig(ca.pool, "user-service", c.cert)
+			client, err := rpc.NewClient(lis.Addr().String(),
+				append(c.opts, rpc.ClientWithTransport(transport.NewTLS(clientCfg)))...)
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			ps := &principalService{}
+			require.NoError(t, client.InitService(ps))
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			resp, err := ps.Whoami(ctx, &WhoamiReq{})
+			assertCode(t, c.wantCode, err)
+			if err == nil {
+				assert.Equal(t, c.wantSubject, resp.Subject)
+			}
+
+			// 客户端的拦截器不经过流式调用, token 要放到元数据里面
+			if c.token != "" {
+				ctx = OutgoingToken(ctx, c.token)
+			}
+			stream, err := ps.Watch(ctx, &WhoamiReq{})
+			require.NoError(t, err)
+			resp, err = stream.Recv()
+			assertCode(t, c.wantWatchCode, err)
+			if err == nil {
+				assert.Equal(t, c.wantSubject, resp.Subject)
+			}
+		})
+	}
+}
+
+func assertCode(t *testing.T, want *rpc.Code, err error) {
+	if want == nil {
+		assert.NoError(t, err)
+		return
+	}
+	st, ok := err.(*rpc.Status)
+	require.True(t, ok, "%v", err)
+	assert.Equal(t, *want, st.Code)
+}
+
+type WhoamiReq struct{}
+
+type WhoamiResp struct {
+	Subject string
+}
+
+type principalService struct {
+	Whoami func(ctx context.Context, req *WhoamiReq) (*WhoamiResp, error)
+	Watch  func(ctx context.Context, req *WhoamiReq) (*rpc.ClientStream[WhoamiReq, WhoamiResp], error)
+}
+
+func (p *principalService) Name() string {
+	return "principal-service"
+}
+
+type principalServiceServer struct{}
+
+func (p *principalServiceServer) Name() string {
+	return "principal-service"
+}
+
+func (p *principalServiceServer) Whoami(ctx context.Context, req *WhoamiReq) (*WhoamiResp, error) {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return nil, rpc.NewStatus(rpc.CodeInternal, "no principal")
+	}
+	return &WhoamiResp{Subject: principal.Subject}, nil
+}
+
+func (p *principalServiceServer) Watch(ctx context.Context, req *WhoamiReq, stream *rpc.ServerStream[WhoamiReq, WhoamiResp]) error {
+	resp, err := p.Whoami(ctx, req)
+	if err != nil {
+		return err
+	}
+	return stream.Send(resp)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+)
+
+var errInvalidToken = errors.New("micro: token 不正确")
+
+// TokenAuthenticator 静态 token, 适合内部工具和简单的服务之间调用
+type TokenAuthenticator struct {
+	tokens []staticToken
+}
+
+type staticToken struct {
+	token []byte
+	p     *Principal
+}
+
+// NewTokenAuthenticator tokens 是 token 到调用方的映射, 调用方的 Method 会被设置成 token
+func NewTokenAuthenticator(tokens map[string]*Principal) *TokenAuthenticator {
+	a := &TokenAuthenticator{tokens: make([]staticToken, 0, len(tokens))}
+	for token, p := range tokens {
+		cp := *p
+		cp.Method = "token"
+		a.tokens = append(a.tokens, staticToken{token: []byte(token), p: &cp})
+	}
+	return a
+}
+
+// Authenticate 逐个用常量时间比较, 不会因为比较的耗时泄露 token
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, cred Credentials) (*Principal, error) {
+	if cred.Token == "" {
+		return nil, errNoCredentials
+	}
+	token := []byte(cred.Token)
+	var found *Principal
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(token, t.token) == 1 {
+			found = t.p
+		}
+	}
+	if found == nil {
+		return nil, errInvalidToken
+	}
+	return found, nil
+}
@@ -0,0 +1,317 @@
+// Package breaker 熔断和自适应限流
+// Breaker 用在客户端, 每个服务实例一个, 下游出问题的时候快速失败, 避免故障扩散
+// Limiter 用在服务端, 快要扛不住的时候提前拒绝请求
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen 熔断器处于打开状态, 请求没有发出去
+var ErrOpen = errors.New("micro: 熔断器已打开")
+
+type State int32
+
+const (
+	// StateClosed 正常放行, 统计失败率和慢调用比例
+	StateClosed State = iota
+	// StateOpen 拒绝所有请求, 过了 openTimeout 进入 StateHalfOpen
+	StateOpen
+	// StateHalfOpen 放少量请求过去试探, 都成功了就关闭, 有一个失败就重新打开
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker 基于滑动窗口的熔断器
+// 窗口内的请求数量达到 minRequests 之后, 失败率或者慢调用比例超过阈值就打开
+type Breaker struct {
+	clock Clock
+
+	windowSize time.Duration
+	buckets    int
+	// minRequests 窗口内的请求太少的话, 比例没有意义, 不会打开
+	minRequests int
+	// failureRate 失败率阈值, 0 到 1 之间
+	failureRate float64
+	// slowCallDuration 超过这个时间就算慢调用, 为 0 就不统计慢调用
+	slowCallDuration time.Duration
+	slowCallRate     float64
+	// openTimeout 打开之后多久进入 half-open
+	openTimeout time.Duration
+	// halfOpenRequests half-open 的时候放过去的试探请求数量
+	halfOpenRequests int
+
+	onStateChange func(from, to State)
+
+	mutex  sync.Mutex
+	state  State
+	counts *window[counts]
+	// generation 每次状态变化都加一, 上一个状态放过去的请求的结果直接丢弃
+	generation uint64
+	openedAt   time.Time
+	// halfOpenInflight half-open 的时候已经放过去的请求, halfOpenSuccess 其中成功的
+	halfOpenInflight int
+	halfOpenSuccess  int
+}
+
+type counts struct {
+	total    int
+	failures int
+	slow     int
+}
+
+type BreakerOption func(b *Breaker)
+
+func NewBreaker(opts ...BreakerOption) *Breaker {
+	b := &Breaker{
+		clock:            realClock{},
+		windowSize:       10 * time.Second,
+		buckets:          10,
+		minRequests:      20,
+		failureRate:      0.5,
+		slowCallRate:     1,
+		openTimeout:      5 * time.Second,
+		halfOpenRequests: 5,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.counts = newWindow[counts](b.windowSize, b.buckets)
+	return b
+}
+
+// BreakerWithWindow 统计窗口的长度和分成多少个桶, 桶越多滑动得越平滑
+// size 和 buckets 必须大于 0, 每个桶至少 1 纳秒, 否则忽略
+func BreakerWithWindow(size time.Duration, buckets int) BreakerOption {
+	return func(b *Breaker) {
+		if size <= 0 || buckets <= 0 || size < time.Duration(buckets) {
+			return
+		}
+		b.windowSize = size
+		b.buckets = buckets
+	}
+}
+
+// BreakerWithMinRequests n < 0 的时候忽略
+func BreakerWithMinRequests(n int) BreakerOption {
+	return func(b *Breaker) {
+		if n < 0 {
+			return
+		}
+		b.minRequests = n
+	}
+}
+
+// BreakerWithFailureRate 失败率达到 rate 就打开, rate 不在 (0, 1] 之间的时候忽略
+func BreakerWithFailureRate(rate float64) BreakerOption {
+	return func(b *Breaker) {
+		if !validRate(rate) {
+			return
+		}
+		b.failureRate = rate
+	}
+}
+
+// BreakerWithSlowCall 耗时超过 duration 的算慢调用, 慢调用比例达到 rate 就打开
+// duration 小于 0 或者 rate 不在 (0, 1] 之间的时候忽略
+func BreakerWithSlowCall(duration time.Duration, rate float64) BreakerOption {
+	return func(b *Breaker) {
+		if duration < 0 || !validRate(rate) {
+			return
+		}
+		b.slowCallDuration = duration
+		b.slowCallRate = rate
+	}
+}
+
+func BreakerWithOpenTimeout(timeout time.Duration) BreakerOption {
+	return func(b *Breaker) {
+		b.openTimeout = timeout
+	}
+}
+
+// BreakerWithHalfOpenRequests n <= 0 的时候忽略, 不然 half-open 的时候一个请求都放不过去, 永远关不上
+func BreakerWithHalfOpenRequests(n int) BreakerOption {
+	return func(b *Breaker) {
+		if n <= 0 {
+			return
+		}
+		b.halfOpenRequests = n
+	}
+}
+
+// validRate 比例只能在 (0, 1] 之间, 0 会让第一个失败的请求就打开熔断器, 大于 1 永远不会打开
+func validRate(rate float64) bool {
+	return rate > 0 && rate <= 1
+}
+
+// BreakerWithStateChange 状态变化的时候回调, 可以用来打日志或者上报监控, 回调的时候持有锁, 不要调用 Breaker 的方法
+func BreakerWithStateChange(fn func(from, to State)) BreakerOption {
+	return func(b *Breaker) {
+		b.onStateChange = fn
+	}
+}
+
+func BreakerWithClock(clock Clock) BreakerOption {
+	return func(b *Breaker) {
+		b.clock = clock
+	}
+}
+
+// State 当前状态, 打开的时间超过了 openTimeout 会返回 StateHalfOpen
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh(b.clock.Now())
+	return b.state
+}
+
+// ready 请求大概率能被放行, 负载均衡挑选节点的时候用它过滤
+func (b *Breaker) ready() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh(b.clock.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return b.halfOpenInflight < b.halfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow 判断请求能不能发出去, 能的话请求结束之后必须调用 done, failed 代表请求是否失败
+// 慢调用是根据 Allow 到 done 之间的时间来判断的
+func (b *Breaker) Allow() (done func(failed bool), err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := b.clock.Now()
+	b.refresh(now)
+	switch b.state {
+	case StateOpen:
+		return nil, ErrOpen
+	case StateHalfOpen:
+		if b.halfOpenInflight >= b.halfOpenRequests {
+			return nil, ErrOpen
+		}
+		b.halfOpenInflight++
+	}
+	generation := b.generation
+	return func(failed bool) {
+		b.done(generation, now, failed)
+	}, nil
+}
+
+func (b *Breaker) done(generation uint64, start time.Time, failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if generation != b.generation {
+		return
+	}
+	now := b.clock.Now()
+	slow := b.slowCallDuration > 0 && now.Sub(start) >= b.slowCallDuration
+	switch b.state {
+	case StateClosed:
+		c := b.counts.current(now)
+		c.total++
+		if failed {
+			c.failures++
+		}
+		if slow {
+			c.slow++
+		}
+		var sum counts
+		b.counts.each(now, true, func(c *counts) {
+			sum.total += c.total
+			sum.failures += c.failures
+			sum.slow += c.slow
+		})
+		if sum.total < b.minRequests {
+			return
+		}
+		total := float64(sum.total)
+		if float64(sum.failures)/total >= b.failureRate ||
+			(b.slowCallDuration > 0 && float64(sum.slow)/total >= b.slowCallRate) {
+			b.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		if failed || slow {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.halfOpenSuccess++
+		if b.halfOpenSuccess >= b.halfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	}
+}
+
+// refresh 打开的时间够长了就进入 half-open
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.openTimeout {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.generation++
+	b.halfOpenInflight = 0
+	b.halfOpenSuccess = 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		// 重新开始统计, 不然马上又会因为旧的数据打开
+		b.counts.reset()
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(from, state)
+	}
+}
+
+// Group 按照 key 管理多个 Breaker, key 一般是服务实例的地址
+type Group struct {
+	opts     []BreakerOption
+	mutex    sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewGroup opts 用来创建每一个 Breaker
+func NewGroup(opts ...BreakerOption) *Group {
+	return &Group{
+		opts:     opts,
+		breakers: make(map[string]*Breaker, 8),
+	}
+}
+
+// Get 取出 key 对应的 Breaker, 没有就创建一个
+func (g *Group) Get(key string) *Breaker {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	b, ok := g.breakers[key]
+	if !ok {
+		b = NewBreaker(g.opts...)
+		g.breakers[key] = b
+	}
+	return b
+}
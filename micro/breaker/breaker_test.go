@@ -0,0 +1,213 @@
+package breaker
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock 测试用的时钟, 只有调用 Advance 时间才会往前走
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// call 发起一次调用, 耗时 latency
+func call(t *testing.T, b *Breaker, clock *fakeClock, failed bool, latency time.Duration) {
+	done, err := b.Allow()
+	require.NoError(t, err)
+	clock.Advance(latency)
+	done(failed)
+}
+
+func TestBreaker(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []BreakerOption
+		// calls 依次发起的调用
+		calls []struct {
+			failed  bool
+			latency time.Duration
+		}
+		wantState State
+	}{
+		{
+			name: "below min requests",
+			opts: []BreakerOption{BreakerWithMinRequests(4)},
+			calls: []struct {
+				failed  bool
+				latency time.Duration
+			}{{failed: true}, {failed: true}, {failed: true}},
+			wantState: StateClosed,
+		},
+		{
+			name: "failure rate",
+			opts: []BreakerOption{BreakerWithMinRequests(4), BreakerWithFailureRate(0.5)},
+			calls: []struct {
+				failed  bool
+				latency time.Duration
+			}{{failed: true}, {failed: false}, {failed: false}, {failed: true}},
+			wantState: StateOpen,
+		},
+		{
+			name: "failure rate not reached",
+			opts: []BreakerOption{BreakerWithMinRequests(4), BreakerWithFailureRate(0.5)},
+			calls: []struct {
+				failed  bool
+				latency time.Duration
+			}{{failed: true}, {failed: false}, {failed: false}, {failed: false}},
+			wantState: StateClosed,
+		},
+		{
+			name: "slow call",
+			opts: []BreakerOption{BreakerWithMinRequests(2), BreakerWithSlowCall(100*time.Millisecond, 0.5)},
+			calls: []struct {
+				failed  bool
+				latency time.Duration
+			}{{latency: 200 * time.Millisecond}, {latency: time.Millisecond}},
+			wantState: StateOpen,
+		},
+		{
+			name: "old failures slide out",
+			opts: []BreakerOption{BreakerWithMinRequests(3), BreakerWithWindow(time.Second, 10)},
+			calls: []struct {
+				failed  bool
+				latency time.Duration
+			}{
+				// 两次失败在窗口滑过去之后就不算了
+				{failed: true}, {failed: true},
+				{latency: 2 * time.Second}, {}, {failed: true},
+			},
+			wantState: StateClosed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clock := newFakeClock()
+			b := NewBreaker(append(c.opts, BreakerWithClock(clock))...)
+			for _, ca := range c.calls {
+				call(t, b, clock, ca.failed, ca.latency)
+			}
+			assert.Equal(t, c.wantState, b.State())
+		})
+	}
+}
+
+func TestBreaker_halfOpen(t *testing.T) {
+	clock := newFakeClock()
+	var changes []string
+	b := NewBreaker(BreakerWithClock(clock),
+		BreakerWithMinRequests(1),
+		BreakerWithOpenTimeout(time.Second),
+		BreakerWithHalfOpenRequests(2),
+		BreakerWithStateChange(func(from, to State) {
+			changes = append(changes, from.String()+"->"+to.String())
+		}))
+
+	// 打开之后拒绝所有请求
+	call(t, b, clock, true, 0)
+	_, err := b.Allow()
+	assert.Equal(t, ErrOpen, err)
+
+	// 试探失败, 重新打开
+	clock.Advance(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	call(t, b, clock, true, 0)
+	assert.Equal(t, StateOpen, b.State())
+
+	// 只放两个试探请求过去
+	clock.Advance(time.Second)
+	done1, err := b.Allow()
+	require.NoError(t, err)
+	done2, err := b.Allow()
+	require.NoError(t, err)
+	_, err = b.Allow()
+	assert.Equal(t, ErrOpen, err)
+	done1(false)
+	assert.Equal(t, StateHalfOpen, b.State())
+	done2(false)
+	assert.Equal(t, StateClosed, b.State())
+
+	// 关闭之后重新统计, 之前的失败不算
+	call(t, b, clock, false, 0)
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}, changes)
+}
+
+func TestBreaker_staleDone(t *testing.T) {
+	clock := newFakeClock()
+	b := NewBreaker(BreakerWithClock(clock), BreakerWithMinRequests(1), BreakerWithOpenTimeout(time.Second))
+	// 打开之前放过去的请求, 结果回来的时候已经是另一个状态了, 直接丢弃
+	stale, err := b.Allow()
+	require.NoError(t, err)
+	call(t, b, clock, true, 0)
+	clock.Advance(time.Second)
+	assert.Equal(t, StateHalfOpen, b.State())
+	stale(true)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
+
+func TestNewBreaker_invalidOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		opt  BreakerOption
+	}{
+		{name: "half open requests", opt: BreakerWithHalfOpenRequests(0)},
+		{name: "negative half open requests", opt: BreakerWithHalfOpenRequests(-1)},
+		{name: "window buckets", opt: BreakerWithWindow(time.Second, 0)},
+		{name: "window size", opt: BreakerWithWindow(0, 10)},
+		{name: "min requests", opt: BreakerWithMinRequests(-1)},
+		{name: "failure rate", opt: BreakerWithFailureRate(0)},
+		{name: "failure rate too large", opt: BreakerWithFailureRate(1.5)},
+		{name: "slow call rate", opt: BreakerWithSlowCall(time.Second, -0.5)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// 不合法的配置直接忽略, 还是默认值
+			assert.Equal(t, NewBreaker(), NewBreaker(c.opt))
+		})
+	}
+
+	// half-open 的时候至少能放一个请求过去, 不会一直卡在 half-open
+	clock := newFakeClock()
+	b := NewBreaker(BreakerWithClock(clock), BreakerWithMinRequests(1),
+		BreakerWithOpenTimeout(time.Second), BreakerWithHalfOpenRequests(0))
+	call(t, b, clock, true, 0)
+	clock.Advance(time.Second)
+	// 用的是默认的 5 个试探请求
+	for i := 0; i < 5; i++ {
+		call(t, b, clock, false, 0)
+	}
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestGroup_Get(t *testing.T) {
+	g := NewGroup(BreakerWithMinRequests(1))
+	b1 := g.Get("127.0.0.1:8080")
+	assert.Same(t, b1, g.Get("127.0.0.1:8080"))
+	assert.NotSame(t, b1, g.Get("127.0.0.1:8081"))
+	assert.Equal(t, 1, b1.minRequests)
+}
@@ -0,0 +1,99 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/startdusk/go-libs/micro/loadbalance"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// PickerBuilder 给每个服务实例一个 Breaker, 熔断器打开的实例不会被选中, 剩下的交给 Builder 去挑选
+// 比如说 micro.ClientWithPickerBuilder("breaker_rr", &breaker.PickerBuilder{Group: g, Builder: &roundrobin.Builder{}})
+// 所有实例都熔断了的话返回 codes.Unavailable
+type PickerBuilder struct {
+	// Group 使用实例的地址作为 key
+	Group *Group
+	// Builder 真正挑选节点的负载均衡算法, 不能为 nil
+	Builder base.PickerBuilder
+}
+
+func (b *PickerBuilder) Name() string {
+	return "BREAKER"
+}
+
+func (b *PickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	addrs := make(map[balancer.SubConn]string, len(info.ReadySCs))
+	for c, ci := range info.ReadySCs {
+		addrs[c] = ci.Address.Addr
+	}
+	fb := &loadbalance.FilterBuilder{
+		Filter: func(info balancer.PickInfo, addr resolver.Address) bool {
+			return b.Group.Get(addr.Addr).ready()
+		},
+		Builder: b.Builder,
+	}
+	return &Picker{
+		next:  fb.Build(info),
+		addrs: addrs,
+		group: b.Group,
+	}
+}
+
+type Picker struct {
+	next  balancer.Picker
+	addrs map[balancer.SubConn]string
+	group *Group
+}
+
+func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := p.next.Pick(info)
+	if errors.Is(err, loadbalance.ErrNoMatchedSubConn) {
+		return res, status.Error(codes.Unavailable, ErrOpen.Error())
+	}
+	if err != nil {
+		return res, err
+	}
+	done, err := p.group.Get(p.addrs[res.SubConn]).Allow()
+	if err != nil {
+		// 过滤之后, 别的请求抢先用完了 half-open 的试探名额
+		return balancer.PickResult{}, status.Error(codes.Unavailable, err.Error())
+	}
+	next := res.Done
+	res.Done = func(info balancer.DoneInfo) {
+		if next != nil {
+			next(info)
+		}
+		done(failedGRPCCode(status.Code(info.Err)))
+	}
+	return res, nil
+}
+
+func failedGRPCCode(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// UnaryServerInterceptor gRPC 的服务端限流, 被拒绝的请求返回 codes.ResourceExhausted
+// 比如说 micro.ServerWithGRPCOptions(grpc.ChainUnaryInterceptor(breaker.UnaryServerInterceptor(l)))
+func UnaryServerInterceptor(l Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		done, err := l.Acquire()
+		if err != nil {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
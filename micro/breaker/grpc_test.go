@@ -0,0 +1,84 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/loadbalance/roundrobin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+func TestPicker(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGroup(BreakerWithClock(clock), BreakerWithMinRequests(1), BreakerWithFailureRate(0.1),
+		BreakerWithOpenTimeout(time.Second), BreakerWithHalfOpenRequests(1))
+	b := &PickerBuilder{Group: g, Builder: &roundrobin.Builder{}}
+	picker := b.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "127.0.0.1:8080"}: {Address: resolver.Address{Addr: "127.0.0.1:8080"}},
+			SubConn{name: "127.0.0.1:8081"}: {Address: resolver.Address{Addr: "127.0.0.1:8081"}},
+		},
+	})
+
+	// 8080 调用失败, 熔断器打开
+	for {
+		res, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		if res.SubConn.(SubConn).name == "127.0.0.1:8080" {
+			res.Done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "unavailable")})
+			break
+		}
+		res.Done(balancer.DoneInfo{})
+	}
+	assert.Equal(t, StateOpen, g.Get("127.0.0.1:8080").State())
+	// 之后只会选中 8081
+	for i := 0; i < 4; i++ {
+		res, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, SubConn{name: "127.0.0.1:8081"}, res.SubConn)
+		// 业务错误不算失败
+		res.Done(balancer.DoneInfo{Err: status.Error(codes.NotFound, "not found")})
+	}
+	assert.Equal(t, StateClosed, g.Get("127.0.0.1:8081").State())
+
+	// 8081 也熔断了, 没有节点可选
+	res, err := picker.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	res.Done(balancer.DoneInfo{Err: errors.New("connection reset")})
+	_, err = picker.Pick(balancer.PickInfo{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, ErrOpen.Error(), status.Convert(err).Message())
+
+	// half-open 之后 8080 又能被选中
+	clock.Advance(time.Second)
+	res, err = picker.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	res.Done(balancer.DoneInfo{})
+	assert.Equal(t, StateClosed, g.Get(res.SubConn.(SubConn).name).State())
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l := NewConcurrencyLimiter(0)
+	_, err := UnaryServerInterceptor(l)(context.Background(), nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+type SubConn struct {
+	name string
+}
+
+func (s SubConn) UpdateAddresses(addrs []resolver.Address) {}
+
+func (s SubConn) Connect() {}
@@ -0,0 +1,186 @@
+package breaker
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+)
+
+// ErrOverloaded Limiter 拒绝请求的时候返回
+// 和 rpc.ErrOverloaded 是同一个错误, 所以 rpc 的客户端收到之后也能用 errors.Is 判断
+var ErrOverloaded = rpc.ErrOverloaded
+
+// Limiter 服务端限流, 请求处理之前调用 Acquire, 处理完了调用 done
+type Limiter interface {
+	Acquire() (done func(), err error)
+}
+
+// ConcurrencyLimiter 限制同时处理的请求数量
+type ConcurrencyLimiter struct {
+	max      int64
+	inflight int64
+}
+
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{max: int64(max)}
+}
+
+func (l *ConcurrencyLimiter) Acquire() (func(), error) {
+	if atomic.AddInt64(&l.inflight, 1) > l.max {
+		atomic.AddInt64(&l.inflight, -1)
+		return nil, ErrOverloaded
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&l.inflight, -1)
+		})
+	}, nil
+}
+
+// AdaptiveLimiter 自适应限流, 思路来自 BBR:
+// 系统能够承受的并发数 = 窗口内每个桶最大的通过数量 * 最小的平均耗时 / 桶的长度
+// 并发数超过了这个值, 说明请求已经开始排队了, 再放进来只会让所有请求都变慢, 不如直接拒绝
+// 设置了 CPU 使用率的话, 只有 CPU 使用率超过阈值(或者刚刚拒绝过请求)的时候才会限流
+// 并发数不超过 minInflight 的时候一定不限流: QPS 很低的时候算出来的并发数很小, 比如说每秒一个请求,
+// 耗时 5ms, 算出来只有 1, 这个时候服务器其实很闲, 不能因为同时来了两个请求就拒绝
+type AdaptiveLimiter struct {
+	clock Clock
+	// cpu 返回 0 到 1 之间的 CPU 使用率, 为 nil 就只看并发数
+	cpu          func() float64
+	cpuThreshold float64
+	// coolDown 拒绝过请求之后, 即便 CPU 使用率降下来了, 也要继续按照并发数限流一段时间, 避免抖动
+	coolDown time.Duration
+	// minInflight 并发数超过它才有可能限流, 这是没有设置 CPU 使用率的时候唯一的压力信号
+	minInflight int64
+
+	mutex    sync.Mutex
+	window   *window[limiterBucket]
+	inflight int64
+	lastDrop time.Time
+}
+
+type limiterBucket struct {
+	pass    int64
+	rtSum   time.Duration
+	rtCount int64
+}
+
+type AdaptiveLimiterOption func(l *AdaptiveLimiter)
+
+func NewAdaptiveLimiter(opts ...AdaptiveLimiterOption) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		clock:        realClock{},
+		cpuThreshold: 0.8,
+		coolDown:     time.Second,
+		minInflight:  32,
+		window:       newWindow[limiterBucket](10*time.Second, 100),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// AdaptiveLimiterWithWindow 统计窗口的长度和分成多少个桶
+func AdaptiveLimiterWithWindow(size time.Duration, buckets int) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		if size <= 0 || buckets <= 0 || size < time.Duration(buckets) {
+			return
+		}
+		l.window = newWindow[limiterBucket](size, buckets)
+	}
+}
+
+// AdaptiveLimiterWithCPU cpu 返回 0 到 1 之间的 CPU 使用率, 超过 threshold 才开始限流
+// cpu 每个请求都会调用, 应该返回定时采样的结果, 不要每次都去计算
+func AdaptiveLimiterWithCPU(cpu func() float64, threshold float64) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.cpu = cpu
+		l.cpuThreshold = threshold
+	}
+}
+
+func AdaptiveLimiterWithCoolDown(coolDown time.Duration) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.coolDown = coolDown
+	}
+}
+
+// AdaptiveLimiterWithMinInflight 并发数超过 n 才有可能限流, 默认是 32
+func AdaptiveLimiterWithMinInflight(n int) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.minInflight = int64(n)
+	}
+}
+
+func AdaptiveLimiterWithClock(clock Clock) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.clock = clock
+	}
+}
+
+func (l *AdaptiveLimiter) Acquire() (func(), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	start := l.clock.Now()
+	if l.shouldDrop(start) {
+		l.lastDrop = start
+		return nil, ErrOverloaded
+	}
+	l.inflight++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			now := l.clock.Now()
+			l.inflight--
+			b := l.window.current(now)
+			b.pass++
+			b.rtSum += now.Sub(start)
+			b.rtCount++
+		})
+	}, nil
+}
+
+func (l *AdaptiveLimiter) shouldDrop(now time.Time) bool {
+	if l.inflight < l.minInflight {
+		return false
+	}
+	if l.cpu != nil && l.cpu() < l.cpuThreshold {
+		if l.lastDrop.IsZero() || now.Sub(l.lastDrop) > l.coolDown {
+			return false
+		}
+	}
+	maxInflight := l.maxInflight(now)
+	return maxInflight > 0 && l.inflight >= maxInflight
+}
+
+// maxInflight 返回 0 代表还没有足够的数据, 不限流
+func (l *AdaptiveLimiter) maxInflight(now time.Time) int64 {
+	var maxPass int64
+	minRT := time.Duration(math.MaxInt64)
+	// 当前的桶还没有统计完, 不算
+	l.window.each(now, false, func(b *limiterBucket) {
+		if b.pass > maxPass {
+			maxPass = b.pass
+		}
+		if b.rtCount > 0 {
+			if rt := b.rtSum / time.Duration(b.rtCount); rt < minRT {
+				minRT = rt
+			}
+		}
+	})
+	if maxPass == 0 {
+		return 0
+	}
+	res := int64(math.Ceil(float64(maxPass) * float64(minRT) / float64(l.window.bucketSize)))
+	if res < 1 {
+		res = 1
+	}
+	return res
+}
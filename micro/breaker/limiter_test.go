@@ -0,0 +1,127 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := NewConcurrencyLimiter(2)
+	done1, err := l.Acquire()
+	require.NoError(t, err)
+	_, err = l.Acquire()
+	require.NoError(t, err)
+	_, err = l.Acquire()
+	assert.Equal(t, ErrOverloaded, err)
+
+	// 多次调用 done 只释放一次
+	done1()
+	done1()
+	_, err = l.Acquire()
+	require.NoError(t, err)
+	_, err = l.Acquire()
+	assert.Equal(t, ErrOverloaded, err)
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	clock := newFakeClock()
+	cpu := 0.9
+	l := NewAdaptiveLimiter(
+		AdaptiveLimiterWithClock(clock),
+		AdaptiveLimiterWithWindow(time.Second, 10),
+		AdaptiveLimiterWithCPU(func() float64 { return cpu }, 0.8),
+		AdaptiveLimiterWithCoolDown(time.Second),
+		AdaptiveLimiterWithMinInflight(1))
+
+	// 没有数据的时候不限流
+	var dones []func()
+	for i := 0; i < 10; i++ {
+		done, err := l.Acquire()
+		require.NoError(t, err)
+		dones = append(dones, done)
+	}
+	// 一个桶 100ms 处理了 10 个请求, 每个耗时 20ms, 所以能承受的并发数是 10 * 20 / 100 = 2
+	clock.Advance(20 * time.Millisecond)
+	for _, done := range dones {
+		done()
+	}
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, int64(2), l.maxInflight(clock.Now()))
+
+	_, err := l.Acquire()
+	require.NoError(t, err)
+	_, err = l.Acquire()
+	require.NoError(t, err)
+	_, err = l.Acquire()
+	assert.Equal(t, ErrOverloaded, err)
+
+	// CPU 降下来了, 但是还在冷却时间里面, 继续限流
+	cpu = 0.1
+	clock.Advance(500 * time.Millisecond)
+	_, err = l.Acquire()
+	assert.Equal(t, ErrOverloaded, err)
+
+	// 冷却时间过了就不限流了
+	clock.Advance(1100 * time.Millisecond)
+	_, err = l.Acquire()
+	assert.NoError(t, err)
+}
+
+func TestAdaptiveLimiter_withoutCPU(t *testing.T) {
+	clock := newFakeClock()
+	l := NewAdaptiveLimiter(
+		AdaptiveLimiterWithClock(clock),
+		AdaptiveLimiterWithWindow(time.Second, 10),
+		AdaptiveLimiterWithMinInflight(1))
+	done, err := l.Acquire()
+	require.NoError(t, err)
+	clock.Advance(100 * time.Millisecond)
+	done()
+	clock.Advance(100 * time.Millisecond)
+	// 1 * 100 / 100 = 1
+	_, err = l.Acquire()
+	require.NoError(t, err)
+	_, err = l.Acquire()
+	assert.Equal(t, ErrOverloaded, err)
+
+	// 窗口滑过去之后数据就没了, 不再限流
+	clock.Advance(time.Second)
+	_, err = l.Acquire()
+	assert.NoError(t, err)
+}
+
+func TestAdaptiveLimiter_idle(t *testing.T) {
+	clock := newFakeClock()
+	l := NewAdaptiveLimiter(
+		AdaptiveLimiterWithClock(clock),
+		AdaptiveLimiterWithWindow(time.Second, 10),
+		AdaptiveLimiterWithMinInflight(4))
+	// QPS 很低, 每个桶只有一个请求, 每个耗时 5ms, 算出来能承受的并发数只有 1
+	for i := 0; i < 3; i++ {
+		done, err := l.Acquire()
+		require.NoError(t, err)
+		clock.Advance(5 * time.Millisecond)
+		done()
+		clock.Advance(95 * time.Millisecond)
+	}
+	assert.Equal(t, int64(1), l.maxInflight(clock.Now()))
+
+	// 服务器很闲, 同时来几个请求也不应该拒绝
+	var dones []func()
+	for i := 0; i < 4; i++ {
+		done, err := l.Acquire()
+		require.NoError(t, err)
+		dones = append(dones, done)
+	}
+	// 并发数超过了下限, 又超过了算出来的并发数, 说明请求真的开始排队了
+	_, err := l.Acquire()
+	assert.Equal(t, ErrOverloaded, err)
+	for _, done := range dones {
+		done()
+	}
+	_, err = l.Acquire()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,59 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+// ClientInterceptor 熔断, rpc.Client 只连接一个服务实例, 所以每个 Client 用一个单独的 Breaker
+// 比如说 rpc.ClientWithInterceptors(breaker.ClientInterceptor(group.Get(addr)))
+// 熔断器打开的时候返回 CodeUnavailable 的 Status
+func ClientInterceptor(b *Breaker) rpc.Interceptor {
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			done, err := b.Allow()
+			if err != nil {
+				return nil, rpc.NewStatus(rpc.CodeUnavailable, err.Error())
+			}
+			resp, err := next(ctx, req)
+			if err != nil {
+				// 调用方自己取消的, 不是下游的问题
+				done(!errors.Is(err, context.Canceled))
+			} else {
+				done(failedCode(rpc.StatusFromResponse(resp)))
+			}
+			return resp, err
+		}
+	}
+}
+
+// failedCode 只有说明下游出了问题的错误码才算失败, 像 CodeNotFound 这种业务错误不算
+func failedCode(st *rpc.Status) bool {
+	if st == nil {
+		return false
+	}
+	switch st.Code {
+	case rpc.CodeUnknown, rpc.CodeDeadlineExceeded, rpc.CodeResourceExhausted, rpc.CodeOverloaded,
+		rpc.CodeInternal, rpc.CodeUnavailable, rpc.CodeDataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// ServerInterceptor 服务端限流, 被拒绝的请求返回 ErrOverloaded, 客户端收到的是 CodeOverloaded
+func ServerInterceptor(l Limiter) rpc.Interceptor {
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			done, err := l.Acquire()
+			if err != nil {
+				return nil, err
+			}
+			defer done()
+			return next(ctx, req)
+		}
+	}
+}
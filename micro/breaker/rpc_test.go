@@ -0,0 +1,99 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientInterceptor(t *testing.T) {
+	cases := []struct {
+		name string
+		// next 下游返回的结果
+		resp *message.Response
+		err  error
+
+		wantState State
+	}{
+		// 响应里面的 Error 是编码之后的 Status: code(4 字节) | message 长度(4 字节) | message
+		{
+			name:      "success",
+			resp:      &message.Response{},
+			wantState: StateClosed,
+		},
+		{
+			name:      "transport error",
+			err:       errors.New("connection reset"),
+			wantState: StateOpen,
+		},
+		{
+			name:      "canceled",
+			err:       context.Canceled,
+			wantState: StateClosed,
+		},
+		{
+			name:      "unavailable",
+			resp:      &message.Response{Error: []byte("\x00\x00\x00\x0e\x00\x00\x00\x00")},
+			wantState: StateOpen,
+		},
+		{
+			name:      "business error",
+			resp:      &message.Response{Error: []byte("\x00\x00\x00\x05\x00\x00\x00\x00")},
+			wantState: StateClosed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := NewBreaker(BreakerWithClock(newFakeClock()), BreakerWithMinRequests(1))
+			handler := ClientInterceptor(b)(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				return c.resp, c.err
+			})
+			resp, err := handler(context.Background(), &message.Request{})
+			assert.Equal(t, c.resp, resp)
+			assert.Equal(t, c.err, err)
+			assert.Equal(t, c.wantState, b.State())
+			if c.wantState != StateOpen {
+				return
+			}
+			// 打开之后请求不会发出去
+			_, err = handler(context.Background(), &message.Request{})
+			assert.Equal(t, rpc.NewStatus(rpc.CodeUnavailable, ErrOpen.Error()), err)
+		})
+	}
+}
+
+func TestServerInterceptor(t *testing.T) {
+	l := NewConcurrencyLimiter(1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	handler := ServerInterceptor(l)(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		close(started)
+		<-block
+		return &message.Response{}, nil
+	})
+	go func() {
+		_, _ = handler(context.Background(), &message.Request{})
+	}()
+	<-started
+	_, err := handler(context.Background(), &message.Request{})
+	assert.Equal(t, ErrOverloaded, err)
+	// 跨越 rpc 边界之后还能判断出来
+	st := rpc.FromError(err)
+	assert.Equal(t, rpc.CodeOverloaded, st.Code)
+	assert.True(t, errors.Is(st, ErrOverloaded))
+
+	close(block)
+	assert.Eventually(t, func() bool {
+		done, err := l.Acquire()
+		if err != nil {
+			return false
+		}
+		done()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,76 @@
+package breaker
+
+import (
+	"time"
+)
+
+// Clock 获取当前时间, 测试的时候可以换成假的时钟
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// window 按照时间划分的滑动窗口, 窗口由 len(buckets) 个桶组成, 每个桶 bucketSize 长
+// 时间往前走的时候, 过期的桶会被重置, 所以不需要后台 goroutine
+// window 不是并发安全的, 由使用者加锁
+type window[T any] struct {
+	bucketSize time.Duration
+	buckets    []T
+	// indexes 每个桶对应的时间序号, 也就是 UnixNano / bucketSize
+	indexes []int64
+}
+
+func newWindow[T any](size time.Duration, buckets int) *window[T] {
+	if buckets <= 0 {
+		buckets = 1
+	}
+	bucketSize := size / time.Duration(buckets)
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &window[T]{
+		bucketSize: bucketSize,
+		buckets:    make([]T, buckets),
+		indexes:    make([]int64, buckets),
+	}
+}
+
+// current 返回 now 所在的桶
+func (w *window[T]) current(now time.Time) *T {
+	idx := now.UnixNano() / int64(w.bucketSize)
+	pos := int(idx % int64(len(w.buckets)))
+	if w.indexes[pos] != idx {
+		var zero T
+		w.buckets[pos] = zero
+		w.indexes[pos] = idx
+	}
+	return &w.buckets[pos]
+}
+
+// each 遍历还在窗口里面的桶, includeCurrent 为 false 的时候跳过 now 所在的桶, 因为它的数据还不完整
+func (w *window[T]) each(now time.Time, includeCurrent bool, fn func(b *T)) {
+	idx := now.UnixNano() / int64(w.bucketSize)
+	for i := range w.buckets {
+		if w.indexes[i] <= idx-int64(len(w.buckets)) || w.indexes[i] > idx {
+			continue
+		}
+		if !includeCurrent && w.indexes[i] == idx {
+			continue
+		}
+		fn(&w.buckets[i])
+	}
+}
+
+// reset 清空所有的桶
+func (w *window[T]) reset() {
+	var zero T
+	for i := range w.buckets {
+		w.buckets[i] = zero
+		w.indexes[i] = 0
+	}
+}
@@ -0,0 +1,159 @@
+package micro
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/retry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	// 注册客户端的健康检查实现, 服务配置里面的 healthCheckConfig 依赖它
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type Client struct {
+	insecure bool
+	rb       resolver.Builder
+	balancer balancer.Builder
+	policies *retry.Config
+
+	healthCheck   bool
+	healthService string
+}
+
+type ClientOption func(c *Client)
+
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ClientWithInsecure 不使用 TLS
+func ClientWithInsecure() ClientOption {
+	return func(c *Client) {
+		c.insecure = true
+	}
+}
+
+// ClientWithRegistry 使用注册中心做服务发现, timeout 是每次从注册中心拉取服务列表的超时时间
+func ClientWithRegistry(r registry.Registry, timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.rb = NewResolverBuilder(r, timeout)
+	}
+}
+
+// ClientWithPickerBuilder 把负载均衡算法注册到 gRPC 里面, name 就是负载均衡算法的名字
+// 注意 balancer.Register 是全局注册, 同名的会被覆盖
+func ClientWithPickerBuilder(name string, b base.PickerBuilder) ClientOption {
+	return func(c *Client) {
+		builder := base.NewBalancerBuilder(name, b, base.Config{HealthCheck: true})
+		balancer.Register(builder)
+		c.balancer = builder
+	}
+}
+
+// ClientWithHealthCheck 用标准的 gRPC 健康检查协议检查每一个实例, 不是 SERVING 的实例不会交给负载均衡算法
+// service 是检查的服务名, 为空代表检查整个服务器, micro.Server 用注册的名字作为服务名
+// 只对 ClientWithPickerBuilder 注册的负载均衡算法生效
+func ClientWithHealthCheck(service string) ClientOption {
+	return func(c *Client) {
+		c.healthCheck = true
+		c.healthService = service
+	}
+}
+
+// ClientWithCallPolicy 设置按照服务和方法区分的调用策略: 重试, 超时和对冲
+// 服务名是 proto 里面的全名, 比如 package.UserService
+// 只有 Idempotent 的方法才会重试, 没有设置 Retryable 的时候只重试 codes.Unavailable
+func ClientWithCallPolicy(cfg retry.Config) ClientOption {
+	return func(c *Client) {
+		c.policies = &cfg
+	}
+}
+
+// Dial 建立到服务的连接, service 就是服务名, 也就是注册到注册中心的名字
+func (c *Client) Dial(ctx context.Context, service string, dialOptions ...grpc.DialOption) (*grpc.ClientConn, error) {
+	address := service
+	opts := make([]grpc.DialOption, 0, len(dialOptions)+3)
+	if c.rb != nil {
+		// 格式是 registry:///service-name, 由 grpcResolverBuilder 负责解析
+		address = fmt.Sprintf("%s:///%s", c.rb.Scheme(), service)
+		opts = append(opts, grpc.WithResolvers(c.rb))
+	}
+	if c.insecure {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if c.balancer != nil {
+		cfg := fmt.Sprintf(`"loadBalancingConfig": [{"%s": {}}]`, c.balancer.Name())
+		if c.healthCheck {
+			cfg += fmt.Sprintf(`, "healthCheckConfig": {"serviceName": %q}`, c.healthService)
+		}
+		opts = append(opts, grpc.WithDefaultServiceConfig("{"+cfg+"}"))
+	}
+	if c.policies != nil {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(callPolicyInterceptor(*c.policies)))
+	}
+	// 用户传入的放最后, 可以覆盖掉我们的默认配置
+	opts = append(opts, dialOptions...)
+	return grpc.DialContext(ctx, address, opts...)
+}
+
+// RetryOn 返回一个判断函数, 错误码在 cs 里面才重试
+func RetryOn(cs ...codes.Code) func(err error) bool {
+	return func(err error) bool {
+		code := status.Code(err)
+		for _, c := range cs {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func callPolicyInterceptor(cfg retry.Config) grpc.UnaryClientInterceptor {
+	retryable := RetryOn(codes.Unavailable)
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		service, name := retry.SplitMethod(method)
+		p := cfg.Policy(service, name)
+		if p == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		msg, ok := reply.(proto.Message)
+		if !ok {
+			// 对冲的时候多个请求同时在跑, 不能共用一个 reply, 不是 proto.Message 就没办法复制, 只能退化成顺序重试
+			if p.HedgingDelay > 0 {
+				cp := *p
+				cp.HedgingDelay = 0
+				p = &cp
+			}
+			_, err := retry.Do(ctx, p, retryable, func(ctx context.Context) (struct{}, error) {
+				return struct{}{}, invoker(ctx, method, req, reply, cc, opts...)
+			})
+			return err
+		}
+		// 剩下的超时时间 gRPC 会通过 grpc-timeout 传给服务端
+		res, err := retry.Do(ctx, p, retryable, func(ctx context.Context) (proto.Message, error) {
+			r := msg.ProtoReflect().New().Interface()
+			return r, invoker(ctx, method, req, r, cc, opts...)
+		})
+		if err != nil {
+			return err
+		}
+		proto.Reset(msg)
+		proto.Merge(msg, res)
+		return nil
+	}
+}
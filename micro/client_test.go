@@ -0,0 +1,327 @@
+package micro
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/example/proto/gen"
+	"github.com/startdusk/go-libs/micro/loadbalance"
+	"github.com/startdusk/go-libs/micro/loadbalance/roundrobin"
+	"github.com/startdusk/go-libs/micro/registry/memory"
+	"github.com/startdusk/go-libs/micro/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func Test_ClientDial(t *testing.T) {
+	r := memory.NewRegistry()
+	server := NewServer("user-service", ServerWithRegistry(r), ServerWithTimeout(time.Second))
+	gen.RegisterUserServiceServer(server, &userServiceServer{})
+	go func() {
+		_ = server.Start("127.0.0.1:0")
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+	// 等待服务端注册成功
+	require.Eventually(t, func() bool {
+		ins, _ := r.ListServices(context.Background(), "user-service")
+		return len(ins) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	pb := &firstPickerBuilder{}
+	client := NewClient(ClientWithInsecure(),
+		ClientWithRegistry(r, time.Second),
+		ClientWithPickerBuilder("first", pb))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, "user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	userClient := gen.NewUserServiceClient(conn)
+	resp, err := userClient.GetById(ctx, &gen.GetByIdReq{Id: 12})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(12), resp.User.Id)
+	// 请求必须经过我们注册的 picker
+	assert.True(t, pb.picked())
+}
+
+func Test_ClientHealthCheck(t *testing.T) {
+	r := memory.NewRegistry()
+	servers := make([]*Server, 0, 2)
+	for i := 1; i <= 2; i++ {
+		server := NewServer("user-service", ServerWithRegistry(r), ServerWithDrainDelay(0))
+		gen.RegisterUserServiceServer(server, &taggedUserService{tag: uint32(i)})
+		go func() {
+			_ = server.Start("127.0.0.1:0")
+		}()
+		servers = append(servers, server)
+	}
+	defer func() {
+		for _, server := range servers {
+			_ = server.Close()
+		}
+	}()
+	require.Eventually(t, func() bool {
+		ins, _ := r.ListServices(context.Background(), "user-service")
+		return len(ins) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	// 只改 gRPC 的健康状态, 注册中心里面还是健康的, 只能靠健康检查过滤掉
+	servers[0].health.SetServingStatus("user-service", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	client := NewClient(ClientWithInsecure(),
+		ClientWithRegistry(r, time.Second),
+		ClientWithPickerBuilder("health_round_robin", &roundrobin.Builder{}),
+		ClientWithHealthCheck("user-service"))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, "user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	userClient := gen.NewUserServiceClient(conn)
+	for i := 0; i < 10; i++ {
+		resp, err := userClient.GetById(ctx, &gen.GetByIdReq{Id: 12})
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), resp.User.Status)
+	}
+}
+
+func Test_ClientOutlier(t *testing.T) {
+	r := memory.NewRegistry()
+	servers := make([]*Server, 0, 2)
+	for i := 1; i <= 2; i++ {
+		server := NewServer("user-service", ServerWithRegistry(r), ServerWithDrainDelay(0))
+		// 第一个实例一直返回 Unavailable
+		gen.RegisterUserServiceServer(server, &taggedUserService{tag: uint32(i), fail: i == 1})
+		go func() {
+			_ = server.Start("127.0.0.1:0")
+		}()
+		servers = append(servers, server)
+	}
+	defer func() {
+		for _, server := range servers {
+			_ = server.Close()
+		}
+	}()
+	require.Eventually(t, func() bool {
+		ins, _ := r.ListServices(context.Background(), "user-service")
+		return len(ins) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	client := NewClient(ClientWithInsecure(),
+		ClientWithRegistry(r, time.Second),
+		ClientWithPickerBuilder("outlier_round_robin", loadbalance.NewOutlierBuilder(&roundrobin.Builder{},
+			loadbalance.OutlierWithMaxFailures(2),
+			loadbalance.OutlierWithEjection(time.Minute, time.Minute))))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := client.Dial(ctx, "user-service", grpc.WithBlock())
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	userClient := gen.NewUserServiceClient(conn)
+	failures := 0
+	for i := 0; i < 20; i++ {
+		_, err = userClient.GetById(ctx, &gen.GetByIdReq{Id: 12})
+		if err != nil {
+			failures++
+		}
+	}
+	// 连续失败两次之后就被摘除了
+	assert.Equal(t, 2, failures)
+}
+
+func Test_ClientCallPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy *retry.Policy
+		// failures 前几次调用返回的错误码
+		failures []codes.Code
+		// delay 第一次调用卡住的时间
+		delay time.Duration
+
+		wantCode     codes.Code
+		wantAttempts int32
+	}{
+		{
+			name:         "retry success",
+			policy:       &retry.Policy{MaxAttempts: 3, Idempotent: true, InitialBackoff: time.Millisecond},
+			failures:     []codes.Code{codes.Unavailable, codes.Unavailable},
+			wantCode:     codes.OK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "not idempotent",
+			policy:       &retry.Policy{MaxAttempts: 3},
+			failures:     []codes.Code{codes.Unavailable},
+			wantCode:     codes.Unavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "not retryable",
+			policy:       &retry.Policy{MaxAttempts: 3, Idempotent: true},
+			failures:     []codes.Code{codes.InvalidArgument},
+			wantCode:     codes.InvalidArgument,
+			wantAttempts: 1,
+		},
+		{
+			name: "retry on",
+			policy: &retry.Policy{MaxAttempts: 3, Idempotent: true,
+				Retryable: RetryOn(codes.Unavailable, codes.ResourceExhausted)},
+			failures:     []codes.Code{codes.ResourceExhausted},
+			wantCode:     codes.OK,
+			wantAttempts: 2,
+		},
+		{
+			name:         "timeout",
+			policy:       &retry.Policy{Timeout: 100 * time.Millisecond},
+			delay:        time.Second,
+			wantCode:     codes.DeadlineExceeded,
+			wantAttempts: 1,
+		},
+		{
+			name: "hedging",
+			policy: &retry.Policy{MaxAttempts: 2, Idempotent: true,
+				HedgingDelay: 50 * time.Millisecond},
+			delay:        time.Second,
+			wantCode:     codes.OK,
+			wantAttempts: 2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svc := &flakyUserServiceServer{failures: c.failures, delay: c.delay}
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			server := grpc.NewServer()
+			gen.RegisterUserServiceServer(server, svc)
+			go func() {
+				_ = server.Serve(lis)
+			}()
+			defer server.Stop()
+
+			client := NewClient(ClientWithInsecure(), ClientWithCallPolicy(retry.Config{
+				Policies: map[string]*retry.Policy{"test.UserService/GetById": c.policy},
+			}))
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			conn, err := client.Dial(ctx, lis.Addr().String())
+			require.NoError(t, err)
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			resp, err := gen.NewUserServiceClient(conn).GetById(ctx, &gen.GetByIdReq{Id: 12})
+			assert.Equal(t, c.wantCode, status.Code(err))
+			assert.Equal(t, c.wantAttempts, atomic.LoadInt32(&svc.attempts))
+			if err != nil {
+				return
+			}
+			assert.Equal(t, uint64(12), resp.User.Id)
+		})
+	}
+}
+
+// flakyUserServiceServer 前几次调用返回错误
+type flakyUserServiceServer struct {
+	gen.UnimplementedUserServiceServer
+	failures []codes.Code
+	delay    time.Duration
+	attempts int32
+}
+
+func (u *flakyUserServiceServer) GetById(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error) {
+	n := atomic.AddInt32(&u.attempts, 1)
+	if n == 1 && u.delay > 0 {
+		select {
+		case <-time.After(u.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if int(n) <= len(u.failures) {
+		return nil, status.Error(u.failures[n-1], "flaky")
+	}
+	return &gen.GetByIdResp{
+		User: &gen.User{
+			Id: req.Id,
+		},
+	}, nil
+}
+
+type userServiceServer struct {
+	gen.UnimplementedUserServiceServer
+}
+
+func (u *userServiceServer) GetById(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error) {
+	return &gen.GetByIdResp{
+		User: &gen.User{
+			Id: req.Id,
+		},
+	}, nil
+}
+
+// taggedUserService 用 Status 标记请求是哪个实例处理的
+type taggedUserService struct {
+	gen.UnimplementedUserServiceServer
+	tag  uint32
+	fail bool
+}
+
+func (u *taggedUserService) GetById(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error) {
+	if u.fail {
+		return nil, status.Error(codes.Unavailable, "mock unavailable")
+	}
+	return &gen.GetByIdResp{User: &gen.User{Id: req.Id, Status: u.tag}}, nil
+}
+
+// firstPickerBuilder 总是选第一个可用连接, 只用来验证 picker 确实被用上了
+type firstPickerBuilder struct {
+	mutex sync.Mutex
+	cnt   int
+}
+
+func (b *firstPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	for sc := range info.ReadySCs {
+		return &firstPicker{sc: sc, b: b}
+	}
+	return nil
+}
+
+func (b *firstPickerBuilder) picked() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.cnt > 0
+}
+
+type firstPicker struct {
+	sc balancer.SubConn
+	b  *firstPickerBuilder
+}
+
+func (p *firstPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.b.mutex.Lock()
+	p.b.cnt++
+	p.b.mutex.Unlock()
+	return balancer.PickResult{SubConn: p.sc}, nil
+}
@@ -0,0 +1,36 @@
+// rpcgen 根据服务接口生成客户端和服务端代码
+//
+// 用法: rpcgen user_service.go
+// 会在同一个目录下面生成 user_service.gen.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/startdusk/go-libs/micro/rpc/gen"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "用法: rpcgen <src.go>")
+		os.Exit(2)
+	}
+	if err := run(os.Args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(src string) error {
+	buf := &bytes.Buffer{}
+	// 先生成到内存里面, 失败了不会留下一个写了一半的文件
+	if err := gen.Gen(buf, src); err != nil {
+		return err
+	}
+	dst := strings.TrimSuffix(src, filepath.Ext(src)) + ".gen.go"
+	return os.WriteFile(dst, buf.Bytes(), 0644)
+}
@@ -0,0 +1,145 @@
+// rpcurl 通过服务端的反射服务调试 rpc 服务, 服务端需要开启 rpc.ServerWithReflection
+//
+// 用法:
+//
+//	rpcurl [-addr 127.0.0.1:8081] [-timeout 3s] list
+//	rpcurl [-addr 127.0.0.1:8081] [-timeout 3s] describe <service>
+//	rpcurl [-addr 127.0.0.1:8081] [-timeout 3s] call <service> <method> [json|-]
+//
+// call 的请求体用 JSON 表示, 不传默认是 {}, 传 - 表示从标准输入读取
+// 请求按 JSON 序列化协议发送, 服务端需要支持 JSON
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	jsonserialize "github.com/startdusk/go-libs/micro/rpc/serialize/json"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/raw"
+)
+
+const usage = `用法:
+  rpcurl [-addr 127.0.0.1:8081] [-timeout 3s] list
+  rpcurl [-addr 127.0.0.1:8081] [-timeout 3s] describe <service>
+  rpcurl [-addr 127.0.0.1:8081] [-timeout 3s] call <service> <method> [json|-]`
+
+var errUsage = errors.New(usage)
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rpcurl", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addr := fs.String("addr", "127.0.0.1:8081", "服务端地址")
+	timeout := fs.Duration("timeout", 3*time.Second, "单次调用的超时时间")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	client, err := rpc.NewClient(*addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	switch args[0] {
+	case "list":
+		if len(args) != 1 {
+			return errUsage
+		}
+		rc := &rpc.ReflectionClient{}
+		if err = client.InitService(rc); err != nil {
+			return err
+		}
+		resp, err := rc.ListServices(ctx, &rpc.ListServicesReq{})
+		if err != nil {
+			return err
+		}
+		for _, name := range resp.Services {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	case "describe":
+		if len(args) != 2 {
+			return errUsage
+		}
+		rc := &rpc.ReflectionClient{}
+		if err = client.InitService(rc); err != nil {
+			return err
+		}
+		desc, err := rc.DescribeService(ctx, &rpc.DescribeServiceReq{Name: args[1]})
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(desc, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout, string(data))
+		return err
+	case "call":
+		if len(args) < 3 || len(args) > 4 {
+			return errUsage
+		}
+		input := []byte("{}")
+		if len(args) == 4 {
+			input = []byte(args[3])
+			if args[3] == "-" {
+				if input, err = io.ReadAll(stdin); err != nil {
+					return err
+				}
+			}
+		}
+		if !json.Valid(input) {
+			return errors.New("rpcurl: 请求不是合法的 JSON")
+		}
+		var output []byte
+		// 服务端返回了业务错误的时候, 也可能同时返回了数据, 一起输出
+		callErr := rpc.Call(ctx, client, &rawJSON{}, args[1], args[2], input, &output)
+		if len(output) > 0 {
+			buf := &bytes.Buffer{}
+			if err = json.Indent(buf, output, "", "  "); err != nil {
+				return err
+			}
+			fmt.Fprintln(stdout, buf.String())
+		}
+		return callErr
+	default:
+		return errUsage
+	}
+}
+
+// rawJSON 原样发送用户输入的 JSON, 对服务端来说就是一个普通的 JSON 请求
+type rawJSON struct {
+	raw.Serializer
+}
+
+func (*rawJSON) Code() uint8 {
+	return (&jsonserialize.Serializer{}).Code()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type EchoReq struct {
+	Msg string `json:"msg"`
+}
+
+type EchoResp struct {
+	Msg string `json:"msg"`
+}
+
+type echoService struct{}
+
+func (echoService) Name() string {
+	return "echo-service"
+}
+
+func (echoService) Echo(ctx context.Context, req *EchoReq) (*EchoResp, error) {
+	if req.Msg == "" {
+		return nil, errors.New("empty")
+	}
+	return &EchoResp{Msg: req.Msg}, nil
+}
+
+func Test_run(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := rpc.NewServer(rpc.ServerWithReflection())
+	server.RegisterService(echoService{})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer func() {
+		_ = lis.Close()
+	}()
+	addr := lis.Addr().String()
+
+	cases := []struct {
+		name  string
+		args  []string
+		stdin string
+
+		wantOut string
+		wantErr string
+	}{
+		{
+			name:    "list",
+			args:    []string{"-addr", addr, "list"},
+			wantOut: "echo-service\nrpc.Reflection\n",
+		},
+		{
+			name:    "describe",
+			args:    []string{"-addr", addr, "describe", "echo-service"},
+			wantOut: `"Name": "Echo"`,
+		},
+		{
+			name:    "call",
+			args:    []string{"-addr", addr, "call", "echo-service", "Echo", `{"msg":"hello"}`},
+			wantOut: "{\n  \"msg\": \"hello\"\n}\n",
+		},
+		{
+			name:    "call stdin",
+			args:    []string{"-addr", addr, "call", "echo-service", "Echo", "-"},
+			stdin:   `{"msg":"world"}`,
+			wantOut: "{\n  \"msg\": \"world\"\n}\n",
+		},
+		{
+			name:    "call error",
+			args:    []string{"-addr", addr, "call", "echo-service", "Echo"},
+			wantErr: "empty",
+		},
+		{
+			name:    "invalid json",
+			args:    []string{"-addr", addr, "call", "echo-service", "Echo", `{"msg"`},
+			wantErr: "rpcurl: 请求不是合法的 JSON",
+		},
+		{
+			name:    "usage",
+			args:    []string{"-addr", addr, "describe"},
+			wantErr: usage,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := run(context.Background(), c.args, strings.NewReader(c.stdin), out)
+			if c.wantErr != "" {
+				assert.EqualError(t, err, c.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, out.String(), c.wantOut)
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/startdusk/go-libs/micro"
 	"github.com/startdusk/go-libs/micro/example/proto/gen"
+	"github.com/startdusk/go-libs/micro/loadbalance"
 	"github.com/startdusk/go-libs/micro/loadbalance/roundrobin"
 	"github.com/startdusk/go-libs/micro/registry/etcd"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -23,7 +24,11 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	pickerBuilder := &roundrobin.Builder{}
+	// 先按照分组过滤, 再在过滤后的节点里面轮询
+	pickerBuilder := &loadbalance.FilterBuilder{
+		Filter:  loadbalance.RouteFilter,
+		Builder: &roundrobin.Builder{},
+	}
 	client := micro.NewClient(micro.ClientWithInsecure(),
 		micro.ClientWithRegistry(r, time.Second*3),
 		micro.ClientWithPickerBuilder(pickerBuilder.Name(), pickerBuilder))
@@ -38,6 +43,8 @@ func main() {
 	userClient := gen.NewUserServiceClient(conn)
 	for i := 0; i < 10; i++ {
 		ctx, cancel = context.WithTimeout(context.Background(), time.Second*3)
+		// 只会打到灰度分组上
+		ctx = loadbalance.CtxWithRoute(ctx, "group", "canary")
 		resp, err := userClient.GetById(ctx, &gen.GetByIdReq{
 			Id: 12,
 		})
@@ -27,11 +27,17 @@ func main() {
 	for i := 0; i < 3; i++ {
 		idx := i
 		eg.Go(func() error {
-			server := micro.NewServer("user-service", micro.ServerWithRegistry(r), micro.ServerWithTimeout(time.Second*3))
+			// 第一个是灰度分组, 其余的是正常分组
+			group := "normal"
+			if idx == 0 {
+				group = "canary"
+			}
+			server := micro.NewServer("user-service", micro.ServerWithRegistry(r),
+				micro.ServerWithTimeout(time.Second*3), micro.ServerWithGroup(group))
 			defer server.Close()
 
 			us := &UserService{
-				name: fmt.Sprintf("server-%d", idx),
+				name: fmt.Sprintf("server-%d-%s", idx, group),
 			}
 			gen.RegisterUserServiceServer(server, us)
 			fmt.Println("启动服务器: " + us.name)
@@ -26,8 +26,8 @@ func main() {
 	// 用户必须要在确认好 UserService 已经完全准备好之后才能启动并且注册
 	gen.RegisterUserServiceServer(server, us)
 	fmt.Println("启动服务器")
-	if err = server.Start(":8081"); err != nil {
+	// 收到 SIGINT 或者 SIGTERM 之后, 先注销, 再等请求处理完, 最后退出
+	if err = server.Run(":8081"); err != nil {
 		fmt.Println(err)
 	}
-	server.Close()
 }
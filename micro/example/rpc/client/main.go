@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/startdusk/go-libs/micro/example/rpc/proto/gen"
 	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/proto"
 )
 
 func main() {
@@ -13,6 +14,9 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	defer func() {
+		_ = c.Close()
+	}()
 	us := &UserService{}
 	err = c.InitService(us)
 	usOneway := &UserService{}
@@ -35,8 +39,19 @@ func main() {
 
 	fmt.Printf("收到错误信息: %s \n", err.Error())
 
+	// 客户端的序列化协议是固定的, protobuf 要单独建一个客户端
+	pc, err := rpc.NewClient("0.0.0.0:8081", rpc.ClientWithSerializer(&proto.Serializer{}))
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = pc.Close()
+	}()
 	usProto := &UserServiceProto{}
-	err = c.InitService(usProto)
+	err = pc.InitService(usProto)
+	if err != nil {
+		panic(err)
+	}
 	presp, err := usProto.GetById(context.Background(), &gen.GetByIdReq{})
 	if err != nil {
 		panic(err)
@@ -53,7 +68,7 @@ type UserService struct {
 	AlwaysError func(ctx context.Context, req *FindByUserIdReq) (*FindByUserIdResp, error)
 }
 
-func (u *UserService) ServiceName() string {
+func (u *UserService) Name() string {
 	return "user"
 }
 
@@ -63,6 +78,6 @@ type UserServiceProto struct {
 	GetById func(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error)
 }
 
-func (u *UserServiceProto) ServiceName() string {
+func (u *UserServiceProto) Name() string {
 	return "user-service-proto"
 }
@@ -10,9 +10,9 @@ func main() {
 	svr := rpc.NewServer()
 	svr.RegisterService(&UserService{})
 	svr.RegisterService(&UserServiceProto{})
-	svr.RegisterSerializer(json.Serializer{})
-	svr.RegisterSerializer(proto.Serializer{})
-	if err := svr.Start(":8081"); err != nil {
+	svr.RegisterSerializer(&json.Serializer{})
+	svr.RegisterSerializer(&proto.Serializer{})
+	if err := svr.Start("tcp", ":8081"); err != nil {
 		panic(err)
 	}
 }
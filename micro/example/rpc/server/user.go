@@ -26,6 +26,6 @@ func (u *UserService) AlwaysError(ctx context.Context, req *FindByUserIdReq) (*F
 	return nil, errors.New("this is an error")
 }
 
-func (u *UserService) ServiceName() string {
+func (u *UserService) Name() string {
 	return "user"
 }
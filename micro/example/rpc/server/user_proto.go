@@ -17,6 +17,6 @@ func (u *UserServiceProto) GetById(ctx context.Context, req *gen.GetByIdReq) (*g
 	}, nil
 }
 
-func (u UserServiceProto) ServiceName() string {
+func (u UserServiceProto) Name() string {
 	return "user-service-proto"
 }
@@ -2,6 +2,7 @@ package micro
 
 import (
 	"context"
+	"github.com/startdusk/go-libs/micro/loadbalance"
 	"github.com/startdusk/go-libs/micro/registry"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
@@ -28,8 +29,15 @@ func (r *grpcResolverBuilder) Build(target resolver.Target, cc resolver.ClientCo
 		close:    make(chan struct{}, 1),
 		timeout:  r.timeout,
 	}
+	// 先订阅再拉取, 不然拉取和订阅之间发生的变化就丢了
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := res.registry.Subscribe(ctx, target.Endpoint)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
 	res.resolve()
-	go res.watch()
+	go res.watch(events, cancel)
 	return res, nil
 }
 
@@ -66,14 +74,28 @@ func (g *grpcResolver) Close() {
 	g.close <- struct{}{}
 }
 
-func (g *grpcResolver) watch() {
-	events, err := g.registry.Subscribe(context.Background(), g.target.Endpoint)
-	if err != nil {
-		g.cc.ReportError(err)
-	}
+func (g *grpcResolver) watch(events <-chan registry.Event, cancel context.CancelFunc) {
+	// cancel 重新订阅之后会变, 所以要包一层
+	defer func() {
+		cancel()
+	}()
+	backoff := minResubscribeBackoff
 	for {
 		select {
-		case <-events:
+		case _, ok := <-events:
+			if !ok {
+				// 注册中心关掉了订阅, 比如说 etcd 的历史版本被 compact 掉了
+				// 等一会儿重新订阅, 再全量拉取一次, 不然就一直用着旧的地址列表
+				cancel()
+				newEvents, newCancel, ok := g.resubscribe(&backoff)
+				if !ok {
+					return
+				}
+				events, cancel = newEvents, newCancel
+				g.resolve()
+				continue
+			}
+			backoff = minResubscribeBackoff
 			// 一种做法就是我们这边区别处理不同事件类型，然后更新数据
 			// switch event.Type {
 			//
@@ -87,6 +109,33 @@ func (g *grpcResolver) watch() {
 	}
 }
 
+const (
+	minResubscribeBackoff = 100 * time.Millisecond
+	maxResubscribeBackoff = 10 * time.Second
+)
+
+// resubscribe 一直重试到订阅成功, 每次失败等待的时间翻倍, resolver 被关闭了就返回 false
+func (g *grpcResolver) resubscribe(backoff *time.Duration) (<-chan registry.Event, context.CancelFunc, bool) {
+	for {
+		select {
+		case <-time.After(*backoff):
+		case <-g.close:
+			return nil, nil, false
+		}
+		*backoff *= 2
+		if *backoff > maxResubscribeBackoff {
+			*backoff = maxResubscribeBackoff
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := g.registry.Subscribe(ctx, g.target.Endpoint)
+		if err == nil {
+			return events, cancel, true
+		}
+		cancel()
+		g.cc.ReportError(err)
+	}
+}
+
 func (g *grpcResolver) resolve() {
 	serviceName := g.target.Endpoint
 	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
@@ -94,14 +143,25 @@ func (g *grpcResolver) resolve() {
 	cancel()
 	if err != nil {
 		g.cc.ReportError(err)
+		return
 	}
 
 	address := make([]resolver.Address, 0, len(instances))
 	for _, ins := range instances {
+		if !ins.Health.Available() {
+			// 不健康的实例直接不给 gRPC, 这样不管用什么负载均衡算法都不会选中它
+			continue
+		}
 		address = append(address, resolver.Address{
 			Addr:       ins.Address,
 			ServerName: ins.Name,
-			Attributes: attributes.New("weight", ins.Weight),
+			Attributes: attributes.New(
+				loadbalance.AttrWeight, ins.Weight,
+				loadbalance.AttrGroup, ins.Group,
+				loadbalance.AttrVersion, ins.Version,
+				loadbalance.AttrTags, ins.Tags,
+				loadbalance.AttrHealth, ins.Health,
+			),
 		})
 	}
 	err = g.cc.UpdateState(resolver.State{
@@ -0,0 +1,142 @@
+package micro
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/loadbalance"
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/registry/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+func Test_grpcResolver_resolve(t *testing.T) {
+	r := memory.NewRegistry()
+	ctx := context.Background()
+	instances := []registry.ServiceInstance{
+		{
+			Name:    "user-service",
+			Address: "127.0.0.1:8081",
+			Weight:  10,
+			Group:   "canary",
+			Version: "v2",
+			Tags:    map[string]string{"region": "cn"},
+			Health:  registry.HealthStatusServing,
+		},
+		// 不健康的实例不会给 gRPC
+		{Name: "user-service", Address: "127.0.0.1:8082", Health: registry.HealthStatusNotServing},
+	}
+	for _, si := range instances {
+		require.NoError(t, r.Register(ctx, si))
+	}
+
+	cc := &mockClientConn{}
+	res, err := NewResolverBuilder(r, time.Second).Build(resolver.Target{Endpoint: "user-service"}, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer res.Close()
+
+	state := cc.lastState()
+	require.Len(t, state.Addresses, 1)
+	addr := state.Addresses[0]
+	assert.Equal(t, "127.0.0.1:8081", addr.Addr)
+	assert.Equal(t, 10, loadbalance.Weight(addr))
+	assert.Equal(t, "canary", loadbalance.Group(addr))
+	assert.Equal(t, "v2", loadbalance.Version(addr))
+	assert.Equal(t, map[string]string{"region": "cn"}, loadbalance.Tags(addr))
+	assert.Equal(t, registry.HealthStatusServing, loadbalance.Health(addr))
+
+	// 实例恢复健康之后, 通过订阅更新到 gRPC
+	healthy := instances[1]
+	healthy.Health = registry.HealthStatusServing
+	require.NoError(t, r.Register(ctx, healthy))
+	assert.Eventually(t, func() bool {
+		return len(cc.lastState().Addresses) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_grpcResolver_resubscribe(t *testing.T) {
+	r := &closingRegistry{Registry: memory.NewRegistry(), closeFirst: make(chan struct{})}
+	ctx := context.Background()
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}
+	require.NoError(t, r.Register(ctx, si))
+
+	cc := &mockClientConn{}
+	res, err := NewResolverBuilder(r, time.Second).Build(resolver.Target{Endpoint: "user-service"}, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer res.Close()
+	require.Len(t, cc.lastState().Addresses, 1)
+
+	// 订阅断开期间的变化收不到事件, 重新订阅之后全量拉取才能发现
+	close(r.closeFirst)
+	require.NoError(t, r.Register(ctx, registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082"}))
+	assert.Eventually(t, func() bool {
+		return len(cc.lastState().Addresses) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// 重新订阅之后还能收到事件
+	require.NoError(t, r.UnRegister(ctx, si))
+	assert.Eventually(t, func() bool {
+		return len(cc.lastState().Addresses) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// closingRegistry 第一次订阅返回的 channel 在 closeFirst 被关闭的时候关闭, 之后收不到任何事件
+type closingRegistry struct {
+	*memory.Registry
+	closeFirst chan struct{}
+
+	mutex      sync.Mutex
+	subscribed bool
+}
+
+func (c *closingRegistry) Subscribe(ctx context.Context, serviceName string) (<-chan registry.Event, error) {
+	c.mutex.Lock()
+	first := !c.subscribed
+	c.subscribed = true
+	c.mutex.Unlock()
+	if !first {
+		return c.Registry.Subscribe(ctx, serviceName)
+	}
+	res := make(chan registry.Event)
+	go func() {
+		<-c.closeFirst
+		close(res)
+	}()
+	return res, nil
+}
+
+type mockClientConn struct {
+	mutex  sync.Mutex
+	states []resolver.State
+}
+
+func (m *mockClientConn) UpdateState(state resolver.State) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.states = append(m.states, state)
+	return nil
+}
+
+func (m *mockClientConn) lastState() resolver.State {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.states) == 0 {
+		return resolver.State{}
+	}
+	return m.states[len(m.states)-1]
+}
+
+func (m *mockClientConn) ReportError(err error) {}
+
+func (m *mockClientConn) NewAddress(addresses []resolver.Address) {}
+
+func (m *mockClientConn) NewServiceConfig(serviceConfig string) {}
+
+func (m *mockClientConn) ParseServiceConfig(serviceConfigJSON string) *serviceconfig.ParseResult {
+	return nil
+}
@@ -0,0 +1,86 @@
+package loadbalance
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// ErrNoMatchedSubConn 没有节点能通过过滤
+// 这里不能返回 balancer.ErrNoSubConnAvailable, 不然 gRPC 会一直阻塞等新的 picker, 直到超时
+var ErrNoMatchedSubConn = errors.New("micro: 没有符合条件的节点")
+
+// FilterBuilder 先用 Filter 过滤节点, 再交给 Builder 构造出来的负载均衡算法去挑选
+// 比如说 &FilterBuilder{Filter: RouteFilter, Builder: &roundrobin.Builder{}}
+// 就是在满足路由条件的节点里面轮询
+type FilterBuilder struct {
+	// Filter 为 nil 的话所有节点都能通过
+	Filter Filter
+	// Builder 真正挑选节点的负载均衡算法, 不能为 nil
+	Builder base.PickerBuilder
+}
+
+func (b *FilterBuilder) Name() string {
+	return "FILTER"
+}
+
+func (b *FilterBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	connections := make([]filterConn, 0, len(info.ReadySCs))
+	for c, ci := range info.ReadySCs {
+		connections = append(connections, filterConn{c: c, ci: ci})
+	}
+	return &FilterBalancer{
+		connections: connections,
+		filter:      b.Filter,
+		builder:     b.Builder,
+		pickers:     make(map[string]balancer.Picker, 4),
+	}
+}
+
+// FilterBalancer 过滤之后的节点集合一般只有有限的几种(比如说按照分组),
+// 所以每一种集合构造一次 Picker 缓存起来, 这样轮询, 最少活跃数这种有状态的算法也能正常工作
+type FilterBalancer struct {
+	connections []filterConn
+	filter      Filter
+	builder     base.PickerBuilder
+
+	mutex   sync.Mutex
+	pickers map[string]balancer.Picker
+}
+
+func (b *FilterBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(b.connections) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	var key strings.Builder
+	readySCs := make(map[balancer.SubConn]base.SubConnInfo, len(b.connections))
+	for i, c := range b.connections {
+		if b.filter != nil && !b.filter(info, c.ci.Address) {
+			continue
+		}
+		readySCs[c.c] = c.ci
+		key.WriteString(strconv.Itoa(i))
+		key.WriteByte(',')
+	}
+	if len(readySCs) == 0 {
+		return balancer.PickResult{}, ErrNoMatchedSubConn
+	}
+
+	b.mutex.Lock()
+	picker, ok := b.pickers[key.String()]
+	if !ok {
+		picker = b.builder.Build(base.PickerBuildInfo{ReadySCs: readySCs})
+		b.pickers[key.String()] = picker
+	}
+	b.mutex.Unlock()
+	return picker.Pick(info)
+}
+
+type filterConn struct {
+	c  balancer.SubConn
+	ci base.SubConnInfo
+}
@@ -0,0 +1,123 @@
+package loadbalance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestFilterBalancer_Pick(t *testing.T) {
+	canary := SubConn{name: "canary"}
+	normal1 := SubConn{name: "normal-1"}
+	normal2 := SubConn{name: "normal-2"}
+	readySCs := map[balancer.SubConn]base.SubConnInfo{
+		canary:  {Address: resolver.Address{Addr: "127.0.0.1:8081", Attributes: attributes.New(AttrGroup, "canary")}},
+		normal1: {Address: resolver.Address{Addr: "127.0.0.1:8082", Attributes: attributes.New(AttrGroup, "normal")}},
+		normal2: {Address: resolver.Address{Addr: "127.0.0.1:8083", Attributes: attributes.New(AttrGroup, "normal")}},
+	}
+
+	cases := []struct {
+		name    string
+		b       *FilterBuilder
+		ctx     context.Context
+		wantErr error
+		wantSub []balancer.SubConn
+	}{
+		{
+			name: "no filter",
+			b:    &FilterBuilder{Builder: &recordBuilder{}},
+			ctx:  context.Background(),
+			// recordBuilder 只会选第一个节点, 所以只要求是三个里面的某一个
+			wantSub: []balancer.SubConn{canary, normal1, normal2},
+		},
+		{
+			name:    "canary",
+			b:       &FilterBuilder{Filter: RouteFilter, Builder: &recordBuilder{}},
+			ctx:     CtxWithRoute(context.Background(), "group", "canary"),
+			wantSub: []balancer.SubConn{canary},
+		},
+		{
+			name:    "normal",
+			b:       &FilterBuilder{Filter: RouteFilter, Builder: &recordBuilder{}},
+			ctx:     CtxWithRoute(context.Background(), "group", "normal"),
+			wantSub: []balancer.SubConn{normal1, normal2},
+		},
+		{
+			name:    "no matched",
+			b:       &FilterBuilder{Filter: RouteFilter, Builder: &recordBuilder{}},
+			ctx:     CtxWithRoute(context.Background(), "group", "unknown"),
+			wantErr: ErrNoMatchedSubConn,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			picker := c.b.Build(base.PickerBuildInfo{ReadySCs: readySCs})
+			res, err := picker.Pick(balancer.PickInfo{Ctx: c.ctx})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Contains(t, c.wantSub, res.SubConn)
+		})
+	}
+}
+
+func TestFilterBalancer_PickerCache(t *testing.T) {
+	rb := &recordBuilder{}
+	picker := (&FilterBuilder{Filter: RouteFilter, Builder: rb}).Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "canary"}: {Address: resolver.Address{Attributes: attributes.New(AttrGroup, "canary")}},
+			SubConn{name: "normal"}: {Address: resolver.Address{Attributes: attributes.New(AttrGroup, "normal")}},
+		},
+	})
+	for i := 0; i < 3; i++ {
+		_, err := picker.Pick(balancer.PickInfo{Ctx: CtxWithRoute(context.Background(), "group", "canary")})
+		require.NoError(t, err)
+		_, err = picker.Pick(balancer.PickInfo{Ctx: CtxWithRoute(context.Background(), "group", "normal")})
+		require.NoError(t, err)
+	}
+	// 两种过滤结果, 所以只构造两次
+	assert.Equal(t, 2, rb.cnt)
+}
+
+func TestFilterBalancer_NoConnections(t *testing.T) {
+	picker := (&FilterBuilder{Builder: &recordBuilder{}}).Build(base.PickerBuildInfo{})
+	_, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+	assert.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
+
+// recordBuilder 记录 Build 的次数, 构造出来的 picker 总是选第一个节点
+type recordBuilder struct {
+	cnt int
+}
+
+func (r *recordBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	r.cnt++
+	for c := range info.ReadySCs {
+		return &firstPicker{c: c}
+	}
+	return nil
+}
+
+type firstPicker struct {
+	c balancer.SubConn
+}
+
+func (f *firstPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{SubConn: f.c}, nil
+}
+
+type SubConn struct {
+	name string
+}
+
+func (s SubConn) UpdateAddresses(addrs []resolver.Address) {}
+
+func (s SubConn) Connect() {}
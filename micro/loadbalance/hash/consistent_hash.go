@@ -0,0 +1,97 @@
+package hash
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+var errKeyNotFound = errors.New("micro: 一致性哈希需要在 context 里面设置 key")
+
+type keyCtx struct{}
+
+// CtxWithKey 设置一致性哈希用的 key, 同样的 key 会落到同一个节点上
+func CtxWithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+func keyFromCtx(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	key, ok := ctx.Value(keyCtx{}).(string)
+	return key, ok
+}
+
+// Balancer 一致性哈希
+// 每个节点在哈希环上有 replicas 个虚拟节点, 请求的 key 哈希之后, 顺时针找到的第一个虚拟节点就是目标节点
+type Balancer struct {
+	// hashes 是排好序的虚拟节点哈希值
+	hashes []uint32
+	nodes  map[uint32]balancer.SubConn
+}
+
+func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(b.hashes) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	key, ok := keyFromCtx(info.Ctx)
+	if !ok {
+		return balancer.PickResult{}, errKeyNotFound
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(b.hashes), func(i int) bool {
+		return b.hashes[i] >= h
+	})
+	// 超过了环上最大的值, 就回到起点
+	if idx == len(b.hashes) {
+		idx = 0
+	}
+	return balancer.PickResult{
+		SubConn: b.nodes[b.hashes[idx]],
+		Done: func(info balancer.DoneInfo) {
+			// 一致性哈希不需要关心调用结果
+		},
+	}, nil
+}
+
+type Builder struct {
+	// Replicas 每个节点的虚拟节点数量, 默认是 10
+	Replicas int
+}
+
+func (b *Builder) Name() string {
+	return "CONSISTENT_HASH"
+}
+
+func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
+	replicas := b.Replicas
+	if replicas <= 0 {
+		replicas = 10
+	}
+	hashes := make([]uint32, 0, len(info.ReadySCs)*replicas)
+	nodes := make(map[uint32]balancer.SubConn, len(info.ReadySCs)*replicas)
+	for c, ci := range info.ReadySCs {
+		for i := 0; i < replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + ci.Address.Addr))
+			// 哈希冲突的话后来的直接忽略
+			if _, ok := nodes[h]; ok {
+				continue
+			}
+			hashes = append(hashes, h)
+			nodes[h] = c
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i] < hashes[j]
+	})
+	return &Balancer{
+		hashes: hashes,
+		nodes:  nodes,
+	}
+}
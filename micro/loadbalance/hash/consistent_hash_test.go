@@ -0,0 +1,103 @@
+package hash
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestBalancer_Pick(t *testing.T) {
+	b := (&Builder{}).Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "127.0.0.1:8080"}: {Address: resolver.Address{Addr: "127.0.0.1:8080"}},
+			SubConn{name: "127.0.0.1:8081"}: {Address: resolver.Address{Addr: "127.0.0.1:8081"}},
+			SubConn{name: "127.0.0.1:8082"}: {Address: resolver.Address{Addr: "127.0.0.1:8082"}},
+		},
+	})
+
+	cases := []struct {
+		name    string
+		b       balancer.Picker
+		ctx     context.Context
+		wantErr error
+	}{
+		{
+			name:    "no connections",
+			b:       (&Builder{}).Build(base.PickerBuildInfo{}),
+			ctx:     CtxWithKey(context.Background(), "user-1"),
+			wantErr: balancer.ErrNoSubConnAvailable,
+		},
+		{
+			name:    "no key",
+			b:       b,
+			ctx:     context.Background(),
+			wantErr: errKeyNotFound,
+		},
+		{
+			name: "key",
+			b:    b,
+			ctx:  CtxWithKey(context.Background(), "user-1"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := c.b.Pick(balancer.PickInfo{Ctx: c.ctx})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			// 同样的 key 总是落到同一个节点上
+			for i := 0; i < 10; i++ {
+				again, err := c.b.Pick(balancer.PickInfo{Ctx: c.ctx})
+				require.NoError(t, err)
+				assert.Equal(t, res.SubConn, again.SubConn)
+			}
+		})
+	}
+}
+
+func TestBuilder_Build(t *testing.T) {
+	readySCs := map[balancer.SubConn]base.SubConnInfo{
+		SubConn{name: "127.0.0.1:8080"}: {Address: resolver.Address{Addr: "127.0.0.1:8080"}},
+		SubConn{name: "127.0.0.1:8081"}: {Address: resolver.Address{Addr: "127.0.0.1:8081"}},
+	}
+	b := (&Builder{Replicas: 50}).Build(base.PickerBuildInfo{ReadySCs: readySCs}).(*Balancer)
+	assert.Len(t, b.hashes, 100)
+	for i := 1; i < len(b.hashes); i++ {
+		assert.Less(t, b.hashes[i-1], b.hashes[i])
+	}
+
+	// 加入一个新节点, 原来的 key 要么不动, 要么迁移到新节点上
+	before := make(map[string]balancer.SubConn, 100)
+	for i := 0; i < 100; i++ {
+		key := "user-" + strconv.Itoa(i)
+		res, err := b.Pick(balancer.PickInfo{Ctx: CtxWithKey(context.Background(), key)})
+		require.NoError(t, err)
+		before[key] = res.SubConn
+	}
+	newSC := SubConn{name: "127.0.0.1:8082"}
+	readySCs[newSC] = base.SubConnInfo{Address: resolver.Address{Addr: "127.0.0.1:8082"}}
+	b = (&Builder{Replicas: 50}).Build(base.PickerBuildInfo{ReadySCs: readySCs}).(*Balancer)
+	for key, sc := range before {
+		res, err := b.Pick(balancer.PickInfo{Ctx: CtxWithKey(context.Background(), key)})
+		require.NoError(t, err)
+		if res.SubConn != sc {
+			assert.Equal(t, newSC, res.SubConn)
+		}
+	}
+}
+
+type SubConn struct {
+	name string
+}
+
+func (s SubConn) UpdateAddresses(addrs []resolver.Address) {}
+
+func (s SubConn) Connect() {}
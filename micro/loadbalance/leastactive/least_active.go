@@ -0,0 +1,62 @@
+package leastactive
+
+import (
+	"math"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Balancer 最少活跃请求数
+// 每个节点记录正在处理的请求数, 挑选的时候选最少的那个
+type Balancer struct {
+	connections []*activeConn
+}
+
+func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(b.connections) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	var res *activeConn
+	var minActive uint32 = math.MaxUint32
+	for _, c := range b.connections {
+		// 这里并不是严格的最少, 读和加之间可能被别人插进来, 但是负载均衡不需要那么精确
+		active := atomic.LoadUint32(&c.active)
+		if active < minActive {
+			minActive = active
+			res = c
+		}
+	}
+	atomic.AddUint32(&res.active, 1)
+	return balancer.PickResult{
+		SubConn: res.c,
+		Done: func(info balancer.DoneInfo) {
+			// 请求结束, 活跃数减一
+			atomic.AddUint32(&res.active, ^uint32(0))
+		},
+	}, nil
+}
+
+type Builder struct{}
+
+func (b *Builder) Name() string {
+	return "LEAST_ACTIVE"
+}
+
+func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
+	connections := make([]*activeConn, 0, len(info.ReadySCs))
+	for c := range info.ReadySCs {
+		connections = append(connections, &activeConn{
+			c: c,
+		})
+	}
+	return &Balancer{
+		connections: connections,
+	}
+}
+
+type activeConn struct {
+	active uint32
+	c      balancer.SubConn
+}
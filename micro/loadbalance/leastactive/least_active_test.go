@@ -0,0 +1,101 @@
+package leastactive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestBalancer_Pick(t *testing.T) {
+	cases := []struct {
+		name       string
+		b          *Balancer
+		wantErr    error
+		wantSub    SubConn
+		wantActive uint32
+	}{
+		{
+			name:    "no connections",
+			b:       &Balancer{},
+			wantErr: balancer.ErrNoSubConnAvailable,
+		},
+		{
+			name: "least active",
+			b: &Balancer{
+				connections: []*activeConn{
+					{c: SubConn{name: "127.0.0.1:8080"}, active: 10},
+					{c: SubConn{name: "127.0.0.1:8081"}, active: 3},
+					{c: SubConn{name: "127.0.0.1:8082"}, active: 5},
+				},
+			},
+			wantSub:    SubConn{name: "127.0.0.1:8081"},
+			wantActive: 4,
+		},
+		{
+			name: "same active",
+			b: &Balancer{
+				connections: []*activeConn{
+					{c: SubConn{name: "127.0.0.1:8080"}, active: 1},
+					{c: SubConn{name: "127.0.0.1:8081"}, active: 1},
+				},
+			},
+			wantSub:    SubConn{name: "127.0.0.1:8080"},
+			wantActive: 2,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := c.b.Pick(balancer.PickInfo{})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.wantSub, res.SubConn)
+			var picked *activeConn
+			for _, ac := range c.b.connections {
+				if ac.c == res.SubConn {
+					picked = ac
+				}
+			}
+			assert.Equal(t, c.wantActive, picked.active)
+			// 请求结束之后活跃数要减回去
+			res.Done(balancer.DoneInfo{})
+			assert.Equal(t, c.wantActive-1, picked.active)
+		})
+	}
+}
+
+func TestBuilder_Build(t *testing.T) {
+	b := &Builder{}
+	picker := b.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "127.0.0.1:8080"}: {},
+			SubConn{name: "127.0.0.1:8081"}: {},
+		},
+	})
+	// 前两个请求都没有结束, 所以会分别落到两个节点上
+	first, err := picker.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	second, err := picker.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SubConn, second.SubConn)
+
+	// 第一个请求结束了, 下一个请求应该落到第一个节点上
+	first.Done(balancer.DoneInfo{})
+	third, err := picker.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, first.SubConn, third.SubConn)
+}
+
+type SubConn struct {
+	name string
+}
+
+func (s SubConn) UpdateAddresses(addrs []resolver.Address) {}
+
+func (s SubConn) Connect() {}
@@ -0,0 +1,311 @@
+package loadbalance
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+var errNotServing = errors.New("micro: 实例不健康")
+
+// Probe 主动探测被摘除的实例, 返回 nil 代表实例恢复了
+type Probe func(ctx context.Context, addr resolver.Address) error
+
+// HealthProbe 用标准的 gRPC 健康检查协议探测, service 为空代表检查整个服务器
+// 每次探测都会新建一个连接, 探测完就关掉, opts 至少要指定传输层的安全配置
+func HealthProbe(service string, opts ...grpc.DialOption) Probe {
+	return func(ctx context.Context, addr resolver.Address) error {
+		cc, err := grpc.DialContext(ctx, addr.Addr, opts...)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = cc.Close()
+		}()
+		resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return errNotServing
+		}
+		return nil
+	}
+}
+
+// OutlierBuilder 客户端的异常实例摘除
+// 连续失败 maxFailures 次的实例会被摘除一段时间, 时间到了之后探测一次, 成功了就恢复, 失败了摘除的时间翻倍
+// 没有设置 Probe 的时候, 放一个真实的请求过去当作探测, 和熔断器的半开状态一样
+// 状态是按照地址保存在 OutlierBuilder 上面的, 所以注册中心的变化导致 picker 重建也不会丢
+// 和 FilterBuilder 一样包在别的负载均衡算法外面, 比如说 NewOutlierBuilder(&roundrobin.Builder{})
+type OutlierBuilder struct {
+	builder *FilterBuilder
+
+	maxFailures int
+	// baseEjection 第一次摘除的时间, 之后每次翻倍, 最多 maxEjection
+	baseEjection time.Duration
+	maxEjection  time.Duration
+	// maxEjectionPercent 最多摘除多少比例的实例, 避免所有实例都被摘除之后没有实例可用
+	maxEjectionPercent int
+	isFailure          func(err error) bool
+	probe              Probe
+	probeTimeout       time.Duration
+	now                func() time.Time
+
+	mutex sync.Mutex
+	hosts map[string]*outlierHost
+	// total 最近一次构造 picker 的时候的实例数量
+	total int
+	// probeSeq 被动探测的请求的编号, 从 1 开始
+	probeSeq uint64
+}
+
+type OutlierOption func(b *OutlierBuilder)
+
+// OutlierWithMaxFailures 连续失败多少次就摘除, 默认 5 次
+func OutlierWithMaxFailures(n int) OutlierOption {
+	return func(b *OutlierBuilder) {
+		b.maxFailures = n
+	}
+}
+
+// OutlierWithEjection 第一次摘除 base, 之后每次翻倍, 最多 max, 默认是 30 秒和 5 分钟
+func OutlierWithEjection(base, max time.Duration) OutlierOption {
+	return func(b *OutlierBuilder) {
+		b.baseEjection = base
+		b.maxEjection = max
+	}
+}
+
+// OutlierWithMaxEjectionPercent 最多摘除的实例比例, 默认 50
+// 向下取整, 所以默认配置下只有一个实例的时候永远不会摘除
+func OutlierWithMaxEjectionPercent(percent int) OutlierOption {
+	return func(b *OutlierBuilder) {
+		b.maxEjectionPercent = percent
+	}
+}
+
+// OutlierWithFailure 判断一次调用是不是算失败
+// 默认只有 Unavailable, DeadlineExceeded, Internal 和 Unknown 算, 业务错误不能说明实例有问题
+func OutlierWithFailure(isFailure func(err error) bool) OutlierOption {
+	return func(b *OutlierBuilder) {
+		b.isFailure = isFailure
+	}
+}
+
+// OutlierWithProbe 用 probe 主动探测被摘除的实例, 不会再拿真实的请求去探测
+func OutlierWithProbe(probe Probe, timeout time.Duration) OutlierOption {
+	return func(b *OutlierBuilder) {
+		b.probe = probe
+		b.probeTimeout = timeout
+	}
+}
+
+func NewOutlierBuilder(builder base.PickerBuilder, opts ...OutlierOption) *OutlierBuilder {
+	b := &OutlierBuilder{
+		maxFailures:        5,
+		baseEjection:       30 * time.Second,
+		maxEjection:        5 * time.Minute,
+		maxEjectionPercent: 50,
+		isFailure:          defaultFailure,
+		probeTimeout:       time.Second,
+		now:                time.Now,
+		hosts:              make(map[string]*outlierHost, 8),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.builder = &FilterBuilder{Filter: b.available, Builder: builder}
+	return b
+}
+
+func defaultFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *OutlierBuilder) Name() string {
+	return "OUTLIER"
+}
+
+func (b *OutlierBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	addrs := make(map[balancer.SubConn]string, len(info.ReadySCs))
+	present := make(map[string]struct{}, len(info.ReadySCs))
+	for c, ci := range info.ReadySCs {
+		addrs[c] = ci.Address.Addr
+		present[ci.Address.Addr] = struct{}{}
+	}
+	b.mutex.Lock()
+	now := b.now()
+	for addr, h := range b.hosts {
+		// 下线的实例没必要记着, 除非它还在摘除中, 比如说只是连接暂时断开了
+		if _, ok := present[addr]; !ok && (!h.ejected || !now.Before(h.ejectedUntil)) {
+			delete(b.hosts, addr)
+		}
+	}
+	b.total = len(present)
+	b.mutex.Unlock()
+	return &outlierPicker{
+		b:      b,
+		picker: b.builder.Build(info),
+		addrs:  addrs,
+	}
+}
+
+// available 过滤掉被摘除的实例
+func (b *OutlierBuilder) available(info balancer.PickInfo, addr resolver.Address) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	h, ok := b.hosts[addr.Addr]
+	if !ok || !h.ejected {
+		return true
+	}
+	if h.probing || b.now().Before(h.ejectedUntil) {
+		return false
+	}
+	if b.probe != nil {
+		h.probing = true
+		go b.runProbe(addr)
+		return false
+	}
+	// 被动探测, 等真的选中了再标记成正在探测
+	return true
+}
+
+// picked 选中了一个摘除时间已经到了的实例, 这个请求就是探测, 结束之前不会再选中它
+// 返回探测的编号, 不是探测的请求返回 0, 只有这个编号的请求的结果才算探测的结果
+func (b *OutlierBuilder) picked(addr string) uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	h, ok := b.hosts[addr]
+	if !ok || !h.ejected || h.probing || b.probe != nil || b.now().Before(h.ejectedUntil) {
+		return 0
+	}
+	b.probeSeq++
+	h.probing = true
+	h.probeID = b.probeSeq
+	return h.probeID
+}
+
+func (b *OutlierBuilder) runProbe(addr resolver.Address) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.probeTimeout)
+	err := b.probe(ctx, addr)
+	cancel()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if h, ok := b.hosts[addr.Addr]; ok && h.probing {
+		b.probed(h, err == nil)
+	}
+}
+
+// record 记录一次调用的结果, probeID 是 picked 返回的探测的编号
+func (b *OutlierBuilder) record(addr string, probeID uint64, err error) {
+	failed := err != nil && b.isFailure(err)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	h, ok := b.hosts[addr]
+	if !ok {
+		if !failed {
+			return
+		}
+		h = &outlierHost{}
+		b.hosts[addr] = h
+	}
+	if h.ejected {
+		// 摘除之前发出去的请求的结果不算数, 只看探测的结果
+		if probeID != 0 && h.probing && h.probeID == probeID {
+			b.probed(h, !failed)
+		}
+		return
+	}
+	if !failed {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures >= b.maxFailures && b.ejectable() {
+		b.eject(h)
+	}
+}
+
+func (b *OutlierBuilder) probed(h *outlierHost, ok bool) {
+	h.probing = false
+	if ok {
+		*h = outlierHost{}
+		return
+	}
+	b.eject(h)
+}
+
+func (b *OutlierBuilder) ejectable() bool {
+	cnt := 0
+	for _, h := range b.hosts {
+		if h.ejected {
+			cnt++
+		}
+	}
+	return cnt < b.total*b.maxEjectionPercent/100
+}
+
+func (b *OutlierBuilder) eject(h *outlierHost) {
+	d := b.baseEjection
+	for i := 0; i < h.ejections && d < b.maxEjection; i++ {
+		d *= 2
+	}
+	if d > b.maxEjection {
+		d = b.maxEjection
+	}
+	h.ejected = true
+	h.ejections++
+	h.failures = 0
+	h.ejectedUntil = b.now().Add(d)
+}
+
+type outlierHost struct {
+	// failures 连续失败的次数
+	failures int
+	ejected  bool
+	// ejections 恢复之前被摘除的次数
+	ejections    int
+	ejectedUntil time.Time
+	// probing 正在探测, 探测结束之前不会被选中
+	probing bool
+	// probeID 被动探测的时候, 当作探测的那个请求的编号
+	probeID uint64
+}
+
+type outlierPicker struct {
+	b      *OutlierBuilder
+	picker balancer.Picker
+	addrs  map[balancer.SubConn]string
+}
+
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	res, err := p.picker.Pick(info)
+	if err != nil {
+		return res, err
+	}
+	addr := p.addrs[res.SubConn]
+	probeID := p.b.picked(addr)
+	done := res.Done
+	res.Done = func(di balancer.DoneInfo) {
+		if done != nil {
+			done(di)
+		}
+		p.b.record(addr, probeID, di.Err)
+	}
+	return res, nil
+}
@@ -0,0 +1,220 @@
+package loadbalance
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	outlierA = SubConn{name: "a"}
+	outlierB = SubConn{name: "b"}
+)
+
+func outlierInfo() base.PickerBuildInfo {
+	return base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+		outlierA: {Address: resolver.Address{Addr: "127.0.0.1:8081"}},
+		outlierB: {Address: resolver.Address{Addr: "127.0.0.1:8082"}},
+	}}
+}
+
+func TestOutlierBuilder_eject(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	notFound := status.Error(codes.NotFound, "not found")
+	cases := []struct {
+		name    string
+		percent int
+		// results 依次发给被选中的实例的调用结果
+		results []error
+		wantSub balancer.SubConn
+	}{
+		{
+			name:    "ejected",
+			percent: 50,
+			results: []error{unavailable, unavailable, unavailable},
+			wantSub: outlierB,
+		},
+		{
+			name:    "business error",
+			percent: 50,
+			results: []error{notFound, notFound, notFound},
+			wantSub: outlierA,
+		},
+		{
+			name:    "success resets",
+			percent: 50,
+			results: []error{unavailable, unavailable, nil, unavailable, unavailable},
+			wantSub: outlierA,
+		},
+		{
+			// 两个实例最多摘除一个, a 被摘除之后 b 怎么失败都不会被摘除
+			name:    "max ejection percent",
+			percent: 50,
+			results: []error{unavailable, unavailable, unavailable, unavailable, unavailable, unavailable},
+			wantSub: outlierB,
+		},
+		{
+			name:    "eject nothing",
+			percent: 0,
+			results: []error{unavailable, unavailable, unavailable},
+			wantSub: outlierA,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := NewOutlierBuilder(&sortedBuilder{},
+				OutlierWithMaxFailures(3),
+				OutlierWithMaxEjectionPercent(c.percent))
+			picker := b.Build(outlierInfo())
+			for _, err := range c.results {
+				res, pickErr := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+				require.NoError(t, pickErr)
+				res.Done(balancer.DoneInfo{Err: err})
+			}
+			res, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+			require.NoError(t, err)
+			assert.Equal(t, c.wantSub, res.SubConn)
+		})
+	}
+}
+
+func TestOutlierBuilder_passiveProbe(t *testing.T) {
+	now := time.Now()
+	b := NewOutlierBuilder(&sortedBuilder{},
+		OutlierWithMaxFailures(1),
+		OutlierWithEjection(time.Second, 10*time.Second))
+	b.now = func() time.Time {
+		return now
+	}
+	picker := b.Build(outlierInfo())
+	pick := func() balancer.PickResult {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		return res
+	}
+
+	pick().Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	assert.Equal(t, outlierB, pick().SubConn)
+
+	// 摘除时间到了, 放一个请求过去探测, 探测结束之前其它请求还是不会选中它
+	now = now.Add(time.Second)
+	probe := pick()
+	assert.Equal(t, outlierA, probe.SubConn)
+	assert.Equal(t, outlierB, pick().SubConn)
+	// 探测失败, 摘除时间翻倍
+	probe.Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	now = now.Add(time.Second)
+	assert.Equal(t, outlierB, pick().SubConn)
+	now = now.Add(time.Second)
+	probe = pick()
+	assert.Equal(t, outlierA, probe.SubConn)
+
+	// 探测成功, 恢复之后重新计数
+	probe.Done(balancer.DoneInfo{})
+	assert.Equal(t, outlierA, pick().SubConn)
+	assert.Equal(t, outlierA, pick().SubConn)
+
+	// picker 重建之后状态还在
+	pick().Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	picker = b.Build(outlierInfo())
+	assert.Equal(t, outlierB, pick().SubConn)
+}
+
+// TestOutlierBuilder_passiveProbeStale 摘除之前发出去的请求在探测的时候才结束, 不能当成探测的结果
+func TestOutlierBuilder_passiveProbeStale(t *testing.T) {
+	now := time.Now()
+	b := NewOutlierBuilder(&sortedBuilder{},
+		OutlierWithMaxFailures(2),
+		OutlierWithEjection(time.Second, 10*time.Second))
+	b.now = func() time.Time {
+		return now
+	}
+	picker := b.Build(outlierInfo())
+	pick := func() balancer.PickResult {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		return res
+	}
+
+	stale := pick()
+	assert.Equal(t, outlierA, stale.SubConn)
+	pick().Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	pick().Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	assert.Equal(t, outlierB, pick().SubConn)
+
+	now = now.Add(time.Second)
+	probe := pick()
+	assert.Equal(t, outlierA, probe.SubConn)
+	// 老的请求成功了也不会恢复, 探测还没有结束
+	stale.Done(balancer.DoneInfo{})
+	assert.Equal(t, outlierB, pick().SubConn)
+
+	probe.Done(balancer.DoneInfo{})
+	assert.Equal(t, outlierA, pick().SubConn)
+}
+
+func TestOutlierBuilder_activeProbe(t *testing.T) {
+	now := time.Now()
+	probeErr := make(chan error, 1)
+	probed := make(chan string, 1)
+	b := NewOutlierBuilder(&sortedBuilder{},
+		OutlierWithMaxFailures(1),
+		OutlierWithEjection(time.Second, 10*time.Second),
+		OutlierWithProbe(func(ctx context.Context, addr resolver.Address) error {
+			probed <- addr.Addr
+			return <-probeErr
+		}, time.Second))
+	// 探测在另外一个 goroutine 里面, 时间要加锁
+	var mutex sync.Mutex
+	b.now = func() time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return now
+	}
+	setNow := func(t time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		now = t
+	}
+	picker := b.Build(outlierInfo())
+	pick := func() balancer.PickResult {
+		res, err := picker.Pick(balancer.PickInfo{Ctx: context.Background()})
+		require.NoError(t, err)
+		return res
+	}
+
+	pick().Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	setNow(now.Add(time.Second))
+	// 主动探测的时候不会用真实的请求去探测
+	assert.Equal(t, outlierB, pick().SubConn)
+	assert.Equal(t, "127.0.0.1:8081", <-probed)
+	probeErr <- nil
+	assert.Eventually(t, func() bool {
+		return pick().SubConn == outlierA
+	}, time.Second, 10*time.Millisecond)
+}
+
+// sortedBuilder 构造出来的 picker 总是选地址最小的节点
+type sortedBuilder struct{}
+
+func (s *sortedBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	conns := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for c := range info.ReadySCs {
+		conns = append(conns, c)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return info.ReadySCs[conns[i]].Address.Addr < info.ReadySCs[conns[j]].Address.Addr
+	})
+	return &firstPicker{c: conns[0]}
+}
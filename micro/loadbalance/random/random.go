@@ -0,0 +1,42 @@
+package random
+
+import (
+	"math/rand"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Balancer 随机
+type Balancer struct {
+	connections []balancer.SubConn
+}
+
+func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(b.connections) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	idx := rand.Intn(len(b.connections))
+	return balancer.PickResult{
+		SubConn: b.connections[idx],
+		Done: func(info balancer.DoneInfo) {
+			// 随机不需要关心调用结果
+		},
+	}, nil
+}
+
+type Builder struct{}
+
+func (b *Builder) Name() string {
+	return "RANDOM"
+}
+
+func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
+	connections := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for c := range info.ReadySCs {
+		connections = append(connections, c)
+	}
+	return &Balancer{
+		connections: connections,
+	}
+}
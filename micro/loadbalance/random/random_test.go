@@ -0,0 +1,74 @@
+package random
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestBalancer_Pick(t *testing.T) {
+	cases := []struct {
+		name    string
+		b       *Balancer
+		wantErr error
+		wantSub []balancer.SubConn
+	}{
+		{
+			name:    "no connections",
+			b:       &Balancer{},
+			wantErr: balancer.ErrNoSubConnAvailable,
+		},
+		{
+			name: "one connection",
+			b: &Balancer{
+				connections: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}},
+			},
+			wantSub: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}},
+		},
+		{
+			name: "multiple connections",
+			b: &Balancer{
+				connections: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}, SubConn{name: "127.0.0.1:8081"}},
+			},
+			wantSub: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}, SubConn{name: "127.0.0.1:8081"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := c.b.Pick(balancer.PickInfo{})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Contains(t, c.wantSub, res.SubConn)
+			assert.NotNil(t, res.Done)
+		})
+	}
+}
+
+func TestBuilder_Build(t *testing.T) {
+	b := &Builder{}
+	picker := b.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "127.0.0.1:8080"}: {},
+			SubConn{name: "127.0.0.1:8081"}: {},
+		},
+	})
+	assert.Len(t, picker.(*Balancer).connections, 2)
+	res, err := picker.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	assert.Contains(t, []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}, SubConn{name: "127.0.0.1:8081"}}, res.SubConn)
+}
+
+type SubConn struct {
+	name string
+}
+
+func (s SubConn) UpdateAddresses(addrs []resolver.Address) {}
+
+func (s SubConn) Connect() {}
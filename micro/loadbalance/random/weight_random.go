@@ -0,0 +1,65 @@
+package random
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/startdusk/go-libs/micro/loadbalance"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// WeightBalancer 加权随机
+// 把所有节点的权重依次累加起来, 然后在 [0, 总权重) 里面随机一个数, 落在哪个区间就选哪个节点
+type WeightBalancer struct {
+	connections []*weightConn
+	totalWeight int
+}
+
+func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(b.connections) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	tgt := rand.Intn(b.totalWeight)
+	// connections 的 sumWeight 是递增的, 可以二分查找
+	idx := sort.Search(len(b.connections), func(i int) bool {
+		return b.connections[i].sumWeight > tgt
+	})
+	return balancer.PickResult{
+		SubConn: b.connections[idx].c,
+		Done: func(info balancer.DoneInfo) {
+			// 加权随机不需要关心调用结果
+		},
+	}, nil
+}
+
+type WeightBuilder struct{}
+
+func (b *WeightBuilder) Name() string {
+	return "WEIGHT_RANDOM"
+}
+
+func (b *WeightBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	connections := make([]*weightConn, 0, len(info.ReadySCs))
+	var totalWeight int
+	for c, ci := range info.ReadySCs {
+		weight := loadbalance.Weight(ci.Address)
+		totalWeight += weight
+		connections = append(connections, &weightConn{
+			c:         c,
+			weight:    weight,
+			sumWeight: totalWeight,
+		})
+	}
+	return &WeightBalancer{
+		connections: connections,
+		totalWeight: totalWeight,
+	}
+}
+
+type weightConn struct {
+	c      balancer.SubConn
+	weight int
+	// sumWeight 是包含自己在内的前缀和
+	sumWeight int
+}
@@ -0,0 +1,56 @@
+package random
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestWeightBalancer_Pick(t *testing.T) {
+	b := &WeightBalancer{}
+	_, err := b.Pick(balancer.PickInfo{})
+	assert.Equal(t, balancer.ErrNoSubConnAvailable, err)
+
+	// 权重为 0 的节点不存在, 所以只可能选中 weight-2
+	b = &WeightBalancer{
+		connections: []*weightConn{
+			{c: SubConn{name: "weight-2"}, weight: 2, sumWeight: 2},
+		},
+		totalWeight: 2,
+	}
+	for i := 0; i < 10; i++ {
+		res, err := b.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, SubConn{name: "weight-2"}, res.SubConn)
+	}
+}
+
+func TestWeightBuilder_Build(t *testing.T) {
+	b := &WeightBuilder{}
+	picker := b.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "weight-1000"}: {
+				Address: resolver.Address{Attributes: attributes.New("weight", 1000)},
+			},
+			// 没有设置权重的当成 1
+			SubConn{name: "weight-1"}: {},
+		},
+	})
+	wb := picker.(*WeightBalancer)
+	assert.Equal(t, 1001, wb.totalWeight)
+	// 前缀和是递增的, 最后一个就是总权重
+	assert.Equal(t, wb.totalWeight, wb.connections[len(wb.connections)-1].sumWeight)
+
+	picked := make(map[string]int, 2)
+	for i := 0; i < 1000; i++ {
+		res, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		picked[res.SubConn.(SubConn).name]++
+	}
+	assert.Greater(t, picked["weight-1000"], picked["weight-1"])
+}
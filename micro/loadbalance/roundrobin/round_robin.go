@@ -0,0 +1,49 @@
+package roundrobin
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Balancer 轮询
+type Balancer struct {
+	index       int32
+	connections []balancer.SubConn
+	length      int32
+}
+
+func (b *Balancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if b.length == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	idx := atomic.AddInt32(&b.index, 1)
+	c := b.connections[uint32(idx)%uint32(b.length)]
+	return balancer.PickResult{
+		SubConn: c,
+		Done: func(info balancer.DoneInfo) {
+			// 轮询不需要关心调用结果
+		},
+	}, nil
+}
+
+type Builder struct{}
+
+func (b *Builder) Name() string {
+	return "ROUND_ROBIN"
+}
+
+// Build 每次可用连接发生变化的时候, gRPC 都会调用 Build 重新构造一个 Balancer
+func (b *Builder) Build(info base.PickerBuildInfo) balancer.Picker {
+	connections := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for c := range info.ReadySCs {
+		connections = append(connections, c)
+	}
+	return &Balancer{
+		connections: connections,
+		// 第一次 AddInt32 之后是 0, 也就是从第一个开始
+		index:  -1,
+		length: int32(len(connections)),
+	}
+}
@@ -0,0 +1,101 @@
+package roundrobin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestBalancer_Pick(t *testing.T) {
+	cases := []struct {
+		name      string
+		b         *Balancer
+		wantErr   error
+		wantSub   SubConn
+		wantIndex int32
+	}{
+		{
+			name: "start",
+			b: &Balancer{
+				index:       -1,
+				connections: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}, SubConn{name: "127.0.0.1:8081"}},
+				length:      2,
+			},
+			wantSub:   SubConn{name: "127.0.0.1:8080"},
+			wantIndex: 0,
+		},
+		{
+			name: "end",
+			b: &Balancer{
+				index:       0,
+				connections: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}, SubConn{name: "127.0.0.1:8081"}},
+				length:      2,
+			},
+			wantSub:   SubConn{name: "127.0.0.1:8081"},
+			wantIndex: 1,
+		},
+		{
+			name: "wrap around",
+			b: &Balancer{
+				index:       1,
+				connections: []balancer.SubConn{SubConn{name: "127.0.0.1:8080"}, SubConn{name: "127.0.0.1:8081"}},
+				length:      2,
+			},
+			wantSub:   SubConn{name: "127.0.0.1:8080"},
+			wantIndex: 2,
+		},
+		{
+			name:    "no connections",
+			b:       &Balancer{index: -1},
+			wantErr: balancer.ErrNoSubConnAvailable,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			res, err := c.b.Pick(balancer.PickInfo{})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.wantSub, res.SubConn)
+			assert.NotNil(t, res.Done)
+			assert.Equal(t, c.wantIndex, c.b.index)
+		})
+	}
+}
+
+func TestBuilder_Build(t *testing.T) {
+	b := &Builder{}
+	picker := b.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "127.0.0.1:8080"}: {},
+			SubConn{name: "127.0.0.1:8081"}: {},
+			SubConn{name: "127.0.0.1:8082"}: {},
+		},
+	})
+	// 轮询一圈, 每个节点都会被选中一次
+	picked := make(map[balancer.SubConn]int, 3)
+	for i := 0; i < 6; i++ {
+		res, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		picked[res.SubConn]++
+	}
+	assert.Equal(t, map[balancer.SubConn]int{
+		SubConn{name: "127.0.0.1:8080"}: 2,
+		SubConn{name: "127.0.0.1:8081"}: 2,
+		SubConn{name: "127.0.0.1:8082"}: 2,
+	}, picked)
+}
+
+type SubConn struct {
+	name string
+}
+
+func (s SubConn) UpdateAddresses(addrs []resolver.Address) {}
+
+func (s SubConn) Connect() {}
@@ -0,0 +1,81 @@
+package roundrobin
+
+import (
+	"sync"
+
+	"github.com/startdusk/go-libs/micro/loadbalance"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// WeightBalancer 平滑的加权轮询
+// 每次挑选的时候, 所有节点的 currentWeight 都加上自己的 efficientWeight,
+// 然后选 currentWeight 最大的节点, 再把它的 currentWeight 减去总权重
+type WeightBalancer struct {
+	connections []*weightConn
+	mutex       sync.Mutex
+}
+
+func (b *WeightBalancer) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(b.connections) == 0 {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	var totalWeight int
+	var res *weightConn
+	b.mutex.Lock()
+	for _, c := range b.connections {
+		totalWeight += c.efficientWeight
+		c.currentWeight += c.efficientWeight
+		if res == nil || res.currentWeight < c.currentWeight {
+			res = c
+		}
+	}
+	res.currentWeight -= totalWeight
+	b.mutex.Unlock()
+	return balancer.PickResult{
+		SubConn: res.c,
+		Done: func(info balancer.DoneInfo) {
+			// 根据调用结果动态调整有效权重
+			// 失败了就降低, 成功了就慢慢恢复, 但是不超过原始权重
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			if info.Err != nil {
+				if res.efficientWeight > 1 {
+					res.efficientWeight--
+				}
+				return
+			}
+			if res.efficientWeight < res.weight {
+				res.efficientWeight++
+			}
+		},
+	}, nil
+}
+
+type WeightBuilder struct{}
+
+func (b *WeightBuilder) Name() string {
+	return "WEIGHT_ROUND_ROBIN"
+}
+
+func (b *WeightBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	connections := make([]*weightConn, 0, len(info.ReadySCs))
+	for c, ci := range info.ReadySCs {
+		weight := loadbalance.Weight(ci.Address)
+		connections = append(connections, &weightConn{
+			c:               c,
+			weight:          weight,
+			efficientWeight: weight,
+		})
+	}
+	return &WeightBalancer{
+		connections: connections,
+	}
+}
+
+type weightConn struct {
+	c               balancer.SubConn
+	weight          int
+	currentWeight   int
+	efficientWeight int
+}
@@ -0,0 +1,80 @@
+package roundrobin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestWeightBalancer_Pick(t *testing.T) {
+	b := &WeightBalancer{
+		connections: []*weightConn{
+			{c: SubConn{name: "weight-5"}, weight: 5, efficientWeight: 5},
+			{c: SubConn{name: "weight-1"}, weight: 1, efficientWeight: 1},
+			{c: SubConn{name: "weight-1-1"}, weight: 1, efficientWeight: 1},
+		},
+	}
+	// 平滑加权轮询的经典序列: a a b a c a a
+	wantNames := []string{"weight-5", "weight-5", "weight-1", "weight-5", "weight-1-1", "weight-5", "weight-5"}
+	for _, want := range wantNames {
+		res, err := b.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		assert.Equal(t, want, res.SubConn.(SubConn).name)
+	}
+	// 一轮下来, currentWeight 都回到 0
+	for _, c := range b.connections {
+		assert.Equal(t, 0, c.currentWeight)
+	}
+}
+
+func TestWeightBalancer_PickDone(t *testing.T) {
+	c := &weightConn{c: SubConn{name: "weight-2"}, weight: 2, efficientWeight: 2}
+	b := &WeightBalancer{connections: []*weightConn{c}}
+
+	res, err := b.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	res.Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	assert.Equal(t, 1, c.efficientWeight)
+
+	// 不会降到 1 以下
+	res.Done(balancer.DoneInfo{Err: errors.New("mock error")})
+	assert.Equal(t, 1, c.efficientWeight)
+
+	// 成功之后恢复, 但是不超过原始权重
+	res.Done(balancer.DoneInfo{})
+	assert.Equal(t, 2, c.efficientWeight)
+	res.Done(balancer.DoneInfo{})
+	assert.Equal(t, 2, c.efficientWeight)
+}
+
+func TestWeightBalancer_NoConnections(t *testing.T) {
+	b := &WeightBalancer{}
+	_, err := b.Pick(balancer.PickInfo{})
+	assert.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
+
+func TestWeightBuilder_Build(t *testing.T) {
+	b := &WeightBuilder{}
+	picker := b.Build(base.PickerBuildInfo{
+		ReadySCs: map[balancer.SubConn]base.SubConnInfo{
+			SubConn{name: "weight-3"}: {
+				Address: resolver.Address{Attributes: attributes.New("weight", 3)},
+			},
+			// 没有设置权重的当成 1
+			SubConn{name: "weight-1"}: {},
+		},
+	})
+	picked := make(map[string]int, 2)
+	for i := 0; i < 8; i++ {
+		res, err := picker.Pick(balancer.PickInfo{})
+		require.NoError(t, err)
+		picked[res.SubConn.(SubConn).name]++
+	}
+	assert.Equal(t, map[string]int{"weight-3": 6, "weight-1": 2}, picked)
+}
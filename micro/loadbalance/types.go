@@ -0,0 +1,114 @@
+package loadbalance
+
+import (
+	"context"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// grpcResolver 把服务实例的信息放在 resolver.Address 的 Attributes 里面, 这些是对应的 key
+const (
+	AttrWeight  = "weight"
+	AttrGroup   = "group"
+	AttrVersion = "version"
+	AttrTags    = "tags"
+	AttrHealth  = "health"
+)
+
+// Weight 取出 grpcResolver 放在 resolver.Address 里面的权重
+// 没有设置或者设置了非法值的, 都当成 1 来处理, 避免节点永远选不中
+func Weight(addr resolver.Address) int {
+	weight, ok := attr(addr, AttrWeight).(int)
+	if !ok || weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+func Group(addr resolver.Address) string {
+	group, _ := attr(addr, AttrGroup).(string)
+	return group
+}
+
+func Version(addr resolver.Address) string {
+	version, _ := attr(addr, AttrVersion).(string)
+	return version
+}
+
+func Tags(addr resolver.Address) map[string]string {
+	tags, _ := attr(addr, AttrTags).(map[string]string)
+	return tags
+}
+
+func Health(addr resolver.Address) registry.HealthStatus {
+	health, _ := attr(addr, AttrHealth).(registry.HealthStatus)
+	return health
+}
+
+func attr(addr resolver.Address, key string) any {
+	if addr.Attributes == nil {
+		return nil
+	}
+	return addr.Attributes.Value(key)
+}
+
+// Filter 返回 true 代表 addr 可以被选中
+type Filter func(info balancer.PickInfo, addr resolver.Address) bool
+
+type routeKey struct{}
+
+// CtxWithRoute 设置路由条件, 可以多次调用, 所有条件都满足的节点才会被 RouteFilter 选中
+// key 是 group 和 version 的时候匹配实例的分组和版本, 其余的 key 匹配实例的 Tags
+// 比如说 CtxWithRoute(ctx, "group", "canary") 就只会把请求发到灰度分组上
+func CtxWithRoute(ctx context.Context, key, value string) context.Context {
+	old, _ := ctx.Value(routeKey{}).(map[string]string)
+	// 复制一份, 不能修改父 context 里面的数据
+	routes := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		routes[k] = v
+	}
+	routes[key] = value
+	return context.WithValue(ctx, routeKey{}, routes)
+}
+
+// RouteFilter 按照 CtxWithRoute 设置的条件过滤, 没有设置条件的话所有节点都可以被选中
+func RouteFilter(info balancer.PickInfo, addr resolver.Address) bool {
+	if info.Ctx == nil {
+		return true
+	}
+	routes, _ := info.Ctx.Value(routeKey{}).(map[string]string)
+	for key, val := range routes {
+		var input string
+		switch key {
+		case AttrGroup:
+			input = Group(addr)
+		case AttrVersion:
+			input = Version(addr)
+		default:
+			input = Tags(addr)[key]
+		}
+		if input != val {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthFilter 过滤掉不健康的节点
+func HealthFilter(info balancer.PickInfo, addr resolver.Address) bool {
+	return Health(addr).Available()
+}
+
+// All 组合多个 Filter, 全部返回 true 才算通过
+func All(filters ...Filter) Filter {
+	return func(info balancer.PickInfo, addr resolver.Address) bool {
+		for _, f := range filters {
+			if !f(info, addr) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,128 @@
+package loadbalance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestRouteFilter(t *testing.T) {
+	addr := resolver.Address{
+		Addr: "127.0.0.1:8081",
+		Attributes: attributes.New(
+			AttrGroup, "canary",
+			AttrVersion, "v2",
+			AttrTags, map[string]string{"region": "cn"},
+		),
+	}
+	cases := []struct {
+		name string
+		ctx  context.Context
+		addr resolver.Address
+		want bool
+	}{
+		{
+			name: "no route",
+			ctx:  context.Background(),
+			addr: addr,
+			want: true,
+		},
+		{
+			name: "group matched",
+			ctx:  CtxWithRoute(context.Background(), "group", "canary"),
+			addr: addr,
+			want: true,
+		},
+		{
+			name: "group not matched",
+			ctx:  CtxWithRoute(context.Background(), "group", "normal"),
+			addr: addr,
+			want: false,
+		},
+		{
+			name: "group and version matched",
+			ctx:  CtxWithRoute(CtxWithRoute(context.Background(), "group", "canary"), "version", "v2"),
+			addr: addr,
+			want: true,
+		},
+		{
+			name: "version not matched",
+			ctx:  CtxWithRoute(CtxWithRoute(context.Background(), "group", "canary"), "version", "v1"),
+			addr: addr,
+			want: false,
+		},
+		{
+			name: "tag matched",
+			ctx:  CtxWithRoute(context.Background(), "region", "cn"),
+			addr: addr,
+			want: true,
+		},
+		{
+			name: "tag not found",
+			ctx:  CtxWithRoute(context.Background(), "zone", "a"),
+			addr: addr,
+			want: false,
+		},
+		{
+			name: "no attributes",
+			ctx:  CtxWithRoute(context.Background(), "group", "canary"),
+			addr: resolver.Address{Addr: "127.0.0.1:8081"},
+			want: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := RouteFilter(balancer.PickInfo{Ctx: c.ctx}, c.addr)
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestCtxWithRoute(t *testing.T) {
+	parent := CtxWithRoute(context.Background(), "group", "canary")
+	child := CtxWithRoute(parent, "group", "normal")
+	// 子 context 覆盖不能影响父 context
+	assert.Equal(t, map[string]string{"group": "canary"}, parent.Value(routeKey{}))
+	assert.Equal(t, map[string]string{"group": "normal"}, child.Value(routeKey{}))
+}
+
+func TestHealthFilter(t *testing.T) {
+	cases := []struct {
+		name   string
+		health registry.HealthStatus
+		want   bool
+	}{
+		{name: "unknown", health: registry.HealthStatusUnknown, want: true},
+		{name: "serving", health: registry.HealthStatusServing, want: true},
+		{name: "not serving", health: registry.HealthStatusNotServing, want: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr := resolver.Address{Attributes: attributes.New(AttrHealth, c.health)}
+			assert.Equal(t, c.want, HealthFilter(balancer.PickInfo{}, addr))
+		})
+	}
+}
+
+func TestAll(t *testing.T) {
+	addr := resolver.Address{Attributes: attributes.New(
+		AttrGroup, "canary",
+		AttrHealth, registry.HealthStatusNotServing,
+	)}
+	info := balancer.PickInfo{Ctx: CtxWithRoute(context.Background(), "group", "canary")}
+	assert.True(t, All()(info, addr))
+	assert.True(t, All(RouteFilter)(info, addr))
+	assert.False(t, All(RouteFilter, HealthFilter)(info, addr))
+}
+
+func TestWeight(t *testing.T) {
+	assert.Equal(t, 1, Weight(resolver.Address{}))
+	assert.Equal(t, 1, Weight(resolver.Address{Attributes: attributes.New(AttrWeight, 0)}))
+	assert.Equal(t, 10, Weight(resolver.Address{Attributes: attributes.New(AttrWeight, 10)}))
+}
@@ -8,135 +8,362 @@ import (
 	"time"
 )
 
+var ErrPoolClosed = errors.New("micro: 连接池已经关闭")
+
 type Pool struct {
-	// 空闲连接队列
-	idlesConns chan *idleConn
+	// 空闲连接, 当成栈来用, 最近归还的在最后面, 优先使用
+	idles []*idleConn
 	// 请求队列
-	reqQueue []connReq
+	reqQueue []*connReq
 
+	// 最大空闲连接数
+	maxIdleCnt int
 	// 最大连接数
 	maxCnt int
 
-	// 当前连接数, 你已经建立好的连接
+	// 当前连接数, 包括在用的, 空闲的和正在建立的
 	cnt int
 
 	// 最大空闲时间
 	maxIdleTime time.Duration
+	// maxLifetime 连接从建立开始最多用多久, 到了就关掉换新的, 为 0 就不限制
+	// 比如说服务端扩容之后, 老连接一直不断的话新的节点就分不到流量
+	maxLifetime time.Duration
+	// healthCheck 借出空闲连接之前检查一下, 返回 error 的连接会被关掉
+	healthCheck func(c net.Conn) error
 
 	factory func() (net.Conn, error)
 
+	// conns 所有建立好还没有关闭的连接 -> 建立的时间
+	conns map[net.Conn]time.Time
+
+	closed bool
+	// drained 关闭之后, 所有的连接都关掉了
+	drained chan struct{}
+
+	stats PoolStats
+	now   func() time.Time
+
 	lock sync.Mutex
 }
 
+// PoolStats 连接池的统计数据
+type PoolStats struct {
+	MaxCnt int
+	// Open 建立好的连接数量
+	Open  int
+	InUse int
+	Idle  int
+
+	// WaitCount 因为连接数到了上限而等待的次数, WaitDuration 总共等待的时间
+	WaitCount    int64
+	WaitDuration time.Duration
+
+	// 下面是因为各种原因关闭的连接数量
+	MaxIdleTimeClosed int64
+	MaxLifetimeClosed int64
+	HealthCheckFailed int64
+}
+
+type PoolOption func(p *Pool)
+
+// PoolWithMaxLifetime 连接最多用多久
+func PoolWithMaxLifetime(lifetime time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.maxLifetime = lifetime
+	}
+}
+
+// PoolWithHealthCheck 借出空闲连接之前做健康检查, 比如说 CheckAlive
+func PoolWithHealthCheck(check func(c net.Conn) error) PoolOption {
+	return func(p *Pool) {
+		p.healthCheck = check
+	}
+}
+
 func NewPool(
 	initCnt int,
 	maxIdleCnt int,
 	maxCnt int,
 	maxIdleTime time.Duration,
-	factory func() (net.Conn, error)) (*Pool, error) {
+	factory func() (net.Conn, error),
+	opts ...PoolOption) (*Pool, error) {
+	if maxCnt <= 0 {
+		// 一个连接都不能建, Get 会一直等下去
+		return nil, errors.New("micro: 最大连接数量必须大于 0")
+	}
+	if maxIdleCnt > maxCnt {
+		return nil, errors.New("micro: 最大空闲数量不能大于最大连接数量")
+	}
 	if initCnt > maxIdleCnt {
 		return nil, errors.New("micro: 初始连接数量不能大于最大空闲数量")
 	}
-	idlesConns := make(chan *idleConn, maxIdleCnt)
+	pool := &Pool{
+		idles:       make([]*idleConn, 0, maxIdleCnt),
+		maxIdleCnt:  maxIdleCnt,
+		maxCnt:      maxCnt,
+		maxIdleTime: maxIdleTime,
+		factory:     factory,
+		conns:       make(map[net.Conn]time.Time, maxCnt),
+		drained:     make(chan struct{}),
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(pool)
+	}
 	for i := 0; i < initCnt; i++ {
 		conn, err := factory()
 		if err != nil {
+			for c := range pool.conns {
+				_ = c.Close()
+			}
 			return nil, err
 		}
-		idlesConns <- &idleConn{c: conn, lastActiveTime: time.Now()}
+		now := pool.now()
+		pool.conns[conn] = now
+		pool.idles = append(pool.idles, &idleConn{c: conn, lastActiveTime: now})
+		pool.cnt++
 	}
-	pool := &Pool{
-		idlesConns:  idlesConns,
-		maxCnt:      maxCnt,
-		cnt:         0,
-		maxIdleTime: maxIdleTime,
-		factory:     factory,
-	}
-
 	return pool, nil
 }
 
-// 获取连接
+// Get 获取连接, 连接数到了上限就等别人归还, 直到 ctx 超时
 func (p *Pool) Get(ctx context.Context) (net.Conn, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	p.lock.Lock()
 	for {
-		select {
-		case ic := <-p.idlesConns:
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if n := len(p.idles); n > 0 {
 			// 代表的是拿到了空闲连接
-			if ic.lastActiveTime.Add(p.maxIdleTime).Before(time.Now()) {
-				_ = ic.c.Close()
+			ic := p.idles[n-1]
+			p.idles = p.idles[:n-1]
+			now := p.now()
+			if p.maxIdleTime > 0 && now.Sub(ic.lastActiveTime) > p.maxIdleTime {
+				p.stats.MaxIdleTimeClosed++
+				p.closeConn(ic.c)
 				continue
 			}
-			return ic.c, nil
-		default:
-			// 没有空闲连接
-			p.lock.Lock()
-			if p.cnt >= p.maxCnt {
-				// 超过上限了
-				req := connReq{connChan: make(chan net.Conn, 1)}
-				p.reqQueue = append(p.reqQueue, req)
+			if p.expired(ic.c, now) {
+				p.stats.MaxLifetimeClosed++
+				p.closeConn(ic.c)
+				continue
+			}
+			if p.healthCheck != nil {
+				// 健康检查可能要读写连接, 不能持有锁, 这时候连接已经不在空闲队列里面了, 别人拿不到
 				p.lock.Unlock()
-				select {
-				case c := <-req.connChan: // 等别人归还
-					return c, nil
-				case <-ctx.Done():
-					// 发生了超时
-					// 选项1: 从队列里面删除掉 req 自己
-					// 选项2: 在这里转发
-					go func() {
-						// 这里选择转发
-						c := <-req.connChan
-						_ = p.Put(context.Background(), c)
-					}()
-					return nil, ctx.Err()
+				err := p.healthCheck(ic.c)
+				p.lock.Lock()
+				if err != nil {
+					p.stats.HealthCheckFailed++
+					p.closeConn(ic.c)
+					continue
 				}
 			}
+			p.lock.Unlock()
+			return ic.c, nil
+		}
 
-			c, err := p.factory()
-			if err != nil {
-				p.lock.Unlock()
-				return nil, err
-			}
+		if p.cnt < p.maxCnt {
+			// 没有空闲连接, 还没到上限, 先占一个位置再去建立连接
 			p.cnt++
 			p.lock.Unlock()
-			return c, nil
+			return p.dial()
+		}
+
+		// 超过上限了, 等别人归还
+		req := &connReq{connChan: make(chan connRes, 1)}
+		p.reqQueue = append(p.reqQueue, req)
+		p.stats.WaitCount++
+		start := p.now()
+		p.lock.Unlock()
+		select {
+		case res := <-req.connChan: // 等别人归还
+			p.addWaitDuration(start)
+			return res.c, res.err
+		case <-ctx.Done():
+			// 发生了超时, 从队列里面删除掉自己
+			p.lock.Lock()
+			removed := p.removeReq(req)
+			p.stats.WaitDuration += p.now().Sub(start)
+			p.lock.Unlock()
+			if !removed {
+				// 别人已经把连接给我们了, 还回去
+				if res := <-req.connChan; res.c != nil {
+					_ = p.Put(context.Background(), res.c)
+				}
+			}
+			return nil, ctx.Err()
 		}
 	}
 }
 
-// 归还连接
+// dial 调用之前已经占好了位置
+func (p *Pool) dial() (net.Conn, error) {
+	c, err := p.factory()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err != nil {
+		p.cnt--
+		p.checkDrained()
+		return nil, err
+	}
+	if p.closed {
+		p.cnt--
+		_ = c.Close()
+		p.checkDrained()
+		return nil, ErrPoolClosed
+	}
+	p.conns[c] = p.now()
+	return c, nil
+}
+
+// Put 归还连接
 func (p *Pool) Put(ctx context.Context, c net.Conn) error {
 	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.conns[c]; !ok {
+		// 已经被关掉了, 比如说关闭连接池的时候超时了
+		return nil
+	}
+	if p.closed {
+		p.closeConn(c)
+		return nil
+	}
+	now := p.now()
+	if p.expired(c, now) {
+		p.stats.MaxLifetimeClosed++
+		p.closeConn(c)
+		p.dialForWaiter()
+		return nil
+	}
 	if len(p.reqQueue) > 0 {
-		// 有阻塞请求
+		// 有阻塞请求, 取走了第一个, 给它连接, 因此它获得了连接, 所以要把它从请求队列里面移除
 		req := p.reqQueue[0]
-		// 取走了第一个, 给它连接, 因此它获得了连接, 所以要把它从请求队列里面移除
 		p.reqQueue = p.reqQueue[1:]
-		p.lock.Unlock()
-		req.connChan <- c
+		req.connChan <- connRes{c: c}
 		return nil
 	}
+	if len(p.idles) >= p.maxIdleCnt {
+		// 空闲队列满了
+		p.closeConn(c)
+		return nil
+	}
+	p.idles = append(p.idles, &idleConn{c: c, lastActiveTime: now})
+	return nil
+}
 
-	p.lock.Unlock()
-	// 没有阻塞请求
-	ic := &idleConn{
-		c:              c,
-		lastActiveTime: time.Now(),
+// Discard 连接坏掉了, 或者上面还有没读完的数据, 不能再给别人用, 关掉它并且释放位置
+func (p *Pool) Discard(c net.Conn) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.conns[c]; !ok {
+		return nil
 	}
+	p.closeConn(c)
+	p.dialForWaiter()
+	return nil
+}
+
+// Close 关闭连接池, 空闲连接马上关掉, 等待中的 Get 返回 ErrPoolClosed
+// 借出去的连接归还的时候关掉, 等它们都归还了才返回, ctx 超时了就强制关掉
+func (p *Pool) Close(ctx context.Context) error {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, ic := range p.idles {
+			p.closeConn(ic.c)
+		}
+		p.idles = nil
+		for _, req := range p.reqQueue {
+			req.connChan <- connRes{err: ErrPoolClosed}
+		}
+		p.reqQueue = nil
+		p.checkDrained()
+	}
+	p.lock.Unlock()
+
 	select {
-	case p.idlesConns <- ic:
-	default:
-		// 空闲队列满了
-		_ = c.Close()
+	case <-p.drained:
+		return nil
+	case <-ctx.Done():
 		p.lock.Lock()
-		p.cnt--
+		for c := range p.conns {
+			p.closeConn(c)
+		}
 		p.lock.Unlock()
+		return ctx.Err()
 	}
-	return nil
+}
+
+func (p *Pool) Stats() PoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	stats := p.stats
+	stats.MaxCnt = p.maxCnt
+	stats.Open = len(p.conns)
+	stats.Idle = len(p.idles)
+	stats.InUse = stats.Open - stats.Idle
+	return stats
+}
+
+func (p *Pool) expired(c net.Conn, now time.Time) bool {
+	return p.maxLifetime > 0 && now.Sub(p.conns[c]) >= p.maxLifetime
+}
+
+// closeConn 调用的时候要持有锁
+func (p *Pool) closeConn(c net.Conn) {
+	delete(p.conns, c)
+	p.cnt--
+	_ = c.Close()
+	p.checkDrained()
+}
+
+// dialForWaiter 关掉了一个连接, 空出了位置, 帮等待的请求建立一个新的连接, 调用的时候要持有锁
+func (p *Pool) dialForWaiter() {
+	if p.closed || len(p.reqQueue) == 0 || p.cnt >= p.maxCnt {
+		return
+	}
+	req := p.reqQueue[0]
+	p.reqQueue = p.reqQueue[1:]
+	p.cnt++
+	go func() {
+		c, err := p.dial()
+		req.connChan <- connRes{c: c, err: err}
+	}()
+}
+
+func (p *Pool) removeReq(req *connReq) bool {
+	for i, r := range p.reqQueue {
+		if r == req {
+			p.reqQueue = append(p.reqQueue[:i], p.reqQueue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) checkDrained() {
+	if !p.closed || p.cnt > 0 {
+		return
+	}
+	select {
+	case <-p.drained:
+	default:
+		close(p.drained)
+	}
+}
+
+func (p *Pool) addWaitDuration(start time.Time) {
+	p.lock.Lock()
+	p.stats.WaitDuration += p.now().Sub(start)
+	p.lock.Unlock()
 }
 
 type idleConn struct {
@@ -145,5 +372,33 @@ type idleConn struct {
 }
 
 type connReq struct {
-	connChan chan net.Conn
+	connChan chan connRes
+}
+
+type connRes struct {
+	c   net.Conn
+	err error
 }
+
+// CheckAlive 检查空闲连接是不是还能用, 可以用作 PoolWithHealthCheck
+// 空闲的连接上不应该有数据, 读到了数据, 或者读到了 EOF 之类的错误都说明连接不能用了, 读超时才说明连接是好的
+// 每次检查最多会等待 aliveCheckTimeout
+func CheckAlive(c net.Conn) error {
+	if err := c.SetReadDeadline(time.Now().Add(aliveCheckTimeout)); err != nil {
+		return err
+	}
+	var buf [1]byte
+	_, err := c.Read(buf[:])
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return c.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		return err
+	}
+	return errUnexpectedData
+}
+
+const aliveCheckTimeout = 100 * time.Microsecond
+
+var errUnexpectedData = errors.New("micro: 空闲连接上读到了数据")
@@ -0,0 +1,328 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/net/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// newConnFactory 每次调用返回一个新的 mock 连接, 关闭的时候要调用 Close
+func newConnFactory(ctrl *gomock.Controller, conns *[]net.Conn) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn := mocks.NewMockConn(ctrl)
+		conn.EXPECT().Close().Return(nil).MaxTimes(1)
+		*conns = append(*conns, conn)
+		return conn, nil
+	}
+}
+
+func TestPool_GetPut(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var conns []net.Conn
+	p, err := NewPool(1, 2, 2, time.Minute, newConnFactory(ctrl, &conns))
+	require.NoError(t, err)
+	assert.Equal(t, PoolStats{MaxCnt: 2, Open: 1, Idle: 1}, p.Stats())
+
+	c1, err := p.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, conns[0], c1)
+	c2, err := p.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, conns[1], c2)
+	assert.Equal(t, PoolStats{MaxCnt: 2, Open: 2, InUse: 2}, p.Stats())
+
+	// 到了上限, 等到超时
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 归还的连接直接给等待的人
+	got := make(chan net.Conn, 1)
+	go func() {
+		c, err := p.Get(context.Background())
+		assert.NoError(t, err)
+		got <- c
+	}()
+	assert.Eventually(t, func() bool {
+		return p.Stats().WaitCount == 2
+	}, time.Second, time.Millisecond)
+	require.NoError(t, p.Put(context.Background(), c1))
+	assert.Same(t, c1, <-got)
+
+	require.NoError(t, p.Put(context.Background(), c1))
+	require.NoError(t, p.Put(context.Background(), c2))
+	stats := p.Stats()
+	assert.Equal(t, 2, stats.Idle)
+	assert.Equal(t, 0, stats.InUse)
+	assert.True(t, stats.WaitDuration > 0)
+
+	// 后归还的先用
+	c, err := p.Get(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, c2, c)
+}
+
+func TestNewPool_invalid(t *testing.T) {
+	cases := []struct {
+		name       string
+		initCnt    int
+		maxIdleCnt int
+		maxCnt     int
+		wantErr    error
+	}{
+		{
+			name:    "zero max",
+			wantErr: errors.New("micro: 最大连接数量必须大于 0"),
+		},
+		{
+			name:       "negative max",
+			maxCnt:     -1,
+			maxIdleCnt: -1,
+			wantErr:    errors.New("micro: 最大连接数量必须大于 0"),
+		},
+		{
+			name:       "idle more than max",
+			maxIdleCnt: 3,
+			maxCnt:     2,
+			wantErr:    errors.New("micro: 最大空闲数量不能大于最大连接数量"),
+		},
+		{
+			name:       "init more than idle",
+			initCnt:    2,
+			maxIdleCnt: 1,
+			maxCnt:     2,
+			wantErr:    errors.New("micro: 初始连接数量不能大于最大空闲数量"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewPool(c.initCnt, c.maxIdleCnt, c.maxCnt, time.Minute, func() (net.Conn, error) {
+				t.Fatal("参数不合法, 不应该建立连接")
+				return nil, nil
+			})
+			assert.Equal(t, c.wantErr, err)
+		})
+	}
+}
+
+func TestPool_Get_evict(t *testing.T) {
+	cases := []struct {
+		name  string
+		opts  []PoolOption
+		after time.Duration
+
+		wantStats PoolStats
+	}{
+		{
+			name:      "max idle time",
+			after:     2 * time.Minute,
+			wantStats: PoolStats{MaxCnt: 1, Open: 1, InUse: 1, MaxIdleTimeClosed: 1},
+		},
+		{
+			name:      "max lifetime",
+			opts:      []PoolOption{PoolWithMaxLifetime(30 * time.Second)},
+			after:     30 * time.Second,
+			wantStats: PoolStats{MaxCnt: 1, Open: 1, InUse: 1, MaxLifetimeClosed: 1},
+		},
+		{
+			name: "health check failed",
+			opts: []PoolOption{PoolWithHealthCheck(func(c net.Conn) error {
+				return io.EOF
+			})},
+			wantStats: PoolStats{MaxCnt: 1, Open: 1, InUse: 1, HealthCheckFailed: 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			var conns []net.Conn
+			p, err := NewPool(1, 1, 1, time.Minute, newConnFactory(ctrl, &conns), c.opts...)
+			require.NoError(t, err)
+			now := time.Now()
+			p.now = func() time.Time {
+				return now.Add(c.after)
+			}
+			conn, err := p.Get(context.Background())
+			require.NoError(t, err)
+			// 旧连接被关掉, 拿到的是新建立的连接
+			require.Len(t, conns, 2)
+			assert.Same(t, conns[1], conn)
+			assert.Equal(t, c.wantStats, p.Stats())
+		})
+	}
+}
+
+func TestPool_Put_maxLifetime(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var conns []net.Conn
+	p, err := NewPool(0, 1, 1, time.Minute, newConnFactory(ctrl, &conns), PoolWithMaxLifetime(time.Minute))
+	require.NoError(t, err)
+	conn, err := p.Get(context.Background())
+	require.NoError(t, err)
+	p.now = func() time.Time {
+		return time.Now().Add(time.Hour)
+	}
+	require.NoError(t, p.Put(context.Background(), conn))
+	assert.Equal(t, PoolStats{MaxCnt: 1, MaxLifetimeClosed: 1}, p.Stats())
+}
+
+func TestPool_Discard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var conns []net.Conn
+	p, err := NewPool(0, 1, 1, time.Minute, newConnFactory(ctrl, &conns))
+	require.NoError(t, err)
+	conn, err := p.Get(context.Background())
+	require.NoError(t, err)
+
+	got := make(chan net.Conn, 1)
+	go func() {
+		c, err := p.Get(context.Background())
+		assert.NoError(t, err)
+		got <- c
+	}()
+	assert.Eventually(t, func() bool {
+		return p.Stats().WaitCount == 1
+	}, time.Second, time.Millisecond)
+	// 丢弃了连接, 等待的人拿到的是新建立的连接
+	require.NoError(t, p.Discard(conn))
+	c := <-got
+	assert.NotSame(t, conn, c)
+	assert.Same(t, conns[1], c)
+	// 重复丢弃, 或者归还已经丢弃的连接, 都不会有影响
+	require.NoError(t, p.Discard(conn))
+	require.NoError(t, p.Put(context.Background(), conn))
+	assert.Equal(t, PoolStats{MaxCnt: 1, Open: 1, InUse: 1, WaitCount: 1, WaitDuration: p.Stats().WaitDuration}, p.Stats())
+}
+
+func TestPool_Close(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var conns []net.Conn
+	p, err := NewPool(0, 2, 2, time.Minute, newConnFactory(ctrl, &conns))
+	require.NoError(t, err)
+	c1, err := p.Get(context.Background())
+	require.NoError(t, err)
+	c2, err := p.Get(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, p.Put(context.Background(), c2))
+	c2, err = p.Get(context.Background())
+	require.NoError(t, err)
+
+	waitErr := make(chan error, 1)
+	go func() {
+		_, err := p.Get(context.Background())
+		waitErr <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return p.Stats().WaitCount == 1
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- p.Close(context.Background())
+	}()
+	// 等待中的请求直接返回
+	assert.Equal(t, ErrPoolClosed, <-waitErr)
+	_, err = p.Get(context.Background())
+	assert.Equal(t, ErrPoolClosed, err)
+
+	// 借出去的连接都归还了, Close 才返回
+	require.NoError(t, p.Put(context.Background(), c1))
+	select {
+	case <-closed:
+		t.Fatal("还有连接没有归还")
+	case <-time.After(10 * time.Millisecond):
+	}
+	require.NoError(t, p.Put(context.Background(), c2))
+	assert.NoError(t, <-closed)
+	assert.Equal(t, 0, p.Stats().Open)
+}
+
+func TestPool_Close_timeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	var conns []net.Conn
+	p, err := NewPool(1, 1, 1, time.Minute, newConnFactory(ctrl, &conns))
+	require.NoError(t, err)
+	conn, err := p.Get(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// 连接一直不还, 超时之后强制关掉
+	assert.Equal(t, context.DeadlineExceeded, p.Close(ctx))
+	assert.Equal(t, 0, p.Stats().Open)
+	require.NoError(t, p.Put(context.Background(), conn))
+}
+
+func TestCheckAlive(t *testing.T) {
+	cases := []struct {
+		name    string
+		mock    func(ctrl *gomock.Controller) net.Conn
+		wantErr error
+	}{
+		{
+			name: "alive",
+			mock: func(ctrl *gomock.Controller) net.Conn {
+				conn := mocks.NewMockConn(ctrl)
+				conn.EXPECT().SetReadDeadline(gomock.Any()).Return(nil)
+				conn.EXPECT().Read(gomock.Any()).Return(0, os.ErrDeadlineExceeded)
+				conn.EXPECT().SetReadDeadline(time.Time{}).Return(nil)
+				return conn
+			},
+		},
+		{
+			name: "closed by peer",
+			mock: func(ctrl *gomock.Controller) net.Conn {
+				conn := mocks.NewMockConn(ctrl)
+				conn.EXPECT().SetReadDeadline(gomock.Any()).Return(nil)
+				conn.EXPECT().Read(gomock.Any()).Return(0, io.EOF)
+				return conn
+			},
+			wantErr: io.EOF,
+		},
+		{
+			name: "unexpected data",
+			mock: func(ctrl *gomock.Controller) net.Conn {
+				conn := mocks.NewMockConn(ctrl)
+				conn.EXPECT().SetReadDeadline(gomock.Any()).Return(nil)
+				conn.EXPECT().Read(gomock.Any()).Return(1, nil)
+				return conn
+			},
+			wantErr: errUnexpectedData,
+		},
+		{
+			name: "set deadline error",
+			mock: func(ctrl *gomock.Controller) net.Conn {
+				conn := mocks.NewMockConn(ctrl)
+				conn.EXPECT().SetReadDeadline(gomock.Any()).Return(errors.New("use of closed network connection"))
+				return conn
+			},
+			wantErr: errors.New("use of closed network connection"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			err := CheckAlive(c.mock(ctrl))
+			assert.Equal(t, c.wantErr, err)
+		})
+	}
+}
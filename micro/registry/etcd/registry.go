@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
+
 	"github.com/startdusk/go-libs/micro/registry"
 	clientV3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
@@ -14,6 +17,16 @@ import (
 type Registry struct {
 	c    *clientV3.Client
 	sess *concurrency.Session
+	// watch 就是 c.Watch, 测试的时候可以换掉
+	watch func(ctx context.Context, key string, opts ...clientV3.OpOption) clientV3.WatchChan
+	// watch 出错之后重试的间隔从 minBackoff 开始翻倍, 最多 maxBackoff
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mutex sync.Mutex
+	// cancels 记录所有 Subscribe 开启的 watch, Close 的时候要一并关掉, watch 结束的时候会删掉自己
+	cancels map[uint64]context.CancelFunc
+	watchID uint64
 }
 
 // 这里 设计不是很好，理论上应该是要传接口的，但接口的方法没有实例的多(redis这个包设计得不好)
@@ -23,8 +36,12 @@ func NewRegistry(c *clientV3.Client) (*Registry, error) {
 		return nil, err
 	}
 	return &Registry{
-		c:    c,
-		sess: sess,
+		c:          c,
+		sess:       sess,
+		watch:      c.Watch,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 10 * time.Second,
+		cancels:    make(map[uint64]context.CancelFunc, 4),
 	}, nil
 }
 
@@ -41,17 +58,142 @@ func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance)
 	_, err := r.c.Delete(ctx, r.instanceKey(si))
 	return err
 }
+
 func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
-	return nil, nil
+	getResp, err := r.c.Get(ctx, r.serviceKey(serviceName), clientV3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	res := make([]registry.ServiceInstance, 0, len(getResp.Kvs))
+	for _, kv := range getResp.Kvs {
+		var si registry.ServiceInstance
+		if err := json.Unmarshal(kv.Value, &si); err != nil {
+			// 有人往我们的前缀下面写了乱七八糟的东西, 直接返回错误
+			return nil, err
+		}
+		res = append(res, si)
+	}
+	return res, nil
 }
+
+// Subscribe 监听服务实例的变化, ctx 被取消或者 Registry 被关闭的时候, 返回的 channel 会被关闭
+// watch 出错了会自己重新 watch, 不会漏掉事件. 只有需要的历史版本已经被 compact 掉了, channel 才会被关闭,
+// 这个时候订阅方应该重新订阅, 再用 ListServices 拉取一次全量数据
 func (r *Registry) Subscribe(ctx context.Context, serviceName string) (<-chan registry.Event, error) {
-	return nil, nil
+	key := r.serviceKey(serviceName)
+	// 拿到当前的版本号, 从它的下一个版本开始 watch, 这样第一次 watch 之前出错也不会漏掉变化
+	getResp, err := r.c.Get(ctx, key, clientV3.WithPrefix(), clientV3.WithCountOnly())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r.mutex.Lock()
+	r.watchID++
+	id := r.watchID
+	r.cancels[id] = cancel
+	r.mutex.Unlock()
+	res := make(chan registry.Event)
+	go func() {
+		defer func() {
+			r.mutex.Lock()
+			delete(r.cancels, id)
+			r.mutex.Unlock()
+			cancel()
+			close(res)
+		}()
+		r.watchLoop(ctx, key, getResp.Header.Revision+1, res)
+	}()
+	return res, nil
+}
+
+// watchLoop 从 rev 开始 watch, 出错了(比如说 etcd 集群暂时没有 leader)就等一会儿, 从最后收到的事件的下一个版本重新 watch
+func (r *Registry) watchLoop(ctx context.Context, key string, rev int64, res chan<- registry.Event) {
+	backoff := r.minBackoff
+	for {
+		// WithRequireLeader 确保 etcd 集群没有 leader 的时候, watch 会返回错误而不是一直卡住
+		watchCtx, cancel := context.WithCancel(clientV3.WithRequireLeader(ctx))
+		watchCh := r.watch(watchCtx, key, clientV3.WithPrefix(), clientV3.WithPrevKV(), clientV3.WithRev(rev))
+		for watchResp := range watchCh {
+			if watchResp.CompactRevision > 0 {
+				// 中间的变化已经找不回来了, 只能让订阅方重新拉取
+				cancel()
+				return
+			}
+			if watchResp.Err() != nil || watchResp.Canceled {
+				break
+			}
+			backoff = r.minBackoff
+			for _, event := range watchResp.Events {
+				rev = event.Kv.ModRevision + 1
+				evt, err := r.convertEvent(event)
+				if err != nil {
+					continue
+				}
+				select {
+				case res <- evt:
+				case <-ctx.Done():
+					cancel()
+					return
+				}
+			}
+		}
+		cancel()
+		// 订阅被取消了, 或者 etcd 的客户端已经关掉了
+		if ctx.Err() != nil || r.c.Ctx().Err() != nil {
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+func (r *Registry) convertEvent(event *clientV3.Event) (registry.Event, error) {
+	var evt registry.Event
+	switch event.Type {
+	case clientV3.EventTypePut:
+		if event.IsCreate() {
+			evt.Type = registry.EventTypeAdd
+		} else {
+			evt.Type = registry.EventTypeUpdate
+		}
+		err := json.Unmarshal(event.Kv.Value, &evt.Instance)
+		return evt, err
+	case clientV3.EventTypeDelete:
+		evt.Type = registry.EventTypeDelete
+		// 删除事件里面没有 value, 只能从 PrevKv 里面拿到被删除的实例
+		if event.PrevKv != nil {
+			err := json.Unmarshal(event.PrevKv.Value, &evt.Instance)
+			return evt, err
+		}
+		return evt, nil
+	default:
+		return evt, fmt.Errorf("micro: 未知的 etcd 事件类型 %v", event.Type)
+	}
 }
 
 func (r *Registry) Close() error {
+	r.mutex.Lock()
+	cancels := r.cancels
+	r.cancels = make(map[uint64]context.CancelFunc)
+	r.mutex.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
 	return r.sess.Close()
 }
 
 func (r *Registry) instanceKey(si registry.ServiceInstance) string {
 	return fmt.Sprintf("/micro/%s/%s", si.Name, si.Address)
 }
+
+// serviceKey 最后的 / 不能少, 不然 user-service 会把 user-service-v2 的实例也查出来
+func (r *Registry) serviceKey(serviceName string) string {
+	return fmt.Sprintf("/micro/%s/", serviceName)
+}
@@ -0,0 +1,148 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientV3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func TestRegistry(t *testing.T) {
+	registrytest.TestRegistry(t, func(t *testing.T) registry.Registry {
+		r, err := NewRegistry(startEtcd(t))
+		require.NoError(t, err)
+		return r
+	})
+}
+
+func TestRegistry_Subscribe_watchError(t *testing.T) {
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 1}
+	cases := []struct {
+		name string
+		// failure 第一次 watch 收到的错误
+		failure clientV3.WatchResponse
+
+		wantEvents []registry.Event
+	}{
+		{
+			// 比如说集群暂时没有 leader, 重新 watch 之后, 出错期间注册的实例也能收到
+			name:       "canceled",
+			failure:    clientV3.WatchResponse{Canceled: true},
+			wantEvents: []registry.Event{{Type: registry.EventTypeAdd, Instance: si}},
+		},
+		{
+			// 历史版本没了, 关闭 channel 让订阅方重新拉取
+			name:    "compacted",
+			failure: clientV3.WatchResponse{CompactRevision: 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := NewRegistry(startEtcd(t))
+			require.NoError(t, err)
+			defer func() {
+				_ = r.Close()
+			}()
+			r.minBackoff = time.Millisecond
+			fail := make(chan struct{})
+			first := true
+			r.watch = func(ctx context.Context, key string, opts ...clientV3.OpOption) clientV3.WatchChan {
+				if !first {
+					return r.c.Watch(ctx, key, opts...)
+				}
+				first = false
+				ch := make(chan clientV3.WatchResponse, 1)
+				go func() {
+					<-fail
+					ch <- c.failure
+					close(ch)
+				}()
+				return ch
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			events, err := r.Subscribe(ctx, "user-service")
+			require.NoError(t, err)
+			require.NoError(t, r.Register(ctx, si))
+			close(fail)
+
+			for _, want := range c.wantEvents {
+				select {
+				case evt, ok := <-events:
+					require.True(t, ok, "channel 被提前关闭了")
+					assert.Equal(t, want, evt)
+				case <-ctx.Done():
+					t.Fatalf("没有收到事件 %s", want.Type)
+				}
+			}
+			if len(c.wantEvents) == 0 {
+				select {
+				case _, ok := <-events:
+					assert.False(t, ok)
+				case <-ctx.Done():
+					t.Fatal("channel 没有被关闭")
+				}
+				// watch 结束之后不再记着它
+				assert.Eventually(t, func() bool {
+					r.mutex.Lock()
+					defer r.mutex.Unlock()
+					return len(r.cancels) == 0
+				}, time.Second, 10*time.Millisecond)
+			}
+		})
+	}
+}
+
+// startEtcd 启动一个内嵌的 etcd, 测试结束的时候自动关闭
+func startEtcd(t *testing.T) *clientV3.Client {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL := freeURL(t)
+	peerURL := freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Server.Stop()
+		t.Fatal("etcd 启动超时")
+	}
+
+	client, err := clientV3.New(clientV3.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: 3 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func freeURL(t *testing.T) url.URL {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+	u, err := url.Parse(fmt.Sprintf("http://%s", addr))
+	require.NoError(t, err)
+	return *u
+}
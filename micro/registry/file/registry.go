@@ -0,0 +1,291 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/registry/memory"
+)
+
+var errRegistryClosed = errors.New("micro: 注册中心已经关闭")
+
+// Registry 基于文件的注册中心, 类似于静态的 DNS 配置
+// 文件的格式由后缀决定, .json 是 JSON, .yaml 和 .yml 是 YAML, 内容形如:
+//
+//	services:
+//	  - name: user-service
+//	    address: 127.0.0.1:8081
+//	    weight: 10
+//
+// Registry 会定时检查文件有没有变化, 有变化就重新加载, 然后通知订阅方
+// Register 和 UnRegister 会直接修改文件
+type Registry struct {
+	path     string
+	interval time.Duration
+
+	// mem 保存的是最近一次成功加载的文件内容, 订阅也是交给它来处理
+	mem *memory.Registry
+	// names 是 mem 里面所有的服务名
+	names map[string]struct{}
+
+	// mutex 保护文件的读写和 modTime
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+
+	close     chan struct{}
+	closeOnce sync.Once
+}
+
+type RegistryOption func(r *Registry)
+
+// RegistryWithInterval 设置检查文件变化的间隔, 默认是一秒
+func RegistryWithInterval(interval time.Duration) RegistryOption {
+	return func(r *Registry) {
+		r.interval = interval
+	}
+}
+
+// NewRegistry 文件不存在的话, 当成是空的注册中心, 第一次 Register 的时候会创建文件
+func NewRegistry(path string, opts ...RegistryOption) (*Registry, error) {
+	r := &Registry{
+		path:     path,
+		interval: time.Second,
+		mem:      memory.NewRegistry(),
+		close:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if _, err := r.codec(); err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	err := r.reload(true)
+	r.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	return r.update(func(instances []registry.ServiceInstance) []registry.ServiceInstance {
+		for i, ins := range instances {
+			if ins.Name == si.Name && ins.Address == si.Address {
+				instances[i] = si
+				return instances
+			}
+		}
+		return append(instances, si)
+	})
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	return r.update(func(instances []registry.ServiceInstance) []registry.ServiceInstance {
+		res := instances[:0]
+		for _, ins := range instances {
+			if ins.Name == si.Name && ins.Address == si.Address {
+				continue
+			}
+			res = append(res, ins)
+		}
+		return res
+	})
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	return r.mem.ListServices(ctx, serviceName)
+}
+
+func (r *Registry) Subscribe(ctx context.Context, serviceName string) (<-chan registry.Event, error) {
+	return r.mem.Subscribe(ctx, serviceName)
+}
+
+func (r *Registry) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.close)
+	})
+	return r.mem.Close()
+}
+
+func (r *Registry) watch() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mutex.Lock()
+			// 文件被改坏了的话, 保留上一次的内容, 等下一次修好
+			_ = r.reload(false)
+			r.mutex.Unlock()
+		case <-r.close:
+			return
+		}
+	}
+}
+
+// update 读出文件, 修改, 写回去, 然后马上重新加载, 不用等下一次检查
+func (r *Registry) update(fn func(instances []registry.ServiceInstance) []registry.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	select {
+	case <-r.close:
+		return errRegistryClosed
+	default:
+	}
+	cfg, err := r.read()
+	if err != nil {
+		return err
+	}
+	cfg.Services = fn(cfg.Services)
+	if err = r.write(cfg); err != nil {
+		return err
+	}
+	return r.reload(true)
+}
+
+// reload 调用方必须持有 mutex
+// force 为 false 的时候, 如果文件的修改时间和大小都没有变化, 就不会重新加载
+func (r *Registry) reload(force bool) error {
+	info, err := os.Stat(r.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var modTime time.Time
+	var size int64
+	if info != nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	if !force && modTime.Equal(r.modTime) && size == r.size {
+		return nil
+	}
+	cfg, err := r.read()
+	if err != nil {
+		return err
+	}
+	r.modTime, r.size = modTime, size
+	return r.apply(cfg.Services)
+}
+
+// apply 对比新旧两份实例列表, 把差异同步到 mem 里面, mem 会负责通知订阅方
+func (r *Registry) apply(instances []registry.ServiceInstance) error {
+	ctx := context.Background()
+	latest := make(map[string]registry.ServiceInstance, len(instances))
+	names := make(map[string]struct{}, len(instances))
+	for _, si := range instances {
+		latest[si.Name+"/"+si.Address] = si
+		names[si.Name] = struct{}{}
+	}
+	// 文件里面没有了的服务, 也要从 mem 里面删掉
+	for name := range r.names {
+		names[name] = struct{}{}
+	}
+	for name := range names {
+		olds, err := r.mem.ListServices(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, old := range olds {
+			if _, ok := latest[old.Name+"/"+old.Address]; !ok {
+				if err = r.mem.UnRegister(ctx, old); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, si := range instances {
+		// 没有变化的话 mem 不会发出事件
+		if err := r.mem.Register(ctx, si); err != nil {
+			return err
+		}
+	}
+	r.names = names
+	return nil
+}
+
+func (r *Registry) read() (*fileConfig, error) {
+	cfg := &fileConfig{}
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return cfg, nil
+	}
+	c, err := r.codec()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// write 先写临时文件再 rename, 保证别人不会读到写了一半的文件
+func (r *Registry) write(cfg *fileConfig) error {
+	c, err := r.codec()
+	if err != nil {
+		return err
+	}
+	data, err := c.marshal(cfg)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+func (r *Registry) codec() (codec, error) {
+	switch strings.ToLower(filepath.Ext(r.path)) {
+	case ".json":
+		return codec{
+			marshal: func(val any) ([]byte, error) {
+				return json.MarshalIndent(val, "", "  ")
+			},
+			unmarshal: json.Unmarshal,
+		}, nil
+	case ".yaml", ".yml":
+		return codec{
+			marshal:   yaml.Marshal,
+			unmarshal: yaml.Unmarshal,
+		}, nil
+	default:
+		return codec{}, errors.New("micro: 只支持 .json, .yaml 和 .yml 格式的文件")
+	}
+}
+
+type codec struct {
+	marshal   func(val any) ([]byte, error)
+	unmarshal func(data []byte, val any) error
+}
+
+type fileConfig struct {
+	Services []registry.ServiceInstance `json:"services" yaml:"services"`
+}
@@ -0,0 +1,144 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, ext := range []string{".json", ".yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			registrytest.TestRegistry(t, func(t *testing.T) registry.Registry {
+				r, err := NewRegistry(filepath.Join(t.TempDir(), "registry"+ext),
+					RegistryWithInterval(10*time.Millisecond))
+				require.NoError(t, err)
+				return r
+			})
+		})
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+		wantRes []registry.ServiceInstance
+	}{
+		{
+			name:    "unsupported ext",
+			file:    "registry.txt",
+			wantErr: true,
+		},
+		{
+			name:    "not exist",
+			file:    "registry.json",
+			wantRes: []registry.ServiceInstance{},
+		},
+		{
+			name: "json",
+			file: "registry.json",
+			content: `{"services": [
+  {"name": "user-service", "address": "127.0.0.1:8081", "weight": 10},
+  {"name": "order-service", "address": "127.0.0.1:8082"}
+]}`,
+			wantRes: []registry.ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10},
+			},
+		},
+		{
+			name: "yaml",
+			file: "registry.yml",
+			content: `services:
+  - name: user-service
+    address: 127.0.0.1:8081
+    weight: 10
+`,
+			wantRes: []registry.ServiceInstance{
+				{Name: "user-service", Address: "127.0.0.1:8081", Weight: 10},
+			},
+		},
+		{
+			name:    "invalid content",
+			file:    "registry.json",
+			content: `{"services": `,
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), c.file)
+			if c.content != "" {
+				require.NoError(t, os.WriteFile(path, []byte(c.content), 0644))
+			}
+			r, err := NewRegistry(path)
+			if c.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				_ = r.Close()
+			}()
+			res, err := r.ListServices(context.Background(), "user-service")
+			require.NoError(t, err)
+			assert.Equal(t, c.wantRes, res)
+		})
+	}
+}
+
+// 直接修改文件, 订阅方也要能收到通知
+func TestRegistry_WatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`services:
+  - name: user-service
+    address: 127.0.0.1:8081
+`), 0644))
+	r, err := NewRegistry(path, RegistryWithInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer func() {
+		_ = r.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := r.Subscribe(ctx, "user-service")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`services:
+  - name: user-service
+    address: 127.0.0.1:8082
+    weight: 2
+`), 0644))
+
+	got := make([]registry.Event, 0, 2)
+	for len(got) < 2 {
+		select {
+		case evt := <-events:
+			got = append(got, evt)
+		case <-ctx.Done():
+			t.Fatal("没有收到事件")
+		}
+	}
+	assert.ElementsMatch(t, []registry.Event{
+		{Type: registry.EventTypeDelete, Instance: registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081"}},
+		{Type: registry.EventTypeAdd, Instance: registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8082", Weight: 2}},
+	}, got)
+
+	// 文件被改坏了的话保留原来的内容
+	require.NoError(t, os.WriteFile(path, []byte(`services: [`), 0644))
+	time.Sleep(50 * time.Millisecond)
+	res, err := r.ListServices(ctx, "user-service")
+	require.NoError(t, err)
+	assert.Equal(t, []registry.ServiceInstance{{Name: "user-service", Address: "127.0.0.1:8082", Weight: 2}}, res)
+}
@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/startdusk/go-libs/micro/registry"
+)
+
+var errRegistryClosed = errors.New("micro: 注册中心已经关闭")
+
+// Registry 进程内的注册中心, 适合测试或者单机部署
+type Registry struct {
+	mutex sync.RWMutex
+	// services 服务名 -> 地址 -> 实例
+	services map[string]map[string]registry.ServiceInstance
+	subs     map[string][]*subscriber
+	closed   bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		services: make(map[string]map[string]registry.ServiceInstance, 8),
+		subs:     make(map[string][]*subscriber, 8),
+	}
+}
+
+// Register 同一个地址重复注册的话, 相当于更新实例信息
+func (r *Registry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return errRegistryClosed
+	}
+	instances, ok := r.services[si.Name]
+	if !ok {
+		instances = make(map[string]registry.ServiceInstance, 4)
+		r.services[si.Name] = instances
+	}
+	typ := registry.EventTypeAdd
+	if old, ok := instances[si.Address]; ok {
+		if old.Equal(si) {
+			// 什么都没变, 不需要通知
+			return nil
+		}
+		typ = registry.EventTypeUpdate
+	}
+	instances[si.Address] = si
+	r.notify(si.Name, registry.Event{Type: typ, Instance: si})
+	return nil
+}
+
+func (r *Registry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return errRegistryClosed
+	}
+	old, ok := r.services[si.Name][si.Address]
+	if !ok {
+		return nil
+	}
+	delete(r.services[si.Name], si.Address)
+	r.notify(si.Name, registry.Event{Type: registry.EventTypeDelete, Instance: old})
+	return nil
+}
+
+func (r *Registry) ListServices(ctx context.Context, serviceName string) ([]registry.ServiceInstance, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.closed {
+		return nil, errRegistryClosed
+	}
+	instances := r.services[serviceName]
+	res := make([]registry.ServiceInstance, 0, len(instances))
+	for _, si := range instances {
+		res = append(res, si)
+	}
+	return res, nil
+}
+
+// Subscribe ctx 被取消或者 Registry 被关闭的时候, 返回的 channel 会被关闭
+func (r *Registry) Subscribe(ctx context.Context, serviceName string) (<-chan registry.Event, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil, errRegistryClosed
+	}
+	sub := newSubscriber(ctx)
+	r.subs[serviceName] = append(r.subs[serviceName], sub)
+	go sub.loop()
+	return sub.ch, nil
+}
+
+func (r *Registry) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	for _, subs := range r.subs {
+		for _, sub := range subs {
+			sub.cancel()
+		}
+	}
+	r.subs = nil
+	return nil
+}
+
+// notify 调用方必须持有写锁
+func (r *Registry) notify(serviceName string, evt registry.Event) {
+	subs := r.subs[serviceName]
+	alive := subs[:0]
+	for _, sub := range subs {
+		if sub.ctx.Err() != nil {
+			// 订阅方已经不要了, 顺手清理掉
+			continue
+		}
+		sub.push(evt)
+		alive = append(alive, sub)
+	}
+	r.subs[serviceName] = alive
+}
+
+// subscriber 用一个无界队列缓存事件, 这样订阅方消费慢也不会阻塞注册
+type subscriber struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex  sync.Mutex
+	queue  []registry.Event
+	signal chan struct{}
+	ch     chan registry.Event
+}
+
+func newSubscriber(ctx context.Context) *subscriber {
+	ctx, cancel := context.WithCancel(ctx)
+	return &subscriber{
+		ctx:    ctx,
+		cancel: cancel,
+		signal: make(chan struct{}, 1),
+		ch:     make(chan registry.Event),
+	}
+}
+
+func (s *subscriber) push(evt registry.Event) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, evt)
+	s.mutex.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+		// 已经有信号了, loop 会把队列里面的都发出去
+	}
+}
+
+func (s *subscriber) loop() {
+	defer close(s.ch)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.signal:
+		}
+		for {
+			s.mutex.Lock()
+			if len(s.queue) == 0 {
+				s.mutex.Unlock()
+				break
+			}
+			evt := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mutex.Unlock()
+			select {
+			case s.ch <- evt:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/registry/registrytest"
+)
+
+func TestRegistry(t *testing.T) {
+	registrytest.TestRegistry(t, func(t *testing.T) registry.Registry {
+		return NewRegistry()
+	})
+}
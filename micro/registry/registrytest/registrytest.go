@@ -0,0 +1,156 @@
+// Package registrytest 提供所有 registry.Registry 实现都应该通过的一致性测试
+//
+// 使用方式:
+//
+//	func TestRegistry(t *testing.T) {
+//		registrytest.TestRegistry(t, func(t *testing.T) registry.Registry {
+//			return NewRegistry()
+//		})
+//	}
+package registrytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventTimeout 等待事件的最长时间, 基于轮询的实现也要在这个时间里面发出事件
+const eventTimeout = 5 * time.Second
+
+// TestRegistry 每个子测试都会调用 newRegistry 创建一个全新的注册中心
+// newRegistry 返回的注册中心里面不能有任何实例
+func TestRegistry(t *testing.T, newRegistry func(t *testing.T) registry.Registry) {
+	t.Run("ListServices", func(t *testing.T) {
+		testListServices(t, newRegistry(t))
+	})
+	t.Run("Subscribe", func(t *testing.T) {
+		testSubscribe(t, newRegistry(t))
+	})
+	t.Run("SubscribeCancel", func(t *testing.T) {
+		testSubscribeCancel(t, newRegistry(t))
+	})
+	t.Run("Close", func(t *testing.T) {
+		testClose(t, newRegistry(t))
+	})
+}
+
+func testListServices(t *testing.T, r registry.Registry) {
+	defer func() {
+		_ = r.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+
+	instances := []registry.ServiceInstance{
+		{Name: "user-service", Address: "127.0.0.1:8081", Weight: 1},
+		// 元数据要原样保存下来
+		{
+			Name:    "user-service",
+			Address: "127.0.0.1:8082",
+			Weight:  2,
+			Group:   "canary",
+			Version: "v1.0.0",
+			Tags:    map[string]string{"region": "cn"},
+			Health:  registry.HealthStatusServing,
+		},
+		// 名字有相同前缀的服务不能被查出来
+		{Name: "user-service-v2", Address: "127.0.0.1:8083", Weight: 3},
+	}
+	for _, si := range instances {
+		require.NoError(t, r.Register(ctx, si))
+	}
+
+	res, err := r.ListServices(ctx, "user-service")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, instances[:2], res)
+
+	res, err = r.ListServices(ctx, "order-service")
+	require.NoError(t, err)
+	assert.Len(t, res, 0)
+
+	// 重复注册相当于更新
+	updated := instances[0]
+	updated.Weight = 10
+	require.NoError(t, r.Register(ctx, updated))
+	res, err = r.ListServices(ctx, "user-service")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []registry.ServiceInstance{updated, instances[1]}, res)
+
+	require.NoError(t, r.UnRegister(ctx, updated))
+	res, err = r.ListServices(ctx, "user-service")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, instances[1:2], res)
+}
+
+func testSubscribe(t *testing.T, r registry.Registry) {
+	defer func() {
+		_ = r.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+
+	events, err := r.Subscribe(ctx, "user-service")
+	require.NoError(t, err)
+
+	si := registry.ServiceInstance{Name: "user-service", Address: "127.0.0.1:8081", Weight: 1}
+	// 其他服务的变化不应该被通知到
+	require.NoError(t, r.Register(ctx, registry.ServiceInstance{Name: "user-service-v2", Address: "127.0.0.1:8083"}))
+	require.NoError(t, r.Register(ctx, si))
+	updated := si
+	updated.Weight = 10
+	require.NoError(t, r.Register(ctx, updated))
+	require.NoError(t, r.UnRegister(ctx, updated))
+
+	wantEvents := []registry.Event{
+		{Type: registry.EventTypeAdd, Instance: si},
+		{Type: registry.EventTypeUpdate, Instance: updated},
+		{Type: registry.EventTypeDelete, Instance: updated},
+	}
+	for _, want := range wantEvents {
+		select {
+		case evt, ok := <-events:
+			require.True(t, ok, "channel 被提前关闭了")
+			assert.Equal(t, want, evt)
+		case <-ctx.Done():
+			t.Fatalf("没有收到事件 %s", want.Type)
+		}
+	}
+}
+
+func testSubscribeCancel(t *testing.T, r registry.Registry) {
+	defer func() {
+		_ = r.Close()
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := r.Subscribe(ctx, "user-service")
+	require.NoError(t, err)
+	cancel()
+	assertClosed(t, events)
+}
+
+func testClose(t *testing.T, r registry.Registry) {
+	events, err := r.Subscribe(context.Background(), "user-service")
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assertClosed(t, events)
+}
+
+func assertClosed(t *testing.T, events <-chan registry.Event) {
+	timer := time.NewTimer(eventTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			t.Fatal("channel 没有被关闭")
+		}
+	}
+}
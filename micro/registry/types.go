@@ -22,7 +22,88 @@ type ServiceInstance struct {
 	// 这边你可以加任意字段, 完全取决于你的服务治理需要什么字段
 
 	Weight int // 权重
+
+	// Group 分组, 比如说 canary 代表灰度分组
+	Group string `json:",omitempty" yaml:",omitempty"`
+	// Version 服务的版本
+	Version string `json:",omitempty" yaml:",omitempty"`
+	// Tags 任意的键值对, 可以用来做路由
+	Tags map[string]string `json:",omitempty" yaml:",omitempty"`
+	// Health 实例的健康状态, 不健康的实例不会被客户端选中
+	Health HealthStatus `json:",omitempty" yaml:",omitempty"`
+}
+
+// Equal 判断两个实例的信息是不是完全一样
+func (si ServiceInstance) Equal(other ServiceInstance) bool {
+	if si.Name != other.Name || si.Address != other.Address || si.Weight != other.Weight ||
+		si.Group != other.Group || si.Version != other.Version || si.Health != other.Health {
+		return false
+	}
+	if len(si.Tags) != len(other.Tags) {
+		return false
+	}
+	for key, val := range si.Tags {
+		if otherVal, ok := other.Tags[key]; !ok || otherVal != val {
+			return false
+		}
+	}
+	return true
+}
+
+type HealthStatus int
+
+const (
+	// HealthStatusUnknown 没有上报健康状态, 当成健康的来处理, 兼容老的实例
+	HealthStatusUnknown HealthStatus = iota
+	// HealthStatusServing 健康, 可以正常处理请求
+	HealthStatusServing
+	// HealthStatusNotServing 不健康, 不应该再有请求过来
+	HealthStatusNotServing
+)
+
+func (h HealthStatus) String() string {
+	switch h {
+	case HealthStatusServing:
+		return "SERVING"
+	case HealthStatusNotServing:
+		return "NOT_SERVING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Available 实例能不能处理请求
+func (h HealthStatus) Available() bool {
+	return h != HealthStatusNotServing
+}
+
+type EventType int
+
+const (
+	EventTypeUnknown EventType = iota
+	// EventTypeAdd 新的服务实例注册上来了
+	EventTypeAdd
+	// EventTypeUpdate 服务实例的信息发生了变化, 比如说权重
+	EventTypeUpdate
+	// EventTypeDelete 服务实例下线了
+	EventTypeDelete
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventTypeAdd:
+		return "ADD"
+	case EventTypeUpdate:
+		return "UPDATE"
+	case EventTypeDelete:
+		return "DELETE"
+	default:
+		return "UNKNOWN"
+	}
 }
 
 type Event struct {
+	Type EventType
+	// Instance 发生变化的服务实例, 对于 EventTypeDelete 来说是删除之前的信息
+	Instance ServiceInstance
 }
@@ -0,0 +1,220 @@
+// Package retry 调用策略: 重试, 总超时时间和对冲请求
+// micro/rpc.Client 和 micro.Client 都使用它
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Policy 一个方法的调用策略
+type Policy struct {
+	// MaxAttempts 最多调用多少次, 包括第一次, 小于等于 1 就是不重试
+	MaxAttempts int
+	// InitialBackoff 第一次重试之前等待的时间, 之后每次乘以 BackoffMultiplier, 最多不超过 MaxBackoff
+	// 为 0 就是不等待马上重试
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// BackoffMultiplier 小于等于 0 的时候使用 2
+	BackoffMultiplier float64
+	// Jitter 退避时间上下浮动的比例, 0.2 就是在 [0.8, 1.2] 倍之间随机, 避免所有客户端同时重试
+	Jitter float64
+
+	// Retryable 判断一个错误要不要重试, 为 nil 的时候使用调用方提供的默认判断
+	Retryable func(err error) bool
+
+	// Timeout 整个调用的超时时间, 包括所有的重试, 为 0 就是不限制
+	// 剩下的时间会传给服务端
+	Timeout time.Duration
+
+	// Idempotent 方法是不是幂等的, 只有幂等的方法才会重试和对冲
+	Idempotent bool
+
+	// HedgingDelay 大于 0 的时候开启对冲:
+	// 过了 HedgingDelay 还没有响应, 就再发一个请求, 最多同时有 MaxAttempts 个, 谁先成功用谁的
+	// 开启对冲之后就不会再按照退避时间重试了
+	HedgingDelay time.Duration
+}
+
+func (p *Policy) attempts() int {
+	if !p.Idempotent || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// backoff 第 n 次重试(从 1 开始)之前等待的时间
+func (p *Policy) backoff(n int) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	multiplier := p.BackoffMultiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < n; i++ {
+		backoff *= multiplier
+		if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff *= 1 + p.Jitter*(rand.Float64()*2-1)
+	}
+	return time.Duration(backoff)
+}
+
+// Config 按照服务和方法配置调用策略
+type Config struct {
+	// Default 没有单独配置的方法使用的策略, 为 nil 就是不使用任何策略
+	Default *Policy
+	// Policies key 是服务名, 或者服务名/方法名, 方法的配置优先
+	Policies map[string]*Policy
+}
+
+// Policy 找到方法的调用策略, 可能返回 nil
+func (c Config) Policy(service, method string) *Policy {
+	if p, ok := c.Policies[service+"/"+method]; ok {
+		return p
+	}
+	if p, ok := c.Policies[service]; ok {
+		return p
+	}
+	return c.Default
+}
+
+// SplitMethod 把 gRPC 的 /package.Service/Method 拆成服务名和方法名
+func SplitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	idx := strings.LastIndexByte(fullMethod, '/')
+	if idx < 0 {
+		return fullMethod, ""
+	}
+	return fullMethod[:idx], fullMethod[idx+1:]
+}
+
+// Do 按照策略执行 fn, p 为 nil 就只调用一次
+// fn 收到的 ctx 带上了 Timeout, 对冲的时候 fn 会被并发调用, 输掉的那些请求的 ctx 会被取消
+// retryable 是 p.Retryable 为 nil 的时候使用的默认判断
+func Do[T any](ctx context.Context, p *Policy, retryable func(err error) bool,
+	fn func(ctx context.Context) (T, error)) (T, error) {
+	if p == nil {
+		return fn(ctx)
+	}
+	if p.Retryable != nil {
+		retryable = p.Retryable
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	attempts := p.attempts()
+	if p.HedgingDelay > 0 && attempts > 1 {
+		return hedge(ctx, p, attempts, retryable, fn)
+	}
+
+	var res T
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			if !retryable(err) {
+				return res, err
+			}
+			if !sleep(ctx, p.backoff(i)) {
+				// 没有时间再重试了, 返回最后一次的错误
+				return res, err
+			}
+		}
+		res, err = fn(ctx)
+		if err == nil || ctx.Err() != nil {
+			return res, err
+		}
+	}
+	return res, err
+}
+
+type result[T any] struct {
+	res T
+	err error
+}
+
+func hedge[T any](ctx context.Context, p *Policy, attempts int, retryable func(err error) bool,
+	fn func(ctx context.Context) (T, error)) (T, error) {
+	// 有一个成功了就取消其他的
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result[T], attempts)
+	launched := 0
+	launch := func() {
+		launched++
+		go func() {
+			res, err := fn(ctx)
+			results <- result[T]{res: res, err: err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(p.HedgingDelay)
+	defer timer.Stop()
+	var last result[T]
+	received := 0
+	for {
+		select {
+		case r := <-results:
+			received++
+			if r.err == nil {
+				return r.res, nil
+			}
+			last = r
+			if !retryable(r.err) {
+				return r.res, r.err
+			}
+			if launched < attempts {
+				// 失败了就不用等 HedgingDelay 了
+				launch()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(p.HedgingDelay)
+			} else if received == launched {
+				return last.res, last.err
+			}
+		case <-timer.C:
+			if launched < attempts {
+				launch()
+				timer.Reset(p.HedgingDelay)
+			}
+		case <-ctx.Done():
+			if received > 0 {
+				return last.res, last.err
+			}
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// sleep 返回 false 表示 ctx 已经结束了
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,214 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errRetryable = errors.New("retryable")
+
+func isRetryable(err error) bool {
+	return errors.Is(err, errRetryable)
+}
+
+func TestDo(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy *Policy
+		// results 第 i 次调用返回的错误, 超出范围的都成功
+		results []error
+
+		wantErr      error
+		wantAttempts int32
+	}{
+		{
+			name:         "nil policy",
+			results:      []error{errRetryable},
+			wantErr:      errRetryable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "not idempotent",
+			policy:       &Policy{MaxAttempts: 3},
+			results:      []error{errRetryable},
+			wantErr:      errRetryable,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retry success",
+			policy:       &Policy{MaxAttempts: 3, Idempotent: true, InitialBackoff: time.Millisecond},
+			results:      []error{errRetryable, errRetryable},
+			wantAttempts: 3,
+		},
+		{
+			name:         "exhausted",
+			policy:       &Policy{MaxAttempts: 2, Idempotent: true},
+			results:      []error{errRetryable, errRetryable, errRetryable},
+			wantErr:      errRetryable,
+			wantAttempts: 2,
+		},
+		{
+			name:         "not retryable",
+			policy:       &Policy{MaxAttempts: 3, Idempotent: true},
+			results:      []error{errors.New("bad request")},
+			wantErr:      errors.New("bad request"),
+			wantAttempts: 1,
+		},
+		{
+			name: "policy retryable",
+			policy: &Policy{MaxAttempts: 3, Idempotent: true, Retryable: func(err error) bool {
+				return false
+			}},
+			results:      []error{errRetryable},
+			wantErr:      errRetryable,
+			wantAttempts: 1,
+		},
+		{
+			name: "timeout during backoff",
+			policy: &Policy{MaxAttempts: 3, Idempotent: true,
+				InitialBackoff: time.Second, Timeout: 50 * time.Millisecond},
+			results:      []error{errRetryable, errRetryable},
+			wantErr:      errRetryable,
+			wantAttempts: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int32
+			res, err := Do(context.Background(), c.policy, isRetryable, func(ctx context.Context) (int32, error) {
+				n := atomic.AddInt32(&attempts, 1)
+				if int(n) <= len(c.results) {
+					return 0, c.results[n-1]
+				}
+				return n, nil
+			})
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantAttempts, attempts)
+			if err == nil {
+				assert.Equal(t, c.wantAttempts, res)
+			}
+		})
+	}
+}
+
+func TestDo_timeout(t *testing.T) {
+	p := &Policy{Timeout: 100 * time.Millisecond}
+	_, err := Do(context.Background(), p, isRetryable, func(ctx context.Context) (int, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.True(t, time.Until(deadline) <= p.Timeout)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestDo_hedging(t *testing.T) {
+	cases := []struct {
+		name string
+		// fn 第 n 次调用(从 1 开始)
+		fn func(ctx context.Context, n int32) (int32, error)
+
+		wantRes      int32
+		wantErr      error
+		wantAttempts int32
+	}{
+		{
+			name: "first slow",
+			fn: func(ctx context.Context, n int32) (int32, error) {
+				if n == 1 {
+					// 第一个请求卡住了, 对冲的请求先返回, 第一个请求会被取消
+					<-ctx.Done()
+					return 0, ctx.Err()
+				}
+				return n, nil
+			},
+			wantRes:      2,
+			wantAttempts: 2,
+		},
+		{
+			name: "failed fast",
+			fn: func(ctx context.Context, n int32) (int32, error) {
+				if n < 3 {
+					return 0, errRetryable
+				}
+				return n, nil
+			},
+			wantRes:      3,
+			wantAttempts: 3,
+		},
+		{
+			name: "not retryable",
+			fn: func(ctx context.Context, n int32) (int32, error) {
+				return 0, context.Canceled
+			},
+			wantErr:      context.Canceled,
+			wantAttempts: 1,
+		},
+		{
+			name: "all failed",
+			fn: func(ctx context.Context, n int32) (int32, error) {
+				return 0, errRetryable
+			},
+			wantErr:      errRetryable,
+			wantAttempts: 3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := &Policy{MaxAttempts: 3, Idempotent: true, HedgingDelay: 20 * time.Millisecond}
+			// 输掉的请求返回的时候可能已经在跑下一个用例了
+			fn := c.fn
+			var attempts int32
+			res, err := Do(context.Background(), p, isRetryable, func(ctx context.Context) (int32, error) {
+				return fn(ctx, atomic.AddInt32(&attempts, 1))
+			})
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantRes, res)
+			assert.Equal(t, c.wantAttempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+func TestPolicy_backoff(t *testing.T) {
+	p := &Policy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, p.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(4))
+	assert.Equal(t, 50*time.Millisecond, p.backoff(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		b := p.backoff(1)
+		assert.True(t, b >= 5*time.Millisecond && b <= 15*time.Millisecond)
+	}
+}
+
+func TestConfig_Policy(t *testing.T) {
+	def := &Policy{MaxAttempts: 1}
+	svc := &Policy{MaxAttempts: 2}
+	method := &Policy{MaxAttempts: 3}
+	cfg := Config{
+		Default: def,
+		Policies: map[string]*Policy{
+			"user-service":        svc,
+			"user-service/Create": method,
+		},
+	}
+	assert.Equal(t, method, cfg.Policy("user-service", "Create"))
+	assert.Equal(t, svc, cfg.Policy("user-service", "GetByID"))
+	assert.Equal(t, def, cfg.Policy("order-service", "Create"))
+	assert.Nil(t, Config{}.Policy("user-service", "GetByID"))
+}
+
+func TestSplitMethod(t *testing.T) {
+	service, method := SplitMethod("/user.UserService/GetById")
+	assert.Equal(t, "user.UserService", service)
+	assert.Equal(t, "GetById", method)
+}
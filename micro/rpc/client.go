@@ -3,24 +3,36 @@ package rpc
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
+	"os"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/silenceper/pool"
-
+	micronet "github.com/startdusk/go-libs/micro/net"
+	"github.com/startdusk/go-libs/micro/retry"
+	"github.com/startdusk/go-libs/micro/rpc/compress"
 	"github.com/startdusk/go-libs/micro/rpc/message"
 	"github.com/startdusk/go-libs/micro/rpc/serialize"
 	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-// InitService
+// InitService 使用 ClientWithServiceSerializer 给这个服务设置的序列化协议, 没有设置就用客户端默认的
 func (c *Client) InitService(service Service) error {
-	return setFuncField(service, c, c.serializer)
+	serializers, ok := c.serviceSerializers[service.Name()]
+	if !ok {
+		return setFuncField(service, c, c.serializer)
+	}
+	return setFuncField(service, c, serializers[0], serializers[1:]...)
 }
 
-func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
+// setFuncField fallbacks 是服务端不支持 s 的时候可以换的序列化协议
+func setFuncField(service Service, p Proxy, s serialize.Serializer, fallbacks ...serialize.Serializer) error {
 	if service == nil {
 		return errors.New("rpc: 不支持nil")
 	}
@@ -32,47 +44,27 @@ func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
 	}
 	val = val.Elem()
 	typ = typ.Elem()
+	set := newSerializerSet(s, fallbacks...)
 	numField := typ.NumField()
 	for i := 0; i < numField; i++ {
 		fieldVal := val.Field(i)
 		fieldTyp := typ.Field(i)
+		if fieldVal.CanSet() && isClientStream(fieldTyp.Type.Out(0)) {
+			fieldVal.Set(reflect.MakeFunc(fieldTyp.Type, streamFunc(service, fieldTyp, p, set)))
+			continue
+		}
 		if fieldVal.CanSet() {
 			fn := func(args []reflect.Value) []reflect.Value {
 				retVal := reflect.New(fieldTyp.Type.Out(0).Elem())
 				// args[0] 是 context // context我们不会上传到服务端, 但context里面的数据可能会
 				ctx := args[0].Interface().(context.Context)
 				// args[1] 是 req
-				reqData, err := s.Encode(args[1].Interface())
-				if err != nil {
-					// 这里相当于返回 (类型的零值, error)
-					return []reflect.Value{
-						retVal,
-						reflect.ValueOf(err),
-					}
+				s := set.get()
+				err := Call(ctx, p, s, service.Name(), fieldTyp.Name, args[1].Interface(), retVal.Interface())
+				if next, ok := set.fallback(s, err); ok {
+					// 服务端不支持这个序列化协议, 换一个它支持的再调用一次
+					err = Call(ctx, p, next, service.Name(), fieldTyp.Name, args[1].Interface(), retVal.Interface())
 				}
-				var meta map[string]string
-				if deadline, ok := ctx.Deadline(); ok {
-					meta = map[string]string{"deadline": strconv.FormatInt(deadline.UnixMilli(), 10)}
-				}
-				if isOneway(ctx) {
-					if meta == nil {
-						meta = map[string]string{"one-way": "true"}
-					} else {
-						meta["one-way"] = "true"
-					}
-				}
-				req := &message.Request{
-					ServiceName: service.Name(),
-					MethodName:  fieldTyp.Name,
-					Serializer:  s.Code(),
-					Data:        reqData,
-					Meta:        meta,
-				}
-				req.CalculateHeaderLength()
-				req.CalculateBodyLength()
-
-				// 真正发起调用
-				resp, err := p.Invoke(ctx, req)
 				if err != nil {
 					// 这里相当于返回 (类型的零值, error)
 					return []reflect.Value{
@@ -80,33 +72,10 @@ func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
 						reflect.ValueOf(err),
 					}
 				}
-				var serverErr error
-				if len(resp.Error) > 0 {
-					// 服务端返回的error
-					serverErr = errors.New(string(resp.Error))
-				}
-
-				if len(resp.Data) > 0 {
-					if err := s.Decode(resp.Data, retVal.Interface()); err != nil {
-						return []reflect.Value{
-							retVal,
-							reflect.ValueOf(err),
-						}
-					}
-				}
-
-				var retErr reflect.Value
-				if serverErr != nil {
-					retErr = reflect.ValueOf(serverErr)
-				} else {
-					// 返回 nil(写法很独特)
-					retErr = reflect.Zero(reflect.TypeOf(new(error)).Elem())
-
-				}
-
 				return []reflect.Value{
 					retVal,
-					retErr,
+					// 返回 nil(写法很独特)
+					reflect.Zero(reflect.TypeOf(new(error)).Elem()),
 				}
 			}
 			// 给结构体字段赋值
@@ -116,12 +85,167 @@ func setFuncField(service Service, p Proxy, s serialize.Serializer) error {
 	return nil
 }
 
-// 长度字段使用的字节数量
-const numOfLengthBytes = 8
+// Call 发起一次普通调用, 生成的客户端代码直接使用它, 不需要反射
+// resp 应该是一个结构体指针, 服务端同时返回了数据和错误的时候, resp 会被填充, 同时返回错误
+func Call(ctx context.Context, p Proxy, s serialize.Serializer, service, method string, req, resp any) error {
+	reqData, err := s.Encode(req)
+	if err != nil {
+		return err
+	}
+	msg := &message.Request{
+		ServiceName: service,
+		MethodName:  method,
+		Serializer:  s.Code(),
+		Data:        reqData,
+		Meta:        buildMeta(ctx),
+	}
+	msg.CalculateHeaderLength()
+	msg.CalculateBodyLength()
+
+	// 真正发起调用
+	r, err := p.Invoke(ctx, msg)
+	if err != nil || r == nil {
+		// oneway 调用发出去就结束了, resp 保持原样
+		return err
+	}
+	if len(r.Data) > 0 {
+		if err = s.Decode(r.Data, resp); err != nil {
+			return err
+		}
+	}
+	if st := StatusFromResponse(r); st != nil {
+		// 服务端返回的error, 还原成 Status, 注册过的哨兵错误可以用 errors.Is 判断
+		return st
+	}
+	return nil
+}
+
+// Serializer 客户端使用的序列化协议, 生成的客户端代码需要它
+func (c *Client) Serializer() serialize.Serializer {
+	return c.serializer
+}
+
+// SerializerFor 调用 service 使用的序列化协议, 生成的客户端代码可以用它代替 Serializer
+func (c *Client) SerializerFor(service string) serialize.Serializer {
+	if serializers, ok := c.serviceSerializers[service]; ok {
+		return serializers[0]
+	}
+	return c.serializer
+}
+
+// streamFunc 流式调用的字段, 打开流之后把流交给用户
+// 流式调用的错误要到 Recv 的时候才知道, 所以不会换序列化协议
+func streamFunc(service Service, fieldTyp reflect.StructField, p Proxy, set *serializerSet) func(args []reflect.Value) []reflect.Value {
+	outTyp := fieldTyp.Type.Out(0)
+	return func(args []reflect.Value) []reflect.Value {
+		ctx := args[0].Interface().(context.Context)
+		s := set.get()
+		retErr := func(err error) []reflect.Value {
+			return []reflect.Value{reflect.Zero(outTyp), reflect.ValueOf(err)}
+		}
+		opener, ok := p.(streamOpener)
+		if !ok {
+			return retErr(errStreamNotSupport)
+		}
+		st, err := opener.newStream(ctx, &message.Request{
+			ServiceName: service.Name(),
+			MethodName:  fieldTyp.Name,
+			Meta:        buildMeta(ctx),
+		}, s)
+		if err != nil {
+			return retErr(err)
+		}
+		// 服务端流, 把请求发过去之后就半关闭
+		if len(args) == 2 {
+			if err = st.send(args[1].Interface()); err == nil {
+				err = st.closeSend()
+			}
+			// io.EOF 说明服务端已经结束了这个流, 比如说被拦截器拒绝了, 真正的错误由 Recv 返回
+			if err != nil && err != io.EOF {
+				st.finish()
+				return retErr(err)
+			}
+		}
+		retVal := reflect.New(outTyp.Elem())
+		retVal.Interface().(clientStreamSetter).setStream(st)
+		return []reflect.Value{
+			retVal,
+			reflect.Zero(reflect.TypeOf(new(error)).Elem()),
+		}
+	}
+}
+
+// buildMeta 把 ctx 里面的元数据放到 Meta 里面传给服务端
+// 先放用户设置的, 再放框架关心的数据, 保证框架的 key 不会被覆盖
+// trace 上下文不在这里放, 要等拦截器都执行完了由 injectTrace 放, 这样服务端的 span 才能挂在客户端拦截器开启的 span 下面
+func buildMeta(ctx context.Context) map[string]string {
+	outgoing, _ := ctx.Value(outgoingMetaKey{}).(map[string]string)
+	meta := make(map[string]string, len(outgoing)+2)
+	for k, v := range outgoing {
+		meta[k] = v
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		meta[metaDeadline] = strconv.FormatInt(deadline.UnixMilli(), 10)
+	}
+	if isOneway(ctx) {
+		meta[metaOneway] = "true"
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// injectTrace 把 trace 上下文和 baggage 放到 Meta 里面, 框架是唯一放 trace 上下文的地方, 拦截器不用再放
+func injectTrace(ctx context.Context, req *message.Request) {
+	if req.Meta == nil {
+		req.Meta = make(map[string]string, 2)
+	}
+	metaPropagator.Inject(ctx, propagation.MapCarrier(req.Meta))
+	if len(req.Meta) == 0 {
+		req.Meta = nil
+	}
+}
 
 type Client struct {
-	pool       pool.Pool
-	serializer serialize.Serializer
+	addr      string
+	transport transport.Transport
+	// dialTimeout 建立连接的超时时间, 包括 TLS 握手
+	dialTimeout time.Duration
+	// idleTimeout 连接空闲超过这个时间就关掉, 下次调用的时候再重新建立, 为 0 就一直保持
+	idleTimeout time.Duration
+	// heartbeat 心跳间隔, 为 0 就不发心跳, heartbeatTimeout 超过这个时间没有收到数据就认为连接断了
+	heartbeat        time.Duration
+	heartbeatTimeout time.Duration
+	serializer       serialize.Serializer
+	// serviceSerializers 服务名 -> 这个服务可以使用的序列化协议, 第一个优先
+	serviceSerializers map[string][]serialize.Serializer
+	// compressor 发送请求使用的压缩算法, 为 nil 就不压缩
+	compressor compress.Compressor
+	// compressors 用来解压响应
+	compressors map[uint8]compress.Compressor
+	// version 新连接一开始使用的协议版本, negotiate 为 true 的时候根据服务端的响应升级
+	version   uint8
+	negotiate bool
+	// maxFrameSize 能接收的最大的响应帧
+	maxFrameSize uint32
+
+	interceptors []Interceptor
+	// handler 套上了 interceptors 的 send
+	handler Handler
+	// policies 调用策略, 重试的时候每一次都会经过 interceptors
+	policies retry.Config
+
+	// reqID 用来生成 RequestID, 响应靠 RequestID 找到对应的请求
+	reqID uint32
+
+	// pool 不为 nil 的时候, 普通调用从连接池里面独占一个连接, 流式调用还是用多路复用的连接
+	pool     *micronet.Pool
+	poolOpts *poolOptions
+
+	mutex sync.Mutex
+	// conn 所有请求共用的多路复用连接, 第一次调用的时候才建立, 断开了会重新建立
+	conn *muxConn
 }
 
 type ClientOption func(c *Client)
@@ -132,28 +256,130 @@ func ClientWithSerializer(serializer serialize.Serializer) ClientOption {
 	}
 }
 
-func NewClient(addr string, opts ...ClientOption) (*Client, error) {
-	p, err := pool.NewChannelPool(&pool.Config{
-		MaxCap:      30,
-		MaxIdle:     10,
-		IdleTimeout: time.Minute,
-		Factory: func() (any, error) {
-			return net.DialTimeout("tcp", addr, 3*time.Second)
-		},
-		Close: func(i any) error {
-			return i.(net.Conn).Close()
-		},
-	})
-	if err != nil {
-		return nil, err
+// ClientWithServiceSerializer 给某个服务单独设置序列化协议, 优先使用 s
+// 服务端不支持 s 的时候会告诉客户端它支持哪些, 客户端换成 fallbacks 里面第一个服务端支持的, 重新调用一次
+func ClientWithServiceSerializer(service string, s serialize.Serializer, fallbacks ...serialize.Serializer) ClientOption {
+	return func(c *Client) {
+		c.serviceSerializers[service] = append([]serialize.Serializer{s}, fallbacks...)
+	}
+}
+
+// ClientWithTransport 默认是 TCP
+func ClientWithTransport(t transport.Transport) ClientOption {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// ClientWithDialTimeout 默认是 3 秒
+func ClientWithDialTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.dialTimeout = timeout
+	}
+}
+
+// ClientWithIdleTimeout 连接上没有请求超过 timeout 就关闭, 默认不关闭
+func ClientWithIdleTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.idleTimeout = timeout
+	}
+}
+
+// ClientWithHeartbeat 连接上超过 interval 没有收到数据就发心跳, 超过 timeout 还是没有收到就关掉连接,
+// 下一次调用会重新建立连接. timeout 应该比 interval 大, 一般是它的两三倍
+// 使用连接池的时候, 空闲超过 interval 的连接借出去之前会先发一次心跳, 收不到响应就换一个
+func ClientWithHeartbeat(interval, timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.heartbeat = interval
+		c.heartbeatTimeout = timeout
+	}
+}
+
+// ClientWithProtocolVersion 固定使用某个协议版本, 不再和服务端协商
+// 默认从 message.Version0 开始, 服务端支持的话升级到 message.MaxVersion, 这样新老服务端都能访问
+// 确定服务端都已经支持新版本的时候可以直接指定, 省掉第一次调用使用老格式
+func ClientWithProtocolVersion(version uint8) ClientOption {
+	return func(c *Client) {
+		c.version = version
+		c.negotiate = false
+	}
+}
+
+// ClientWithMaxFrameSize 能接收的最大的帧, 默认是 DefaultMaxFrameSize, 超过了就关闭连接
+func ClientWithMaxFrameSize(size uint32) ClientOption {
+	return func(c *Client) {
+		c.maxFrameSize = size
+	}
+}
+
+// ClientWithCompressor 设置发送请求使用的压缩算法, 同时也会注册这个算法用来解压响应
+func ClientWithCompressor(compressor compress.Compressor) ClientOption {
+	return func(c *Client) {
+		c.compressor = compressor
+		c.compressors[compressor.Code()] = compressor
+	}
+}
+
+// ClientWithInterceptors 第一个 Interceptor 在最外层
+func ClientWithInterceptors(interceptors ...Interceptor) ClientOption {
+	return func(c *Client) {
+		c.interceptors = append(c.interceptors, interceptors...)
+	}
+}
+
+// ClientWithCallPolicy 设置按照服务和方法区分的调用策略: 重试, 超时和对冲
+// 只有 Idempotent 的方法才会重试, 没有设置 Retryable 的时候只重试 CodeUnavailable 和网络错误
+func ClientWithCallPolicy(cfg retry.Config) ClientOption {
+	return func(c *Client) {
+		c.policies = cfg
+	}
+}
+
+type poolOptions struct {
+	maxIdleCnt  int
+	maxCnt      int
+	maxIdleTime time.Duration
+	opts        []micronet.PoolOption
+}
+
+// ClientWithPool 普通调用使用连接池, 每个请求独占一个连接, 而不是所有请求共用一个多路复用的连接
+// 适合服务端不支持多路复用, 或者单个连接成为瓶颈的场景. 默认借出连接之前会用 micronet.CheckAlive 检查连接,
+// 设置了 ClientWithHeartbeat 的话空闲太久的连接会发心跳检查
+func ClientWithPool(maxIdleCnt, maxCnt int, maxIdleTime time.Duration, opts ...micronet.PoolOption) ClientOption {
+	return func(c *Client) {
+		c.poolOpts = &poolOptions{
+			maxIdleCnt:  maxIdleCnt,
+			maxCnt:      maxCnt,
+			maxIdleTime: maxIdleTime,
+			opts:        opts,
+		}
 	}
+}
+
+func NewClient(addr string, opts ...ClientOption) (*Client, error) {
 	c := &Client{
-		pool:       p,
-		serializer: &json.Serializer{},
+		addr:               addr,
+		transport:          transport.TCP{},
+		dialTimeout:        3 * time.Second,
+		serializer:         &json.Serializer{},
+		compressors:        make(map[uint8]compress.Compressor, 4),
+		serviceSerializers: make(map[string][]serialize.Serializer, 4),
+		negotiate:          true,
+		maxFrameSize:       DefaultMaxFrameSize,
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.poolOpts != nil {
+		// 用户的配置放在后面, 可以覆盖默认的健康检查
+		poolOpts := append([]micronet.PoolOption{micronet.PoolWithHealthCheck(c.checkPooled)}, c.poolOpts.opts...)
+		pool, err := micronet.NewPool(0, c.poolOpts.maxIdleCnt, c.poolOpts.maxCnt, c.poolOpts.maxIdleTime, c.dialPooled, poolOpts...)
+		if err != nil {
+			return nil, err
+		}
+		c.pool = pool
+	}
+	c.handler = chain(c.send, c.interceptors)
 	return c, nil
 }
 
@@ -161,52 +387,342 @@ func (c *Client) Invoke(ctx context.Context, req *message.Request) (*message.Res
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	var (
-		resp *message.Response
-		err  error
-	)
-	ch := make(chan struct{})
+	p := c.policies.Policy(req.ServiceName, req.MethodName)
+	if p == nil {
+		return c.handler(ctx, req)
+	}
+	resp, err := retry.Do(ctx, p, defaultRetryable, func(ctx context.Context) (*message.Response, error) {
+		resp, err := c.handler(ctx, attemptReq(ctx, req))
+		if err != nil {
+			return resp, err
+		}
+		// 服务端返回的错误放在响应里面, 也要判断要不要重试
+		if st := StatusFromResponse(resp); st != nil {
+			return resp, &respError{resp: resp, st: st}
+		}
+		return resp, nil
+	})
+	var re *respError
+	if errors.As(err, &re) {
+		// 最终还是失败了, 把服务端的响应原样交给调用方
+		return re.resp, nil
+	}
+	return resp, err
+}
+
+// attemptReq 每一次尝试都使用一个新的请求, 因为 send 会修改请求
+// deadline 换成这一次尝试剩下的时间
+func attemptReq(ctx context.Context, req *message.Request) *message.Request {
+	r := *req
+	r.Meta = make(map[string]string, len(req.Meta)+1)
+	for k, v := range req.Meta {
+		r.Meta[k] = v
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		r.Meta[metaDeadline] = strconv.FormatInt(deadline.UnixMilli(), 10)
+	}
+	return &r
+}
+
+// respError 服务端在响应里面返回的错误
+type respError struct {
+	resp *message.Response
+	st   *Status
+}
+
+func (r *respError) Error() string {
+	return r.st.Error()
+}
+
+func (r *respError) Unwrap() error {
+	return r.st
+}
+
+// RetryOn 返回一个判断函数, 错误码在 codes 里面才重试
+func RetryOn(codes ...Code) func(err error) bool {
+	return func(err error) bool {
+		code := FromError(err).Code
+		for _, c := range codes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// defaultRetryable 只重试服务不可用和网络错误, 超时和取消不重试
+func defaultRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st.Code == CodeUnavailable
+	}
+	var netErr net.Error
+	return errors.Is(err, errConnClosed) || errors.Is(err, errHeartbeatTimeout) ||
+		errors.As(err, &netErr) || errors.Is(err, io.EOF)
+}
+
+// send 真正把请求发给服务端
+func (c *Client) send(ctx context.Context, req *message.Request) (*message.Response, error) {
+	req.RequestID = atomic.AddUint32(&c.reqID, 1)
+	injectTrace(ctx, req)
+	// Interceptor 可能修改了 Meta 或者 Data
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	if c.compressor != nil && len(req.Data) > 0 {
+		data, err := c.compressor.Compress(req.Data)
+		if err != nil {
+			return nil, err
+		}
+		req.Data = data
+		req.Compresser = c.compressor.Code()
+		req.CalculateBodyLength()
+	}
+	if c.pool != nil {
+		return c.sendPooled(ctx, req)
+	}
+	conn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	// 超时或者取消的时候, muxConn 只会放弃这一个请求, 连接还能继续用
+	resp, err := conn.send(ctx, req)
+	if errors.Is(err, errIdleClosed) {
+		// 拿到连接之后它刚好因为空闲被关掉了, send 只有在登记请求之前发现连接关了才会返回 errIdleClosed,
+		// 请求一定还没有写出去, 换一个新的连接重新发不会让服务端执行两次
+		if conn, err = c.getConn(); err != nil {
+			return nil, err
+		}
+		resp, err = conn.send(ctx, req)
+	}
+	if err != nil || resp == nil {
+		// oneway 调用没有响应
+		return nil, err
+	}
+	resp.Data, err = decompress(c.compressors, resp.Compresser, resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// sendPooled 从连接池里面拿一个连接, 独占它发请求读响应
+// 出了任何错误连接上都可能残留没读完的数据, 不能再还回去, 直接丢掉
+func (c *Client) sendPooled(ctx context.Context, req *message.Request) (*message.Response, error) {
+	conn, err := c.pool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// 没有超时时间的时候就是零值, 代表不超时
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = c.pool.Discard(conn)
+		return nil, err
+	}
+	// ctx 被取消的时候打断阻塞的读写
+	done, stopped := make(chan struct{}), make(chan struct{})
 	go func() {
-		resp, err = c.doInvoke(ctx, req)
-		ch <- struct{}{}
-		close(ch)
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
 	}()
-	// 监听超时等待
-	// 这种写法的缺点是每次都会消耗一个channel
-	select {
-	case <-ch:
-		return resp, err
-	case <-ctx.Done(): // 超时
-		return nil, ctx.Err()
+	resp, err := exchange(ctx, conn.(*pooledConn), req)
+	close(done)
+	<-stopped
+	if err != nil {
+		_ = c.pool.Discard(conn)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// 连接的超时时间就是 ctx 的超时时间, 可能比 ctx 先一步到
+			return nil, context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	conn.(*pooledConn).lastUsed = time.Now()
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		_ = c.pool.Discard(conn)
+	} else {
+		_ = c.pool.Put(context.Background(), conn)
 	}
+	if resp == nil {
+		return nil, nil
+	}
+	resp.Data, err = decompress(c.compressors, resp.Compresser, resp.Data)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
-func (c *Client) doInvoke(ctx context.Context, req *message.Request) (*message.Response, error) {
-	data := message.EncodeReq(req)
-	resp, err := c.send(ctx, data)
+// exchange 在独占的连接上写请求, 读响应
+func exchange(ctx context.Context, conn *pooledConn, req *message.Request) (*message.Response, error) {
+	data, err := encodeReq(req, conn.version)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(data); err != nil {
+		return nil, err
+	}
+	if isOneway(ctx) {
+		return nil, nil
+	}
+	data, err = readMsg(conn, conn.maxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := message.DecodeResp(data)
 	if err != nil {
 		return nil, err
 	}
-	return message.DecodeResp(resp), nil
+	if resp.RequestID != req.RequestID {
+		return nil, errUnexpectedResp
+	}
+	if conn.negotiate {
+		conn.version = negotiateVersion(conn.version, resp)
+	}
+	return resp, nil
+}
+
+// pooledConn 连接池里面的连接, 同一时间只有一个请求在用, 记录自己协商好的协议版本
+type pooledConn struct {
+	net.Conn
+	version      uint8
+	negotiate    bool
+	maxFrameSize uint32
+	// lastUsed 最后一次成功使用的时间
+	lastUsed time.Time
+}
+
+// checkPooled 借出连接之前的健康检查
+// 最近用过的连接只检查有没有被关掉, 空闲太久的连接发一次心跳, 中间的 NAT 可能已经悄悄把它丢掉了
+func (c *Client) checkPooled(conn net.Conn) error {
+	pc := conn.(*pooledConn)
+	if c.heartbeat <= 0 || time.Since(pc.lastUsed) < c.heartbeat {
+		return micronet.CheckAlive(pc)
+	}
+	if err := pc.SetDeadline(time.Now().Add(c.heartbeatTimeout)); err != nil {
+		return err
+	}
+	if _, err := exchange(context.Background(), pc, newPing(atomic.AddUint32(&c.reqID, 1))); err != nil {
+		return err
+	}
+	pc.lastUsed = time.Now()
+	return pc.SetDeadline(time.Time{})
 }
 
-func (c *Client) send(ctx context.Context, data []byte) ([]byte, error) {
-	val, err := c.pool.Get()
+var errUnexpectedResp = errors.New("rpc: 响应和请求对不上")
+
+func (c *Client) newStream(ctx context.Context, open *message.Request, s serialize.Serializer) (*clientStream, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	conn, err := c.getConn()
 	if err != nil {
 		return nil, err
 	}
-	conn := val.(net.Conn)
-	defer func() {
-		_ = conn.Close()
-	}()
+	if err = conn.upgrade(ctx, atomic.AddUint32(&c.reqID, 1)); err != nil {
+		return nil, err
+	}
+	id := atomic.AddUint32(&c.reqID, 1)
+	ms, err := conn.openStream(id)
+	if err != nil {
+		return nil, err
+	}
+	open.RequestID = id
+	injectTrace(ctx, open)
+	open.Flag = message.FlagStream
+	open.Serializer = s.Code()
+	if c.compressor != nil {
+		open.Compresser = c.compressor.Code()
+	}
+	open.CalculateHeaderLength()
+	open.CalculateBodyLength()
+	if err = conn.writeReq(open); err != nil {
+		conn.removeStream(id)
+		return nil, err
+	}
+	st := &clientStream{
+		ctx:         ctx,
+		id:          id,
+		conn:        conn,
+		serializer:  s,
+		compressor:  c.compressor,
+		compressors: c.compressors,
+		frames:      ms.frames,
+		window:      ms.window,
+		done:        make(chan struct{}),
+	}
+	go st.watch()
+	return st, nil
+}
+
+// RegisterCompressor 注册用来解压响应的压缩算法
+func (c *Client) RegisterCompressor(compressor compress.Compressor) {
+	c.compressors[compressor.Code()] = compressor
+}
 
-	if _, err := conn.Write(data); err != nil {
+func (c *Client) getConn() (*muxConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil && !c.conn.closed() {
+		return c.conn, nil
+	}
+	conn, err := c.dial()
+	if err != nil {
 		return nil, err
 	}
+	c.conn = newMuxConn(conn, muxOptions{
+		idleTimeout:      c.idleTimeout,
+		heartbeat:        c.heartbeat,
+		heartbeatTimeout: c.heartbeatTimeout,
+		version:          c.version,
+		negotiate:        c.negotiate,
+		maxFrameSize:     c.maxFrameSize,
+	})
+	return c.conn, nil
+}
 
-	if isOneway(ctx) {
-		return nil, errors.New("micro: 这是一个 oneway 调用, 你不应该处理任何结果")
+func (c *Client) dialPooled() (net.Conn, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
 	}
+	return &pooledConn{
+		Conn:         conn,
+		version:      c.version,
+		negotiate:    c.negotiate,
+		maxFrameSize: c.maxFrameSize,
+		lastUsed:     time.Now(),
+	}, nil
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.dialTimeout)
+	defer cancel()
+	return c.transport.Dial(ctx, c.addr)
+}
 
-	return ReadMsg(conn)
+// Close 关闭连接, 正在等待响应的请求会返回错误
+// 使用了连接池的话, 会等待借出去的连接都归还了再返回
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var err error
+	if c.conn != nil {
+		err = c.conn.Close()
+	}
+	if c.pool != nil {
+		if perr := c.pool.Close(context.Background()); err == nil {
+			err = perr
+		}
+	}
+	return err
 }
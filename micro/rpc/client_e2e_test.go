@@ -5,11 +5,22 @@ import (
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	micronet "github.com/startdusk/go-libs/micro/net"
 	"github.com/startdusk/go-libs/micro/proto/gen"
+	"github.com/startdusk/go-libs/micro/rpc/compress"
+	"github.com/startdusk/go-libs/micro/rpc/compress/gzip"
+	"github.com/startdusk/go-libs/micro/rpc/compress/snappy"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/startdusk/go-libs/micro/rpc/serialize"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/msgpack"
 	"github.com/startdusk/go-libs/micro/rpc/serialize/proto"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
 )
 
 // 测试proto序列化
@@ -54,7 +65,7 @@ func Test_InitServiceProto(t *testing.T) {
 				service.Msg = ""
 				service.Err = errors.New("this is a error")
 			},
-			wantErr:  errors.New("this is a error"),
+			wantErr:  NewStatus(CodeUnknown, "this is a error"),
 			wantResp: &GetByIDResp{},
 		},
 		{
@@ -66,7 +77,7 @@ func Test_InitServiceProto(t *testing.T) {
 			wantResp: &GetByIDResp{
 				Msg: "hello world",
 			},
-			wantErr: errors.New("this is a error"),
+			wantErr: NewStatus(CodeUnknown, "this is a error"),
 		},
 	}
 
@@ -123,7 +134,7 @@ func Test_InitClientProxy(t *testing.T) {
 				service.Msg = ""
 				service.Err = errors.New("this is a error")
 			},
-			wantErr:  errors.New("this is a error"),
+			wantErr:  NewStatus(CodeUnknown, "this is a error"),
 			wantResp: &GetByIDResp{},
 		},
 		{
@@ -135,7 +146,7 @@ func Test_InitClientProxy(t *testing.T) {
 			wantResp: &GetByIDResp{
 				Msg: "hello world",
 			},
-			wantErr: errors.New("this is a error"),
+			wantErr: NewStatus(CodeUnknown, "this is a error"),
 		},
 	}
 
@@ -180,8 +191,8 @@ func Test_oneway(t *testing.T) {
 				service.Err = errors.New("mock error")
 				service.Msg = "hello world"
 			},
+			// oneway 调用发出去就返回, 没有结果也没有错误
 			wantResp: &GetByIDResp{},
-			wantErr:  errors.New("micro: 这是一个 oneway 调用, 你不应该处理任何结果"),
 		},
 	}
 
@@ -250,3 +261,511 @@ func Test_timeout(t *testing.T) {
 		})
 	}
 }
+
+// 测试多路复用: 并发的请求共用一个连接, 服务端也是并发处理的
+func Test_multiplexing(t *testing.T) {
+	addr := ":8085"
+	server := NewServer()
+	service := &UserServiceServerTimeout{t: t, sleep: 500 * time.Millisecond, Msg: "hello world"}
+	server.RegisterService(service)
+	go func() {
+		err := server.Start("tcp", addr)
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	time.Sleep(3 * time.Second)
+	usClient := &UserService{}
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	err = client.InitService(usClient)
+	require.NoError(t, err)
+
+	const n = 20
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := usClient.GetByID(ctx, &GetByIDReq{ID: 123})
+			assert.NoError(t, err)
+			assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+		}()
+	}
+	wg.Wait()
+	// 串行处理的话至少要 n * 500ms
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+// 测试压缩: 服务端用请求使用的压缩算法来压缩响应
+func Test_compress(t *testing.T) {
+	addr := ":8086"
+	server := NewServer()
+	service := &UserServiceServer{Msg: "hello world"}
+	server.RegisterService(service)
+	server.RegisterCompressor(&gzip.Compressor{})
+	server.RegisterCompressor(&snappy.Compressor{})
+	go func() {
+		err := server.Start("tcp", addr)
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	time.Sleep(3 * time.Second)
+
+	cases := []struct {
+		name       string
+		compressor compress.Compressor
+		wantResp   *GetByIDResp
+		wantErr    error
+	}{
+		{
+			name:       "gzip",
+			compressor: &gzip.Compressor{},
+			wantResp:   &GetByIDResp{Msg: "hello world"},
+		},
+		{
+			name:       "snappy",
+			compressor: &snappy.Compressor{},
+			wantResp:   &GetByIDResp{Msg: "hello world"},
+		},
+		{
+			// 服务端没有注册这个压缩算法
+			name:       "unknown compressor",
+			compressor: unknownCompressor{},
+			wantResp:   &GetByIDResp{},
+			wantErr:    NewStatus(CodeUnimplemented, "micro: 不支持的压缩算法"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := NewClient(addr, ClientWithCompressor(c.compressor))
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantResp, resp)
+		})
+	}
+}
+
+type unknownCompressor struct{}
+
+func (u unknownCompressor) Code() uint8 {
+	return 100
+}
+
+func (u unknownCompressor) Compress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (u unknownCompressor) Decompress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func Test_transport(t *testing.T) {
+	memory := transport.NewMemory()
+	cases := []struct {
+		name      string
+		transport transport.Transport
+		addr      string
+	}{
+		{
+			name:      "memory",
+			transport: memory,
+			addr:      "user-service",
+		},
+		{
+			name:      "unix",
+			transport: transport.Unix{},
+			addr:      filepath.Join(t.TempDir(), "user-service.sock"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lis, err := c.transport.Listen(c.addr)
+			require.NoError(t, err)
+			defer func() {
+				_ = lis.Close()
+			}()
+			server := NewServer()
+			server.RegisterService(&UserServiceServer{Msg: "hello world"})
+			go func() {
+				_ = server.Serve(lis)
+			}()
+
+			client, err := NewClient(c.addr, ClientWithTransport(c.transport))
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+			require.NoError(t, err)
+			assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+		})
+	}
+}
+
+func Test_idleTimeout(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := NewServer()
+	server.RegisterService(&UserServiceServer{Msg: "hello world"})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	client, err := NewClient("user-service",
+		ClientWithTransport(memory),
+		ClientWithIdleTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+	_, err = usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+	require.NoError(t, err)
+	client.mutex.Lock()
+	first := client.conn
+	client.mutex.Unlock()
+
+	// 空闲太久连接被关掉了, 下次调用会建立新的连接
+	require.Eventually(t, first.closed, time.Second, 10*time.Millisecond)
+	assert.Equal(t, errIdleClosed, first.closedErr())
+	resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+	require.NoError(t, err)
+	assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+	client.mutex.Lock()
+	assert.NotSame(t, first, client.conn)
+	client.mutex.Unlock()
+}
+
+func Test_dialTimeout(t *testing.T) {
+	// 没有人 Accept, Dial 会一直等到超时
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	client, err := NewClient("user-service",
+		ClientWithTransport(memory),
+		ClientWithDialTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+	_, err = usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func Test_pool(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := NewServer()
+	server.RegisterService(&UserServiceServer{Msg: "hello world"})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	client, err := NewClient("user-service",
+		ClientWithTransport(memory),
+		ClientWithPool(2, 2, time.Minute))
+	require.NoError(t, err)
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+			assert.NoError(t, err)
+			assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+		}()
+	}
+	wg.Wait()
+	stats := client.pool.Stats()
+	assert.Equal(t, 2, stats.Open)
+	assert.Equal(t, 2, stats.Idle)
+
+	// 连接池关闭之后, 调用直接返回错误
+	require.NoError(t, client.Close())
+	_, err = usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+	assert.Equal(t, micronet.ErrPoolClosed, err)
+}
+
+func Test_pool_timeout(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := NewServer()
+	server.RegisterService(&UserServiceServerTimeout{t: t, sleep: 100 * time.Millisecond, Msg: "hello world"})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	client, err := NewClient("user-service",
+		ClientWithTransport(memory),
+		ClientWithPool(1, 1, time.Minute))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	usClient := &UserService{}
+	require.NoError(t, client.InitService(usClient))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = usClient.GetByID(ctx, &GetByIDReq{ID: 123})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// 超时的连接上还会有响应过来, 不能再用了
+	assert.Equal(t, 0, client.pool.Stats().Open)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := usClient.GetByID(ctx, &GetByIDReq{ID: 123})
+	require.NoError(t, err)
+	assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+	assert.Equal(t, 1, client.pool.Stats().Idle)
+}
+
+func Test_protocolVersion(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("user-service")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := NewServer()
+	server.RegisterService(&UserServiceServer{Msg: "hello world"})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	cases := []struct {
+		name string
+		opts []ClientOption
+
+		wantVersion uint8
+	}{
+		{
+			// 第一次调用用老格式, 之后升级
+			name:        "negotiate",
+			wantVersion: message.MaxVersion,
+		},
+		{
+			name:        "old client",
+			opts:        []ClientOption{ClientWithProtocolVersion(message.Version0)},
+			wantVersion: message.Version0,
+		},
+		{
+			name:        "new client",
+			opts:        []ClientOption{ClientWithProtocolVersion(message.Version1)},
+			wantVersion: message.Version1,
+		},
+		{
+			name:        "pool",
+			opts:        []ClientOption{ClientWithPool(1, 1, time.Minute)},
+			wantVersion: message.MaxVersion,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := NewClient("user-service", append(c.opts, ClientWithTransport(memory))...)
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			for i := 0; i < 2; i++ {
+				resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+				require.NoError(t, err)
+				assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+			}
+			if client.pool != nil {
+				conn, err := client.pool.Get(context.Background())
+				require.NoError(t, err)
+				assert.Equal(t, c.wantVersion, conn.(*pooledConn).version)
+				require.NoError(t, client.pool.Put(context.Background(), conn))
+				return
+			}
+			assert.Equal(t, c.wantVersion, client.conn.version)
+		})
+	}
+}
+
+func Test_heartbeat(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []ClientOption
+
+		// wantReconnect 服务端因为空闲关掉了连接, 下一次调用重新建立连接
+		wantReconnect bool
+	}{
+		{
+			name:          "no heartbeat",
+			wantReconnect: true,
+		},
+		{
+			name: "heartbeat",
+			opts: []ClientOption{ClientWithHeartbeat(10*time.Millisecond, 100*time.Millisecond)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			memory := transport.NewMemory()
+			lis, err := memory.Listen("user-service")
+			require.NoError(t, err)
+			defer func() {
+				_ = lis.Close()
+			}()
+			server := NewServer(ServerWithIdleTimeout(50 * time.Millisecond))
+			server.RegisterService(&UserServiceServer{Msg: "hello world"})
+			go func() {
+				_ = server.Serve(lis)
+			}()
+
+			client, err := NewClient("user-service", append(c.opts, ClientWithTransport(memory))...)
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			_, err = usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+			require.NoError(t, err)
+			client.mutex.Lock()
+			first := client.conn
+			client.mutex.Unlock()
+
+			time.Sleep(150 * time.Millisecond)
+			assert.Equal(t, c.wantReconnect, first.closed())
+			resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+			require.NoError(t, err)
+			assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+			client.mutex.Lock()
+			assert.Equal(t, c.wantReconnect, first != client.conn)
+			client.mutex.Unlock()
+		})
+	}
+}
+
+func Test_serviceSerializer(t *testing.T) {
+	cases := []struct {
+		name string
+		// serializers 服务端在 json 之外还支持的序列化协议
+		serializers []serialize.Serializer
+		opts        []ClientOption
+
+		// wantCodes 服务端依次收到的请求使用的序列化协议
+		wantCodes []uint8
+		wantErr   error
+	}{
+		{
+			name:        "supported",
+			serializers: []serialize.Serializer{&msgpack.Serializer{}},
+			opts: []ClientOption{
+				ClientWithServiceSerializer("user-service", &msgpack.Serializer{}, &json.Serializer{}),
+			},
+			wantCodes: []uint8{3, 3},
+		},
+		{
+			// 服务端不支持 msgpack, 第一次调用被拒绝之后换成 json, 之后一直用 json
+			name: "fallback",
+			opts: []ClientOption{
+				ClientWithServiceSerializer("user-service", &msgpack.Serializer{}, &json.Serializer{}),
+			},
+			wantCodes: []uint8{3, 1, 1},
+		},
+		{
+			name: "no fallback",
+			opts: []ClientOption{
+				ClientWithServiceSerializer("user-service", &msgpack.Serializer{}),
+			},
+			wantCodes: []uint8{3, 3},
+			wantErr:   NewStatus(CodeUnimplemented, "micro: 不支持的序列化协议").WithDetails([]byte{1}),
+		},
+		{
+			// 其他的服务不受影响
+			name: "other service",
+			opts: []ClientOption{
+				ClientWithServiceSerializer("order-service", &msgpack.Serializer{}),
+			},
+			wantCodes: []uint8{1, 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			memory := transport.NewMemory()
+			lis, err := memory.Listen("user-service")
+			require.NoError(t, err)
+			defer func() {
+				_ = lis.Close()
+			}()
+			var mutex sync.Mutex
+			var codes []uint8
+			server := NewServer(ServerWithInterceptors(func(next Handler) Handler {
+				return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+					mutex.Lock()
+					codes = append(codes, req.Serializer)
+					mutex.Unlock()
+					return next(ctx, req)
+				}
+			}))
+			for _, s := range c.serializers {
+				server.RegisterSerializer(s)
+			}
+			server.RegisterService(&UserServiceServer{Msg: "hello world"})
+			go func() {
+				_ = server.Serve(lis)
+			}()
+
+			client, err := NewClient("user-service", append(c.opts, ClientWithTransport(memory))...)
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			usClient := &UserService{}
+			require.NoError(t, client.InitService(usClient))
+			for i := 0; i < 2; i++ {
+				resp, err := usClient.GetByID(context.Background(), &GetByIDReq{ID: 123})
+				if c.wantErr != nil {
+					assert.Equal(t, c.wantErr, err)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.Equal(t, c.wantCodes, codes)
+		})
+	}
+}
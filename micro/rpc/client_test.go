@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	gomock "go.uber.org/mock/gomock"
+	"net"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/startdusk/go-libs/micro/proto/gen"
+	"github.com/startdusk/go-libs/micro/retry"
 	"github.com/startdusk/go-libs/micro/rpc/message"
 	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
 )
@@ -80,6 +85,222 @@ func Test_setFuncField(t *testing.T) {
 	}
 }
 
+func TestClient_Invoke_callPolicy(t *testing.T) {
+	unavailable := &message.Response{Error: encodeStatus(NewStatus(CodeUnavailable, "unavailable"))}
+	cases := []struct {
+		name   string
+		policy *retry.Policy
+		// results 第 i 次调用的返回, 超出范围的都成功
+		results []func() (*message.Response, error)
+
+		wantResp     *message.Response
+		wantErr      error
+		wantAttempts int32
+	}{
+		{
+			name:   "no policy",
+			policy: nil,
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) { return unavailable, nil },
+			},
+			wantResp:     unavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:   "not idempotent",
+			policy: &retry.Policy{MaxAttempts: 3},
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) { return unavailable, nil },
+			},
+			wantResp:     unavailable,
+			wantAttempts: 1,
+		},
+		{
+			name:   "retry unavailable",
+			policy: &retry.Policy{MaxAttempts: 3, Idempotent: true},
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) { return unavailable, nil },
+				func() (*message.Response, error) { return nil, errConnClosed },
+			},
+			wantResp:     &message.Response{Data: []byte("ok")},
+			wantAttempts: 3,
+		},
+		{
+			name:   "exhausted",
+			policy: &retry.Policy{MaxAttempts: 2, Idempotent: true},
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) { return unavailable, nil },
+				func() (*message.Response, error) { return unavailable, nil },
+			},
+			// 服务端的错误原样交给调用方
+			wantResp:     unavailable,
+			wantAttempts: 2,
+		},
+		{
+			name:   "not retryable",
+			policy: &retry.Policy{MaxAttempts: 3, Idempotent: true},
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) {
+					return &message.Response{Error: encodeStatus(NewStatus(CodeNotFound, "not found"))}, nil
+				},
+			},
+			wantResp:     &message.Response{Error: encodeStatus(NewStatus(CodeNotFound, "not found"))},
+			wantAttempts: 1,
+		},
+		{
+			name: "retry on",
+			policy: &retry.Policy{MaxAttempts: 3, Idempotent: true,
+				Retryable: RetryOn(CodeNotFound)},
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) {
+					return &message.Response{Error: encodeStatus(NewStatus(CodeNotFound, "not found"))}, nil
+				},
+			},
+			wantResp:     &message.Response{Data: []byte("ok")},
+			wantAttempts: 2,
+		},
+		{
+			name:   "deadline not retryable",
+			policy: &retry.Policy{MaxAttempts: 3, Idempotent: true},
+			results: []func() (*message.Response, error){
+				func() (*message.Response, error) { return nil, context.DeadlineExceeded },
+			},
+			wantErr:      context.DeadlineExceeded,
+			wantAttempts: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int32
+			client, err := NewClient("", ClientWithCallPolicy(retry.Config{
+				Policies: map[string]*retry.Policy{"user-service/GetByID": c.policy},
+			}))
+			require.NoError(t, err)
+			client.handler = func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				n := atomic.AddInt32(&attempts, 1)
+				if int(n) <= len(c.results) {
+					return c.results[n-1]()
+				}
+				return &message.Response{Data: []byte("ok")}, nil
+			}
+			resp, err := client.Invoke(context.Background(), &message.Request{
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+			})
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantResp, resp)
+			assert.Equal(t, c.wantAttempts, attempts)
+		})
+	}
+}
+
+func TestClient_checkPooled(t *testing.T) {
+	cases := []struct {
+		name     string
+		lastUsed time.Duration
+		// serve 模拟服务端
+		serve func(srv net.Conn)
+
+		wantErr bool
+	}{
+		{
+			// 刚用过的连接不发心跳
+			name:     "recently used",
+			lastUsed: time.Millisecond,
+			serve:    func(srv net.Conn) {},
+		},
+		{
+			name:     "pong",
+			lastUsed: time.Minute,
+			serve: func(srv net.Conn) {
+				data, err := ReadMsg(srv)
+				if err != nil {
+					return
+				}
+				req, err := message.DecodeReq(data)
+				if err != nil {
+					return
+				}
+				_, _ = srv.Write(message.EncodeResp(pong(req)))
+			},
+		},
+		{
+			// 服务端收到了心跳但是没有响应
+			name:     "no pong",
+			lastUsed: time.Minute,
+			serve: func(srv net.Conn) {
+				_, _ = ReadMsg(srv)
+			},
+			wantErr: true,
+		},
+		{
+			// 服务端已经关掉了连接
+			name:     "closed",
+			lastUsed: time.Millisecond,
+			serve: func(srv net.Conn) {
+				_ = srv.Close()
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := NewClient("user-service", ClientWithHeartbeat(time.Second, 50*time.Millisecond))
+			require.NoError(t, err)
+			cli, srv := net.Pipe()
+			defer func() {
+				_ = srv.Close()
+			}()
+			serve := c.serve
+			serving := make(chan struct{})
+			go func() {
+				defer close(serving)
+				serve(srv)
+			}()
+			if c.wantErr && c.lastUsed < time.Second {
+				// 不发心跳的时候要等服务端先关掉连接
+				<-serving
+			}
+			conn := &pooledConn{Conn: cli, lastUsed: time.Now().Add(-c.lastUsed)}
+			err = client.checkPooled(conn)
+			assert.Equal(t, c.wantErr, err != nil)
+		})
+	}
+}
+
+func TestClient_Invoke_deadline(t *testing.T) {
+	var deadlines []int64
+	client, err := NewClient("", ClientWithCallPolicy(retry.Config{
+		Default: &retry.Policy{MaxAttempts: 2, Idempotent: true, Timeout: time.Second},
+	}))
+	require.NoError(t, err)
+	client.handler = func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		deadline, err := strconv.ParseInt(req.Meta["deadline"], 10, 64)
+		require.NoError(t, err)
+		deadlines = append(deadlines, deadline)
+		return nil, errConnClosed
+	}
+	// 调用方给的超时时间更长, 以调用策略的为准
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req := &message.Request{
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Meta:        buildMeta(ctx),
+	}
+	_, err = client.Invoke(ctx, req)
+	assert.Equal(t, errConnClosed, err)
+	require.Len(t, deadlines, 2)
+	remain := time.Until(time.UnixMilli(deadlines[0]))
+	assert.True(t, remain > 0 && remain <= time.Second)
+	// 每次尝试共用一个超时时间
+	assert.Equal(t, deadlines[0], deadlines[1])
+	// 原来的请求不会被修改
+	ctxDeadline, _ := ctx.Deadline()
+	assert.Equal(t, strconv.FormatInt(ctxDeadline.UnixMilli(), 10), req.Meta["deadline"])
+}
+
 type mockProxy struct{}
 
 type UserService struct {
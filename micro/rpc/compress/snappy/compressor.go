@@ -0,0 +1,20 @@
+package snappy
+
+import (
+	"github.com/golang/snappy"
+)
+
+// Compressor 压缩率不如 gzip, 但是快很多, 适合对延迟敏感的场景
+type Compressor struct{}
+
+func (c *Compressor) Code() uint8 {
+	return 2
+}
+
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *Compressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
@@ -0,0 +1,47 @@
+package snappy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressor(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "empty",
+			data: []byte{},
+		},
+		{
+			name: "hello world",
+			data: []byte("hello world"),
+		},
+		{
+			name: "large",
+			data: bytes.Repeat([]byte("hello world"), 1024),
+		},
+	}
+
+	c := &Compressor{}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			compressed, err := c.Compress(tc.data)
+			require.NoError(t, err)
+			data, err := c.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, len(tc.data), len(data))
+			assert.True(t, bytes.Equal(tc.data, data))
+		})
+	}
+}
+
+func TestCompressor_Invalid(t *testing.T) {
+	c := &Compressor{}
+	_, err := c.Decompress([]byte("not compressed"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,9 @@
+package compress
+
+// Compressor 压缩算法, Code 会写到 message 的 Compresser 字段里面
+// Code 为 0 表示不压缩, 所以实现不能使用 0
+type Compressor interface {
+	Code() uint8
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
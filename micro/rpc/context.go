@@ -2,6 +2,11 @@ package rpc
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // 通过上下文标记为一次调用
@@ -17,3 +22,108 @@ func isOneway(ctx context.Context) bool {
 	oneway, ok := val.(bool)
 	return ok && oneway
 }
+
+// 框架自己使用的 Meta 的 key, 业务不能用 OutgoingMeta 设置, IncomingMeta 里面也看不到
+const (
+	metaDeadline = "deadline"
+	metaOneway   = "one-way"
+)
+
+// metaPropagator 自动传递 OpenTelemetry 的 trace 上下文和 baggage, 用的是 W3C 的格式
+// 所以没有用 opentelemetry 拦截器也能把服务端的 trace 和客户端的连起来, baggage 也会一路传下去
+var metaPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+var reservedMeta = func() map[string]struct{} {
+	keys := map[string]struct{}{metaDeadline: {}, metaOneway: {}}
+	for _, key := range metaPropagator.Fields() {
+		keys[key] = struct{}{}
+	}
+	return keys
+}()
+
+// IsReservedMeta key 是不是框架保留的, 包括 deadline, one-way 和 traceparent, tracestate, baggage
+func IsReservedMeta(key string) bool {
+	_, ok := reservedMeta[key]
+	return ok
+}
+
+type outgoingMetaKey struct{}
+
+// OutgoingMeta 设置发给服务端的元数据, 比如说租户和认证信息, 可以多次调用, 同一个 key 后面的会覆盖前面的
+// 服务端用 IncomingMeta 读取. 元数据不会自动传给下一跳, 需要一路传下去的数据用 OpenTelemetry 的 baggage
+// value 可以是任意的字节, 不过协议版本 0 不支持包含 \n 和 \r 的元数据
+// key 是框架保留的会 panic, 这是写代码的时候就应该发现的错误
+func OutgoingMeta(ctx context.Context, key, value string) context.Context {
+	if IsReservedMeta(key) {
+		panic(fmt.Sprintf("rpc: %s 是框架保留的元数据", key))
+	}
+	old, _ := ctx.Value(outgoingMetaKey{}).(map[string]string)
+	// 复制一份, 不能修改父 context 里面的数据
+	meta := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		meta[k] = v
+	}
+	meta[key] = value
+	return context.WithValue(ctx, outgoingMetaKey{}, meta)
+}
+
+type incomingMetaKey struct{}
+
+// IncomingMeta 客户端用 OutgoingMeta 设置的元数据, 不包括框架保留的
+// 返回的是一份拷贝, 可以随便修改
+func IncomingMeta(ctx context.Context) map[string]string {
+	meta, _ := ctx.Value(incomingMetaKey{}).(map[string]string)
+	res := make(map[string]string, len(meta))
+	for k, v := range meta {
+		res[k] = v
+	}
+	return res
+}
+
+// withIncomingMeta 过滤掉框架保留的元数据, 剩下的放到 ctx 里面
+func withIncomingMeta(ctx context.Context, meta map[string]string) context.Context {
+	var incoming map[string]string
+	for k, v := range meta {
+		if IsReservedMeta(k) {
+			continue
+		}
+		if incoming == nil {
+			incoming = make(map[string]string, len(meta))
+		}
+		incoming[k] = v
+	}
+	if incoming == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, incomingMetaKey{}, incoming)
+}
+
+// Peer 调用方的连接信息
+type Peer struct {
+	Addr net.Addr
+	// TLS 使用 TLS 传输的时候才有, 双向认证的时候 PeerCertificates 就是客户端的证书
+	TLS *tls.ConnectionState
+}
+
+func newPeer(conn net.Conn) *Peer {
+	p := &Peer{Addr: conn.RemoteAddr()}
+	if tc, ok := conn.(interface {
+		ConnectionState() tls.ConnectionState
+	}); ok {
+		state := tc.ConnectionState()
+		p.TLS = &state
+	}
+	return p
+}
+
+type peerKey struct{}
+
+func withPeer(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// PeerFromContext 服务端取出调用方的连接信息, 拦截器和服务的方法都可以用
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok && p != nil
+}
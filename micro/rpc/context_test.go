@@ -0,0 +1,248 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOutgoingMeta(t *testing.T) {
+	parent := OutgoingMeta(context.Background(), "tenant", "t1")
+	cases := []struct {
+		name string
+		key  string
+		val  string
+
+		wantMeta  map[string]string
+		wantPanic bool
+	}{
+		{
+			name:     "add",
+			key:      "token",
+			val:      "abc",
+			wantMeta: map[string]string{"tenant": "t1", "token": "abc"},
+		},
+		{
+			name:     "override",
+			key:      "tenant",
+			val:      "t2",
+			wantMeta: map[string]string{"tenant": "t2"},
+		},
+		{
+			name:      "deadline",
+			key:       "deadline",
+			wantPanic: true,
+		},
+		{
+			name:      "traceparent",
+			key:       "traceparent",
+			wantPanic: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.wantPanic {
+				assert.Panics(t, func() {
+					OutgoingMeta(parent, c.key, c.val)
+				})
+				return
+			}
+			ctx := OutgoingMeta(parent, c.key, c.val)
+			assert.Equal(t, c.wantMeta, buildMeta(ctx))
+			// 父 context 里面的不受影响
+			assert.Equal(t, map[string]string{"tenant": "t1"}, buildMeta(parent))
+		})
+	}
+}
+
+func Test_injectTrace(t *testing.T) {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	traced := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	cases := []struct {
+		name string
+		ctx  context.Context
+		meta map[string]string
+
+		wantMeta map[string]string
+	}{
+		{
+			name: "traced",
+			ctx:  traced,
+			meta: map[string]string{"one-way": "true"},
+			wantMeta: map[string]string{
+				"one-way":     "true",
+				"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			},
+		},
+		{
+			// 没有 trace 上下文的时候不能多出一个空的 Meta, Version0 的帧要和以前一样
+			name: "no trace",
+			ctx:  context.Background(),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := &message.Request{Meta: c.meta}
+			injectTrace(c.ctx, req)
+			assert.Equal(t, c.wantMeta, req.Meta)
+		})
+	}
+}
+
+func Test_withIncomingMeta(t *testing.T) {
+	cases := []struct {
+		name string
+		meta map[string]string
+
+		wantMeta map[string]string
+	}{
+		{
+			name:     "nil",
+			wantMeta: map[string]string{},
+		},
+		{
+			name: "reserved",
+			meta: map[string]string{
+				"deadline": "123", "one-way": "true", "baggage": "k=v",
+				"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"tenant":      "t1",
+			},
+			wantMeta: map[string]string{"tenant": "t1"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := withIncomingMeta(context.Background(), c.meta)
+			meta := IncomingMeta(ctx)
+			assert.Equal(t, c.wantMeta, meta)
+			// 返回的是拷贝
+			meta["tenant"] = "t2"
+			assert.Equal(t, c.wantMeta, IncomingMeta(ctx))
+		})
+	}
+}
+
+func TestMeta_propagation(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("meta")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := NewServer()
+	ms := &metaServiceServer{}
+	server.RegisterService(ms)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	member, err := baggage.NewMember("user", "tom")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name string
+		opts []ClientOption
+		meta map[string]string
+
+		wantErr error
+	}{
+		{
+			name: "v0",
+			opts: []ClientOption{ClientWithProtocolVersion(message.Version0)},
+			meta: map[string]string{"tenant": "t1", "token": "abc"},
+		},
+		{
+			// 版本 1 的元数据可以是任意的字节
+			name: "binary",
+			opts: []ClientOption{ClientWithProtocolVersion(message.Version1)},
+			meta: map[string]string{"tenant": "t1", "bin": "a\nb\r\x00"},
+		},
+		{
+			name:    "binary v0",
+			opts:    []ClientOption{ClientWithProtocolVersion(message.Version0)},
+			meta:    map[string]string{"bin": "a\nb"},
+			wantErr: message.ErrInvalidMeta,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := NewClient("meta", append(c.opts, ClientWithTransport(memory))...)
+			require.NoError(t, err)
+			defer func() {
+				_ = client.Close()
+			}()
+			mc := &metaServiceClient{}
+			require.NoError(t, client.InitService(mc))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+			}))
+			ctx = baggage.ContextWithBaggage(ctx, bag)
+			for k, v := range c.meta {
+				ctx = OutgoingMeta(ctx, k, v)
+			}
+			resp, err := mc.Capture(ctx, &MetaCaptureReq{})
+			assert.ErrorIs(t, err, c.wantErr)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.meta, resp.Meta)
+			assert.Equal(t, traceID.String(), resp.TraceID)
+			assert.Equal(t, "tom", resp.User)
+		})
+	}
+}
+
+type MetaCaptureReq struct{}
+
+type MetaCaptureResp struct {
+	Meta    map[string]string
+	TraceID string
+	User    string
+}
+
+type metaServiceClient struct {
+	Capture func(ctx context.Context, req *MetaCaptureReq) (*MetaCaptureResp, error)
+}
+
+func (m *metaServiceClient) Name() string {
+	return "meta-service"
+}
+
+type metaServiceServer struct{}
+
+func (m *metaServiceServer) Name() string {
+	return "meta-service"
+}
+
+func (m *metaServiceServer) Capture(ctx context.Context, req *MetaCaptureReq) (*MetaCaptureResp, error) {
+	return &MetaCaptureResp{
+		Meta:    IncomingMeta(ctx),
+		TraceID: trace.SpanContextFromContext(ctx).TraceID().String(),
+		User:    baggage.FromContext(ctx).Member("user").Value(),
+	}, nil
+}
@@ -0,0 +1,255 @@
+package gen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// serviceDirective 在接口的注释里面指定服务名, 没有的话就用接口名
+//
+//	//rpc:service user-service
+//	type UserService interface {...}
+const serviceDirective = "//rpc:service "
+
+type SingleFileVisitor struct {
+	file *FileVisitor
+}
+
+var _ ast.Visitor = &SingleFileVisitor{}
+
+func (sfv *SingleFileVisitor) Visit(node ast.Node) ast.Visitor {
+	fn, ok := node.(*ast.File)
+	if !ok {
+		return sfv
+	}
+	fv := &FileVisitor{
+		Package: fn.Name.String(),
+	}
+	sfv.file = fv
+	return fv
+}
+
+func (sfv *SingleFileVisitor) Get() (*File, error) {
+	fv := sfv.file
+	services := make([]Service, 0, len(fv.services))
+	// 生成的代码用到了哪些包
+	used := map[string]bool{}
+	for _, sv := range fv.services {
+		if sv.err != nil {
+			return nil, sv.err
+		}
+		if len(sv.methods) == 0 {
+			return nil, fmt.Errorf("rpc-gen: 接口 %s 没有方法", sv.name)
+		}
+		for _, m := range sv.methods {
+			for _, pkg := range m.pkgs {
+				used[pkg] = true
+			}
+		}
+		services = append(services, Service{
+			Name:        sv.name,
+			ServiceName: sv.serviceName,
+			Methods:     sv.methods,
+		})
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("rpc-gen: 没有找到服务接口")
+	}
+
+	imports := make([]string, 0, len(fv.imports))
+	for _, imp := range fv.imports {
+		if used[imp.name] && !builtinImports[imp.path] {
+			imports = append(imports, imp.String())
+		}
+	}
+	return &File{
+		Package:  fv.Package,
+		Imports:  imports,
+		Services: services,
+	}, nil
+}
+
+// builtinImports 模板里面已经导入了的包
+var builtinImports = map[string]bool{
+	"context":                                true,
+	"github.com/startdusk/go-libs/micro/rpc": true,
+	"github.com/startdusk/go-libs/micro/rpc/serialize": true,
+}
+
+type FileVisitor struct {
+	Package  string
+	imports  []importSpec
+	services []*ServiceVisitor
+	// doc type 关键字上面的注释, 只有一个类型的时候注释在这里而不在 TypeSpec 上
+	doc *ast.CommentGroup
+}
+
+var _ ast.Visitor = &FileVisitor{}
+
+func (fv *FileVisitor) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.GenDecl:
+		fv.doc = n.Doc
+	case *ast.TypeSpec:
+		if _, ok := n.Type.(*ast.InterfaceType); !ok {
+			// 只关心接口
+			return nil
+		}
+		doc := n.Doc
+		if doc == nil {
+			doc = fv.doc
+		}
+		v := &ServiceVisitor{
+			name:        n.Name.String(),
+			serviceName: serviceName(n.Name.String(), doc),
+		}
+		fv.services = append(fv.services, v)
+		return v
+	case *ast.ImportSpec:
+		p, _ := strconv.Unquote(n.Path.Value)
+		imp := importSpec{path: p, name: path.Base(p)}
+		if n.Name != nil && n.Name.String() != "" {
+			// 处理导入包有别名的情况, 如 a "import/bbb"
+			imp.alias = n.Name.String()
+			imp.name = imp.alias
+		}
+		fv.imports = append(fv.imports, imp)
+	}
+	return fv
+}
+
+func serviceName(name string, doc *ast.CommentGroup) string {
+	if doc == nil {
+		return name
+	}
+	for _, c := range doc.List {
+		if strings.HasPrefix(c.Text, serviceDirective) {
+			return strings.TrimSpace(strings.TrimPrefix(c.Text, serviceDirective))
+		}
+	}
+	return name
+}
+
+type ServiceVisitor struct {
+	name        string
+	serviceName string
+	methods     []Method
+	err         error
+}
+
+var _ ast.Visitor = &ServiceVisitor{}
+
+func (sv *ServiceVisitor) Visit(node ast.Node) ast.Visitor {
+	n, ok := node.(*ast.Field)
+	if !ok || sv.err != nil {
+		return sv
+	}
+	fn, ok := n.Type.(*ast.FuncType)
+	if !ok {
+		sv.err = fmt.Errorf("rpc-gen: 接口 %s 不支持嵌入其他接口", sv.name)
+		return nil
+	}
+	for _, name := range n.Names {
+		m, err := newMethod(name.String(), fn)
+		if err != nil {
+			sv.err = fmt.Errorf("rpc-gen: %s.%s %w", sv.name, name.String(), err)
+			return nil
+		}
+		sv.methods = append(sv.methods, m)
+	}
+	// 参数里面的 Field 不需要再访问了
+	return nil
+}
+
+func newMethod(name string, fn *ast.FuncType) (Method, error) {
+	errSignature := errors.New("的签名必须是 func(ctx context.Context, req *Req) (*Resp, error), 暂时不支持流式方法")
+	params := flatten(fn.Params)
+	results := flatten(fn.Results)
+	if len(params) != 2 || len(results) != 2 {
+		return Method{}, errSignature
+	}
+	req, ok := params[1].(*ast.StarExpr)
+	if !ok || types.ExprString(params[0]) != "context.Context" {
+		return Method{}, errSignature
+	}
+	resp, ok := results[0].(*ast.StarExpr)
+	if !ok || types.ExprString(results[1]) != "error" {
+		return Method{}, errSignature
+	}
+	m := Method{
+		Name:     name,
+		ReqType:  types.ExprString(req.X),
+		RespType: types.ExprString(resp.X),
+	}
+	for _, expr := range []ast.Expr{req.X, resp.X} {
+		ast.Inspect(expr, func(node ast.Node) bool {
+			if sel, ok := node.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					m.pkgs = append(m.pkgs, x.String())
+				}
+			}
+			return true
+		})
+	}
+	return m, nil
+}
+
+// flatten func(a, b int) 这种写法是一个 Field 有两个名字
+func flatten(list *ast.FieldList) []ast.Expr {
+	if list == nil {
+		return nil
+	}
+	res := make([]ast.Expr, 0, len(list.List))
+	for _, f := range list.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			res = append(res, f.Type)
+		}
+	}
+	return res
+}
+
+type importSpec struct {
+	path  string
+	alias string
+	// name 代码里面引用这个包使用的名字
+	name string
+}
+
+func (i importSpec) String() string {
+	if i.alias != "" {
+		return i.alias + " " + strconv.Quote(i.path)
+	}
+	return strconv.Quote(i.path)
+}
+
+type File struct {
+	Package  string
+	Imports  []string
+	Services []Service
+}
+
+type Service struct {
+	// Name 接口名
+	Name string
+	// ServiceName 注册到 rpc.Server 上的服务名
+	ServiceName string
+	Methods     []Method
+}
+
+type Method struct {
+	Name string
+	// ReqType 和 RespType 都是去掉了 * 的类型
+	ReqType  string
+	RespType string
+	// pkgs 参数类型引用的包
+	pkgs []string
+}
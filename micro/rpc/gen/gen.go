@@ -0,0 +1,53 @@
+package gen
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+
+	_ "embed"
+	"text/template"
+)
+
+//go:embed tpl.gohtml
+var genRPC string
+
+// Gen 读取 srcFile 里面的服务接口, 生成客户端和服务端代码
+// 接口的方法必须是 func(ctx context.Context, req *Req) (*Resp, error)
+func Gen(w io.Writer, srcFile string) error {
+	return gen(w, srcFile, nil)
+}
+
+// gen src 不为 nil 的时候直接解析 src, 方便测试
+func gen(w io.Writer, filename string, src any) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	v := &SingleFileVisitor{}
+	ast.Walk(v, f)
+	file, err := v.Get()
+	if err != nil {
+		return err
+	}
+
+	tpl := template.New("gen-rpc")
+	tpl, err = tpl.Parse(genRPC)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err = tpl.Execute(buf, file); err != nil {
+		return err
+	}
+	data, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,120 @@
+package gen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	protogen "github.com/startdusk/go-libs/micro/proto/gen"
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/gen/testdata"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGen(t *testing.T) {
+	b := &bytes.Buffer{}
+	require.NoError(t, Gen(b, "testdata/user_service.go"))
+	// 生成的代码和 testdata 里面的一致, 修改了模板之后要重新 go generate
+	want, err := os.ReadFile("testdata/user_service.gen.go")
+	require.NoError(t, err)
+	assert.Equal(t, string(want), b.String())
+}
+
+func TestGen_Error(t *testing.T) {
+	cases := []struct {
+		name    string
+		src     string
+		wantErr error
+	}{
+		{
+			name:    "no service",
+			src:     `package a; type User struct{}`,
+			wantErr: errors.New("rpc-gen: 没有找到服务接口"),
+		},
+		{
+			name:    "no method",
+			src:     `package a; type UserService interface{}`,
+			wantErr: errors.New("rpc-gen: 接口 UserService 没有方法"),
+		},
+		{
+			name:    "embedded",
+			src:     `package a; type UserService interface{ fmt.Stringer }`,
+			wantErr: errors.New("rpc-gen: 接口 UserService 不支持嵌入其他接口"),
+		},
+		{
+			name: "no context",
+			src:  `package a; type UserService interface{ GetByID(req *Req) (*Resp, error) }`,
+			wantErr: errors.New("rpc-gen: UserService.GetByID 的签名必须是 " +
+				"func(ctx context.Context, req *Req) (*Resp, error), 暂时不支持流式方法"),
+		},
+		{
+			name: "not pointer",
+			src:  `package a; type UserService interface{ GetByID(ctx context.Context, req Req) (*Resp, error) }`,
+			wantErr: errors.New("rpc-gen: UserService.GetByID 的签名必须是 " +
+				"func(ctx context.Context, req *Req) (*Resp, error), 暂时不支持流式方法"),
+		},
+		{
+			name: "no error",
+			src:  `package a; type UserService interface{ GetByID(ctx context.Context, req *Req) (*Resp, bool) }`,
+			wantErr: errors.New("rpc-gen: UserService.GetByID 的签名必须是 " +
+				"func(ctx context.Context, req *Req) (*Resp, error), 暂时不支持流式方法"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := gen(&bytes.Buffer{}, "src.go", c.src)
+			assert.EqualError(t, err, c.wantErr.Error())
+		})
+	}
+}
+
+// 生成的代码要能和框架配合使用
+func TestGen_e2e(t *testing.T) {
+	addr := ":8088"
+	server := rpc.NewServer()
+	server.RegisterSerializer(&proto.Serializer{})
+	testdata.RegisterUserServiceServer(server, &userService{})
+	go func() {
+		err := server.Start("tcp", addr)
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	time.Sleep(3 * time.Second)
+
+	client, err := rpc.NewClient(addr, rpc.ClientWithSerializer(&proto.Serializer{}))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	us := testdata.NewUserServiceClient(client, client.Serializer())
+	resp, err := us.GetByIDProto(context.Background(), &protogen.GetByIDReq{Id: 12})
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), resp.User.Id)
+
+	// 服务端没有注册的服务
+	jsonClient, err := rpc.NewClient(addr)
+	require.NoError(t, err)
+	defer func() {
+		_ = jsonClient.Close()
+	}()
+	orders := testdata.NewOrderServiceClient(jsonClient, jsonClient.Serializer())
+	_, err = orders.Create(context.Background(), &testdata.CreateOrderReq{UserID: 12})
+	assert.Equal(t, rpc.NewStatus(rpc.CodeUnimplemented, "rpc: 你要调用的服务不存在"), err)
+}
+
+type userService struct{}
+
+func (u *userService) GetByID(ctx context.Context, req *testdata.GetByIDReq) (*testdata.GetByIDResp, error) {
+	return nil, errors.New("proto 不支持普通结构体")
+}
+
+func (u *userService) GetByIDProto(ctx context.Context, req *protogen.GetByIDReq) (*protogen.GetByIDResp, error) {
+	return &protogen.GetByIDResp{User: &protogen.User{Id: req.Id}}, nil
+}
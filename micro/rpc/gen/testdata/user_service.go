@@ -0,0 +1,40 @@
+package testdata
+
+import (
+	"context"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/proto/gen"
+)
+
+//go:generate go run github.com/startdusk/go-libs/micro/cmd/rpcgen user_service.go
+
+// UserService 用户服务
+//
+//rpc:service user-service
+type UserService interface {
+	GetByID(ctx context.Context, req *GetByIDReq) (*GetByIDResp, error)
+	GetByIDProto(ctx context.Context, req *gen.GetByIDReq) (*gen.GetByIDResp, error)
+}
+
+// OrderService 没有指定服务名, 使用接口名
+type OrderService interface {
+	Create(context.Context, *CreateOrderReq) (*CreateOrderResp, error)
+}
+
+type GetByIDReq struct {
+	ID int64
+}
+
+type GetByIDResp struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+type CreateOrderReq struct {
+	UserID int64
+}
+
+type CreateOrderResp struct {
+	ID int64
+}
@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/startdusk/go-libs/micro/registry"
+)
+
+// HealthServiceName 内置的健康检查服务的名字, 用 ServerWithHealth 开启, 语义和 gRPC 的健康检查协议一样
+const HealthServiceName = "rpc.Health"
+
+type HealthCheckReq struct {
+	// Service 为空代表检查整个服务器
+	Service string
+}
+
+type HealthCheckResp struct {
+	Status registry.HealthStatus
+}
+
+// HealthClient 健康检查服务的客户端, 用 Client.InitService 初始化
+type HealthClient struct {
+	Check func(ctx context.Context, req *HealthCheckReq) (*HealthCheckResp, error)
+	// Watch 先返回当前的状态, 之后状态每变化一次返回一次
+	// 不存在的服务返回 registry.HealthStatusUnknown, 注册了之后就能收到新的状态
+	Watch func(ctx context.Context, req *HealthCheckReq) (*ClientStream[HealthCheckReq, HealthCheckResp], error)
+}
+
+func (h *HealthClient) Name() string {
+	return HealthServiceName
+}
+
+// healthService 没有设置过状态的服务, 只要注册了就是 registry.HealthStatusServing
+type healthService struct {
+	s *Server
+
+	mutex    sync.Mutex
+	statuses map[string]registry.HealthStatus
+	watchers map[string]map[chan registry.HealthStatus]struct{}
+}
+
+func newHealthService(s *Server) *healthService {
+	return &healthService{
+		s:        s,
+		statuses: make(map[string]registry.HealthStatus, 4),
+		watchers: make(map[string]map[chan registry.HealthStatus]struct{}, 4),
+	}
+}
+
+func (h *healthService) Name() string {
+	return HealthServiceName
+}
+
+func (h *healthService) Check(ctx context.Context, req *HealthCheckReq) (*HealthCheckResp, error) {
+	h.mutex.Lock()
+	status, ok := h.status(req.Service)
+	h.mutex.Unlock()
+	if !ok {
+		return nil, NewStatus(CodeNotFound, "rpc: 你要调用的服务不存在")
+	}
+	return &HealthCheckResp{Status: status}, nil
+}
+
+func (h *healthService) Watch(ctx context.Context, req *HealthCheckReq, stream *ServerStream[HealthCheckReq, HealthCheckResp]) error {
+	// 缓冲一个就够了, 来不及发的中间状态直接丢掉, 只要最后的状态是对的
+	ch := make(chan registry.HealthStatus, 1)
+	h.mutex.Lock()
+	status, _ := h.status(req.Service)
+	ch <- status
+	watchers, ok := h.watchers[req.Service]
+	if !ok {
+		watchers = make(map[chan registry.HealthStatus]struct{}, 1)
+		h.watchers[req.Service] = watchers
+	}
+	watchers[ch] = struct{}{}
+	h.mutex.Unlock()
+	defer func() {
+		h.mutex.Lock()
+		delete(watchers, ch)
+		if len(watchers) == 0 {
+			delete(h.watchers, req.Service)
+		}
+		h.mutex.Unlock()
+	}()
+
+	for {
+		select {
+		case status = <-ch:
+			if err := stream.Send(&HealthCheckResp{Status: status}); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// status 调用者要持有锁
+func (h *healthService) status(service string) (registry.HealthStatus, bool) {
+	if status, ok := h.statuses[service]; ok {
+		return status, true
+	}
+	if service == "" {
+		return registry.HealthStatusServing, true
+	}
+	if _, ok := h.s.services[service]; ok {
+		return registry.HealthStatusServing, true
+	}
+	return registry.HealthStatusUnknown, false
+}
+
+func (h *healthService) set(service string, status registry.HealthStatus) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.statuses[service] = status
+	for ch := range h.watchers[service] {
+		// 旧的状态还没发出去的话, 换成新的
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- status
+	}
+}
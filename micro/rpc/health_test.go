@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHealthClient(t *testing.T, server *Server) *HealthClient {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("health")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	client, err := NewClient("health", ClientWithTransport(memory))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	hc := &HealthClient{}
+	require.NoError(t, client.InitService(hc))
+	return hc
+}
+
+func TestHealth_Check(t *testing.T) {
+	server := NewServer(ServerWithHealth())
+	server.RegisterService(&UserServiceServer{})
+	server.RegisterService(&StreamServiceServer{})
+	server.SetServingStatus("stream-service", registry.HealthStatusNotServing)
+	hc := newHealthClient(t, server)
+
+	cases := []struct {
+		name    string
+		service string
+
+		wantStatus registry.HealthStatus
+		wantErr    error
+	}{
+		{
+			name:       "server",
+			wantStatus: registry.HealthStatusServing,
+		},
+		{
+			// 注册了就是健康的
+			name:       "registered",
+			service:    "user-service",
+			wantStatus: registry.HealthStatusServing,
+		},
+		{
+			name:       "not serving",
+			service:    "stream-service",
+			wantStatus: registry.HealthStatusNotServing,
+		},
+		{
+			name:    "unknown",
+			service: "pay-service",
+			wantErr: NewStatus(CodeNotFound, "rpc: 你要调用的服务不存在"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := hc.Check(context.Background(), &HealthCheckReq{Service: c.service})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.wantStatus, resp.Status)
+		})
+	}
+}
+
+func TestHealth_Watch(t *testing.T) {
+	server := NewServer(ServerWithHealth())
+	hc := newHealthClient(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// 还没注册的服务, 先返回 unknown
+	stream, err := hc.Watch(ctx, &HealthCheckReq{Service: "user-service"})
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, registry.HealthStatusUnknown, resp.Status)
+
+	for _, status := range []registry.HealthStatus{registry.HealthStatusServing, registry.HealthStatusNotServing} {
+		server.SetServingStatus("user-service", status)
+		resp, err = stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, status, resp.Status)
+	}
+	// 别的服务的变化收不到
+	server.SetServingStatus("", registry.HealthStatusNotServing)
+	server.SetServingStatus("user-service", registry.HealthStatusServing)
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, registry.HealthStatusServing, resp.Status)
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+// Handler 处理一次调用
+// 在服务端就是执行本地的方法, 在客户端就是把请求发给服务端
+type Handler func(ctx context.Context, req *message.Request) (*message.Response, error)
+
+// Interceptor 和 web, orm 里面的 Middleware 是一样的, 服务端和客户端都可以用
+// 客户端的 Interceptor 可以修改 req.Meta, 发送之前会重新计算长度
+type Interceptor func(next Handler) Handler
+
+// chain 第一个 Interceptor 在最外层, 最先执行
+func chain(root Handler, interceptors []Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		root = interceptors[i](root)
+	}
+	return root
+}
+
+// StreamHandler 处理一个流, open 是打开流的帧, 服务端的方法拿到的流的上下文就是 ctx
+type StreamHandler func(ctx context.Context, open *message.Request) error
+
+// StreamInterceptor 只在服务端使用, 在调用流式方法之前执行, 比如说认证和鉴权
+// 流里面的每一帧不会经过它
+type StreamInterceptor func(next StreamHandler) StreamHandler
+
+func chainStream(root StreamHandler, interceptors []StreamInterceptor) StreamHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		root = interceptors[i](root)
+	}
+	return root
+}
@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_chain(t *testing.T) {
+	var steps []string
+	record := func(name string) Interceptor {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				steps = append(steps, name+" before")
+				resp, err := next(ctx, req)
+				steps = append(steps, name+" after")
+				return resp, err
+			}
+		}
+	}
+	root := func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		steps = append(steps, "root")
+		return &message.Response{}, nil
+	}
+
+	h := chain(root, []Interceptor{record("first"), record("second")})
+	_, err := h(context.Background(), &message.Request{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first before", "second before", "root", "second after", "first after"}, steps)
+}
+
+func TestServer_handleReq(t *testing.T) {
+	var invoked []string
+	server := NewServer(ServerWithInterceptors(func(next Handler) Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			invoked = append(invoked, req.MethodName)
+			return next(ctx, req)
+		}
+	}))
+	server.RegisterService(&panicService{})
+
+	cases := []struct {
+		name    string
+		method  string
+		wantErr *Status
+	}{
+		{
+			// 业务代码 panic 了, 服务端要把它转成 error
+			name:    "panic",
+			method:  "Panic",
+			wantErr: NewStatus(CodeInternal, "micro: 服务端 panic: oops"),
+		},
+		{
+			name:    "method not found",
+			method:  "NotFound",
+			wantErr: NewStatus(CodeUnimplemented, "micro: 你要调用的方法不存在"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := &message.Request{
+				RequestID:   1,
+				ServiceName: "panic-service",
+				MethodName:  c.method,
+				Serializer:  1,
+				Data:        []byte(`{"ID":123}`),
+			}
+			req.CalculateHeaderLength()
+			req.CalculateBodyLength()
+			resp := server.handleReq(context.Background(), req)
+			assert.Equal(t, c.wantErr, StatusFromResponse(resp))
+		})
+	}
+	assert.Equal(t, []string{"Panic", "NotFound"}, invoked)
+}
+
+type panicService struct{}
+
+func (p *panicService) Name() string {
+	return "panic-service"
+}
+
+func (p *panicService) Panic(ctx context.Context, req *GetByIDReq) (*GetByIDResp, error) {
+	panic("oops")
+}
+
+func TestServer_streamInterceptors(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("stream-interceptor")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	var steps []string
+	server := NewServer(ServerWithStreamInterceptors(
+		func(next StreamHandler) StreamHandler {
+			return func(ctx context.Context, open *message.Request) error {
+				_, ok := PeerFromContext(ctx)
+				steps = append(steps, fmt.Sprintf("%s peer %v", open.MethodName, ok))
+				return next(ctx, open)
+			}
+		},
+		func(next StreamHandler) StreamHandler {
+			return func(ctx context.Context, open *message.Request) error {
+				if open.MethodName == "Block" {
+					return NewStatus(CodePermissionDenied, "denied")
+				}
+				return next(ctx, open)
+			}
+		}))
+	server.RegisterService(&StreamServiceServer{cancelled: make(chan struct{})})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	client, err := NewClient("stream-interceptor", ClientWithTransport(memory))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	ssClient := &StreamService{}
+	require.NoError(t, client.InitService(ssClient))
+
+	cases := []struct {
+		name   string
+		method func(ctx context.Context, req *WatchReq) (*ClientStream[WatchReq, Event], error)
+
+		wantEvents int
+		wantErr    error
+	}{
+		{
+			name:       "passed",
+			method:     ssClient.Watch,
+			wantEvents: 2,
+			wantErr:    io.EOF,
+		},
+		{
+			// 被拦截器拒绝了, 服务端的方法不会执行
+			name:    "rejected",
+			method:  ssClient.Block,
+			wantErr: NewStatus(CodePermissionDenied, "denied"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			stream, err := c.method(ctx, &WatchReq{Count: 2})
+			require.NoError(t, err)
+			events := 0
+			for {
+				_, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				events++
+			}
+			assert.Equal(t, c.wantEvents, events)
+			assert.Equal(t, c.wantErr, err)
+		})
+	}
+	assert.Equal(t, []string{"Watch peer true", "Block peer true"}, steps)
+}
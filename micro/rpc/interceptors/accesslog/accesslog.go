@@ -0,0 +1,57 @@
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+type InterceptorBuilder struct {
+	logFunc func(log string)
+}
+
+func (b *InterceptorBuilder) LogFunc(fn func(log string)) *InterceptorBuilder {
+	b.logFunc = fn
+	return b
+}
+
+// Build 服务端和客户端都可以用
+func (b InterceptorBuilder) Build() rpc.Interceptor {
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (resp *message.Response, err error) {
+			startTime := time.Now()
+			defer func() {
+				al := accessLog{
+					Service:  req.ServiceName,
+					Method:   req.MethodName,
+					Duration: time.Since(startTime).String(),
+				}
+				if err != nil {
+					al.Error = err.Error()
+				} else if st := rpc.StatusFromResponse(resp); st != nil {
+					// 客户端收到的服务端的 error
+					al.Error = st.Message
+				}
+				// 客户端的 RequestID 在发送的时候才会生成, 所以这里再取
+				al.RequestID = req.RequestID
+				// Ignore error here, we are sure our data is good.
+				data, _ := json.Marshal(al)
+				if b.logFunc != nil {
+					b.logFunc(string(data))
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
+
+type accessLog struct {
+	RequestID uint32 `json:"request_id"`
+	Service   string `json:"service"`
+	Method    string `json:"method"`
+	Duration  string `json:"duration"`
+	Error     string `json:"error,omitempty"`
+}
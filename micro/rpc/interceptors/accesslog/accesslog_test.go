@@ -0,0 +1,62 @@
+package accesslog
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptorBuilder_Build(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler rpc.Handler
+		wantLog string
+	}{
+		{
+			name: "no error",
+			handler: func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				return &message.Response{}, nil
+			},
+			wantLog: `{"request_id":12,"service":"user-service","method":"GetByID","duration":"0s"}`,
+		},
+		{
+			name: "error",
+			handler: func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				return nil, errors.New("mock error")
+			},
+			wantLog: `{"request_id":12,"service":"user-service","method":"GetByID","duration":"0s","error":"mock error"}`,
+		},
+		{
+			name: "biz error",
+			handler: func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				return &message.Response{Error: []byte("biz error")}, nil
+			},
+			wantLog: `{"request_id":12,"service":"user-service","method":"GetByID","duration":"0s","error":"biz error"}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var log string
+			builder := &InterceptorBuilder{}
+			h := builder.LogFunc(func(l string) {
+				log = l
+			}).Build()(c.handler)
+			_, _ = h(context.Background(), &message.Request{
+				RequestID:   12,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+			})
+			// 耗时不固定, 只比较其他字段
+			assert.Regexp(t, `"duration":"[^"]+"`, log)
+			assert.Equal(t, c.wantLog, durationRegexp.ReplaceAllString(log, `"duration":"0s"`))
+		})
+	}
+}
+
+var durationRegexp = regexp.MustCompile(`"duration":"[^"]+"`)
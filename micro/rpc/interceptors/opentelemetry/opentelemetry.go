@@ -0,0 +1,81 @@
+package opentelemetry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/startdusk/go-libs/micro/rpc/interceptors/opentelemetry"
+
+// InterceptorBuilder trace 上下文由 rpc 框架自己在 Meta 里面传递, 拦截器只负责开启 span
+type InterceptorBuilder struct {
+	Tracer trace.Tracer
+}
+
+// BuildClient 客户端发起调用之前开启一个 span, 框架发请求的时候会把这个 span 的上下文传给服务端
+func (b InterceptorBuilder) BuildClient() rpc.Interceptor {
+	b.init()
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			ctx, span := b.Tracer.Start(ctx, spanName(req), trace.WithSpanKind(trace.SpanKindClient))
+			defer span.End()
+			setAttributes(span, req)
+
+			resp, err := next(ctx, req)
+			recordErr(span, resp, err)
+			return resp, err
+		}
+	}
+}
+
+// BuildServer 框架已经从 Meta 里面取出了客户端的 trace 上下文放在 ctx 里面, 服务端的 span 会和客户端的连在一起
+func (b InterceptorBuilder) BuildServer() rpc.Interceptor {
+	b.init()
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			ctx, span := b.Tracer.Start(ctx, spanName(req), trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+			setAttributes(span, req)
+
+			resp, err := next(ctx, req)
+			recordErr(span, resp, err)
+			return resp, err
+		}
+	}
+}
+
+func (b *InterceptorBuilder) init() {
+	if b.Tracer == nil {
+		b.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
+	}
+}
+
+// spanName 形如 user-service/GetByID
+func spanName(req *message.Request) string {
+	return fmt.Sprintf("%s/%s", req.ServiceName, req.MethodName)
+}
+
+func setAttributes(span trace.Span, req *message.Request) {
+	span.SetAttributes(attribute.String("rpc.service", req.ServiceName))
+	span.SetAttributes(attribute.String("rpc.method", req.MethodName))
+	span.SetAttributes(attribute.String("component", "rpc"))
+}
+
+func recordErr(span trace.Span, resp *message.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	if st := rpc.StatusFromResponse(resp); st != nil {
+		span.SetAttributes(attribute.String("rpc.code", st.Code.String()))
+		span.SetStatus(codes.Error, st.Message)
+	}
+}
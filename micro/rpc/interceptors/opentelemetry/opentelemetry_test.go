@@ -0,0 +1,95 @@
+package opentelemetry
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// trace 上下文只由框架传递, 拦截器不会往 Meta 里面放, 服务端的 span 照样能和客户端的连起来
+func TestInterceptorBuilder_Propagation(t *testing.T) {
+	builder := InterceptorBuilder{
+		Tracer: trace.NewNoopTracerProvider().Tracer("test"),
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := rpc.NewServer(rpc.ServerWithInterceptors(builder.BuildServer()))
+	server.RegisterService(&traceServiceServer{})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer func() {
+		_ = lis.Close()
+	}()
+
+	// 拦截器交给下一层的请求里面还没有 trace 上下文
+	var clientMeta map[string]string
+	client, err := rpc.NewClient(lis.Addr().String(), rpc.ClientWithInterceptors(
+		builder.BuildClient(),
+		func(next rpc.Handler) rpc.Handler {
+			return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+				// 发请求的时候框架会往同一个 map 里面放 trace 上下文, 所以要复制一份
+				clientMeta = make(map[string]string, len(req.Meta))
+				for k, v := range req.Meta {
+					clientMeta[k] = v
+				}
+				return next(ctx, req)
+			}
+		}))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	tc := &traceServiceClient{}
+	require.NoError(t, client.InitService(tc))
+
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	resp, err := tc.Capture(ctx, &CaptureReq{})
+	require.NoError(t, err)
+	assert.NotContains(t, clientMeta, "traceparent")
+	assert.Equal(t, traceID.String(), resp.TraceID)
+	assert.True(t, resp.Remote)
+}
+
+type CaptureReq struct{}
+
+type CaptureResp struct {
+	TraceID string
+	Remote  bool
+}
+
+type traceServiceClient struct {
+	Capture func(ctx context.Context, req *CaptureReq) (*CaptureResp, error)
+}
+
+func (t *traceServiceClient) Name() string {
+	return "trace-service"
+}
+
+type traceServiceServer struct{}
+
+func (t *traceServiceServer) Name() string {
+	return "trace-service"
+}
+
+func (t *traceServiceServer) Capture(ctx context.Context, req *CaptureReq) (*CaptureResp, error) {
+	sc := trace.SpanContextFromContext(ctx)
+	return &CaptureResp{TraceID: sc.TraceID().String(), Remote: sc.IsRemote()}, nil
+}
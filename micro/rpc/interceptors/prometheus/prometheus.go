@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+type InterceptorBuilder struct {
+	Namespace string
+	Subsystem string
+	Name      string
+	Help      string
+}
+
+// Build 服务端和客户端都可以用, 记录调用的耗时(毫秒)
+// 同一个进程里面服务端和客户端都要用的话, Name 不能一样
+func (b InterceptorBuilder) Build() rpc.Interceptor {
+	vector := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Name:      b.Name,
+		Subsystem: b.Subsystem,
+		Namespace: b.Namespace,
+		Help:      b.Help,
+
+		Objectives: map[float64]float64{
+			0.5:   0.01,
+			0.75:  0.01,
+			0.90:  0.01,
+			0.99:  0.001,
+			0.999: 0.0001,
+		},
+	}, []string{
+		"service",
+		"method",
+		"error", // 是否返回了 error
+	})
+
+	prometheus.MustRegister(vector)
+
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			startTime := time.Now()
+			resp, err := next(ctx, req)
+			hasErr := err != nil || (resp != nil && len(resp.Error) > 0)
+			duration := time.Since(startTime).Milliseconds()
+			vector.WithLabelValues(req.ServiceName, req.MethodName, boolLabel(hasErr)).Observe(float64(duration))
+			return resp, err
+		}
+	}
+}
+
+func boolLabel(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
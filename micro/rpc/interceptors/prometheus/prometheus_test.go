@@ -0,0 +1,50 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptorBuilder_Build(t *testing.T) {
+	interceptor := InterceptorBuilder{
+		Namespace: "startdusk",
+		Subsystem: "rpc",
+		Name:      "test_server",
+		Help:      "rpc 调用耗时",
+	}.Build()
+
+	ok := interceptor(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		return &message.Response{}, nil
+	})
+	fail := interceptor(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		return nil, errors.New("mock error")
+	})
+	req := &message.Request{ServiceName: "user-service", MethodName: "GetByID"}
+	_, _ = ok(context.Background(), req)
+	_, _ = ok(context.Background(), req)
+	_, _ = fail(context.Background(), req)
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	counts := map[string]uint64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "startdusk_rpc_test_server" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "error" {
+					counts[l.GetValue()] = m.GetSummary().GetSampleCount()
+				}
+			}
+		}
+	}
+	// 成功和失败分开统计
+	assert.Equal(t, map[string]uint64{"false": 2, "true": 1}, counts)
+}
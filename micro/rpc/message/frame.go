@@ -0,0 +1,198 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// 协议版本
+// Version0 是最早的格式: 固定 15 字节的头部, 字符串用 \n 和 \r 分隔, 没有魔数, 没有校验, 也没有 Flag
+// Version1 开头是魔数, 元数据按照长度前缀编码, 可以放任意字节, 固定部分的头部带 CRC32 校验
+//
+// 帧的格式由开头有没有魔数决定, 不是由 Version 字段决定. 老的帧开头是头部长度, 不可能这么大
+// 客户端一开始用 Version0 发请求, 新的服务端在 Version0 的响应里面带上自己支持的最高版本(Response.MaxVersion),
+// 客户端看到之后就把这个连接升级到双方都支持的最高版本. 老的服务端原样返回请求里面的版本字节, 也就是 0, 连接就一直用 Version0
+// 流式调用和心跳的 Flag 只能放在 Version1 的帧里面, 连接还是 Version0 的时候不能用
+const (
+	Version0 uint8 = iota
+	Version1
+
+	// MaxVersion 当前支持的最高版本
+	MaxVersion = Version1
+)
+
+// FramePrefixLength 读一个帧的时候先读这么多字节, 这是两种格式固定头部长度里面短的那个
+// 有了它就能用 FixedHeaderLength 知道固定头部有多长
+const FramePrefixLength = headerLength
+
+var magic = [2]byte{0xCA, 0xFE}
+
+// headerLengthV1 Version1 固定部分的头部长度
+// magic(2) version(1) flag(1) head length(4) body length(4) request id(4) compresser(1) serializer(1) checksum(4)
+const headerLengthV1 = 22
+
+// checksumOffset 校验和在头部的位置
+// 校验和只覆盖固定部分的头部, 这样读帧的时候不用分配内存就能先确认长度字段是对的
+// 服务名和元数据都有长度前缀, 解码的时候会检查越界, 坏掉了也不会把后面的帧读乱
+const checksumOffset = 18
+
+var (
+	ErrInvalidFrame       = errors.New("rpc: 非法的帧")
+	ErrChecksum           = errors.New("rpc: 头部校验和不对")
+	ErrUnsupportedVersion = errors.New("rpc: 不支持的协议版本")
+	// ErrInvalidMeta Version0 的元数据不能包含 \n 和 \r, 需要升级到 Version1
+	ErrInvalidMeta = errors.New("rpc: 元数据包含了分隔符, 当前协议版本不支持")
+	// ErrUnsupportedFlag Version0 的帧没有 Flag, 流式调用和心跳需要 Version1
+	ErrUnsupportedFlag = errors.New("rpc: 当前协议版本不支持流式调用和心跳")
+	// ErrMetaTooLarge Version1 的服务名, 方法名和元数据的键最长 65535 字节
+	ErrMetaTooLarge = errors.New("rpc: 元数据太大")
+)
+
+// FixedHeaderLength 根据帧的前 FramePrefixLength 个字节算出固定部分的头部有多长
+func FixedHeaderLength(prefix []byte) int {
+	if hasMagic(prefix) {
+		return headerLengthV1
+	}
+	return headerLength
+}
+
+// FrameLength 根据帧固定部分的头部算出整个帧的长度, Version1 的帧会先检查校验和
+// header 的长度必须是 FixedHeaderLength 的结果
+func FrameLength(header []byte) (uint64, error) {
+	if hasMagic(header) {
+		if len(header) < headerLengthV1 {
+			return 0, ErrInvalidFrame
+		}
+		if header[2] == Version0 || header[2] > MaxVersion {
+			return 0, ErrUnsupportedVersion
+		}
+		if !validChecksum(header) {
+			return 0, ErrChecksum
+		}
+		headLen := binary.BigEndian.Uint32(header[4:8])
+		if headLen < headerLengthV1 {
+			return 0, ErrInvalidFrame
+		}
+		return uint64(headLen) + uint64(binary.BigEndian.Uint32(header[8:12])), nil
+	}
+	if len(header) < headerLength {
+		return 0, ErrInvalidFrame
+	}
+	headLen := binary.BigEndian.Uint32(header[:4])
+	if headLen < headerLength {
+		return 0, ErrInvalidFrame
+	}
+	return uint64(headLen) + uint64(binary.BigEndian.Uint32(header[4:8])), nil
+}
+
+func hasMagic(data []byte) bool {
+	return len(data) >= 2 && data[0] == magic[0] && data[1] == magic[1]
+}
+
+// putHeaderV1 写入 Version1 的固定头部, 校验和最后再算
+func putHeaderV1(bs []byte, version, flag uint8, headLen, bodyLen, reqID uint32, compresser, serializer uint8) {
+	bs[0], bs[1] = magic[0], magic[1]
+	bs[2] = version
+	bs[3] = flag
+	binary.BigEndian.PutUint32(bs[4:8], headLen)
+	binary.BigEndian.PutUint32(bs[8:12], bodyLen)
+	binary.BigEndian.PutUint32(bs[12:16], reqID)
+	bs[16] = compresser
+	bs[17] = serializer
+}
+
+// checksum 固定部分的头部除了校验和本身以外的 CRC32
+func checksum(header []byte) uint32 {
+	return crc32.ChecksumIEEE(header[:checksumOffset])
+}
+
+func putChecksum(bs []byte) {
+	binary.BigEndian.PutUint32(bs[checksumOffset:headerLengthV1], checksum(bs))
+}
+
+func validChecksum(bs []byte) bool {
+	return binary.BigEndian.Uint32(bs[checksumOffset:headerLengthV1]) == checksum(bs)
+}
+
+// checkFrameV1 校验 Version1 帧的长度, 版本和校验和, 返回头部长度和 body 长度
+func checkFrameV1(data []byte) (uint32, uint32, error) {
+	if len(data) < headerLengthV1 {
+		return 0, 0, ErrInvalidFrame
+	}
+	if data[2] == Version0 || data[2] > MaxVersion {
+		return 0, 0, ErrUnsupportedVersion
+	}
+	if !validChecksum(data) {
+		return 0, 0, ErrChecksum
+	}
+	headLen := binary.BigEndian.Uint32(data[4:8])
+	bodyLen := binary.BigEndian.Uint32(data[8:12])
+	if headLen < headerLengthV1 || uint64(len(data)) != uint64(headLen)+uint64(bodyLen) {
+		return 0, 0, ErrInvalidFrame
+	}
+	return headLen, bodyLen, nil
+}
+
+// checkFrameV0 校验 Version0 帧的长度, 返回头部长度和 body 长度
+func checkFrameV0(data []byte) (uint32, uint32, error) {
+	if len(data) < headerLength {
+		return 0, 0, ErrInvalidFrame
+	}
+	headLen := binary.BigEndian.Uint32(data[:4])
+	bodyLen := binary.BigEndian.Uint32(data[4:8])
+	if headLen < headerLength || uint64(len(data)) != uint64(headLen)+uint64(bodyLen) {
+		return 0, 0, ErrInvalidFrame
+	}
+	return headLen, bodyLen, nil
+}
+
+// reader 按照长度前缀读数据, 越界了就记下错误, 后面的读取都返回零值
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = ErrInvalidFrame
+		return nil
+	}
+	bs := r.data[:n]
+	r.data = r.data[n:]
+	return bs
+}
+
+func (r *reader) uint16() uint16 {
+	bs := r.next(2)
+	if bs == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(bs)
+}
+
+func (r *reader) uint32() uint32 {
+	bs := r.next(4)
+	if bs == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(bs)
+}
+
+// string16 长度用 2 个字节表示的字符串
+func (r *reader) string16() string {
+	return string(r.next(int(r.uint16())))
+}
+
+// string32 长度用 4 个字节表示的字符串
+func (r *reader) string32() string {
+	n := r.uint32()
+	if uint64(n) > uint64(len(r.data)) {
+		r.err = ErrInvalidFrame
+		return ""
+	}
+	return string(r.next(int(n)))
+}
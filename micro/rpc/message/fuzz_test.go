@@ -0,0 +1,78 @@
+package message
+
+import (
+	"testing"
+)
+
+func FuzzDecodeReq(f *testing.F) {
+	for _, version := range []uint8{Version0, Version1} {
+		req := &Request{
+			RequestID:   123,
+			Version:     version,
+			ServiceName: "user-service",
+			MethodName:  "GetByID",
+			Meta:        map[string]string{"trace-id": "123456"},
+			Data:        []byte("Hello world"),
+		}
+		if version >= Version1 {
+			req.Flag = FlagStream
+		}
+		req.CalculateHeaderLength()
+		req.CalculateBodyLength()
+		data, err := EncodeReq(req)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req, err := DecodeReq(data)
+		if err != nil {
+			return
+		}
+		// 能解出来的请求, 重新编码再解码应该得到一样的结果
+		req.CalculateHeaderLength()
+		req.CalculateBodyLength()
+		encoded, err := EncodeReq(req)
+		if err != nil {
+			return
+		}
+		again, err := DecodeReq(encoded)
+		if err != nil {
+			t.Fatalf("重新编码的请求解不出来: %v", err)
+		}
+		if again.ServiceName != req.ServiceName || again.MethodName != req.MethodName ||
+			len(again.Meta) != len(req.Meta) || string(again.Data) != string(req.Data) {
+			t.Fatalf("重新编码之后不一致: %+v %+v", req, again)
+		}
+	})
+}
+
+func FuzzDecodeResp(f *testing.F) {
+	for _, version := range []uint8{Version0, Version1} {
+		resp := &Response{
+			RequestID: 123,
+			Version:   version,
+			Error:     []byte("this is a error"),
+			Data:      []byte("Hello world"),
+		}
+		resp.CalculateHeaderLength()
+		resp.CalculateBodyLength()
+		f.Add(EncodeResp(resp))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resp, err := DecodeResp(data)
+		if err != nil {
+			return
+		}
+		resp.CalculateHeaderLength()
+		resp.CalculateBodyLength()
+		again, err := DecodeResp(EncodeResp(resp))
+		if err != nil {
+			t.Fatalf("重新编码的响应解不出来: %v", err)
+		}
+		if string(again.Error) != string(resp.Error) || string(again.Data) != string(resp.Data) {
+			t.Fatalf("重新编码之后不一致: %+v %+v", resp, again)
+		}
+	})
+}
@@ -3,6 +3,8 @@ package message
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
+	"strings"
 )
 
 const (
@@ -10,13 +12,38 @@ const (
 	metaSpliter = '\r'
 )
 
+// headerLength Version0 固定部分的头部长度, 和最早的格式一个字节都不能差, 否则老的客户端和服务端都解不出来
+const headerLength = 15
+
+// Flag 帧类型, 普通调用是 0, 只有 Version1 的帧才有这个字段
+// 流式调用的所有帧都使用同一个 RequestID, 也就是流的 ID
+const (
+	// FlagStream 这是流式调用的帧
+	FlagStream uint8 = 1 << iota
+	// FlagEndStream 发送方不会再发送数据了
+	// 客户端发出来就是半关闭, 服务端发出来就是整个流结束了, 这个时候 Error 就是流的错误
+	FlagEndStream
+	// FlagCancel 客户端放弃了这个流, 服务端应该取消处理
+	FlagCancel
+	// FlagPing 心跳请求, 没有服务名和方法名, 服务端收到之后马上用 FlagPong 响应
+	FlagPing
+	// FlagPong 心跳响应, RequestID 和心跳请求一样
+	FlagPong
+	// FlagWindowUpdate 流量控制, 和 FlagStream 一起用, Data 是 4 个字节大端序的窗口增量,
+	// 告诉对端又消费掉了这么多数据, 可以继续发了
+	FlagWindowUpdate
+)
+
 type Request struct {
 	HeadLength uint32
 	BodyLength uint32
 	RequestID  uint32
+	// Version 协议版本, 决定了编码成哪种格式
 	Version    uint8
 	Compresser uint8
 	Serializer uint8
+	// Flag Version0 的帧没有地方放它, 流式调用和心跳要等连接升级到 Version1 才能用
+	Flag uint8
 
 	ServiceName string
 	MethodName  string
@@ -24,7 +51,30 @@ type Request struct {
 	Data        []byte
 }
 
-func EncodeReq(req *Request) []byte {
+// EncodeReq 按照 req.Version 编码请求, 调用之前要先算好 HeadLength 和 BodyLength
+func EncodeReq(req *Request) ([]byte, error) {
+	switch req.Version {
+	case Version0:
+		return encodeReqV0(req)
+	case Version1:
+		return encodeReqV1(req)
+	default:
+		return nil, ErrUnsupportedVersion
+	}
+}
+
+func encodeReqV0(req *Request) ([]byte, error) {
+	if req.Flag != 0 {
+		return nil, ErrUnsupportedFlag
+	}
+	if invalidV0(req.ServiceName) || invalidV0(req.MethodName) {
+		return nil, ErrInvalidMeta
+	}
+	for key, val := range req.Meta {
+		if invalidV0(key) || invalidV0(val) {
+			return nil, ErrInvalidMeta
+		}
+	}
 	// 总长度为 head 长度 + body 长度
 	bs := make([]byte, req.HeadLength+req.BodyLength)
 
@@ -34,14 +84,14 @@ func EncodeReq(req *Request) []byte {
 	binary.BigEndian.PutUint32(bs[4:8], req.BodyLength)
 	// 3.写入 Request ID
 	binary.BigEndian.PutUint32(bs[8:12], req.RequestID)
-	// 4.写入 Version
-	bs[12] = req.Version
+	// 4.Version 固定写 0, 老的服务端会原样返回它, 客户端就知道不能升级
+	bs[12] = Version0
 	// 5.写入Compresser
 	bs[13] = req.Compresser
 	// 6.写入Serializer
 	bs[14] = req.Serializer
 
-	cur := bs[15:]
+	cur := bs[headerLength:]
 	// 7.写入ServiceName
 	copy(cur, req.ServiceName)
 
@@ -75,32 +125,89 @@ func EncodeReq(req *Request) []byte {
 	// 12.写入Data
 	copy(cur, req.Data)
 
-	return bs
+	return bs, nil
+}
+
+func invalidV0(s string) bool {
+	return strings.IndexByte(s, spliter) >= 0 || strings.IndexByte(s, metaSpliter) >= 0
+}
+
+func encodeReqV1(req *Request) ([]byte, error) {
+	if len(req.ServiceName) > math.MaxUint16 || len(req.MethodName) > math.MaxUint16 || len(req.Meta) > math.MaxUint16 {
+		return nil, ErrMetaTooLarge
+	}
+	for key := range req.Meta {
+		if len(key) > math.MaxUint16 {
+			return nil, ErrMetaTooLarge
+		}
+	}
+	bs := make([]byte, req.HeadLength+req.BodyLength)
+	putHeaderV1(bs, req.Version, req.Flag, req.HeadLength, req.BodyLength, req.RequestID, req.Compresser, req.Serializer)
+
+	// 服务名, 方法名和元数据都是 长度 + 内容
+	cur := bs[headerLengthV1:]
+	cur = putString16(cur, req.ServiceName)
+	cur = putString16(cur, req.MethodName)
+	binary.BigEndian.PutUint16(cur, uint16(len(req.Meta)))
+	cur = cur[2:]
+	for key, val := range req.Meta {
+		cur = putString16(cur, key)
+		binary.BigEndian.PutUint32(cur, uint32(len(val)))
+		cur = cur[4:]
+		cur = cur[copy(cur, val):]
+	}
+	copy(cur, req.Data)
+
+	putChecksum(bs)
+	return bs, nil
+}
+
+func putString16(bs []byte, s string) []byte {
+	binary.BigEndian.PutUint16(bs, uint16(len(s)))
+	return bs[2+copy(bs[2:], s):]
+}
+
+// DecodeReq 解码请求, 两种格式都支持, 数据不完整或者被篡改了会返回错误
+func DecodeReq(data []byte) (*Request, error) {
+	if hasMagic(data) {
+		return decodeReqV1(data)
+	}
+	return decodeReqV0(data)
 }
 
-func DecodeReq(data []byte) *Request {
+func decodeReqV0(data []byte) (*Request, error) {
+	headLen, bodyLen, err := checkFrameV0(data)
+	if err != nil {
+		return nil, err
+	}
 	req := &Request{}
 	// 1.解head长度
-	req.HeadLength = binary.BigEndian.Uint32(data[:4])
+	req.HeadLength = headLen
 	// 2.解body长度
-	req.BodyLength = binary.BigEndian.Uint32(data[4:8])
+	req.BodyLength = bodyLen
 	// 3.解Request ID
 	req.RequestID = binary.BigEndian.Uint32(data[8:12])
-	// 4.解Version
-	req.Version = data[12]
+	// 4.老的客户端可能随便填了 Version, 按照帧的格式来, 这就是 Version0
+	req.Version = Version0
 	// 5.解Compresser
 	req.Compresser = data[13]
 	// 6.解Serializer
 	req.Serializer = data[14]
 
-	header := data[15:req.HeadLength] // 将 header 和 body 切割
+	header := data[headerLength:req.HeadLength] // 将 header 和 body 切割
 
 	// 7.解ServiceName
 	index := bytes.IndexByte(header, spliter)
+	if index == -1 {
+		return nil, ErrInvalidFrame
+	}
 	req.ServiceName = string(header[:index])
 
 	header = header[index+1:]
 	index = bytes.IndexByte(header, spliter)
+	if index == -1 {
+		return nil, ErrInvalidFrame
+	}
 	// 8.解MethodName
 	req.MethodName = string(header[:index])
 	header = header[index+1:]
@@ -112,6 +219,9 @@ func DecodeReq(data []byte) *Request {
 		for index != -1 {
 			pair := header[:index]
 			pairIndex := bytes.IndexByte(pair, metaSpliter)
+			if pairIndex == -1 {
+				return nil, ErrInvalidFrame
+			}
 			key := string(pair[:pairIndex])
 			val := string(pair[pairIndex+1:])
 			meta[key] = val
@@ -121,16 +231,67 @@ func DecodeReq(data []byte) *Request {
 
 		req.Meta = meta
 	}
+	if len(header) > 0 {
+		return nil, ErrInvalidFrame
+	}
 
 	if req.BodyLength > 0 {
 		req.Data = data[req.HeadLength:]
 	}
 
-	return req
+	return req, nil
+}
+
+func decodeReqV1(data []byte) (*Request, error) {
+	headLen, bodyLen, err := checkFrameV1(data)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		HeadLength: headLen,
+		BodyLength: bodyLen,
+		RequestID:  binary.BigEndian.Uint32(data[12:16]),
+		Version:    data[2],
+		Compresser: data[16],
+		Serializer: data[17],
+		Flag:       data[3],
+	}
+	r := &reader{data: data[headerLengthV1:headLen]}
+	req.ServiceName = r.string16()
+	req.MethodName = r.string16()
+	if cnt := int(r.uint16()); cnt > 0 {
+		// 每个元数据至少 6 个字节, 防止伪造的数量导致分配很大的 map
+		if cnt > len(r.data)/6 {
+			return nil, ErrInvalidFrame
+		}
+		req.Meta = make(map[string]string, cnt)
+		for i := 0; i < cnt; i++ {
+			key := r.string16()
+			req.Meta[key] = r.string32()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) > 0 {
+		return nil, ErrInvalidFrame
+	}
+	if req.BodyLength > 0 {
+		req.Data = data[req.HeadLength:]
+	}
+	return req, nil
 }
 
 func (req *Request) CalculateHeaderLength() {
-	length := 15 + len(req.ServiceName) + 1 + len(req.MethodName) + 1
+	if req.Version >= Version1 {
+		length := headerLengthV1 + 2 + len(req.ServiceName) + 2 + len(req.MethodName) + 2
+		for key, val := range req.Meta {
+			length += 2 + len(key) + 4 + len(val)
+		}
+		req.HeadLength = uint32(length)
+		return
+	}
+	length := headerLength + len(req.ServiceName) + 1 + len(req.MethodName) + 1
 	for key, val := range req.Meta {
 		length += len(key)
 		length++ // 分隔符
@@ -1,9 +1,13 @@
 package message
 
 import (
+	"encoding/binary"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_EncodeDecodeReq(t *testing.T) {
@@ -15,7 +19,6 @@ func Test_EncodeDecodeReq(t *testing.T) {
 			name: "normal",
 			req: &Request{
 				RequestID:   123,
-				Version:     12,
 				Compresser:  13,
 				Serializer:  14,
 				ServiceName: "user-service",
@@ -31,7 +34,6 @@ func Test_EncodeDecodeReq(t *testing.T) {
 			name: "no meta",
 			req: &Request{
 				RequestID:   123,
-				Version:     12,
 				Compresser:  13,
 				Serializer:  14,
 				ServiceName: "user-service",
@@ -43,7 +45,6 @@ func Test_EncodeDecodeReq(t *testing.T) {
 			name: "no data",
 			req: &Request{
 				RequestID:   123,
-				Version:     12,
 				Compresser:  13,
 				Serializer:  14,
 				ServiceName: "user-service",
@@ -58,7 +59,6 @@ func Test_EncodeDecodeReq(t *testing.T) {
 			name: "no meta and data",
 			req: &Request{
 				RequestID:   123,
-				Version:     12,
 				Compresser:  13,
 				Serializer:  14,
 				ServiceName: "user-service",
@@ -69,7 +69,6 @@ func Test_EncodeDecodeReq(t *testing.T) {
 			name: "data with \n",
 			req: &Request{
 				RequestID:   123,
-				Version:     12,
 				Compresser:  13,
 				Serializer:  14,
 				ServiceName: "user-service",
@@ -85,7 +84,6 @@ func Test_EncodeDecodeReq(t *testing.T) {
 			name: "data with \r",
 			req: &Request{
 				RequestID:   123,
-				Version:     12,
 				Compresser:  13,
 				Serializer:  14,
 				ServiceName: "user-service",
@@ -97,17 +95,261 @@ func Test_EncodeDecodeReq(t *testing.T) {
 				Data: []byte("Hello\rworld"),
 			},
 		},
+	}
+
+	for _, c := range cases {
+		for _, version := range []uint8{Version0, Version1} {
+			t.Run(fmt.Sprintf("%s v%d", c.name, version), func(t *testing.T) {
+				c.req.Version = version
+				// Version0 的帧没有 Flag
+				c.req.Flag = 0
+				if version >= Version1 {
+					c.req.Flag = FlagStream | FlagEndStream
+				}
+				c.req.CalculateHeaderLength()
+				c.req.CalculateBodyLength()
+				data, err := EncodeReq(c.req)
+				require.NoError(t, err)
+				decodeReq, err := DecodeReq(data)
+				require.NoError(t, err)
+				assert.Equal(t, c.req, decodeReq)
+			})
+		}
+	}
+}
+
+// Test_Req_v0Golden golden 是最早的代码编码出来的请求, Version0 必须和它一个字节都不差
+func Test_Req_v0Golden(t *testing.T) {
+	golden := []byte{
+		0x0, 0x0, 0x0, 0x34, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x7b, 0x0, 0x1, 0x2,
+		'u', 's', 'e', 'r', '-', 's', 'e', 'r', 'v', 'i', 'c', 'e', '\n',
+		'G', 'e', 't', 'B', 'y', 'I', 'D', '\n',
+		't', 'r', 'a', 'c', 'e', '-', 'i', 'd', '\r', '1', '2', '3', '4', '5', '6', '\n',
+		'h', 'e', 'l', 'l', 'o',
+	}
+	want := &Request{
+		HeadLength:  52,
+		BodyLength:  5,
+		RequestID:   123,
+		Version:     Version0,
+		Compresser:  1,
+		Serializer:  2,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Meta:        map[string]string{"trace-id": "123456"},
+		Data:        []byte("hello"),
+	}
+
+	req, err := DecodeReq(golden)
+	require.NoError(t, err)
+	assert.Equal(t, want, req)
+
+	length, err := FrameLength(golden[:FramePrefixLength])
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(golden)), length)
+
+	want.HeadLength, want.BodyLength = 0, 0
+	want.CalculateHeaderLength()
+	want.CalculateBodyLength()
+	data, err := EncodeReq(want)
+	require.NoError(t, err)
+	assert.Equal(t, golden, data)
+}
 
-		// 禁止开发者在框架的Meta里面加\n \r
+func Test_EncodeReq_meta(t *testing.T) {
+	cases := []struct {
+		name    string
+		req     *Request
+		wantErr error
+	}{
+		{
+			// Version0 用 \n 和 \r 做分隔符, 元数据里面不能有
+			name: "v0 meta with separator",
+			req: &Request{
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+				Meta:        map[string]string{"trace-id": "123\n456"},
+			},
+			wantErr: ErrInvalidMeta,
+		},
+		{
+			name: "v0 service name with separator",
+			req: &Request{
+				ServiceName: "user\rservice",
+				MethodName:  "GetByID",
+			},
+			wantErr: ErrInvalidMeta,
+		},
+		{
+			name: "v0 flag",
+			req: &Request{
+				Flag:        FlagPing,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+			},
+			wantErr: ErrUnsupportedFlag,
+		},
+		{
+			name: "v1 binary meta",
+			req: &Request{
+				Version:     Version1,
+				ServiceName: "user\nservice",
+				MethodName:  "GetByID",
+				Meta: map[string]string{
+					"trace-id\r": "123\n456",
+					"bin":        string([]byte{0, 1, 2, 0xff}),
+					"":           "",
+				},
+				Data: []byte("Hello world"),
+			},
+		},
+		{
+			name: "v1 key too large",
+			req: &Request{
+				Version:     Version1,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+				Meta:        map[string]string{strings.Repeat("a", 1<<16): "a"},
+			},
+			wantErr: ErrMetaTooLarge,
+		},
+		{
+			name: "unsupported version",
+			req: &Request{
+				Version:     MaxVersion + 1,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 			c.req.CalculateHeaderLength()
 			c.req.CalculateBodyLength()
-			data := EncodeReq(c.req)
-			decodeReq := DecodeReq(data)
+			data, err := EncodeReq(c.req)
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			decodeReq, err := DecodeReq(data)
+			require.NoError(t, err)
 			assert.Equal(t, c.req, decodeReq)
 		})
 	}
 }
+
+func Test_DecodeReq_invalid(t *testing.T) {
+	req := &Request{
+		RequestID:   123,
+		Version:     Version1,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Meta:        map[string]string{"trace-id": "123456"},
+		Data:        []byte("Hello world"),
+	}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	valid, err := EncodeReq(req)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		data    func() []byte
+		wantErr error
+	}{
+		{
+			name: "header tampered",
+			data: func() []byte {
+				data := append([]byte{}, valid...)
+				// 改了 RequestID 的一个字节
+				data[12] = 0xff
+				return data
+			},
+			wantErr: ErrChecksum,
+		},
+		{
+			name: "truncated",
+			data: func() []byte {
+				return valid[:len(valid)-1]
+			},
+			wantErr: ErrInvalidFrame,
+		},
+		{
+			name: "unsupported version",
+			data: func() []byte {
+				data := append([]byte{}, valid...)
+				data[2] = MaxVersion + 1
+				return data
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name: "v0 too short",
+			data: func() []byte {
+				return []byte{0, 0, 0, 16}
+			},
+			wantErr: ErrInvalidFrame,
+		},
+		{
+			name: "v0 no separator",
+			data: func() []byte {
+				data := make([]byte, headerLength+4)
+				binary.BigEndian.PutUint32(data, headerLength+4)
+				copy(data[headerLength:], "user")
+				return data
+			},
+			wantErr: ErrInvalidFrame,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := DecodeReq(c.data())
+			assert.Equal(t, c.wantErr, err)
+		})
+	}
+}
+
+func Test_FrameLength(t *testing.T) {
+	cases := []struct {
+		name    string
+		version uint8
+	}{
+		{
+			name:    "v0",
+			version: Version0,
+		},
+		{
+			name:    "v1",
+			version: Version1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := &Request{
+				Version:     c.version,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+				Data:        []byte("Hello world"),
+			}
+			req.CalculateHeaderLength()
+			req.CalculateBodyLength()
+			data, err := EncodeReq(req)
+			require.NoError(t, err)
+			header := data[:FixedHeaderLength(data[:FramePrefixLength])]
+			length, err := FrameLength(header)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(len(data)), length)
+
+			if c.version >= Version1 {
+				// 长度字段被改了, 分配内存之前就能发现
+				header[8] = 0xff
+				_, err = FrameLength(header)
+				assert.Equal(t, ErrChecksum, err)
+			}
+		})
+	}
+}
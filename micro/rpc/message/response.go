@@ -8,15 +8,24 @@ type Response struct {
 	HeadLength uint32
 	BodyLength uint32
 	RequestID  uint32
-	Version    uint8
+	// Version 协议版本, 决定了编码成哪种格式
+	Version uint8
+	// MaxVersion 只在 Version0 的响应里面有, 是服务端支持的最高版本, 客户端用它来升级连接
+	MaxVersion uint8
 	Compresser uint8
 	Serializer uint8
-	Error      []byte
+	// Flag 只有 Version1 的帧才有, 服务端只会在 Version1 的连接上发出带 Flag 的响应
+	Flag  uint8
+	Error []byte
 
 	Data []byte
 }
 
+// EncodeResp 按照 resp.Version 编码响应, 调用之前要先算好 HeadLength 和 BodyLength
 func EncodeResp(resp *Response) []byte {
+	if resp.Version >= Version1 {
+		return encodeRespV1(resp)
+	}
 	// 总长度为 head 长度 + body 长度
 	bs := make([]byte, resp.HeadLength+resp.BodyLength)
 
@@ -26,14 +35,14 @@ func EncodeResp(resp *Response) []byte {
 	binary.BigEndian.PutUint32(bs[4:8], resp.BodyLength)
 	// 3.写入 Request ID
 	binary.BigEndian.PutUint32(bs[8:12], resp.RequestID)
-	// 4.写入 Version
-	bs[12] = resp.Version
+	// 4.原来的 Version 字节, 现在用来告诉客户端服务端支持的最高版本
+	bs[12] = resp.MaxVersion
 	// 5.写入 Compresser
 	bs[13] = resp.Compresser
 	// 6.写入 Serializer
 	bs[14] = resp.Serializer
 
-	cur := bs[15:]
+	cur := bs[headerLength:]
 
 	// 7.写入 Error
 	copy(cur, resp.Error)
@@ -45,24 +54,43 @@ func EncodeResp(resp *Response) []byte {
 	return bs
 }
 
-func DecodeResp(data []byte) *Response {
+func encodeRespV1(resp *Response) []byte {
+	bs := make([]byte, resp.HeadLength+resp.BodyLength)
+	putHeaderV1(bs, resp.Version, resp.Flag, resp.HeadLength, resp.BodyLength, resp.RequestID, resp.Compresser, resp.Serializer)
+	cur := bs[headerLengthV1:]
+	cur = cur[copy(cur, resp.Error):]
+	copy(cur, resp.Data)
+	putChecksum(bs)
+	return bs
+}
+
+// DecodeResp 解码响应, 两种格式都支持, 数据不完整或者被篡改了会返回错误
+func DecodeResp(data []byte) (*Response, error) {
+	if hasMagic(data) {
+		return decodeRespV1(data)
+	}
+	headLen, bodyLen, err := checkFrameV0(data)
+	if err != nil {
+		return nil, err
+	}
 	resp := &Response{}
 	// 1.解head长度
-	resp.HeadLength = binary.BigEndian.Uint32(data[:4])
+	resp.HeadLength = headLen
 	// 2.解body长度
-	resp.BodyLength = binary.BigEndian.Uint32(data[4:8])
+	resp.BodyLength = bodyLen
 	// 3.解Request ID
 	resp.RequestID = binary.BigEndian.Uint32(data[8:12])
-	// 4.解Version
-	resp.Version = data[12]
+	// 4.解服务端支持的最高版本, 老的服务端这里是请求里面的 0
+	resp.Version = Version0
+	resp.MaxVersion = data[12]
 	// 5.解Compresser
 	resp.Compresser = data[13]
 	// 6.解Serializer
 	resp.Serializer = data[14]
 
 	// 7.解Error
-	if resp.HeadLength > 15 {
-		resp.Error = data[15:resp.HeadLength]
+	if resp.HeadLength > headerLength {
+		resp.Error = data[headerLength:resp.HeadLength]
 	}
 
 	// 8.解Data
@@ -70,11 +98,38 @@ func DecodeResp(data []byte) *Response {
 		resp.Data = data[resp.HeadLength:]
 	}
 
-	return resp
+	return resp, nil
+}
+
+func decodeRespV1(data []byte) (*Response, error) {
+	headLen, bodyLen, err := checkFrameV1(data)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{
+		HeadLength: headLen,
+		BodyLength: bodyLen,
+		RequestID:  binary.BigEndian.Uint32(data[12:16]),
+		Version:    data[2],
+		Compresser: data[16],
+		Serializer: data[17],
+		Flag:       data[3],
+	}
+	if headLen > headerLengthV1 {
+		resp.Error = data[headerLengthV1:headLen]
+	}
+	if bodyLen > 0 {
+		resp.Data = data[headLen:]
+	}
+	return resp, nil
 }
 
 func (resp *Response) CalculateHeaderLength() {
-	resp.HeadLength = 15 + uint32(len(resp.Error))
+	if resp.Version >= Version1 {
+		resp.HeadLength = headerLengthV1 + uint32(len(resp.Error))
+		return
+	}
+	resp.HeadLength = headerLength + uint32(len(resp.Error))
 }
 
 func (resp *Response) CalculateBodyLength() {
@@ -1,9 +1,12 @@
 package message
 
 import (
+	"encoding/binary"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_EncodeDecodeResp(t *testing.T) {
@@ -15,7 +18,6 @@ func Test_EncodeDecodeResp(t *testing.T) {
 			name: "normal",
 			resp: &Response{
 				RequestID:  123,
-				Version:    12,
 				Compresser: 13,
 				Serializer: 14,
 				Error:      []byte("this is a error"),
@@ -26,7 +28,6 @@ func Test_EncodeDecodeResp(t *testing.T) {
 			name: "no error",
 			resp: &Response{
 				RequestID:  123,
-				Version:    12,
 				Compresser: 13,
 				Serializer: 14,
 				Data:       []byte("Hello world"),
@@ -36,20 +37,109 @@ func Test_EncodeDecodeResp(t *testing.T) {
 			name: "no data and error",
 			resp: &Response{
 				RequestID:  123,
-				Version:    12,
 				Compresser: 13,
 				Serializer: 14,
 			},
 		},
 	}
 
+	for _, c := range cases {
+		for _, version := range []uint8{Version0, Version1} {
+			t.Run(fmt.Sprintf("%s v%d", c.name, version), func(t *testing.T) {
+				c.resp.Version = version
+				if version == Version0 {
+					c.resp.MaxVersion = MaxVersion
+					c.resp.Flag = 0
+				} else {
+					c.resp.MaxVersion = 0
+					c.resp.Flag = FlagStream
+				}
+				c.resp.CalculateHeaderLength()
+				c.resp.CalculateBodyLength()
+				data := EncodeResp(c.resp)
+				decodeResp, err := DecodeResp(data)
+				require.NoError(t, err)
+				assert.Equal(t, c.resp, decodeResp)
+			})
+		}
+	}
+}
+
+// Test_Resp_v0Golden golden 是最早的代码编码出来的响应, Version0 必须和它一个字节都不差
+func Test_Resp_v0Golden(t *testing.T) {
+	golden := []byte{
+		0x0, 0x0, 0x0, 0x13, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x7b, 0x0, 0x1, 0x2,
+		'o', 'o', 'p', 's',
+		'h', 'e', 'l', 'l', 'o',
+	}
+	want := &Response{
+		HeadLength: 19,
+		BodyLength: 5,
+		RequestID:  123,
+		Version:    Version0,
+		Compresser: 1,
+		Serializer: 2,
+		Error:      []byte("oops"),
+		Data:       []byte("hello"),
+	}
+
+	resp, err := DecodeResp(golden)
+	require.NoError(t, err)
+	assert.Equal(t, want, resp)
+
+	want.HeadLength, want.BodyLength = 0, 0
+	want.CalculateHeaderLength()
+	want.CalculateBodyLength()
+	assert.Equal(t, golden, EncodeResp(want))
+}
+
+func Test_DecodeResp_invalid(t *testing.T) {
+	resp := &Response{
+		RequestID: 123,
+		Version:   Version1,
+		Error:     []byte("this is a error"),
+		Data:      []byte("Hello world"),
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	valid := EncodeResp(resp)
+
+	cases := []struct {
+		name    string
+		data    func() []byte
+		wantErr error
+	}{
+		{
+			name: "header tampered",
+			data: func() []byte {
+				data := append([]byte{}, valid...)
+				data[12] = 0xff
+				return data
+			},
+			wantErr: ErrChecksum,
+		},
+		{
+			name: "truncated",
+			data: func() []byte {
+				return valid[:headerLengthV1-1]
+			},
+			wantErr: ErrInvalidFrame,
+		},
+		{
+			name: "v0 head length too small",
+			data: func() []byte {
+				data := make([]byte, headerLength)
+				binary.BigEndian.PutUint32(data, 4)
+				return data
+			},
+			wantErr: ErrInvalidFrame,
+		},
+	}
+
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			c.resp.CalculateHeaderLength()
-			c.resp.CalculateBodyLength()
-			data := EncodeResp(c.resp)
-			decodeResp := DecodeResp(data)
-			assert.Equal(t, c.resp, decodeResp)
+			_, err := DecodeResp(c.data())
+			assert.Equal(t, c.wantErr, err)
 		})
 	}
 }
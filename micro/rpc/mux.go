@@ -0,0 +1,426 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+var (
+	errConnClosed = errors.New("micro: 连接已经关闭")
+	// errIdleClosed 连接空闲太久被关掉了, 只有 send 在登记请求之前发现连接已经关了才会返回它,
+	// 这个时候请求一定还没有发出去, 可以换一个连接重新发
+	errIdleClosed = errors.New("micro: 连接空闲太久已经关闭")
+	// errStreamUnsupported 服务端只支持 Version0, 没有流式调用
+	errStreamUnsupported = errors.New("rpc: 服务端的协议版本太老, 不支持流式调用")
+	// errHeartbeatTimeout 太久没有收到服务端的任何数据, 连接可能已经断了, 比如说中间的 NAT 把它丢掉了
+	errHeartbeatTimeout = errors.New("micro: 心跳超时, 连接已经关闭")
+)
+
+// muxConn 多路复用的连接
+// 所有请求共用一个 net.Conn, 写的时候加锁保证一个请求是完整写进去的,
+// 读由一个单独的 goroutine 负责, 根据 RequestID 把响应分发给对应的请求
+type muxConn struct {
+	conn net.Conn
+
+	writeMutex sync.Mutex
+
+	mutex sync.Mutex
+	// pending 等待响应的请求, RequestID -> 接收响应的 channel
+	pending map[uint32]chan *message.Response
+	// streams 流式调用, 流的 ID -> 流的状态
+	streams map[uint32]*muxStream
+	// oneways 正在写的 oneway 调用, 它们不在 pending 里面, 写完之前连接也不算空闲
+	oneways int
+	err     error
+
+	// done 连接关闭之后会被关掉
+	done chan struct{}
+
+	// idleTimeout 没有请求也没有流超过这个时间就关闭连接, 为 0 就不关闭
+	idleTimer *time.Timer
+	lastUsed  time.Time
+	// lastRecv 最后一次收到服务端数据的时间, 心跳用它判断连接是不是还活着
+	lastRecv time.Time
+
+	// version 发请求使用的协议版本, negotiate 为 true 的时候会根据服务端的响应升级
+	version uint8
+
+	muxOptions
+}
+
+// muxOptions 建立连接的时候从 Client 拿到的配置
+type muxOptions struct {
+	// idleTimeout 没有请求也没有流超过这个时间就关闭连接, 为 0 就不关闭
+	idleTimeout time.Duration
+	// heartbeat 超过这个时间没有收到服务端的数据就发一个心跳, 为 0 就不发
+	// heartbeatTimeout 超过这个时间没有收到服务端的数据就认为连接已经断了
+	heartbeat        time.Duration
+	heartbeatTimeout time.Duration
+	// version 一开始使用的协议版本
+	version   uint8
+	negotiate bool
+	// maxFrameSize 能接收的最大的帧, 为 0 就用 DefaultMaxFrameSize
+	maxFrameSize uint32
+}
+
+func newMuxConn(conn net.Conn, opts muxOptions) *muxConn {
+	now := time.Now()
+	m := &muxConn{
+		conn:       conn,
+		version:    opts.version,
+		pending:    make(map[uint32]chan *message.Response, 16),
+		streams:    make(map[uint32]*muxStream, 4),
+		done:       make(chan struct{}),
+		lastUsed:   now,
+		lastRecv:   now,
+		muxOptions: opts,
+	}
+	if opts.idleTimeout > 0 {
+		m.mutex.Lock()
+		m.idleTimer = time.AfterFunc(opts.idleTimeout, m.checkIdle)
+		m.mutex.Unlock()
+	}
+	if opts.heartbeat > 0 {
+		go m.heartbeatLoop()
+	}
+	go m.readLoop()
+	return m
+}
+
+// heartbeatLoop 连接上一段时间没有收到数据就发心跳, 太久没有收到数据就关掉连接
+// 心跳不算使用连接, 不会影响空闲关闭
+func (m *muxConn) heartbeatLoop() {
+	ticker := time.NewTicker(m.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		m.mutex.Lock()
+		silent := time.Since(m.lastRecv)
+		m.mutex.Unlock()
+		if silent >= m.heartbeatTimeout {
+			m.closeWithErr(errHeartbeatTimeout)
+			return
+		}
+		if silent >= m.heartbeat {
+			// 写失败了 write 会关掉连接, 下一轮就退出了
+			_ = m.writeReq(newPing(0))
+		}
+	}
+}
+
+// checkIdle 定时检查连接是不是空闲太久了
+func (m *muxConn) checkIdle() {
+	m.mutex.Lock()
+	if m.err != nil {
+		m.mutex.Unlock()
+		return
+	}
+	idle := time.Since(m.lastUsed)
+	if len(m.pending) > 0 || len(m.streams) > 0 || m.oneways > 0 || idle < m.idleTimeout {
+		wait := m.idleTimeout - idle
+		if wait <= 0 {
+			// 还有请求在等响应, 等它们结束之后再算
+			wait = m.idleTimeout
+		}
+		m.idleTimer.Reset(wait)
+		m.mutex.Unlock()
+		return
+	}
+	// 必须在同一把锁里面标记连接已经关闭, 不然 send 可能趁着解锁的间隙登记请求并且写出去,
+	// 调用方又会把 errIdleClosed 当成请求没有发出去重新发一次, 不幂等的调用就执行了两次
+	m.err = errIdleClosed
+	m.shutdown()
+}
+
+func (m *muxConn) readLoop() {
+	for {
+		data, err := readMsg(m.conn, m.maxFrameSize)
+		if err != nil {
+			m.closeWithErr(err)
+			return
+		}
+		resp, err := message.DecodeResp(data)
+		if err != nil {
+			// 帧已经乱了, 后面的数据也不可信
+			m.closeWithErr(err)
+			return
+		}
+		m.mutex.Lock()
+		m.lastRecv = time.Now()
+		if m.negotiate {
+			m.version = negotiateVersion(m.version, resp)
+		}
+		m.mutex.Unlock()
+		if resp.Flag&message.FlagStream != 0 {
+			m.dispatchStream(resp)
+			continue
+		}
+		m.mutex.Lock()
+		// 心跳的响应一般没有人等, 只有 upgrade 发的探测请求在等
+		ch, ok := m.pending[resp.RequestID]
+		delete(m.pending, resp.RequestID)
+		if resp.Flag&message.FlagPong == 0 {
+			m.lastUsed = time.Now()
+		}
+		m.mutex.Unlock()
+		// 找不到说明请求已经被取消了, 直接丢掉响应
+		if ok {
+			// ch 有一个缓冲, 这里不会阻塞
+			ch <- resp
+		}
+	}
+}
+
+// send 发送请求并等待响应, ctx 被取消的时候只会放弃这一个请求, 不会影响连接上的其他请求
+func (m *muxConn) send(ctx context.Context, req *message.Request) (*message.Response, error) {
+	// oneway 调用服务端不会响应, 发出去就算成功了
+	if isOneway(ctx) {
+		m.mutex.Lock()
+		if m.err != nil {
+			err := m.err
+			m.mutex.Unlock()
+			return nil, err
+		}
+		// 和 pending 一样, 在同一把锁里面登记, checkIdle 就不会在写之前把连接关掉
+		m.oneways++
+		m.lastUsed = time.Now()
+		m.mutex.Unlock()
+		err := m.writeReq(req)
+		m.mutex.Lock()
+		m.oneways--
+		m.mutex.Unlock()
+		return nil, err
+	}
+
+	ch := make(chan *message.Response, 1)
+	m.mutex.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mutex.Unlock()
+		return nil, err
+	}
+	m.pending[req.RequestID] = ch
+	m.lastUsed = time.Now()
+	m.mutex.Unlock()
+
+	if err := m.writeReq(req); err != nil {
+		m.remove(req.RequestID)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		m.remove(req.RequestID)
+		return nil, ctx.Err()
+	case <-m.done:
+		// readLoop 可能在关闭之前已经把响应放进去了
+		select {
+		case resp := <-ch:
+			return resp, nil
+		default:
+		}
+		err := m.closedErr()
+		if err == errIdleClosed {
+			// 请求已经登记过了, 可能已经发出去了, 不能让调用方当成没有发出去重试
+			err = errConnClosed
+		}
+		return nil, err
+	}
+}
+
+// muxStream 一个流在连接上的状态
+type muxStream struct {
+	// frames 服务端发过来还没有被 Recv 取走的帧, 最多 streamWindow 字节
+	frames *queue[*message.Response]
+	// window 还能发给服务端多少数据
+	window *flowWindow
+}
+
+func (m *muxConn) dispatchStream(resp *message.Response) {
+	m.mutex.Lock()
+	st, ok := m.streams[resp.RequestID]
+	if resp.Flag&message.FlagEndStream != 0 {
+		delete(m.streams, resp.RequestID)
+		m.lastUsed = time.Now()
+	}
+	m.mutex.Unlock()
+	// 找不到说明流已经被取消了
+	if !ok {
+		return
+	}
+	if resp.Flag&message.FlagWindowUpdate != 0 {
+		st.window.release(windowIncrement(resp.Data))
+		return
+	}
+	if resp.Flag&message.FlagEndStream != 0 {
+		// 服务端已经结束了, 再 Send 也没有意义
+		st.window.closeWithErr(io.EOF)
+	}
+	// 服务端不遵守流量控制的话 push 会失败, Recv 取完已经收到的帧之后会拿到错误, 然后取消这个流
+	_ = st.frames.push(resp, frameCost(resp.Flag, resp.Data))
+}
+
+// openStream 注册一个流, 之后收到的这个 ID 的帧都会放到返回的队列里面
+func (m *muxConn) openStream(id uint32) (*muxStream, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	st := &muxStream{
+		frames: newQueue[*message.Response](streamWindow),
+		window: newFlowWindow(),
+	}
+	m.streams[id] = st
+	m.lastUsed = time.Now()
+	return st, nil
+}
+
+func (m *muxConn) removeStream(id uint32) {
+	m.mutex.Lock()
+	delete(m.streams, id)
+	m.lastUsed = time.Now()
+	m.mutex.Unlock()
+}
+
+// upgrade 流式调用需要 Version1 的帧, 连接还在使用 Version0 的时候先发一个心跳完成协商
+// Version0 的心跳就是一个空的请求, 服务端返回什么都可以, 只要响应里面带上了它支持的版本
+func (m *muxConn) upgrade(ctx context.Context, id uint32) error {
+	if m.currentVersion() >= message.Version1 {
+		return nil
+	}
+	if m.negotiate {
+		if _, err := m.send(ctx, newPing(id)); err != nil {
+			return err
+		}
+		if m.currentVersion() >= message.Version1 {
+			return nil
+		}
+	}
+	return errStreamUnsupported
+}
+
+func (m *muxConn) currentVersion() uint8 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.version
+}
+
+// writeReq 用连接当前的协议版本编码请求, 再写进去
+func (m *muxConn) writeReq(req *message.Request) error {
+	data, err := encodeReq(req, m.currentVersion())
+	if err != nil {
+		return err
+	}
+	return m.write(data)
+}
+
+// encodeReq 按照 version 编码请求, Version0 没有 Flag, 心跳就变成一个空的请求
+func encodeReq(req *message.Request, version uint8) ([]byte, error) {
+	req.Version = version
+	if version == message.Version0 && req.Flag == message.FlagPing {
+		req.Flag = 0
+	}
+	req.CalculateHeaderLength()
+	return message.EncodeReq(req)
+}
+
+func (m *muxConn) write(data []byte) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+	_, err := m.conn.Write(data)
+	if err != nil {
+		// 可能只写了一半, 这个连接上的数据已经乱了, 只能关掉
+		m.closeWithErr(err)
+	}
+	return err
+}
+
+// newPing 心跳请求, Version0 的连接上是一个空的请求, 服务端会返回服务不存在的错误, 一样说明连接是好的
+func newPing(id uint32) *message.Request {
+	req := &message.Request{
+		RequestID: id,
+		Flag:      message.FlagPing,
+	}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	return req
+}
+
+// negotiateVersion 根据服务端的响应决定后面使用的协议版本, 只会升级不会降级
+func negotiateVersion(cur uint8, resp *message.Response) uint8 {
+	v := resp.Version
+	if resp.MaxVersion > v {
+		v = resp.MaxVersion
+	}
+	if v > message.MaxVersion {
+		v = message.MaxVersion
+	}
+	if v > cur {
+		return v
+	}
+	return cur
+}
+
+func (m *muxConn) remove(reqID uint32) {
+	m.mutex.Lock()
+	delete(m.pending, reqID)
+	m.mutex.Unlock()
+}
+
+// closed 连接是不是已经不能用了
+// 不能看 done, shutdown 是先设置 m.err 再关闭 done 的, 中间这段时间 send 已经会返回错误了
+func (m *muxConn) closed() bool {
+	return m.closedErr() != nil
+}
+
+func (m *muxConn) closedErr() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.err
+}
+
+func (m *muxConn) Close() error {
+	m.closeWithErr(errConnClosed)
+	return nil
+}
+
+func (m *muxConn) closeWithErr(err error) {
+	m.mutex.Lock()
+	if m.err != nil {
+		m.mutex.Unlock()
+		return
+	}
+	m.err = err
+	m.shutdown()
+}
+
+// shutdown 关闭连接, 调用的时候必须持有 m.mutex 并且已经设置好了 m.err, 返回之前会释放锁
+func (m *muxConn) shutdown() {
+	err := m.err
+	if m.idleTimer != nil {
+		m.idleTimer.Stop()
+	}
+	m.pending = make(map[uint32]chan *message.Response)
+	streams := m.streams
+	m.streams = make(map[uint32]*muxStream)
+	m.mutex.Unlock()
+	for _, st := range streams {
+		st.frames.closeWithErr(err)
+		st.window.closeWithErr(err)
+	}
+	close(m.done)
+	_ = m.conn.Close()
+}
@@ -0,0 +1,449 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_muxConn(t *testing.T) {
+	cases := []struct {
+		name string
+		// reply 服务端收到的请求, 返回要按照什么顺序响应
+		reply func(reqs []*message.Request) []*message.Request
+	}{
+		{
+			name: "in order",
+			reply: func(reqs []*message.Request) []*message.Request {
+				return reqs
+			},
+		},
+		{
+			// 响应乱序也能找到对应的请求
+			name: "reverse order",
+			reply: func(reqs []*message.Request) []*message.Request {
+				res := make([]*message.Request, 0, len(reqs))
+				for i := len(reqs) - 1; i >= 0; i-- {
+					res = append(res, reqs[i])
+				}
+				return res
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cli, srv := net.Pipe()
+			conn := newMuxConn(cli, muxOptions{negotiate: true})
+			defer func() {
+				_ = conn.Close()
+			}()
+			const n = 10
+			go func() {
+				reqs := make([]*message.Request, 0, n)
+				for i := 0; i < n; i++ {
+					data, err := ReadMsg(srv)
+					if err != nil {
+						return
+					}
+					req, err := message.DecodeReq(data)
+					if err != nil {
+						return
+					}
+					reqs = append(reqs, req)
+				}
+				for _, req := range c.reply(reqs) {
+					_, _ = srv.Write(message.EncodeResp(newTestResp(req)))
+				}
+			}()
+
+			var wg sync.WaitGroup
+			for i := 1; i <= n; i++ {
+				wg.Add(1)
+				go func(id uint32) {
+					defer wg.Done()
+					resp, err := conn.send(context.Background(), newTestReq(id))
+					require.NoError(t, err)
+					assert.Equal(t, id, resp.RequestID)
+					assert.Equal(t, strconv.Itoa(int(id)), string(resp.Data))
+				}(uint32(i))
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func Test_muxConn_Cancel(t *testing.T) {
+	cli, srv := net.Pipe()
+	conn := newMuxConn(cli, muxOptions{negotiate: true})
+	defer func() {
+		_ = conn.Close()
+	}()
+	go func() {
+		for {
+			data, err := ReadMsg(srv)
+			if err != nil {
+				return
+			}
+			req, err := message.DecodeReq(data)
+			if err != nil {
+				return
+			}
+			// 第一个请求不响应, 让它超时
+			if req.RequestID == 1 {
+				continue
+			}
+			_, _ = srv.Write(message.EncodeResp(newTestResp(req)))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := conn.send(ctx, newTestReq(1))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 一个请求超时了, 连接上的其他请求不受影响
+	resp, err := conn.send(context.Background(), newTestReq(2))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), resp.RequestID)
+	assert.False(t, conn.closed())
+}
+
+func Test_muxConn_Close(t *testing.T) {
+	cli, srv := net.Pipe()
+	conn := newMuxConn(cli, muxOptions{negotiate: true})
+	received := make(chan struct{})
+	go func() {
+		_, _ = ReadMsg(srv)
+		close(received)
+		// 服务端断开连接, 等待中的请求都要返回错误
+		_ = srv.Close()
+	}()
+
+	_, err := conn.send(context.Background(), newTestReq(1))
+	<-received
+	assert.Error(t, err)
+	assert.True(t, conn.closed())
+
+	_, err = conn.send(context.Background(), newTestReq(2))
+	assert.Error(t, err)
+}
+
+func Test_muxConn_idle(t *testing.T) {
+	cli, srv := net.Pipe()
+	conn := newMuxConn(cli, muxOptions{negotiate: true, idleTimeout: 20 * time.Millisecond})
+	received := make(chan struct{}, 1)
+	go func() {
+		for {
+			if _, err := ReadMsg(srv); err != nil {
+				return
+			}
+			received <- struct{}{}
+		}
+	}()
+
+	require.Eventually(t, conn.closed, time.Second, 10*time.Millisecond)
+	// 空闲关闭之后 send 在登记请求之前就发现了, 请求一定没有写出去, 调用方可以放心重试
+	_, err := conn.send(context.Background(), newTestReq(1))
+	assert.Equal(t, errIdleClosed, err)
+	select {
+	case <-received:
+		t.Fatal("请求不应该被写出去")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_muxConn_idleAfterSent(t *testing.T) {
+	cli, srv := net.Pipe()
+	conn := newMuxConn(cli, muxOptions{negotiate: true})
+	go func() {
+		_, _ = ReadMsg(srv)
+		// 请求已经写出去了, 这时候连接被关掉
+		conn.closeWithErr(errIdleClosed)
+	}()
+
+	// 已经登记过的请求不能返回 errIdleClosed, 不然调用方会重试, 服务端就执行了两次
+	_, err := conn.send(context.Background(), newTestReq(1))
+	assert.Equal(t, errConnClosed, err)
+}
+
+// Test_muxConn_closedBeforeDone shutdown 先设置错误再关闭 done, 中间这段时间连接也要算关闭了, 不然 getConn 会拿到同一个连接
+func Test_muxConn_closedBeforeDone(t *testing.T) {
+	cli, _ := net.Pipe()
+	conn := newMuxConn(cli, muxOptions{negotiate: true})
+	conn.mutex.Lock()
+	conn.err = errIdleClosed
+	conn.mutex.Unlock()
+	assert.True(t, conn.closed())
+
+	conn.mutex.Lock()
+	conn.shutdown()
+}
+
+// Test_muxConn_idleOneway 正在写的 oneway 调用不能被空闲关闭打断
+func Test_muxConn_idleOneway(t *testing.T) {
+	cli, srv := net.Pipe()
+	conn := newMuxConn(cli, muxOptions{negotiate: true, idleTimeout: time.Hour})
+	defer func() {
+		_ = conn.Close()
+	}()
+	errCh := make(chan error, 1)
+	go func() {
+		// net.Pipe 没有人读, 写会一直阻塞
+		_, err := conn.send(CtxWithOneway(context.Background()), newTestReq(1))
+		errCh <- err
+	}()
+	require.Eventually(t, func() bool {
+		conn.mutex.Lock()
+		defer conn.mutex.Unlock()
+		return conn.oneways == 1
+	}, time.Second, time.Millisecond)
+
+	conn.mutex.Lock()
+	conn.lastUsed = time.Now().Add(-2 * time.Hour)
+	conn.mutex.Unlock()
+	conn.checkIdle()
+	assert.False(t, conn.closed())
+
+	_, err := ReadMsg(srv)
+	require.NoError(t, err)
+	assert.NoError(t, <-errCh)
+}
+
+func Test_muxConn_negotiate(t *testing.T) {
+	cases := []struct {
+		name string
+		// serverVersion 服务端在 Version0 的响应里面带的版本字节, 老的服务端原样返回请求里面的 0
+		serverVersion uint8
+		negotiate     bool
+		version       uint8
+
+		wantVersions []uint8
+	}{
+		{
+			name:          "old server",
+			serverVersion: message.Version0,
+			negotiate:     true,
+			wantVersions:  []uint8{message.Version0, message.Version0},
+		},
+		{
+			name:          "new server",
+			serverVersion: message.Version1,
+			negotiate:     true,
+			wantVersions:  []uint8{message.Version0, message.Version1, message.Version1},
+		},
+		{
+			name:          "future server",
+			serverVersion: message.MaxVersion + 1,
+			negotiate:     true,
+			wantVersions:  []uint8{message.Version0, message.MaxVersion},
+		},
+		{
+			name:          "fixed version",
+			serverVersion: message.Version1,
+			version:       message.Version1,
+			wantVersions:  []uint8{message.Version1, message.Version1},
+		},
+		{
+			name:          "negotiation disabled",
+			serverVersion: message.Version1,
+			version:       message.Version0,
+			wantVersions:  []uint8{message.Version0, message.Version0},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cli, srv := net.Pipe()
+			conn := newMuxConn(cli, muxOptions{version: c.version, negotiate: c.negotiate})
+			defer func() {
+				_ = conn.Close()
+			}()
+			versions := make(chan uint8, len(c.wantVersions))
+			serverVersion := c.serverVersion
+			go func() {
+				for {
+					data, err := ReadMsg(srv)
+					if err != nil {
+						return
+					}
+					req, err := message.DecodeReq(data)
+					if err != nil {
+						return
+					}
+					versions <- req.Version
+					resp := newTestResp(req)
+					resp.Version = req.Version
+					resp.MaxVersion = serverVersion
+					resp.CalculateHeaderLength()
+					_, _ = srv.Write(message.EncodeResp(resp))
+				}
+			}()
+			for i, want := range c.wantVersions {
+				_, err := conn.send(context.Background(), newTestReq(uint32(i+1)))
+				require.NoError(t, err)
+				assert.Equal(t, want, <-versions)
+			}
+		})
+	}
+}
+
+func Test_muxConn_upgrade(t *testing.T) {
+	cases := []struct {
+		name          string
+		serverVersion uint8
+		negotiate     bool
+		version       uint8
+
+		wantVersion uint8
+		wantErr     error
+	}{
+		{
+			name:          "old server",
+			serverVersion: message.Version0,
+			negotiate:     true,
+			wantVersion:   message.Version0,
+			wantErr:       errStreamUnsupported,
+		},
+		{
+			name:          "new server",
+			serverVersion: message.Version1,
+			negotiate:     true,
+			wantVersion:   message.Version1,
+		},
+		{
+			name:          "negotiation disabled",
+			serverVersion: message.Version1,
+			version:       message.Version0,
+			wantVersion:   message.Version0,
+			wantErr:       errStreamUnsupported,
+		},
+		{
+			name:        "already upgraded",
+			version:     message.Version1,
+			wantVersion: message.Version1,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cli, srv := net.Pipe()
+			conn := newMuxConn(cli, muxOptions{version: c.version, negotiate: c.negotiate})
+			defer func() {
+				_ = conn.Close()
+			}()
+			serverVersion := c.serverVersion
+			go func() {
+				for {
+					data, err := ReadMsg(srv)
+					if err != nil {
+						return
+					}
+					req, err := message.DecodeReq(data)
+					if err != nil {
+						return
+					}
+					// Version0 的心跳是一个空的请求, 老的服务端会返回服务不存在
+					resp := newTestResp(req)
+					resp.Error = []byte("服务不存在")
+					resp.MaxVersion = serverVersion
+					resp.CalculateHeaderLength()
+					_, _ = srv.Write(message.EncodeResp(resp))
+				}
+			}()
+			err := conn.upgrade(context.Background(), 1)
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantVersion, conn.currentVersion())
+		})
+	}
+}
+
+func Test_muxConn_heartbeat(t *testing.T) {
+	cases := []struct {
+		name string
+		// version 连接使用的协议版本, Version0 的心跳是一个空的请求
+		version uint8
+		// pong 服务端是不是响应心跳
+		pong bool
+
+		wantErr error
+	}{
+		{
+			name:    "alive",
+			version: message.Version1,
+			pong:    true,
+		},
+		{
+			name:    "alive v0",
+			version: message.Version0,
+			pong:    true,
+		},
+		{
+			// 服务端不响应, 连接可能已经断了
+			name:    "dead",
+			wantErr: errHeartbeatTimeout,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cli, srv := net.Pipe()
+			conn := newMuxConn(cli, muxOptions{heartbeat: 10 * time.Millisecond, heartbeatTimeout: 50 * time.Millisecond, version: c.version})
+			defer func() {
+				_ = conn.Close()
+			}()
+			respond := c.pong
+			go func() {
+				for {
+					data, err := ReadMsg(srv)
+					if err != nil {
+						return
+					}
+					req, err := message.DecodeReq(data)
+					if err != nil {
+						return
+					}
+					if respond && (req.Flag&message.FlagPing != 0 || req.ServiceName == "") {
+						_, _ = srv.Write(message.EncodeResp(pong(req)))
+					}
+				}
+			}()
+			time.Sleep(150 * time.Millisecond)
+			if c.wantErr == nil {
+				assert.False(t, conn.closed())
+				return
+			}
+			assert.True(t, conn.closed())
+			assert.Equal(t, c.wantErr, conn.closedErr())
+		})
+	}
+}
+
+func newTestReq(id uint32) *message.Request {
+	req := &message.Request{
+		RequestID:   id,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Data:        []byte(strconv.Itoa(int(id))),
+	}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	return req
+}
+
+func newTestResp(req *message.Request) *message.Response {
+	resp := &message.Response{
+		RequestID: req.RequestID,
+		Data:      req.Data,
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	return resp
+}
@@ -0,0 +1,187 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// ReflectionServiceName 内置的反射服务的名字, 用 ServerWithReflection 开启
+// 它本身也是一个普通的服务, 用 json 或者 msgpack 调用
+const ReflectionServiceName = "rpc.Reflection"
+
+// 方法的类型
+const (
+	MethodUnary = "unary"
+	// MethodServerStream 服务端流, 客户端发一个请求
+	MethodServerStream = "server_stream"
+	// MethodStream 客户端流或者双向流, 服务端的方法签名区分不出来
+	MethodStream = "stream"
+)
+
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc
+}
+
+type MethodDesc struct {
+	Name string
+	Kind string
+	// Request 和 Response 只有用 RegisterService 注册的服务才有, 生成的代码注册的服务只有方法名
+	Request  *TypeSchema `json:",omitempty"`
+	Response *TypeSchema `json:",omitempty"`
+}
+
+// TypeSchema 请求和响应的类型, 足够用来手写 JSON 的程度
+type TypeSchema struct {
+	// Name 类型的名字, 匿名类型没有名字
+	Name string `json:",omitempty"`
+	// Kind reflect.Kind 的名字, 比如说 struct, string, int64, slice, map, ptr
+	Kind   string
+	Fields []FieldSchema `json:",omitempty"`
+	// Elem 切片, 数组, 指针和 map 的元素类型, Key 是 map 的键的类型
+	Elem *TypeSchema `json:",omitempty"`
+	Key  *TypeSchema `json:",omitempty"`
+}
+
+type FieldSchema struct {
+	Name string
+	// Tag 字段原本的标签, 里面有 json 之类的名字
+	Tag  string `json:",omitempty"`
+	Type *TypeSchema
+}
+
+type ListServicesReq struct{}
+
+type ListServicesResp struct {
+	Services []string
+}
+
+type DescribeServiceReq struct {
+	Name string
+}
+
+// ReflectionClient 反射服务的客户端, 用 Client.InitService 初始化
+type ReflectionClient struct {
+	ListServices    func(ctx context.Context, req *ListServicesReq) (*ListServicesResp, error)
+	DescribeService func(ctx context.Context, req *DescribeServiceReq) (*ServiceDesc, error)
+}
+
+func (r *ReflectionClient) Name() string {
+	return ReflectionServiceName
+}
+
+// reflectionService 服务端的反射服务, 调用的时候才去读注册的服务, 所以在它之后注册的服务也能看到
+type reflectionService struct {
+	s *Server
+}
+
+func (r *reflectionService) Name() string {
+	return ReflectionServiceName
+}
+
+func (r *reflectionService) ListServices(ctx context.Context, req *ListServicesReq) (*ListServicesResp, error) {
+	names := make([]string, 0, len(r.s.services))
+	for name := range r.s.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &ListServicesResp{Services: names}, nil
+}
+
+func (r *reflectionService) DescribeService(ctx context.Context, req *DescribeServiceReq) (*ServiceDesc, error) {
+	service, ok := r.s.services[req.Name]
+	if !ok {
+		return nil, NewStatus(CodeNotFound, "rpc: 你要调用的服务不存在")
+	}
+	return &ServiceDesc{Name: req.Name, Methods: service.describe()}, nil
+}
+
+func (s *handlerStub) describe() []MethodDesc {
+	methods := make([]MethodDesc, 0, len(s.handlers))
+	for name := range s.handlers {
+		methods = append(methods, MethodDesc{Name: name, Kind: MethodUnary})
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Name < methods[j].Name
+	})
+	return methods
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// describe 和 invoke 判断方法的规则一样, 不能调用的方法不会列出来
+// reflect 返回的方法已经按照名字排好序了
+func (s *reflectionStub) describe() []MethodDesc {
+	typ := s.value.Type()
+	methods := make([]MethodDesc, 0, typ.NumMethod())
+	for i := 0; i < typ.NumMethod(); i++ {
+		method := typ.Method(i)
+		// 第一个参数是接收器
+		mt := method.Type
+		if mt.NumIn() < 2 || mt.In(1) != contextType {
+			continue
+		}
+		desc := MethodDesc{Name: method.Name}
+		switch {
+		case mt.NumIn() == 3 && mt.NumOut() == 2 && mt.Out(1) == errorType &&
+			mt.In(2).Kind() == reflect.Pointer && mt.Out(0).Kind() == reflect.Pointer && !isServerStream(mt.In(2)):
+			desc.Kind = MethodUnary
+			desc.Request = newTypeSchema(mt.In(2).Elem())
+			desc.Response = newTypeSchema(mt.Out(0).Elem())
+		case mt.NumOut() == 1 && mt.Out(0) == errorType && isServerStream(mt.In(mt.NumIn()-1)) &&
+			(mt.NumIn() == 3 || mt.NumIn() == 4 && mt.In(2).Kind() == reflect.Pointer):
+			desc.Kind = MethodStream
+			if mt.NumIn() == 4 {
+				desc.Kind = MethodServerStream
+			}
+			// ServerStream[Req, Resp] 的 Recv 返回 *Req, Send 接收 *Resp
+			stream := mt.In(mt.NumIn() - 1)
+			recv, _ := stream.MethodByName("Recv")
+			send, _ := stream.MethodByName("Send")
+			desc.Request = newTypeSchema(recv.Type.Out(0).Elem())
+			desc.Response = newTypeSchema(send.Type.In(1).Elem())
+		default:
+			continue
+		}
+		methods = append(methods, desc)
+	}
+	return methods
+}
+
+func newTypeSchema(typ reflect.Type) *TypeSchema {
+	return typeSchema(typ, make(map[reflect.Type]bool, 4))
+}
+
+// typeSchema visiting 是正在展开的结构体, 递归的类型第二次出现的时候只给名字
+func typeSchema(typ reflect.Type, visiting map[reflect.Type]bool) *TypeSchema {
+	schema := &TypeSchema{Name: typ.Name(), Kind: typ.Kind().String()}
+	switch typ.Kind() {
+	case reflect.Struct:
+		if visiting[typ] {
+			return schema
+		}
+		visiting[typ] = true
+		defer delete(visiting, typ)
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			schema.Fields = append(schema.Fields, FieldSchema{
+				Name: field.Name,
+				Tag:  string(field.Tag),
+				Type: typeSchema(field.Type, visiting),
+			})
+		}
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		schema.Elem = typeSchema(typ.Elem(), visiting)
+	case reflect.Map:
+		schema.Key = typeSchema(typ.Key(), visiting)
+		schema.Elem = typeSchema(typ.Elem(), visiting)
+	}
+	return schema
+}
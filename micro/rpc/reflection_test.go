@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReflection(t *testing.T) {
+	memory := transport.NewMemory()
+	lis, err := memory.Listen("reflection")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	server := NewServer(ServerWithReflection())
+	server.RegisterService(&UserServiceServer{})
+	server.RegisterService(&StreamServiceServer{})
+	server.RegisterHandlers("order-service", map[string]MethodHandler{
+		"Get":    nil,
+		"Cancel": nil,
+	})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+
+	client, err := NewClient("reflection", ClientWithTransport(memory))
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	rc := &ReflectionClient{}
+	require.NoError(t, client.InitService(rc))
+
+	list, err := rc.ListServices(context.Background(), &ListServicesReq{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order-service", ReflectionServiceName, "stream-service", "user-service"}, list.Services)
+
+	intSchema := &TypeSchema{Name: "int", Kind: "int"}
+	numSchema := &TypeSchema{Name: "Num", Kind: "struct", Fields: []FieldSchema{{Name: "Val", Type: intSchema}}}
+	watchReqSchema := &TypeSchema{Name: "WatchReq", Kind: "struct", Fields: []FieldSchema{
+		{Name: "Count", Type: intSchema},
+		{Name: "Fail", Type: &TypeSchema{Name: "bool", Kind: "bool"}},
+	}}
+	eventSchema := &TypeSchema{Name: "Event", Kind: "struct", Fields: []FieldSchema{{Name: "ID", Type: intSchema}}}
+	cases := []struct {
+		name    string
+		service string
+
+		wantDesc *ServiceDesc
+		wantErr  error
+	}{
+		{
+			name:    "stream service",
+			service: "stream-service",
+			wantDesc: &ServiceDesc{
+				Name: "stream-service",
+				Methods: []MethodDesc{
+					{Name: "Block", Kind: MethodServerStream, Request: watchReqSchema, Response: eventSchema},
+					{Name: "Echo", Kind: MethodStream, Request: numSchema, Response: numSchema},
+					{Name: "Sum", Kind: MethodStream, Request: numSchema, Response: numSchema},
+					{Name: "Unary", Kind: MethodUnary, Request: numSchema, Response: numSchema},
+					{Name: "Watch", Kind: MethodServerStream, Request: watchReqSchema, Response: eventSchema},
+				},
+			},
+		},
+		{
+			// 生成的代码注册的服务不知道类型
+			name:    "handlers",
+			service: "order-service",
+			wantDesc: &ServiceDesc{
+				Name: "order-service",
+				Methods: []MethodDesc{
+					{Name: "Cancel", Kind: MethodUnary},
+					{Name: "Get", Kind: MethodUnary},
+				},
+			},
+		},
+		{
+			name:    "not found",
+			service: "pay-service",
+			wantErr: NewStatus(CodeNotFound, "rpc: 你要调用的服务不存在"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			desc, err := rc.DescribeService(context.Background(), &DescribeServiceReq{Name: c.service})
+			assert.Equal(t, c.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.wantDesc, desc)
+		})
+	}
+}
+
+type treeNode struct {
+	Val      int `json:"val"`
+	Children []*treeNode
+	Attrs    map[string][2]byte
+	parent   *treeNode
+}
+
+func Test_newTypeSchema(t *testing.T) {
+	schema := newTypeSchema(typeOf[treeNode]())
+	// 递归的类型第二次出现的时候只有名字
+	node := &TypeSchema{Name: "treeNode", Kind: "struct"}
+	assert.Equal(t, &TypeSchema{
+		Name: "treeNode",
+		Kind: "struct",
+		Fields: []FieldSchema{
+			{Name: "Val", Tag: `json:"val"`, Type: &TypeSchema{Name: "int", Kind: "int"}},
+			{Name: "Children", Type: &TypeSchema{Kind: "slice", Elem: &TypeSchema{Kind: "ptr", Elem: node}}},
+			{Name: "Attrs", Type: &TypeSchema{
+				Kind: "map",
+				Key:  &TypeSchema{Name: "string", Kind: "string"},
+				Elem: &TypeSchema{Kind: "array", Elem: &TypeSchema{Name: "uint8", Kind: "uint8"}},
+			}},
+		},
+	}, schema)
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
@@ -0,0 +1,20 @@
+package msgpack
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Serializer MessagePack, 和 json 一样不需要生成代码, 但是更紧凑, 编解码也更快
+type Serializer struct{}
+
+func (s *Serializer) Code() uint8 {
+	return 3
+}
+
+func (s *Serializer) Encode(val any) ([]byte, error) {
+	return msgpack.Marshal(val)
+}
+
+func (s *Serializer) Decode(data []byte, val any) error {
+	return msgpack.Unmarshal(data, val)
+}
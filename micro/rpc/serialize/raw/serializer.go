@@ -0,0 +1,41 @@
+package raw
+
+import (
+	"errors"
+)
+
+var errNotBytes = errors.New("micro: 必须是 []byte 或者 *[]byte")
+
+// Serializer 直接传输字节, 不做任何编码, 也不复制数据
+// 适合业务自己已经编码好的数据, 比如说转发请求的网关
+// 方法的参数和返回值都应该是 *[]byte, 解码出来的切片和收到的数据共用底层数组, 要长期持有的话自己复制一份
+type Serializer struct{}
+
+func (s *Serializer) Code() uint8 {
+	return 4
+}
+
+// Encode val 可以是 []byte 或者 *[]byte
+func (s *Serializer) Encode(val any) ([]byte, error) {
+	switch v := val.(type) {
+	case []byte:
+		return v, nil
+	case *[]byte:
+		if v == nil {
+			return nil, nil
+		}
+		return *v, nil
+	default:
+		return nil, errNotBytes
+	}
+}
+
+// Decode val 必须是 *[]byte
+func (s *Serializer) Decode(data []byte, val any) error {
+	v, ok := val.(*[]byte)
+	if !ok || v == nil {
+		return errNotBytes
+	}
+	*v = data
+	return nil
+}
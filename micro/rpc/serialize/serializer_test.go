@@ -0,0 +1,163 @@
+package serialize_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/proto/gen"
+	"github.com/startdusk/go-libs/micro/rpc/serialize"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/msgpack"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/proto"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/raw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+type User struct {
+	ID     int64
+	Name   string
+	Tags   []string
+	Extra  map[string]string
+	Avatar []byte
+}
+
+// Test_Serializer 所有的序列化协议, 编码之后再解码应该得到一样的数据
+func Test_Serializer(t *testing.T) {
+	user := &User{
+		ID:     123,
+		Name:   "Tom",
+		Tags:   []string{"a", "b"},
+		Extra:  map[string]string{"k": "v"},
+		Avatar: []byte{0, 1, 0xff},
+	}
+	bs := []byte("hello\x00world")
+	cases := []struct {
+		name       string
+		serializer serialize.Serializer
+		val        any
+		// newVal 解码用的空值
+		newVal func() any
+		equal  func(t *testing.T, want, got any)
+	}{
+		{
+			name:       "json",
+			serializer: &json.Serializer{},
+			val:        user,
+			newVal:     func() any { return &User{} },
+		},
+		{
+			name:       "json zero value",
+			serializer: &json.Serializer{},
+			val:        &User{},
+			newVal:     func() any { return &User{} },
+		},
+		{
+			name:       "msgpack",
+			serializer: &msgpack.Serializer{},
+			val:        user,
+			newVal:     func() any { return &User{} },
+		},
+		{
+			name:       "msgpack zero value",
+			serializer: &msgpack.Serializer{},
+			val:        &User{},
+			newVal:     func() any { return &User{} },
+		},
+		{
+			name:       "proto",
+			serializer: &proto.Serializer{},
+			val:        &gen.GetByIDResp{User: &gen.User{Id: 123, Name: "Tom"}},
+			newVal:     func() any { return &gen.GetByIDResp{} },
+			equal: func(t *testing.T, want, got any) {
+				assert.True(t, protobuf.Equal(want.(protobuf.Message), got.(protobuf.Message)))
+			},
+		},
+		{
+			name:       "proto zero value",
+			serializer: &proto.Serializer{},
+			val:        &gen.GetByIDResp{},
+			newVal:     func() any { return &gen.GetByIDResp{} },
+			equal: func(t *testing.T, want, got any) {
+				assert.True(t, protobuf.Equal(want.(protobuf.Message), got.(protobuf.Message)))
+			},
+		},
+		{
+			name:       "raw",
+			serializer: &raw.Serializer{},
+			val:        &bs,
+			newVal:     func() any { return new([]byte) },
+		},
+		{
+			name:       "raw slice",
+			serializer: &raw.Serializer{},
+			val:        bs,
+			newVal:     func() any { return new([]byte) },
+			equal: func(t *testing.T, want, got any) {
+				assert.Equal(t, want, *got.(*[]byte))
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := c.serializer.Encode(c.val)
+			require.NoError(t, err)
+			got := c.newVal()
+			require.NoError(t, c.serializer.Decode(data, got))
+			if c.equal != nil {
+				c.equal(t, c.val, got)
+				return
+			}
+			assert.Equal(t, c.val, got)
+		})
+	}
+}
+
+func Test_Serializer_invalid(t *testing.T) {
+	cases := []struct {
+		name       string
+		serializer serialize.Serializer
+		val        any
+	}{
+		{
+			name:       "proto not message",
+			serializer: &proto.Serializer{},
+			val:        &User{},
+		},
+		{
+			name:       "raw not bytes",
+			serializer: &raw.Serializer{},
+			val:        &User{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := c.serializer.Encode(c.val)
+			assert.Error(t, err)
+			assert.Error(t, c.serializer.Decode([]byte{}, c.val))
+		})
+	}
+}
+
+// Test_Serializer_Code 服务端靠 Code 找到序列化协议, 不能重复
+func Test_Serializer_Code(t *testing.T) {
+	codes := make(map[uint8]string)
+	for _, s := range []serialize.Serializer{&json.Serializer{}, &proto.Serializer{}, &msgpack.Serializer{}, &raw.Serializer{}} {
+		name, ok := codes[s.Code()]
+		assert.False(t, ok, "%T 和 %s 的 Code 重复了", s, name)
+		codes[s.Code()] = fmt.Sprintf("%T", s)
+	}
+}
+
+// Test_raw_zeroCopy 解码不复制数据
+func Test_raw_zeroCopy(t *testing.T) {
+	s := &raw.Serializer{}
+	data := []byte("hello")
+	var got []byte
+	require.NoError(t, s.Decode(data, &got))
+	data[0] = 'H'
+	assert.Equal(t, "Hello", string(got))
+}
@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/startdusk/go-libs/micro/rpc/serialize"
+)
+
+const unsupportedSerializerMsg = "micro: 不支持的序列化协议"
+
+// unsupportedSerializer 服务端不支持请求使用的序列化协议, Details 里面是服务端支持的协议的 Code, 客户端可以据此换一个
+func unsupportedSerializer(serializers map[uint8]serialize.Serializer) *Status {
+	codes := make([]byte, 0, len(serializers))
+	for code := range serializers {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	return NewStatus(CodeUnimplemented, unsupportedSerializerMsg).WithDetails(codes)
+}
+
+// SupportedSerializers 服务端因为不支持请求的序列化协议而拒绝了请求的时候, 返回服务端支持的协议的 Code
+// 老版本的服务端不会告诉客户端它支持什么, 这个时候 ok 是 false
+func SupportedSerializers(err error) (codes []uint8, ok bool) {
+	var st *Status
+	if !errors.As(err, &st) || st.Code != CodeUnimplemented ||
+		st.Message != unsupportedSerializerMsg || len(st.Details) == 0 {
+		return nil, false
+	}
+	return st.Details, true
+}
+
+// serializerSet 一个服务可以使用的序列化协议, 按照优先级排列
+// 一开始用第一个, 服务端不支持的话, 换成服务端支持的优先级最高的那个, 之后的调用都用它
+type serializerSet struct {
+	candidates []serialize.Serializer
+
+	mutex   sync.RWMutex
+	current serialize.Serializer
+}
+
+func newSerializerSet(s serialize.Serializer, fallbacks ...serialize.Serializer) *serializerSet {
+	return &serializerSet{
+		candidates: append([]serialize.Serializer{s}, fallbacks...),
+		current:    s,
+	}
+}
+
+func (s *serializerSet) get() serialize.Serializer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current
+}
+
+// fallback used 被服务端拒绝了, 根据服务端支持的协议换一个, 没有可以换的就返回 false
+func (s *serializerSet) fallback(used serialize.Serializer, err error) (serialize.Serializer, bool) {
+	if len(s.candidates) == 1 {
+		return nil, false
+	}
+	codes, ok := SupportedSerializers(err)
+	if !ok {
+		return nil, false
+	}
+	for _, candidate := range s.candidates {
+		if candidate.Code() == used.Code() {
+			continue
+		}
+		for _, code := range codes {
+			if candidate.Code() == code {
+				s.mutex.Lock()
+				s.current = candidate
+				s.mutex.Unlock()
+				return candidate, true
+			}
+		}
+	}
+	return nil, false
+}
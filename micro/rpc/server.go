@@ -2,51 +2,214 @@ package rpc
 
 import (
 	"context"
-	"errors"
+	"io"
 	"net"
 	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/rpc/compress"
 	"github.com/startdusk/go-libs/micro/rpc/message"
 
 	"github.com/startdusk/go-libs/micro/rpc/serialize"
 	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
+	"github.com/startdusk/go-libs/micro/rpc/transport"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Server struct {
-	services    map[string]reflectionStub
+	services    map[string]stub
 	serializers map[uint8]serialize.Serializer // 客户端一般只有一种序列化协议，不同的客户端可以选不同的序列化协议
+	compressors map[uint8]compress.Compressor  // 和序列化协议一样, 不同的客户端可以选不同的压缩算法
+
+	interceptors []Interceptor
+	// handler 套上了 interceptors 的 Invoke
+	handler Handler
+	// streamInterceptors 流式调用不经过 interceptors, 只经过它
+	streamInterceptors []StreamInterceptor
+
+	// workers 限制同时处理的普通调用和 oneway 调用的数量, 满了之后请求在每个连接自己的 backlog 里面排队
+	workers chan struct{}
+	// maxStreams 每个连接上同时打开的流的上限, 流不占 workers, 不限制的话一个客户端就能打开无数个流
+	maxStreams int
+	// backlog 每个连接最多有多少个请求在排队, 再多就直接返回 ErrOverloaded
+	// 读连接的 goroutine 不会因为 workers 满了而阻塞, 这样心跳和流式调用的帧还能及时处理
+	backlog int
+
+	// transport 为 nil 的时候按照 Start 传入的 network 监听
+	transport transport.Transport
+
+	// idleTimeout 连接上超过这个时间没有收到任何数据, 也没有正在处理的请求, 就关掉它, 为 0 就不关
+	idleTimeout time.Duration
+
+	// maxFrameSize 能接收的最大的请求帧, 超过了直接关闭连接
+	maxFrameSize uint32
+
+	reflection bool
+
+	// health 健康检查的状态, 不管有没有开启健康检查服务都可以设置
+	health       *healthService
+	healthServer bool
+}
+
+type ServerOption func(s *Server)
+
+// ServerWithInterceptors 第一个 Interceptor 在最外层
+func ServerWithInterceptors(interceptors ...Interceptor) ServerOption {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// ServerWithStreamInterceptors 流式调用使用的拦截器, 第一个在最外层
+func ServerWithStreamInterceptors(interceptors ...StreamInterceptor) ServerOption {
+	return func(s *Server) {
+		s.streamInterceptors = append(s.streamInterceptors, interceptors...)
+	}
 }
 
-func NewServer() *Server {
+// ServerWithMaxConcurrency 同时处理的请求数量上限, 默认是 1024, 流式调用不算在里面, n <= 0 的时候忽略
+func ServerWithMaxConcurrency(n int) ServerOption {
+	return func(s *Server) {
+		if n > 0 {
+			s.workers = make(chan struct{}, n)
+		}
+	}
+}
+
+// ServerWithBacklog 同时处理的请求满了之后, 每个连接最多有 n 个请求排队, 默认是 128, n <= 0 的时候忽略
+// 排队的也满了, 新的请求直接返回 ErrOverloaded, oneway 调用没办法告诉客户端, 只能停下来等排队的位置
+func ServerWithBacklog(n int) ServerOption {
+	return func(s *Server) {
+		if n > 0 {
+			s.backlog = n
+		}
+	}
+}
+
+// ServerWithMaxStreams 每个连接上同时打开的流的数量上限, 默认是 100, n <= 0 的时候忽略
+// 超过了新打开的流直接以 CodeResourceExhausted 结束
+func ServerWithMaxStreams(n int) ServerOption {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxStreams = n
+		}
+	}
+}
+
+// ServerWithTransport 设置了之后 Start 的 network 参数会被忽略
+func ServerWithTransport(t transport.Transport) ServerOption {
+	return func(s *Server) {
+		s.transport = t
+	}
+}
+
+// ServerWithIdleTimeout 关闭空闲的连接, 客户端的心跳也算收到了数据
+// 客户端死掉了又没有断开连接的时候, 比如说中间的 NAT 把连接丢掉了, 服务端靠它回收连接
+func ServerWithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idleTimeout = timeout
+	}
+}
+
+// ServerWithMaxFrameSize 能接收的最大的帧, 默认是 DefaultMaxFrameSize
+// 超过的帧在分配内存之前就会被拒绝, 连接会被关掉, 客户端收到的是连接断开的错误
+func ServerWithMaxFrameSize(size uint32) ServerOption {
+	return func(s *Server) {
+		s.maxFrameSize = size
+	}
+}
+
+// ServerWithReflection 注册内置的反射服务 ReflectionServiceName, 可以列出服务, 方法和请求响应的结构
+// 调试工具 micro/cmd/rpcurl 依赖它
+func ServerWithReflection() ServerOption {
+	return func(s *Server) {
+		s.reflection = true
+	}
+}
+
+// ServerWithHealth 注册内置的健康检查服务 HealthServiceName, 状态用 Server.SetServingStatus 修改
+func ServerWithHealth() ServerOption {
+	return func(s *Server) {
+		s.healthServer = true
+	}
+}
+
+func NewServer(opts ...ServerOption) *Server {
 	s := &Server{
-		services:    make(map[string]reflectionStub, 16),     // 16是预估值
-		serializers: make(map[uint8]serialize.Serializer, 4), // 4是预估值, 4种序列化协议顶天了
+		services:     make(map[string]stub, 16),               // 16是预估值
+		serializers:  make(map[uint8]serialize.Serializer, 4), // 4是预估值, 4种序列化协议顶天了
+		compressors:  make(map[uint8]compress.Compressor, 4),
+		workers:      make(chan struct{}, 1024),
+		backlog:      128,
+		maxStreams:   100,
+		maxFrameSize: DefaultMaxFrameSize,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.handler = chain(s.Invoke, s.interceptors)
 	s.RegisterSerializer(&json.Serializer{})
+	s.health = newHealthService(s)
+	if s.reflection {
+		s.RegisterService(&reflectionService{s: s})
+	}
+	if s.healthServer {
+		s.RegisterService(s.health)
+	}
 	return s
 }
 
+// SetServingStatus 修改健康状态, service 为空代表整个服务器, 正在 Watch 的客户端会马上收到新的状态
+// 比如说依赖的数据库连不上了, 可以先设置成 registry.HealthStatusNotServing, 恢复之后再改回来
+func (s *Server) SetServingStatus(service string, status registry.HealthStatus) {
+	s.health.set(service, status)
+}
+
 func (s *Server) RegisterSerializer(serializer serialize.Serializer) {
 	s.serializers[serializer.Code()] = serializer
 }
 
+// RegisterCompressor 注册压缩算法, 服务端会用请求使用的算法来压缩响应
+func (s *Server) RegisterCompressor(compressor compress.Compressor) {
+	s.compressors[compressor.Code()] = compressor
+}
+
 func (s *Server) RegisterService(service Service) {
-	s.services[service.Name()] = reflectionStub{
+	s.services[service.Name()] = &reflectionStub{
 		s:           service,
 		value:       reflect.ValueOf(service),
 		serializers: s.serializers,
 	}
 }
 
+// RegisterHandlers 注册生成的代码, 方法名 -> MethodHandler, 调用的时候不需要反射
+func (s *Server) RegisterHandlers(name string, handlers map[string]MethodHandler) {
+	s.services[name] = &handlerStub{
+		handlers:    handlers,
+		serializers: s.serializers,
+	}
+}
+
 func (s *Server) Start(network string, addr string) error {
-	lis, err := net.Listen(network, addr)
+	var lis net.Listener
+	var err error
+	if s.transport != nil {
+		lis, err = s.transport.Listen(addr)
+	} else {
+		lis, err = net.Listen(network, addr)
+	}
 	if err != nil {
 		// 比较常见的错误就是端口被占用
 		return err
 	}
+	return s.Serve(lis)
+}
 
+// Serve 在 lis 上接收连接, 直到 lis 被关闭
+func (s *Server) Serve(lis net.Listener) error {
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
@@ -61,43 +224,417 @@ func (s *Server) Start(network string, addr string) error {
 	}
 }
 
+// handleConn 同一个连接上的请求是并发处理的, 客户端靠 RequestID 把响应和请求对应起来
 func (s *Server) handleConn(conn net.Conn) error {
+	sc := newServerConn(conn, s.idleTimeout)
+	// 连接断开了, 还在处理的流都要取消
+	defer sc.close()
+	backlog := make(chan *message.Request, s.backlog)
+	defer close(backlog)
+	go s.dispatch(sc, backlog)
 	for {
-		data, err := ReadMsg(conn)
+		data, err := readMsg(conn, s.maxFrameSize)
 		if err != nil {
 			return err
 		}
 
-		// 还原调用信息
-		req := message.DecodeReq(data)
-		ctx := context.Background()
-		cancel := func() {}
-		if len(req.Meta) > 0 {
-			if deadlineStr, ok := req.Meta["deadline"]; ok {
-				if deadline, err := strconv.ParseInt(deadlineStr, 10, 64); err == nil {
-					ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(deadline))
-				}
-			}
-			if oneway, ok := req.Meta["one-way"]; ok && oneway == "true" {
-				ctx = CtxWithOneway(ctx)
+		// 还原调用信息, 解不出来说明数据已经乱了, 只能断开连接
+		req, err := message.DecodeReq(data)
+		if err != nil {
+			return err
+		}
+		sc.received()
+		if sc.peer == nil {
+			// 读到了完整的帧, TLS 的握手肯定已经完成了
+			sc.peer = newPeer(conn)
+		}
+		if req.Flag&message.FlagPing != 0 {
+			_ = sc.write(pong(req))
+			continue
+		}
+		if req.Flag&message.FlagStream != 0 {
+			// 同一个流的帧要按照顺序处理, 所以不能放到 goroutine 里面
+			s.handleStreamFrame(sc, req)
+			continue
+		}
+		sc.begin()
+		select {
+		case backlog <- req:
+			continue
+		default:
+		}
+		if isOnewayReq(req) {
+			// oneway 调用客户端已经当成成功了, 丢掉就再也没人知道, 只能停止读连接等排队的位置,
+			// 心跳和流式调用的帧会晚一点处理, 客户端也会因为写不进去而慢下来
+			select {
+			case backlog <- req:
+			case <-sc.ctx.Done():
+				sc.end()
 			}
+			continue
+		}
+		// 排队的请求也满了, 不能阻塞在这里, 不然心跳和流式调用的帧都读不到了
+		sc.end()
+		_ = sc.write(overloaded(req))
+	}
+}
+
+// dispatch 按照顺序取出排队的请求, 占到位置之后再处理
+func (s *Server) dispatch(sc *serverConn, backlog <-chan *message.Request) {
+	for req := range backlog {
+		select {
+		case s.workers <- struct{}{}:
+		case <-sc.ctx.Done():
+			// 连接已经断了, 排队的请求没必要处理了
+			sc.end()
+			continue
 		}
+		req := req
+		go func() {
+			defer func() {
+				sc.end()
+				<-s.workers
+			}()
+			// 连接断了普通调用的结果也发不出去了, 跟着连接一起取消, 把位置让出来
+			// oneway 调用客户端已经当成成功了, 不能因为客户端断开连接就半途而废
+			parent := sc.ctx
+			if isOnewayReq(req) {
+				parent = context.Background()
+			}
+			resp := s.handleReq(withPeer(parent, sc.peer), req)
+			// oneway 调用客户端不等结果, 也就不需要响应
+			if isOnewayReq(req) {
+				return
+			}
+			_ = sc.write(resp)
+		}()
+	}
+}
+
+func overloaded(req *message.Request) *message.Response {
+	resp := &message.Response{
+		RequestID:  req.RequestID,
+		Version:    req.Version,
+		Serializer: req.Serializer,
+		Error:      encodeStatus(FromError(ErrOverloaded)),
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	return resp
+}
 
-		resp, err := s.Invoke(ctx, req)
-		cancel() // 调用已经结束, 执行取消deadline
+func pong(ping *message.Request) *message.Response {
+	resp := &message.Response{
+		RequestID: ping.RequestID,
+		Version:   ping.Version,
+		Flag:      message.FlagPong,
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	return resp
+}
+
+func isOnewayReq(req *message.Request) bool {
+	return req.Meta[metaOneway] == "true"
+}
+
+// handleReq parent 里面有调用方的连接信息
+func (s *Server) handleReq(parent context.Context, req *message.Request) *message.Response {
+	if req.Compresser != 0 {
+		resp, err := s.decompressReq(req)
+		if err != nil {
+			// 解压都失败了, 只能返回不压缩的响应
+			resp.Error = encodeStatus(FromError(err))
+			resp.CalculateHeaderLength()
+			resp.CalculateBodyLength()
+			return resp
+		}
+	}
+	ctx, cancel := reqContext(parent, req)
+	resp, err := s.handler(ctx, req)
+	cancel() // 调用已经结束, 执行取消deadline
+	if resp == nil {
+		// Interceptor 可能直接返回了 nil
+		resp = &message.Response{
+			RequestID:  req.RequestID,
+			Version:    req.Version,
+			Compresser: req.Compresser,
+			Serializer: req.Serializer,
+		}
+	}
+	if err != nil {
+		// 业务 error 和框架的 error 都转成 Status, 客户端可以还原出错误码和哨兵错误
+		resp.Error = encodeStatus(FromError(err))
+	}
+	// 用和请求一样的压缩算法压缩响应
+	if resp.Compresser != 0 && len(resp.Data) > 0 {
+		data, err := s.compressors[resp.Compresser].Compress(resp.Data)
 		if err != nil {
-			// 可能是你的业务error
-			// 暂时不知道怎么处理的error
-			resp.Error = []byte(err.Error())
+			resp.Compresser = 0
+			resp.Data = nil
+			resp.Error = encodeStatus(NewStatus(CodeInternal, err.Error()))
+		} else {
+			resp.Data = data
 		}
+	}
 
-		resp.CalculateHeaderLength()
-		resp.CalculateBodyLength()
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	return resp
+}
 
-		if _, err := conn.Write(message.EncodeResp(resp)); err != nil {
-			return err
+// reqContext 根据 Meta 还原客户端的 ctx 里面的数据: deadline, oneway, trace 上下文, baggage 和用户设置的元数据
+func reqContext(parent context.Context, req *message.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if len(req.Meta) > 0 {
+		if deadlineStr, ok := req.Meta[metaDeadline]; ok {
+			if deadline, err := strconv.ParseInt(deadlineStr, 10, 64); err == nil {
+				cancel()
+				ctx, cancel = context.WithDeadline(parent, time.UnixMilli(deadline))
+			}
+		}
+		if isOnewayReq(req) {
+			ctx = CtxWithOneway(ctx)
 		}
+		ctx = metaPropagator.Extract(ctx, propagation.MapCarrier(req.Meta))
+		ctx = withIncomingMeta(ctx, req.Meta)
 	}
+	return ctx, cancel
+}
+
+func (s *Server) handleStreamFrame(sc *serverConn, req *message.Request) {
+	sc.mutex.Lock()
+	st, ok := sc.streams[req.RequestID]
+	if !ok {
+		// 流已经结束了, 客户端还发过来的帧直接丢掉
+		if req.ServiceName == "" {
+			sc.mutex.Unlock()
+			return
+		}
+		if len(sc.streams) >= s.maxStreams {
+			sc.mutex.Unlock()
+			// 没有登记这个流, 客户端后面发过来的帧都会被丢掉
+			_ = sc.write(endStream(req, errTooManyStreams))
+			return
+		}
+		ctx, cancel := reqContext(withPeer(sc.ctx, sc.peer), req)
+		st = &serverStream{
+			ctx:    ctx,
+			cancel: cancel,
+			open:   req,
+			frames: newQueue[*message.Request](streamWindow),
+			window: newFlowWindow(),
+			write:  sc.write,
+		}
+		sc.streams[req.RequestID] = st
+		sc.mutex.Unlock()
+		go s.runStream(sc, st)
+		// 打开流的帧一般不带数据
+		if len(req.Data) == 0 && req.Flag&message.FlagEndStream == 0 {
+			return
+		}
+	} else {
+		sc.mutex.Unlock()
+	}
+	if req.Flag&message.FlagCancel != 0 {
+		st.cancel()
+		return
+	}
+	if req.Flag&message.FlagWindowUpdate != 0 {
+		st.window.release(windowIncrement(req.Data))
+		return
+	}
+	// 不能阻塞在这里等业务代码消费, 客户端不遵守流量控制就直接结束这个流
+	// Recv 取完已经收到的帧之后会拿到 errStreamFlowControl
+	if err := st.frames.push(req, frameCost(req.Flag, req.Data)); err != nil {
+		st.cancel()
+	}
+}
+
+func (s *Server) runStream(sc *serverConn, st *serverStream) {
+	handler := chainStream(func(ctx context.Context, open *message.Request) error {
+		st.ctx = ctx
+		return s.invokeStream(st)
+	}, s.streamInterceptors)
+	err := handler(st.ctx, st.open)
+	if st.frames.closedErr() == errStreamFlowControl {
+		// 业务代码可能没有调用 Recv, 也要让客户端知道流是因为不遵守流量控制被结束的
+		err = errStreamFlowControl
+	}
+	st.cancel()
+	sc.removeStream(st.open.RequestID)
+
+	// 告诉客户端流结束了
+	_ = sc.write(endStream(st.open, err))
+}
+
+// endStream 结束流的帧, err 就是流的错误
+func endStream(open *message.Request, err error) *message.Response {
+	resp := &message.Response{
+		RequestID:  open.RequestID,
+		Version:    open.Version,
+		Serializer: open.Serializer,
+		Flag:       message.FlagStream | message.FlagEndStream,
+	}
+	if err != nil {
+		resp.Error = encodeStatus(FromError(err))
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	return resp
+}
+
+func (s *Server) invokeStream(st *serverStream) error {
+	service, ok := s.services[st.open.ServiceName]
+	if !ok {
+		return NewStatus(CodeUnimplemented, "rpc: 你要调用的服务不存在")
+	}
+	serializer, ok := s.serializers[st.open.Serializer]
+	if !ok {
+		return unsupportedSerializer(s.serializers)
+	}
+	st.serializer = serializer
+	if st.open.Compresser != 0 {
+		compressor, ok := s.compressors[st.open.Compresser]
+		if !ok {
+			return NewStatus(CodeUnimplemented, "micro: 不支持的压缩算法")
+		}
+		st.compressor = compressor
+	}
+	return service.invokeStream(st)
+}
+
+// serverConn 服务端的一个连接
+type serverConn struct {
+	conn net.Conn
+	// 多个 goroutine 写同一个连接, 要保证每个响应是完整写进去的
+	writeMutex sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// peer 收到第一个帧的时候初始化, 只在 handleConn 里面修改
+	peer *Peer
+
+	mutex sync.Mutex
+	// streams 正在处理的流
+	streams map[uint32]*serverStream
+	// active 正在处理的普通调用的数量
+	active int
+
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	// lastRecv 最后一次收到数据的时间
+	lastRecv time.Time
+}
+
+func newServerConn(conn net.Conn, idleTimeout time.Duration) *serverConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &serverConn{
+		conn:        conn,
+		ctx:         ctx,
+		cancel:      cancel,
+		streams:     make(map[uint32]*serverStream, 4),
+		idleTimeout: idleTimeout,
+		lastRecv:    time.Now(),
+	}
+	if idleTimeout > 0 {
+		c.mutex.Lock()
+		c.idleTimer = time.AfterFunc(idleTimeout, c.checkIdle)
+		c.mutex.Unlock()
+	}
+	return c
+}
+
+// checkIdle 定时检查连接是不是空闲太久了, 关掉连接之后 handleConn 会读到错误退出
+func (c *serverConn) checkIdle() {
+	c.mutex.Lock()
+	if c.ctx.Err() != nil {
+		c.mutex.Unlock()
+		return
+	}
+	idle := time.Since(c.lastRecv)
+	if c.active > 0 || len(c.streams) > 0 || idle < c.idleTimeout {
+		wait := c.idleTimeout - idle
+		if wait <= 0 {
+			// 还有请求在处理, 等它们结束之后再算
+			wait = c.idleTimeout
+		}
+		c.idleTimer.Reset(wait)
+		c.mutex.Unlock()
+		return
+	}
+	c.mutex.Unlock()
+	_ = c.conn.Close()
+}
+
+func (c *serverConn) received() {
+	c.mutex.Lock()
+	c.lastRecv = time.Now()
+	c.mutex.Unlock()
+}
+
+func (c *serverConn) begin() {
+	c.mutex.Lock()
+	c.active++
+	c.mutex.Unlock()
+}
+
+func (c *serverConn) end() {
+	c.mutex.Lock()
+	c.active--
+	// 处理请求的时间不算空闲
+	c.lastRecv = time.Now()
+	c.mutex.Unlock()
+}
+
+func (c *serverConn) close() {
+	c.mutex.Lock()
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.mutex.Unlock()
+	c.cancel()
+}
+
+func (c *serverConn) write(resp *message.Response) error {
+	if resp.Version == message.Version0 {
+		// 告诉老格式的客户端可以升级
+		resp.MaxVersion = message.MaxVersion
+	}
+	resp.CalculateHeaderLength()
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := c.conn.Write(message.EncodeResp(resp))
+	if err != nil {
+		_ = c.conn.Close()
+	}
+	return err
+}
+
+func (c *serverConn) removeStream(id uint32) {
+	c.mutex.Lock()
+	delete(c.streams, id)
+	c.lastRecv = time.Now()
+	c.mutex.Unlock()
+}
+
+// decompressReq 解压请求数据, 失败的时候返回的 resp 可以直接用来响应客户端
+func (s *Server) decompressReq(req *message.Request) (*message.Response, error) {
+	resp := &message.Response{
+		RequestID:  req.RequestID,
+		Version:    req.Version,
+		Serializer: req.Serializer,
+	}
+	compressor, ok := s.compressors[req.Compresser]
+	if !ok {
+		return resp, NewStatus(CodeUnimplemented, "micro: 不支持的压缩算法")
+	}
+	data, err := compressor.Decompress(req.Data)
+	if err != nil {
+		return resp, err
+	}
+	req.Data = data
+	return resp, nil
 }
 
 func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Response, error) {
@@ -109,15 +646,11 @@ func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Res
 		Serializer: req.Serializer,
 	}
 	if !ok {
-		return resp, errors.New("rpc: 你要调用的服务不存在")
+		return resp, NewStatus(CodeUnimplemented, "rpc: 你要调用的服务不存在")
 	}
-	// 一次调用，不需要返回结果
-	if isOneway(ctx) {
-		go func() {
-			_, _ = service.invoke(ctx, req)
-		}()
-		// 也可以返回resp, nil
-		return resp, errors.New("micro: 微服务服务端 oneway 请求")
+	// 在真正调用之前就检查序列化协议, 顺便告诉客户端服务端支持哪些
+	if _, ok = s.serializers[req.Serializer]; !ok {
+		return resp, unsupportedSerializer(s.serializers)
 	}
 	data, err := service.invoke(ctx, req)
 	resp.Data = data
@@ -128,21 +661,84 @@ func (s *Server) Invoke(ctx context.Context, req *message.Request) (*message.Res
 	return resp, nil
 }
 
+// stub 服务端处理一个服务的调用
+type stub interface {
+	invoke(ctx context.Context, req *message.Request) ([]byte, error)
+	invokeStream(st *serverStream) error
+	// describe 反射服务用来列出方法
+	describe() []MethodDesc
+}
+
+// MethodHandler 生成的服务端代码使用
+// decode 把请求数据解码到参数里面, 返回值为 nil 表示没有数据
+type MethodHandler func(ctx context.Context, decode func(req any) error) (any, error)
+
+type handlerStub struct {
+	handlers    map[string]MethodHandler
+	serializers map[uint8]serialize.Serializer
+}
+
+func (s *handlerStub) invoke(ctx context.Context, req *message.Request) (res []byte, err error) {
+	// 业务代码 panic 了不能让整个服务端挂掉, 转成 error 返回给客户端
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = Errorf(CodeInternal, "micro: 服务端 panic: %v", r)
+		}
+	}()
+	handler, ok := s.handlers[req.MethodName]
+	if !ok {
+		return nil, NewStatus(CodeUnimplemented, "micro: 你要调用的方法不存在")
+	}
+	serializer, ok := s.serializers[req.Serializer]
+	if !ok {
+		return nil, unsupportedSerializer(s.serializers)
+	}
+	resp, err := handler(ctx, func(val any) error {
+		return serializer.Decode(req.Data, val)
+	})
+	if resp == nil {
+		return nil, err
+	}
+	res, serErr := serializer.Encode(resp)
+	if serErr != nil {
+		return nil, serErr
+	}
+	return res, err
+}
+
+func (s *handlerStub) invokeStream(st *serverStream) error {
+	return NewStatus(CodeUnimplemented, "micro: 这个方法不是流式方法")
+}
+
 type reflectionStub struct {
 	s           Service
 	value       reflect.Value
 	serializers map[uint8]serialize.Serializer // 客户端一般只有一种序列化协议，不同的客户端可以选不同的序列化协议
 }
 
-func (s *reflectionStub) invoke(ctx context.Context, req *message.Request) ([]byte, error) {
+func (s *reflectionStub) invoke(ctx context.Context, req *message.Request) (res []byte, err error) {
+	// 业务代码 panic 了不能让整个服务端挂掉, 转成 error 返回给客户端
+	defer func() {
+		if r := recover(); r != nil {
+			res = nil
+			err = Errorf(CodeInternal, "micro: 服务端 panic: %v", r)
+		}
+	}()
 	// 通过反射找到方法, 并且执行调用
 	method := s.value.MethodByName(req.MethodName)
+	if !method.IsValid() {
+		return nil, NewStatus(CodeUnimplemented, "micro: 你要调用的方法不存在")
+	}
+	if method.Type().NumIn() != 2 || method.Type().NumOut() != 2 {
+		return nil, NewStatus(CodeUnimplemented, "micro: 流式方法不能用普通的方式调用")
+	}
 	in := make([]reflect.Value, 2)
 	in[0] = reflect.ValueOf(ctx)
 	inReq := reflect.New(method.Type().In(1).Elem())
 	serializer, ok := s.serializers[req.Serializer]
 	if !ok {
-		return nil, errors.New("micro: 不支持的序列化协议")
+		return nil, unsupportedSerializer(s.serializers)
 	}
 	if err := serializer.Decode(req.Data, inReq.Interface()); err != nil {
 		return nil, err
@@ -151,12 +747,10 @@ func (s *reflectionStub) invoke(ctx context.Context, req *message.Request) ([]by
 	results := method.Call(in)
 	// results[0] 是返回值
 	// results[1] 是error
-	var err error
 	if results[1].Interface() != nil {
 		err = results[1].Interface().(error)
 	}
 
-	var res []byte
 	if results[0].IsNil() {
 		return nil, err
 	} else {
@@ -168,3 +762,42 @@ func (s *reflectionStub) invoke(ctx context.Context, req *message.Request) ([]by
 	}
 	return res, err
 }
+
+func (s *reflectionStub) invokeStream(st *serverStream) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Errorf(CodeInternal, "micro: 服务端 panic: %v", r)
+		}
+	}()
+	method := s.value.MethodByName(st.open.MethodName)
+	if !method.IsValid() {
+		return NewStatus(CodeUnimplemented, "micro: 你要调用的方法不存在")
+	}
+	typ := method.Type()
+	numIn := typ.NumIn()
+	if numIn < 2 || numIn > 3 || typ.NumOut() != 1 || !isServerStream(typ.In(numIn-1)) {
+		return NewStatus(CodeUnimplemented, "micro: 这个方法不是流式方法")
+	}
+	stream := reflect.New(typ.In(numIn - 1).Elem())
+	stream.Interface().(serverStreamSetter).setStream(st)
+
+	in := make([]reflect.Value, 0, numIn)
+	in = append(in, reflect.ValueOf(st.ctx))
+	// 服务端流, 第一个数据帧就是请求
+	if numIn == 3 {
+		req := reflect.New(typ.In(1).Elem())
+		if err = st.recv(req.Interface()); err != nil {
+			if err == io.EOF {
+				return NewStatus(CodeInvalidArgument, "micro: 没有收到请求")
+			}
+			return err
+		}
+		in = append(in, req)
+	}
+	in = append(in, stream)
+	results := method.Call(in)
+	if results[0].Interface() != nil {
+		return results[0].Interface().(error)
+	}
+	return nil
+}
@@ -0,0 +1,497 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc/compress"
+	"github.com/startdusk/go-libs/micro/rpc/compress/gzip"
+	"github.com/startdusk/go-libs/micro/rpc/compress/snappy"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_handleReq_compress(t *testing.T) {
+	server := NewServer()
+	server.RegisterService(&UserServiceServer{Msg: "hello world"})
+	server.RegisterCompressor(&gzip.Compressor{})
+	server.RegisterCompressor(&snappy.Compressor{})
+
+	cases := []struct {
+		name       string
+		compressor compress.Compressor
+	}{
+		{
+			name:       "gzip",
+			compressor: &gzip.Compressor{},
+		},
+		{
+			name:       "snappy",
+			compressor: &snappy.Compressor{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := c.compressor.Compress([]byte(`{"ID":123}`))
+			require.NoError(t, err)
+			req := &message.Request{
+				RequestID:   1,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+				Serializer:  1,
+				Compresser:  c.compressor.Code(),
+				Data:        data,
+			}
+			req.CalculateHeaderLength()
+			req.CalculateBodyLength()
+
+			resp := server.handleReq(context.Background(), req)
+			assert.Equal(t, c.compressor.Code(), resp.Compresser)
+			data, err = c.compressor.Decompress(resp.Data)
+			require.NoError(t, err)
+			assert.Equal(t, `{"Msg":"hello world"}`, string(data))
+		})
+	}
+}
+
+func TestServer_handleConn_oneway(t *testing.T) {
+	server := NewServer()
+	called := make(chan string, 2)
+	server.RegisterHandlers("user-service", map[string]MethodHandler{
+		"GetByID": func(ctx context.Context, decode func(req any) error) (any, error) {
+			called <- "GetByID"
+			return map[string]string{"Msg": "hello world"}, nil
+		},
+	})
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	oneway := &message.Request{
+		RequestID:   1,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Serializer:  1,
+		Meta:        map[string]string{"one-way": "true"},
+	}
+	normal := &message.Request{
+		RequestID:   2,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Serializer:  1,
+	}
+	for _, req := range []*message.Request{oneway, normal} {
+		req.CalculateHeaderLength()
+		req.CalculateBodyLength()
+		data, err := message.EncodeReq(req)
+		require.NoError(t, err)
+		_, err = client.Write(data)
+		require.NoError(t, err)
+	}
+	// 两个请求都处理了, 但是只有普通调用有响应
+	assert.Equal(t, "GetByID", <-called)
+	assert.Equal(t, "GetByID", <-called)
+	data, err := ReadMsg(client)
+	require.NoError(t, err)
+	resp, err := message.DecodeResp(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), resp.RequestID)
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = ReadMsg(client)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestServer_handleConn_backpressure(t *testing.T) {
+	server := NewServer(ServerWithMaxConcurrency(1), ServerWithBacklog(1))
+	block := make(chan struct{})
+	server.RegisterHandlers("user-service", map[string]MethodHandler{
+		"GetByID": func(ctx context.Context, decode func(req any) error) (any, error) {
+			<-block
+			return nil, nil
+		},
+	})
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	write := func(req *message.Request) {
+		data, err := encodeReq(req, message.Version1)
+		assert.NoError(t, err)
+		_, err = client.Write(data)
+		assert.NoError(t, err)
+	}
+	read := func() *message.Response {
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+		data, err := ReadMsg(client)
+		require.NoError(t, err)
+		resp, err := message.DecodeResp(data)
+		require.NoError(t, err)
+		return resp
+	}
+	newReq := func(id uint32) *message.Request {
+		req := &message.Request{
+			RequestID:   id,
+			ServiceName: "user-service",
+			MethodName:  "GetByID",
+			Serializer:  1,
+		}
+		req.CalculateBodyLength()
+		return req
+	}
+	// 第一个请求占住了唯一的位置, 后面的请求排队, 排满了就直接被拒绝
+	// dispatch 拿出来等位置的请求不占排队的位置, 所以第三个请求可能被拒绝, 也可能在排队, 第四个一定被拒绝
+	// net.Pipe 没有缓冲, 服务端写响应的时候要有人在读, 所以在另外一个 goroutine 里面写
+	go func() {
+		for id := uint32(1); id <= 4; id++ {
+			write(newReq(id))
+		}
+		// 满了也能响应心跳
+		write(newPing(5))
+	}()
+	rejected := make(map[uint32]bool, 2)
+	for {
+		resp := read()
+		if resp.Flag&message.FlagPong != 0 {
+			assert.Equal(t, uint32(5), resp.RequestID)
+			break
+		}
+		assert.Equal(t, CodeOverloaded, StatusFromResponse(resp).Code)
+		rejected[resp.RequestID] = true
+	}
+	assert.True(t, rejected[4])
+
+	close(block)
+	var wantIDs, ids []uint32
+	for id := uint32(1); id <= 3; id++ {
+		if !rejected[id] {
+			wantIDs = append(wantIDs, id)
+			ids = append(ids, read().RequestID)
+		}
+	}
+	assert.ElementsMatch(t, wantIDs, ids)
+}
+
+func TestServer_handleConn_onewayBackpressure(t *testing.T) {
+	server := NewServer(ServerWithMaxConcurrency(1), ServerWithBacklog(1))
+	block := make(chan struct{})
+	var called int32
+	server.RegisterHandlers("user-service", map[string]MethodHandler{
+		"GetByID": func(ctx context.Context, decode func(req any) error) (any, error) {
+			<-block
+			atomic.AddInt32(&called, 1)
+			return nil, nil
+		},
+	})
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	const n = 5
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for id := uint32(1); id <= n; id++ {
+			req := &message.Request{
+				RequestID:   id,
+				ServiceName: "user-service",
+				MethodName:  "GetByID",
+				Serializer:  1,
+				Meta:        map[string]string{"one-way": "true"},
+			}
+			req.CalculateBodyLength()
+			data, err := encodeReq(req, message.Version1)
+			assert.NoError(t, err)
+			_, err = client.Write(data)
+			assert.NoError(t, err)
+		}
+	}()
+	// 排队的位置满了, 服务端不再读连接, 客户端写不进去
+	select {
+	case <-written:
+		t.Fatal("服务端应该停下来等排队的位置")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// 有位置了之后所有的 oneway 调用都会被处理, 一个都不能丢
+	close(block)
+	<-written
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&called) == n
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestServer_handleConn_disconnect 客户端断开连接之后, 还在处理的普通调用要被取消
+func TestServer_handleConn_disconnect(t *testing.T) {
+	server := NewServer()
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server.RegisterHandlers("user-service", map[string]MethodHandler{
+		"GetByID": func(ctx context.Context, decode func(req any) error) (any, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		},
+	})
+	conn, client := net.Pipe()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	req := &message.Request{
+		RequestID:   1,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+		Serializer:  1,
+	}
+	req.CalculateBodyLength()
+	data, err := encodeReq(req, message.Version1)
+	require.NoError(t, err)
+	_, err = client.Write(data)
+	require.NoError(t, err)
+	<-started
+	require.NoError(t, client.Close())
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("客户端断开之后调用没有被取消")
+	}
+}
+
+func TestServerWithMaxConcurrency_invalid(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		server := NewServer(ServerWithMaxConcurrency(n), ServerWithBacklog(n))
+		assert.Equal(t, 1024, cap(server.workers))
+		assert.Equal(t, 128, server.backlog)
+	}
+}
+
+func TestServer_handleConn_ping(t *testing.T) {
+	server := NewServer()
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	data, err := encodeReq(newPing(3), message.Version1)
+	require.NoError(t, err)
+	_, err = client.Write(data)
+	require.NoError(t, err)
+	data, err = ReadMsg(client)
+	require.NoError(t, err)
+	resp, err := message.DecodeResp(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), resp.RequestID)
+	assert.Equal(t, message.FlagPong, resp.Flag)
+}
+
+// TestServer_handleConn_oldClient 按照最早的格式手写的请求, 服务端要能处理, 响应也要是老的客户端能解的格式
+func TestServer_handleConn_oldClient(t *testing.T) {
+	server := NewServer()
+	server.RegisterService(&UserServiceServer{Msg: "hello"})
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	req := []byte{
+		// 头部长度 36, body 长度 10, RequestID 7, Version 0, 不压缩, json
+		0x0, 0x0, 0x0, 0x24, 0x0, 0x0, 0x0, 0xa, 0x0, 0x0, 0x0, 0x7, 0x0, 0x0, 0x1,
+	}
+	req = append(req, "user-service\nGetByID\n"...)
+	req = append(req, `{"ID":123}`...)
+	_, err := client.Write(req)
+	require.NoError(t, err)
+
+	data, err := ReadMsg(client)
+	require.NoError(t, err)
+	// 老的客户端认为头部固定是 15 个字节, 后面紧跟着 Error
+	assert.Equal(t, uint32(15), binary.BigEndian.Uint32(data[:4]))
+	assert.Equal(t, uint32(7), binary.BigEndian.Uint32(data[8:12]))
+	assert.Equal(t, uint8(1), data[14])
+	assert.JSONEq(t, `{"Msg":"hello"}`, string(data[15:]))
+}
+
+func TestServer_handleConn_maxFrameSize(t *testing.T) {
+	v1 := &message.Request{
+		RequestID:   1,
+		Version:     message.Version1,
+		ServiceName: "user-service",
+		MethodName:  "GetByID",
+	}
+	v1.CalculateHeaderLength()
+	v1.CalculateBodyLength()
+	valid, err := message.EncodeReq(v1)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name string
+		data func() []byte
+
+		wantErr error
+	}{
+		{
+			// 长度字段说 body 有 2G, 读到头部就拒绝了
+			name: "v0 too large",
+			data: func() []byte {
+				data := make([]byte, 15)
+				binary.BigEndian.PutUint32(data[:4], 15)
+				binary.BigEndian.PutUint32(data[4:8], 1<<31)
+				return data
+			},
+			wantErr: ErrFrameTooLarge,
+		},
+		{
+			name: "v1 too large",
+			data: func() []byte {
+				req := *v1
+				req.Data = make([]byte, 1025)
+				req.CalculateBodyLength()
+				data, err := message.EncodeReq(&req)
+				require.NoError(t, err)
+				return data
+			},
+			wantErr: ErrFrameTooLarge,
+		},
+		{
+			// 长度字段被篡改了, 校验和对不上, 不会按照它去分配内存
+			name: "v1 length tampered",
+			data: func() []byte {
+				data := append([]byte{}, valid...)
+				data[8] = 0x7f
+				return data
+			},
+			wantErr: message.ErrChecksum,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := NewServer(ServerWithMaxFrameSize(1024))
+			conn, client := net.Pipe()
+			defer func() {
+				_ = client.Close()
+			}()
+			done := make(chan error, 1)
+			go func() {
+				done <- server.handleConn(conn)
+			}()
+			go func() {
+				_, _ = client.Write(c.data())
+			}()
+			select {
+			case err := <-done:
+				assert.Equal(t, c.wantErr, err)
+			case <-time.After(time.Second):
+				t.Fatal("服务端没有拒绝这个帧")
+			}
+		})
+	}
+}
+
+func TestServer_handleConn_idleTimeout(t *testing.T) {
+	cases := []struct {
+		name string
+		// before 在等待之前客户端做的事情
+		before func(t *testing.T, client net.Conn)
+
+		wantClosed bool
+	}{
+		{
+			name:       "idle",
+			before:     func(t *testing.T, client net.Conn) {},
+			wantClosed: true,
+		},
+		{
+			// 请求还在处理, 不算空闲
+			name: "active request",
+			before: func(t *testing.T, client net.Conn) {
+				req := &message.Request{
+					RequestID:   1,
+					ServiceName: "user-service",
+					MethodName:  "GetByID",
+					Serializer:  1,
+				}
+				req.CalculateHeaderLength()
+				req.CalculateBodyLength()
+				data, err := message.EncodeReq(req)
+				require.NoError(t, err)
+				_, err = client.Write(data)
+				require.NoError(t, err)
+			},
+		},
+		{
+			// 客户端一直在发心跳, 不算空闲
+			name: "heartbeat",
+			before: func(t *testing.T, client net.Conn) {
+				go func() {
+					for i := 0; i < 10; i++ {
+						data, _ := encodeReq(newPing(uint32(i)), message.Version1)
+						if _, err := client.Write(data); err != nil {
+							return
+						}
+						if _, err := ReadMsg(client); err != nil {
+							return
+						}
+						time.Sleep(20 * time.Millisecond)
+					}
+				}()
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := NewServer(ServerWithIdleTimeout(50 * time.Millisecond))
+			block := make(chan struct{})
+			server.RegisterHandlers("user-service", map[string]MethodHandler{
+				"GetByID": func(ctx context.Context, decode func(req any) error) (any, error) {
+					<-block
+					return nil, nil
+				},
+			})
+			defer close(block)
+			conn, client := net.Pipe()
+			defer func() {
+				_ = client.Close()
+			}()
+			done := make(chan error, 1)
+			go func() {
+				done <- server.handleConn(conn)
+			}()
+			c.before(t, client)
+			select {
+			case <-done:
+				assert.True(t, c.wantClosed)
+			case <-time.After(150 * time.Millisecond):
+				assert.False(t, c.wantClosed)
+			}
+		})
+	}
+}
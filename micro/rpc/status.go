@@ -0,0 +1,204 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+)
+
+// Code 错误码, 0-16 和 gRPC 的错误码含义一样
+// 业务自定义的错误码从 CodeCustomStart 开始
+type Code uint32
+
+const (
+	CodeOK Code = iota
+	CodeCanceled
+	CodeUnknown
+	CodeInvalidArgument
+	CodeDeadlineExceeded
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodeResourceExhausted
+	CodeFailedPrecondition
+	CodeAborted
+	CodeOutOfRange
+	CodeUnimplemented
+	CodeInternal
+	CodeUnavailable
+	CodeDataLoss
+	CodeUnauthenticated
+)
+
+// 框架自己的错误码, gRPC 里面没有对应的
+const (
+	// CodeOverloaded 服务端过载, 提前拒绝了请求, 对应 ErrOverloaded
+	// 和 CodeResourceExhausted 分开, 客户端才能区分服务端过载和限流之类的配额用完
+	CodeOverloaded Code = 100
+)
+
+// CodeCustomStart 业务自定义错误码的起始值, 小于它的都是框架保留的
+const CodeCustomStart Code = 1000
+
+var codeNames = map[Code]string{
+	CodeOK:                 "OK",
+	CodeCanceled:           "Canceled",
+	CodeUnknown:            "Unknown",
+	CodeInvalidArgument:    "InvalidArgument",
+	CodeDeadlineExceeded:   "DeadlineExceeded",
+	CodeNotFound:           "NotFound",
+	CodeAlreadyExists:      "AlreadyExists",
+	CodePermissionDenied:   "PermissionDenied",
+	CodeResourceExhausted:  "ResourceExhausted",
+	CodeFailedPrecondition: "FailedPrecondition",
+	CodeAborted:            "Aborted",
+	CodeOutOfRange:         "OutOfRange",
+	CodeUnimplemented:      "Unimplemented",
+	CodeInternal:           "Internal",
+	CodeUnavailable:        "Unavailable",
+	CodeDataLoss:           "DataLoss",
+	CodeUnauthenticated:    "Unauthenticated",
+	CodeOverloaded:         "Overloaded",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Status 跨越 rpc 边界的错误, 类似于 gRPC 的 status
+// 服务端返回的 error 都会被转成 Status 传给客户端
+type Status struct {
+	Code    Code
+	Message string
+	// Details 附加的错误信息, 一般是序列化之后的结构体, 框架不会解析它
+	Details []byte
+
+	// err 对应的哨兵错误, 客户端根据 Code 找回来, 这样 errors.Is 才能用
+	err error
+}
+
+func NewStatus(code Code, msg string) *Status {
+	return &Status{Code: code, Message: msg}
+}
+
+func Errorf(code Code, format string, args ...any) error {
+	return NewStatus(code, fmt.Sprintf(format, args...))
+}
+
+// Error 只返回 Message, 和之前直接返回错误字符串的行为保持一致
+func (s *Status) Error() string {
+	return s.Message
+}
+
+func (s *Status) Unwrap() error {
+	return s.err
+}
+
+// WithDetails 返回一个带上 details 的副本
+func (s *Status) WithDetails(details []byte) *Status {
+	res := *s
+	res.Details = details
+	return &res
+}
+
+// ErrOverloaded 服务端过载, 提前拒绝了请求, 错误码是 CodeOverloaded, 客户端可以用 errors.Is 判断出来
+// 其它 CodeResourceExhausted 的错误, 比如说被限流了, 不是 ErrOverloaded
+var ErrOverloaded = errors.New("micro: 服务过载")
+
+var (
+	errorsMutex sync.RWMutex
+	// codeErrors 错误码 -> 哨兵错误
+	codeErrors = map[Code]error{
+		CodeCanceled:         context.Canceled,
+		CodeDeadlineExceeded: context.DeadlineExceeded,
+		CodeOverloaded:       ErrOverloaded,
+	}
+	// codeOrder FromError 按照这个顺序匹配, 框架内置的在前面, 业务的按照注册的顺序
+	// 一个错误同时包装了多个哨兵错误的时候, 每次得到的错误码都是一样的, 不能依赖 map 的遍历顺序
+	codeOrder = []Code{CodeCanceled, CodeDeadlineExceeded, CodeOverloaded}
+)
+
+// RegisterError 注册哨兵错误, 服务端和客户端都要注册
+// 服务端返回的 error 只要 errors.Is(err, sentinel) 就会带上 code,
+// 客户端收到之后 errors.Is(err, sentinel) 也能判断出来
+// code 必须大于等于 CodeCustomStart, 重复注册会 panic
+func RegisterError(code Code, sentinel error) {
+	if code < CodeCustomStart {
+		panic(fmt.Sprintf("micro: 错误码 %d 是框架保留的", code))
+	}
+	errorsMutex.Lock()
+	defer errorsMutex.Unlock()
+	if _, ok := codeErrors[code]; ok {
+		panic(fmt.Sprintf("micro: 错误码 %d 已经注册过了", code))
+	}
+	codeErrors[code] = sentinel
+	codeOrder = append(codeOrder, code)
+}
+
+// FromError 把任意 error 转成 Status
+// 已经是 Status 的原样返回, 注册过的哨兵错误使用注册的 code, 其余的都是 CodeUnknown
+// 匹配上多个哨兵错误的时候, 框架内置的优先, 然后是先注册的
+func FromError(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	var st *Status
+	if errors.As(err, &st) {
+		return st
+	}
+	errorsMutex.RLock()
+	defer errorsMutex.RUnlock()
+	for _, code := range codeOrder {
+		if sentinel := codeErrors[code]; errors.Is(err, sentinel) {
+			return &Status{Code: code, Message: err.Error(), err: sentinel}
+		}
+	}
+	return &Status{Code: CodeUnknown, Message: err.Error()}
+}
+
+// StatusFromResponse 解析响应里面的错误, 没有错误的时候返回 nil
+func StatusFromResponse(resp *message.Response) *Status {
+	if resp == nil || len(resp.Error) == 0 {
+		return nil
+	}
+	return decodeStatus(resp.Error)
+}
+
+// encodeStatus 编码格式:
+// code(4 字节) | message 长度(4 字节) | message | details
+func encodeStatus(st *Status) []byte {
+	bs := make([]byte, 8+len(st.Message)+len(st.Details))
+	binary.BigEndian.PutUint32(bs[:4], uint32(st.Code))
+	binary.BigEndian.PutUint32(bs[4:8], uint32(len(st.Message)))
+	copy(bs[8:], st.Message)
+	copy(bs[8+len(st.Message):], st.Details)
+	return bs
+}
+
+func decodeStatus(data []byte) *Status {
+	st := &Status{}
+	msgLen := uint32(0)
+	if len(data) >= 8 {
+		msgLen = binary.BigEndian.Uint32(data[4:8])
+	}
+	if len(data) < 8 || uint64(msgLen) > uint64(len(data)-8) {
+		// 格式不对, 可能是老版本的服务端直接返回的错误字符串
+		return &Status{Code: CodeUnknown, Message: string(data)}
+	}
+	st.Code = Code(binary.BigEndian.Uint32(data[:4]))
+	st.Message = string(data[8 : 8+msgLen])
+	if details := data[8+msgLen:]; len(details) > 0 {
+		st.Details = append([]byte(nil), details...)
+	}
+	errorsMutex.RLock()
+	st.err = codeErrors[st.Code]
+	errorsMutex.RUnlock()
+	return st
+}
@@ -0,0 +1,233 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+)
+
+var (
+	errUserNotFound = errors.New("user not found")
+	errUserBanned   = errors.New("user banned")
+)
+
+func init() {
+	RegisterError(CodeCustomStart+1, errUserNotFound)
+	RegisterError(CodeCustomStart+2, errUserBanned)
+}
+
+// multiError 同时包装了多个错误, Go 1.20 之前没有 Unwrap() []error, 只能自己实现 Is
+type multiError []error
+
+func (m multiError) Error() string {
+	return "multi error"
+}
+
+func (m multiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStatus_encode(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want *Status
+	}{
+		{
+			name: "status",
+			data: encodeStatus(NewStatus(CodeNotFound, "not found")),
+			want: NewStatus(CodeNotFound, "not found"),
+		},
+		{
+			name: "details",
+			data: encodeStatus(NewStatus(CodeInvalidArgument, "invalid").WithDetails([]byte(`{"field":"id"}`))),
+			want: &Status{Code: CodeInvalidArgument, Message: "invalid", Details: []byte(`{"field":"id"}`)},
+		},
+		{
+			// 老版本的服务端直接返回错误字符串
+			name: "plain string",
+			data: []byte("this is a error"),
+			want: NewStatus(CodeUnknown, "this is a error"),
+		},
+		{
+			name: "invalid message length",
+			data: []byte{0, 0, 0, 5, 0, 0, 0, 100, 'a'},
+			want: NewStatus(CodeUnknown, string([]byte{0, 0, 0, 5, 0, 0, 0, 100, 'a'})),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, decodeStatus(c.data))
+		})
+	}
+}
+
+func TestFromError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		wantCode Code
+		wantMsg  string
+	}{
+		{
+			name:     "nil",
+			wantCode: CodeOK,
+		},
+		{
+			name:     "status",
+			err:      Errorf(CodeNotFound, "user %d not found", 12),
+			wantCode: CodeNotFound,
+			wantMsg:  "user 12 not found",
+		},
+		{
+			name:     "wrapped sentinel",
+			err:      fmt.Errorf("get user: %w", errUserNotFound),
+			wantCode: CodeCustomStart + 1,
+			wantMsg:  "get user: user not found",
+		},
+		{
+			name:     "deadline",
+			err:      context.DeadlineExceeded,
+			wantCode: CodeDeadlineExceeded,
+			wantMsg:  "context deadline exceeded",
+		},
+		{
+			name:     "overloaded",
+			err:      ErrOverloaded,
+			wantCode: CodeOverloaded,
+			wantMsg:  "micro: 服务过载",
+		},
+		{
+			name:     "unknown",
+			err:      errors.New("mock error"),
+			wantCode: CodeUnknown,
+			wantMsg:  "mock error",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := FromError(c.err)
+			if c.err == nil {
+				assert.Nil(t, st)
+				return
+			}
+			assert.Equal(t, c.wantCode, st.Code)
+			assert.Equal(t, c.wantMsg, st.Message)
+		})
+	}
+}
+
+// TestFromError_order 匹配上多个哨兵错误的时候, 错误码必须是确定的
+func TestFromError_order(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		wantCode Code
+	}{
+		{
+			// 先注册的优先
+			name:     "registered order",
+			err:      multiError{errUserBanned, errUserNotFound},
+			wantCode: CodeCustomStart + 1,
+		},
+		{
+			// 框架内置的优先
+			name:     "builtin first",
+			err:      multiError{errUserNotFound, context.DeadlineExceeded},
+			wantCode: CodeDeadlineExceeded,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				assert.Equal(t, c.wantCode, FromError(c.err).Code)
+			}
+		})
+	}
+}
+
+func TestRegisterError(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterError(CodeNotFound, errors.New("reserved"))
+	})
+	assert.Panics(t, func() {
+		RegisterError(CodeCustomStart+1, errors.New("duplicate"))
+	})
+}
+
+// 服务端返回的哨兵错误, 客户端可以用 errors.Is 和 errors.As 判断
+func Test_setFuncField_status(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		wantIs   error
+		wantCode Code
+		wantMsg  string
+	}{
+		{
+			name:     "sentinel",
+			err:      fmt.Errorf("get user: %w", errUserNotFound),
+			wantIs:   errUserNotFound,
+			wantCode: CodeCustomStart + 1,
+			wantMsg:  "get user: user not found",
+		},
+		{
+			name:     "deadline",
+			err:      context.DeadlineExceeded,
+			wantIs:   context.DeadlineExceeded,
+			wantCode: CodeDeadlineExceeded,
+			wantMsg:  "context deadline exceeded",
+		},
+		{
+			name:     "overloaded",
+			err:      ErrOverloaded,
+			wantIs:   ErrOverloaded,
+			wantCode: CodeOverloaded,
+			wantMsg:  "micro: 服务过载",
+		},
+		{
+			// 限流之类的配额用完不是过载
+			name:     "resource exhausted",
+			err:      NewStatus(CodeResourceExhausted, "ratelimit: 请求太多"),
+			wantCode: CodeResourceExhausted,
+			wantMsg:  "ratelimit: 请求太多",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			p := NewMockProxy(ctrl)
+			resp := &message.Response{Error: encodeStatus(FromError(c.err))}
+			p.EXPECT().Invoke(gomock.Any(), gomock.Any()).Return(resp, nil)
+
+			service := &UserService{}
+			require.NoError(t, setFuncField(service, p, &json.Serializer{}))
+			_, err := service.GetByID(context.Background(), &GetByIDReq{ID: 12})
+			if c.wantIs != nil {
+				assert.True(t, errors.Is(err, c.wantIs))
+			} else {
+				assert.False(t, errors.Is(err, ErrOverloaded))
+			}
+			var st *Status
+			require.True(t, errors.As(err, &st))
+			assert.Equal(t, c.wantCode, st.Code)
+			assert.Equal(t, c.wantMsg, st.Message)
+		})
+	}
+}
@@ -0,0 +1,588 @@
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/startdusk/go-libs/micro/rpc/compress"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/startdusk/go-libs/micro/rpc/serialize"
+)
+
+// 流式调用的协议:
+// 1. 客户端发送打开流的帧, 带上服务名, 方法名和 Meta, 不带数据
+// 2. 双方发送数据帧, 都使用打开流时的 RequestID 作为流的 ID
+// 3. 客户端发送 FlagEndStream 表示半关闭, 服务端 Recv 会返回 io.EOF
+// 4. 服务端方法返回之后发送 FlagEndStream 帧, 带上错误, 整个流结束
+// 5. 客户端的 ctx 被取消了就发送 FlagCancel 帧, 服务端会取消 ctx
+// 6. 每个方向最多有 streamWindow 字节的数据帧还没有被对端消费掉, 用完了 Send 就会阻塞,
+// 接收方 Recv 取走数据之后用 FlagWindowUpdate 帧把窗口还回去. 对端不遵守窗口, 这个流就会以 CodeResourceExhausted 结束
+// 流式调用不经过 Interceptor, 服务端可以用 ServerWithStreamInterceptors 设置流式调用的拦截器
+
+const (
+	// streamWindow 流量控制的窗口, 双方都按照这个值计算, 所以不能配置
+	// 发送方在窗口还有剩余的时候可以发一个任意大小的帧, 所以接收方缓存的数据最多是 streamWindow 加上一个帧
+	streamWindow = 1 << 20
+	// frameOverhead 每个数据帧至少占这么多窗口, 不然对端可以发无数个空的帧
+	frameOverhead = 64
+)
+
+var (
+	errStreamSendClosed = errors.New("micro: 流已经关闭了发送")
+	errStreamNotSupport = errors.New("rpc: 不支持流式调用")
+	// errStreamFlowControl 对端发送的数据超过了窗口
+	errStreamFlowControl = NewStatus(CodeResourceExhausted, "rpc: 对端不遵守流量控制")
+	// errTooManyStreams 连接上打开的流超过了 ServerWithMaxStreams
+	errTooManyStreams = NewStatus(CodeResourceExhausted, "rpc: 连接上打开的流太多了")
+)
+
+// ClientStream 客户端的流, 用在服务的字段上:
+// 服务端流: func(ctx context.Context, req *Req) (*ClientStream[Req, Resp], error), 请求发出去之后会自动 CloseSend
+// 客户端流和双向流: func(ctx context.Context) (*ClientStream[Req, Resp], error)
+// Send 和 Recv 可以在不同的 goroutine 里面调用, 但是不能多个 goroutine 同时 Recv
+type ClientStream[Req any, Resp any] struct {
+	s *clientStream
+}
+
+func (c *ClientStream[Req, Resp]) setStream(s *clientStream) {
+	c.s = s
+}
+
+func (c *ClientStream[Req, Resp]) Context() context.Context {
+	return c.s.ctx
+}
+
+// Send 服务端的接收窗口用完了会阻塞, 服务端已经结束了这个流就返回 io.EOF, 流的错误要用 Recv 拿到
+func (c *ClientStream[Req, Resp]) Send(req *Req) error {
+	return c.s.send(req)
+}
+
+// CloseSend 半关闭, 告诉服务端不会再发送数据了
+func (c *ClientStream[Req, Resp]) CloseSend() error {
+	return c.s.closeSend()
+}
+
+// Recv 流正常结束返回 io.EOF, 服务端返回了错误就返回 *Status
+func (c *ClientStream[Req, Resp]) Recv() (*Resp, error) {
+	resp := new(Resp)
+	if err := c.s.recv(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CloseAndRecv 客户端流使用, 半关闭之后等待服务端唯一的响应
+func (c *ClientStream[Req, Resp]) CloseAndRecv() (*Resp, error) {
+	if err := c.CloseSend(); err != nil {
+		return nil, err
+	}
+	resp, err := c.Recv()
+	if err != nil {
+		return nil, err
+	}
+	// 还要等到流结束, 服务端可能在发送响应之后又返回了错误
+	if _, err = c.Recv(); err != io.EOF {
+		if err == nil {
+			return nil, errors.New("micro: 服务端返回了多个响应")
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ServerStream 服务端的流, 用在服务的方法上:
+// 服务端流: func(ctx context.Context, req *Req, stream *ServerStream[Req, Resp]) error
+// 客户端流和双向流: func(ctx context.Context, stream *ServerStream[Req, Resp]) error
+// 方法返回就表示流结束了, 返回的 error 会传给客户端
+type ServerStream[Req any, Resp any] struct {
+	s *serverStream
+}
+
+func (s *ServerStream[Req, Resp]) setStream(st *serverStream) {
+	s.s = st
+}
+
+func (s *ServerStream[Req, Resp]) Context() context.Context {
+	return s.s.ctx
+}
+
+func (s *ServerStream[Req, Resp]) Send(resp *Resp) error {
+	return s.s.send(resp)
+}
+
+// Recv 客户端半关闭之后返回 io.EOF
+func (s *ServerStream[Req, Resp]) Recv() (*Req, error) {
+	req := new(Req)
+	if err := s.s.recv(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// 泛型类型没办法通过反射创建, 所以只能创建出来之后再通过这两个接口把真正的流设置进去
+type clientStreamSetter interface {
+	setStream(s *clientStream)
+}
+
+type serverStreamSetter interface {
+	setStream(s *serverStream)
+}
+
+var (
+	clientStreamSetterType = reflect.TypeOf((*clientStreamSetter)(nil)).Elem()
+	serverStreamSetterType = reflect.TypeOf((*serverStreamSetter)(nil)).Elem()
+)
+
+// isClientStream typ 是不是 *ClientStream[Req, Resp]
+func isClientStream(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Pointer && typ.Implements(clientStreamSetterType)
+}
+
+// isServerStream typ 是不是 *ServerStream[Req, Resp]
+func isServerStream(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Pointer && typ.Implements(serverStreamSetterType)
+}
+
+// streamOpener Proxy 同时实现了这个接口才支持流式调用
+type streamOpener interface {
+	newStream(ctx context.Context, open *message.Request, s serialize.Serializer) (*clientStream, error)
+}
+
+type clientStream struct {
+	ctx         context.Context
+	id          uint32
+	conn        *muxConn
+	serializer  serialize.Serializer
+	compressor  compress.Compressor
+	compressors map[uint8]compress.Compressor
+	frames      *queue[*message.Response]
+	// window 还能发给服务端多少数据
+	window *flowWindow
+	// consumed Recv 取走了但是还没有还给服务端的窗口
+	consumed int
+
+	sendMutex  sync.Mutex
+	sendClosed bool
+
+	// recvErr 流结束之后 Recv 一直返回它
+	recvErr error
+
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+// watch ctx 被取消了就通知服务端
+func (s *clientStream) watch() {
+	select {
+	case <-s.ctx.Done():
+		s.cancelRemote()
+		s.frames.closeWithErr(s.ctx.Err())
+		s.finish()
+	case <-s.done:
+	case <-s.conn.done:
+	}
+}
+
+// cancelRemote 通知服务端取消这个流
+func (s *clientStream) cancelRemote() {
+	req := &message.Request{
+		RequestID:  s.id,
+		Serializer: s.serializer.Code(),
+		Flag:       message.FlagStream | message.FlagCancel,
+	}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	_ = s.conn.writeReq(req)
+}
+
+func (s *clientStream) send(val any) error {
+	data, err := s.serializer.Encode(val)
+	if err != nil {
+		return err
+	}
+	req := &message.Request{
+		RequestID:  s.id,
+		Serializer: s.serializer.Code(),
+		Flag:       message.FlagStream,
+		Data:       data,
+	}
+	if s.compressor != nil {
+		req.Data, err = s.compressor.Compress(data)
+		if err != nil {
+			return err
+		}
+		req.Compresser = s.compressor.Code()
+	}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+
+	if s.sendIsClosed() {
+		return errStreamSendClosed
+	}
+	// 服务端还没有消费掉之前发的数据就等着, 不能让它替我们缓存无限多的数据
+	// 等的时候不能拿着 sendMutex, 不然 CloseSend 也会被卡住
+	if err = s.window.acquire(s.ctx, frameCost(req.Flag, req.Data)); err != nil {
+		return err
+	}
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.sendClosed {
+		return errStreamSendClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.conn.writeReq(req)
+}
+
+func (s *clientStream) sendIsClosed() bool {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	return s.sendClosed
+}
+
+func (s *clientStream) closeSend() error {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	req := &message.Request{
+		RequestID:  s.id,
+		Serializer: s.serializer.Code(),
+		Flag:       message.FlagStream | message.FlagEndStream,
+	}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	return s.conn.writeReq(req)
+}
+
+func (s *clientStream) recv(val any) error {
+	if s.recvErr != nil {
+		return s.recvErr
+	}
+	resp, err := s.frames.pop(context.Background())
+	if err != nil {
+		if err == errStreamFlowControl {
+			s.cancelRemote()
+		}
+		s.recvErr = err
+		s.finish()
+		return err
+	}
+	if resp.Flag&message.FlagEndStream != 0 {
+		s.recvErr = io.EOF
+		if st := StatusFromResponse(resp); st != nil {
+			s.recvErr = st
+		}
+		s.finish()
+		return s.recvErr
+	}
+	s.consumed += frameCost(resp.Flag, resp.Data)
+	if s.consumed >= streamWindow/4 {
+		// 攒够了再还, 不用每个帧都回一个 FlagWindowUpdate
+		req := &message.Request{
+			RequestID:  s.id,
+			Serializer: s.serializer.Code(),
+			Flag:       message.FlagStream | message.FlagWindowUpdate,
+			Data:       windowUpdateData(s.consumed),
+		}
+		req.CalculateHeaderLength()
+		req.CalculateBodyLength()
+		_ = s.conn.writeReq(req)
+		s.consumed = 0
+	}
+	data, err := decompress(s.compressors, resp.Compresser, resp.Data)
+	if err != nil {
+		return err
+	}
+	return s.serializer.Decode(data, val)
+}
+
+func (s *clientStream) finish() {
+	s.doneOnce.Do(func() {
+		close(s.done)
+		s.conn.removeStream(s.id)
+	})
+}
+
+type serverStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// open 打开流的帧, 里面有服务名和方法名
+	open       *message.Request
+	serializer serialize.Serializer
+	compressor compress.Compressor
+	frames     *queue[*message.Request]
+	// window 还能发给客户端多少数据
+	window *flowWindow
+	write  func(resp *message.Response) error
+
+	// eof 客户端已经半关闭了
+	eof bool
+	// consumed recv 取走了但是还没有还给客户端的窗口
+	consumed int
+}
+
+func (s *serverStream) send(val any) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	data, err := s.serializer.Encode(val)
+	if err != nil {
+		return err
+	}
+	resp := &message.Response{
+		RequestID:  s.open.RequestID,
+		Version:    s.open.Version,
+		Serializer: s.open.Serializer,
+		Flag:       message.FlagStream,
+		Data:       data,
+	}
+	if s.compressor != nil {
+		resp.Data, err = s.compressor.Compress(data)
+		if err != nil {
+			return err
+		}
+		resp.Compresser = s.compressor.Code()
+	}
+	resp.CalculateHeaderLength()
+	resp.CalculateBodyLength()
+	if err = s.window.acquire(s.ctx, frameCost(resp.Flag, resp.Data)); err != nil {
+		return err
+	}
+	return s.write(resp)
+}
+
+func (s *serverStream) recv(val any) error {
+	if s.eof {
+		return io.EOF
+	}
+	req, err := s.frames.pop(s.ctx)
+	if err != nil {
+		return err
+	}
+	s.consumed += frameCost(req.Flag, req.Data)
+	if s.consumed >= streamWindow/4 {
+		resp := &message.Response{
+			RequestID:  s.open.RequestID,
+			Version:    s.open.Version,
+			Serializer: s.open.Serializer,
+			Flag:       message.FlagStream | message.FlagWindowUpdate,
+			Data:       windowUpdateData(s.consumed),
+		}
+		resp.CalculateHeaderLength()
+		resp.CalculateBodyLength()
+		_ = s.write(resp)
+		s.consumed = 0
+	}
+	if req.Flag&message.FlagEndStream != 0 {
+		s.eof = true
+		if len(req.Data) == 0 {
+			return io.EOF
+		}
+	}
+	data := req.Data
+	if s.compressor != nil && len(data) > 0 {
+		data, err = s.compressor.Decompress(data)
+		if err != nil {
+			return err
+		}
+	}
+	return s.serializer.Decode(data, val)
+}
+
+// queue 流的帧先放进来, 消费的时候再取出来
+// 读连接的 goroutine 不能被某一个流阻塞住, 不然同一个连接上的其他请求都会被卡住,
+// 所以满了也不会等, 而是直接拒绝, 靠流量控制保证守规矩的对端不会把它放满
+type queue[T any] struct {
+	mutex sync.Mutex
+	items []queueItem[T]
+	// size 队列里面的帧一共占了多少窗口, 超过 limit 就不能再放了, limit 为 0 不限制
+	size   int
+	limit  int
+	err    error
+	notify chan struct{}
+}
+
+type queueItem[T any] struct {
+	item T
+	size int
+}
+
+func newQueue[T any](limit int) *queue[T] {
+	return &queue[T]{limit: limit, notify: make(chan struct{}, 1)}
+}
+
+// push size 是这个帧占的窗口, 控制帧是 0, 一定放得进去
+// 队列已经满了就返回 errStreamFlowControl, 同时关闭队列
+func (q *queue[T]) push(item T, size int) error {
+	q.mutex.Lock()
+	if q.err != nil {
+		q.mutex.Unlock()
+		return nil
+	}
+	if size > 0 && q.limit > 0 && q.size >= q.limit {
+		q.err = errStreamFlowControl
+		q.mutex.Unlock()
+		q.wakeup()
+		return errStreamFlowControl
+	}
+	q.items = append(q.items, queueItem[T]{item: item, size: size})
+	q.size += size
+	q.mutex.Unlock()
+	q.wakeup()
+	return nil
+}
+
+// closeWithErr 已经放进来的还可以取出来, 取完之后 pop 返回 err
+func (q *queue[T]) closeWithErr(err error) {
+	q.mutex.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mutex.Unlock()
+	q.wakeup()
+}
+
+func (q *queue[T]) closedErr() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.err
+}
+
+func (q *queue[T]) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue[T]) pop(ctx context.Context) (T, error) {
+	var zero T
+	for {
+		q.mutex.Lock()
+		if len(q.items) > 0 {
+			it := q.items[0]
+			q.items[0] = queueItem[T]{}
+			q.items = q.items[1:]
+			q.size -= it.size
+			q.mutex.Unlock()
+			return it.item, nil
+		}
+		if q.err != nil {
+			err := q.err
+			q.mutex.Unlock()
+			return zero, err
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// flowWindow 发送方的窗口, 用完了就等对端的 FlagWindowUpdate
+type flowWindow struct {
+	mutex  sync.Mutex
+	credit int
+	err    error
+	notify chan struct{}
+}
+
+func newFlowWindow() *flowWindow {
+	return &flowWindow{credit: streamWindow, notify: make(chan struct{}, 1)}
+}
+
+// acquire 窗口还有剩余就可以发, 哪怕 n 比剩下的大, 不然大于窗口的帧永远发不出去
+func (w *flowWindow) acquire(ctx context.Context, n int) error {
+	for {
+		w.mutex.Lock()
+		if w.err != nil {
+			err := w.err
+			w.mutex.Unlock()
+			return err
+		}
+		if n == 0 || w.credit > 0 {
+			w.credit -= n
+			more := w.credit > 0
+			w.mutex.Unlock()
+			if more {
+				// 可能还有别人在等
+				w.wakeup()
+			}
+			return nil
+		}
+		w.mutex.Unlock()
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *flowWindow) release(n int) {
+	w.mutex.Lock()
+	w.credit += n
+	w.mutex.Unlock()
+	w.wakeup()
+}
+
+// closeWithErr 之后 acquire 都返回 err, 流已经结束了就不用再等窗口了
+func (w *flowWindow) closeWithErr(err error) {
+	w.mutex.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mutex.Unlock()
+	w.wakeup()
+}
+
+func (w *flowWindow) wakeup() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// frameCost 一个帧占多少窗口, 只有数据帧才占, 结束, 取消和 FlagWindowUpdate 帧都不占
+func frameCost(flag uint8, data []byte) int {
+	if flag&(message.FlagEndStream|message.FlagCancel|message.FlagWindowUpdate) != 0 {
+		return 0
+	}
+	return len(data) + frameOverhead
+}
+
+func windowUpdateData(n int) []byte {
+	bs := make([]byte, 4)
+	binary.BigEndian.PutUint32(bs, uint32(n))
+	return bs
+}
+
+// windowIncrement 解不出来就当作 0, 对端自己会卡住, 影响不到我们
+func windowIncrement(data []byte) int {
+	if len(data) != 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(data))
+}
+
+// decompress code 为 0 表示没有压缩
+func decompress(compressors map[uint8]compress.Compressor, code uint8, data []byte) ([]byte, error) {
+	if code == 0 || len(data) == 0 {
+		return data, nil
+	}
+	compressor, ok := compressors[code]
+	if !ok {
+		return nil, errors.New("micro: 不支持的压缩算法")
+	}
+	return compressor.Decompress(data)
+}
@@ -0,0 +1,375 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/startdusk/go-libs/micro/rpc/serialize/json"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_stream(t *testing.T) {
+	addr := ":8087"
+	server := NewServer()
+	service := &StreamServiceServer{cancelled: make(chan struct{})}
+	server.RegisterService(service)
+	server.RegisterService(&UserServiceServer{Msg: "hello world"})
+	flood := &FloodServiceServer{}
+	server.RegisterService(flood)
+	go func() {
+		err := server.Start("tcp", addr)
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	time.Sleep(3 * time.Second)
+	client, err := NewClient(addr)
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	ssClient := &StreamService{}
+	require.NoError(t, client.InitService(ssClient))
+
+	t.Run("server stream", func(t *testing.T) {
+		cases := []struct {
+			name       string
+			req        *WatchReq
+			wantEvents []*Event
+			wantErr    error
+		}{
+			{
+				name:       "events",
+				req:        &WatchReq{Count: 3},
+				wantEvents: []*Event{{ID: 0}, {ID: 1}, {ID: 2}},
+				wantErr:    io.EOF,
+			},
+			{
+				name:    "no events",
+				req:     &WatchReq{},
+				wantErr: io.EOF,
+			},
+			{
+				// 发送了一部分之后返回错误
+				name:       "error",
+				req:        &WatchReq{Count: 1, Fail: true},
+				wantEvents: []*Event{{ID: 0}},
+				wantErr:    NewStatus(CodeAborted, "watch failed"),
+			},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				stream, err := ssClient.Watch(context.Background(), c.req)
+				require.NoError(t, err)
+				var events []*Event
+				for {
+					evt, err := stream.Recv()
+					if err != nil {
+						assert.Equal(t, c.wantErr, err)
+						break
+					}
+					events = append(events, evt)
+				}
+				assert.Equal(t, c.wantEvents, events)
+			})
+		}
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		stream, err := ssClient.Sum(context.Background())
+		require.NoError(t, err)
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, stream.Send(&Num{Val: i}))
+		}
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		assert.Equal(t, &Num{Val: 10}, resp)
+		// 半关闭之后就不能再发送了
+		assert.Equal(t, errStreamSendClosed, stream.Send(&Num{Val: 1}))
+	})
+
+	t.Run("bidi stream", func(t *testing.T) {
+		stream, err := ssClient.Echo(context.Background())
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, stream.Send(&Num{Val: i}))
+			resp, err := stream.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, &Num{Val: i}, resp)
+		}
+		require.NoError(t, stream.CloseSend())
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := ssClient.Block(ctx, &WatchReq{})
+		require.NoError(t, err)
+		cancel()
+		_, err = stream.Recv()
+		assert.Equal(t, context.Canceled, err)
+		// 服务端也要感知到
+		select {
+		case <-service.cancelled:
+		case <-time.After(3 * time.Second):
+			t.Fatal("服务端没有取消")
+		}
+	})
+
+	t.Run("not stream method", func(t *testing.T) {
+		stream, err := ssClient.Unary(context.Background())
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, NewStatus(CodeUnimplemented, "micro: 这个方法不是流式方法"), err)
+	})
+
+	t.Run("flow control", func(t *testing.T) {
+		const count, size = 64, 64 << 10
+		fs := &FloodService{}
+		require.NoError(t, client.InitService(fs))
+		stream, err := fs.Flood(context.Background(), &FloodReq{Count: count, Size: size})
+		require.NoError(t, err)
+		// 客户端不 Recv, 服务端用完窗口之后就发不动了, 最多比窗口多发一个帧
+		time.Sleep(300 * time.Millisecond)
+		assert.LessOrEqual(t, atomic.LoadInt64(&flood.flooded), int64(streamWindow/size+1))
+		for i := 0; i < count; i++ {
+			chunk, err := stream.Recv()
+			require.NoError(t, err)
+			assert.Equal(t, i, chunk.ID)
+		}
+		_, err = stream.Recv()
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, int64(count), atomic.LoadInt64(&flood.flooded))
+	})
+
+	// 流式调用和普通调用可以共用一个连接
+	t.Run("unary", func(t *testing.T) {
+		us := &UserService{}
+		require.NoError(t, client.InitService(us))
+		resp, err := us.GetByID(context.Background(), &GetByIDReq{ID: 123})
+		require.NoError(t, err)
+		assert.Equal(t, &GetByIDResp{Msg: "hello world"}, resp)
+	})
+}
+
+type WatchReq struct {
+	Count int
+	Fail  bool
+}
+
+type Event struct {
+	ID int
+}
+
+type Num struct {
+	Val int
+}
+
+type FloodReq struct {
+	Count int
+	Size  int
+}
+
+type Chunk struct {
+	ID   int
+	Data []byte
+}
+
+type StreamService struct {
+	Watch func(ctx context.Context, req *WatchReq) (*ClientStream[WatchReq, Event], error)
+	Sum   func(ctx context.Context) (*ClientStream[Num, Num], error)
+	Echo  func(ctx context.Context) (*ClientStream[Num, Num], error)
+	Block func(ctx context.Context, req *WatchReq) (*ClientStream[WatchReq, Event], error)
+	Unary func(ctx context.Context) (*ClientStream[Num, Num], error)
+}
+
+func (s *StreamService) Name() string {
+	return "stream-service"
+}
+
+type StreamServiceServer struct {
+	cancelled chan struct{}
+}
+
+func (s *StreamServiceServer) Name() string {
+	return "stream-service"
+}
+
+func (s *StreamServiceServer) Watch(ctx context.Context, req *WatchReq, stream *ServerStream[WatchReq, Event]) error {
+	for i := 0; i < req.Count; i++ {
+		if err := stream.Send(&Event{ID: i}); err != nil {
+			return err
+		}
+	}
+	if req.Fail {
+		return Errorf(CodeAborted, "watch failed")
+	}
+	return nil
+}
+
+func (s *StreamServiceServer) Sum(ctx context.Context, stream *ServerStream[Num, Num]) error {
+	sum := 0
+	for {
+		num, err := stream.Recv()
+		if err == io.EOF {
+			return stream.Send(&Num{Val: sum})
+		}
+		if err != nil {
+			return err
+		}
+		sum += num.Val
+	}
+}
+
+func (s *StreamServiceServer) Echo(ctx context.Context, stream *ServerStream[Num, Num]) error {
+	for {
+		num, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(num); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *StreamServiceServer) Block(ctx context.Context, req *WatchReq, stream *ServerStream[WatchReq, Event]) error {
+	<-ctx.Done()
+	close(s.cancelled)
+	return ctx.Err()
+}
+
+type FloodService struct {
+	Flood func(ctx context.Context, req *FloodReq) (*ClientStream[FloodReq, Chunk], error)
+}
+
+func (s *FloodService) Name() string {
+	return "flood-service"
+}
+
+type FloodServiceServer struct {
+	// flooded Flood 已经发出去的帧的数量
+	flooded int64
+}
+
+func (s *FloodServiceServer) Name() string {
+	return "flood-service"
+}
+
+func (s *FloodServiceServer) Flood(ctx context.Context, req *FloodReq, stream *ServerStream[FloodReq, Chunk]) error {
+	for i := 0; i < req.Count; i++ {
+		if err := stream.Send(&Chunk{ID: i, Data: make([]byte, req.Size)}); err != nil {
+			return err
+		}
+		atomic.AddInt64(&s.flooded, 1)
+	}
+	return nil
+}
+
+func (s *StreamServiceServer) Unary(ctx context.Context, req *Num) (*Num, error) {
+	return req, nil
+}
+
+func TestServer_streamFlowControl(t *testing.T) {
+	server := NewServer()
+	server.RegisterService(&StreamServiceServer{cancelled: make(chan struct{})})
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	newFrame := func(req *message.Request) []byte {
+		req.RequestID = 1
+		req.Serializer = (&json.Serializer{}).Code()
+		req.Flag |= message.FlagStream
+		req.CalculateHeaderLength()
+		req.CalculateBodyLength()
+		data, err := encodeReq(req, message.Version1)
+		require.NoError(t, err)
+		return data
+	}
+	open := newFrame(&message.Request{ServiceName: "stream-service", MethodName: "Block"})
+	// Block 只读第一个帧作为请求, 之后就再也不 Recv 了
+	data := append([]byte("{}"), bytes.Repeat([]byte(" "), 64<<10)...)
+	frame := newFrame(&message.Request{Data: data})
+	// net.Pipe 没有缓冲, 在另外一个 goroutine 里面写
+	go func() {
+		if _, err := client.Write(open); err != nil {
+			return
+		}
+		// 远远超过窗口, 服务端不能全部缓存下来
+		for i := 0; i < 2*streamWindow/len(data); i++ {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(3*time.Second)))
+	for {
+		bs, err := ReadMsg(client)
+		require.NoError(t, err)
+		resp, err := message.DecodeResp(bs)
+		require.NoError(t, err)
+		if resp.Flag&message.FlagEndStream != 0 {
+			assert.Equal(t, errStreamFlowControl, StatusFromResponse(resp))
+			return
+		}
+	}
+}
+
+func TestServer_maxStreams(t *testing.T) {
+	server := NewServer(ServerWithMaxStreams(1))
+	server.RegisterService(&StreamServiceServer{cancelled: make(chan struct{})})
+	conn, client := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+	go func() {
+		_ = server.handleConn(conn)
+	}()
+
+	go func() {
+		// 第一个流一直等请求, 不会结束, 第二个流就打不开了
+		for id := uint32(1); id <= 2; id++ {
+			open := &message.Request{
+				RequestID:   id,
+				ServiceName: "stream-service",
+				MethodName:  "Block",
+				Serializer:  (&json.Serializer{}).Code(),
+				Flag:        message.FlagStream,
+			}
+			open.CalculateHeaderLength()
+			open.CalculateBodyLength()
+			data, err := encodeReq(open, message.Version1)
+			assert.NoError(t, err)
+			if _, err = client.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(3*time.Second)))
+	bs, err := ReadMsg(client)
+	require.NoError(t, err)
+	resp, err := message.DecodeResp(bs)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), resp.RequestID)
+	assert.Equal(t, message.FlagStream|message.FlagEndStream, resp.Flag)
+	assert.Equal(t, errTooManyStreams, StatusFromResponse(resp))
+}
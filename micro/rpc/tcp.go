@@ -1,24 +1,57 @@
 package rpc
 
 import (
-	"encoding/binary"
+	"errors"
+	"io"
 	"net"
+
+	"github.com/startdusk/go-libs/micro/rpc/message"
 )
 
+// DefaultMaxFrameSize 默认一个帧最大 16MB, 头部和 body 加起来
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrFrameTooLarge 对端发过来的帧超过了上限, 连接会被关掉
+var ErrFrameTooLarge = errors.New("rpc: 帧太大")
+
+// ReadMsg 用 DefaultMaxFrameSize 作为上限读一个帧
 func ReadMsg(conn net.Conn) ([]byte, error) {
+	return readMsg(conn, DefaultMaxFrameSize)
+}
+
+// readMsg 读一个完整的帧, 超过 maxSize 的帧在分配内存之前就会被拒绝, maxSize 为 0 就用 DefaultMaxFrameSize
+// 长度字段是对端随便填的, 不先检查的话一个连接就能让我们分配几个 G 的内存
+func readMsg(conn net.Conn, maxSize uint32) ([]byte, error) {
 	// 协议头 + 协议体
 
-	lenBytes := make([]byte, numOfLengthBytes)
-	_, err := conn.Read(lenBytes)
+	// 一次 Read 不一定能读满, 必须用 ReadFull
+	// 先读出两种格式的固定头部都有的部分, 再根据有没有魔数把固定头部读完
+	header := make([]byte, message.FramePrefixLength)
+	_, err := io.ReadFull(conn, header)
 	if err != nil {
 		return nil, err
 	}
+	if n := message.FixedHeaderLength(header); n > len(header) {
+		rest := make([]byte, n-len(header))
+		if _, err = io.ReadFull(conn, rest); err != nil {
+			return nil, err
+		}
+		header = append(header, rest...)
+	}
 
-	headerLen := binary.BigEndian.Uint32(lenBytes[:4])
-	bodyLen := binary.BigEndian.Uint32(lenBytes[4:])
-	length := headerLen + bodyLen
+	// Version1 的帧这里会先检查头部的校验和
+	length, err := message.FrameLength(header)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	if length > uint64(maxSize) {
+		return nil, ErrFrameTooLarge
+	}
 	bs := make([]byte, length)
-	_, err = conn.Read(bs[8:])
-	copy(bs[:8], lenBytes)
+	copy(bs, header)
+	_, err = io.ReadFull(conn, bs[len(header):])
 	return bs, err
 }
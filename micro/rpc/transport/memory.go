@@ -0,0 +1,106 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	ErrAddrInUse      = errors.New("micro: 地址已经被占用")
+	ErrListenerClosed = errors.New("micro: 监听已经关闭")
+	errNoListener     = errors.New("micro: 没有服务端监听这个地址")
+)
+
+// Memory 内存里面的传输层, 连接是 net.Pipe, 不占用端口, 一般在测试里面用
+// 客户端和服务端要使用同一个 Memory
+type Memory struct {
+	mutex     sync.Mutex
+	listeners map[string]*memoryListener
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		listeners: make(map[string]*memoryListener, 4),
+	}
+}
+
+func (m *Memory) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	m.mutex.Lock()
+	l, ok := m.listeners[addr]
+	m.mutex.Unlock()
+	if !ok {
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(addr), Err: errNoListener}
+	}
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		_ = server.Close()
+		_ = client.Close()
+		return nil, &net.OpError{Op: "dial", Net: "memory", Addr: memoryAddr(addr), Err: ErrListenerClosed}
+	case <-ctx.Done():
+		_ = server.Close()
+		_ = client.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Memory) Listen(addr string) (net.Listener, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.listeners[addr]; ok {
+		return nil, ErrAddrInUse
+	}
+	l := &memoryListener{
+		m:     m,
+		addr:  memoryAddr(addr),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	m.listeners[addr] = l
+	return l, nil
+}
+
+type memoryListener struct {
+	m     *Memory
+	addr  memoryAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+		l.m.mutex.Lock()
+		delete(l.m.listeners, string(l.addr))
+		l.m.mutex.Unlock()
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string {
+	return "memory"
+}
+
+func (a memoryAddr) String() string {
+	return string(a)
+}
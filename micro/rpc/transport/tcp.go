@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"context"
+	"net"
+)
+
+// TCP 默认的传输层
+type TCP struct{}
+
+func (TCP) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (TCP) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// Unix Unix domain socket, addr 是 socket 文件的路径, 同一台机器上的进程通信比 TCP 快
+type Unix struct{}
+
+func (Unix) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", addr)
+}
+
+// Listen 监听之前不会删除已经存在的 socket 文件, 上一个进程没有正常退出的话要自己删掉
+func (Unix) Listen(addr string) (net.Listener, error) {
+	return net.Listen("unix", addr)
+}
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// TLS 基于 TCP 的 TLS, 客户端和服务端使用各自的 Config
+// 双向认证(mTLS)的时候服务端的 Config 要设置 ClientAuth 和 ClientCAs, 客户端的 Config 要设置 Certificates
+// 可以使用 ServerTLSConfig 和 ClientTLSConfig 来创建
+type TLS struct {
+	Config *tls.Config
+}
+
+func NewTLS(cfg *tls.Config) *TLS {
+	return &TLS{Config: cfg}
+}
+
+// Dial 握手也算在 ctx 的超时时间里面
+func (t *TLS) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	d := &tls.Dialer{Config: t.Config}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// Listen 握手在第一次读写的时候进行
+func (t *TLS) Listen(addr string) (net.Listener, error) {
+	return tls.Listen("tcp", addr, t.Config)
+}
+
+// ServerTLSConfig clientCAs 不为 nil 的时候开启双向认证, 客户端必须提供 clientCAs 签发的证书
+func ServerTLSConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAs != nil {
+		cfg.ClientCAs = clientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg
+}
+
+// ClientTLSConfig rootCAs 用来验证服务端的证书, 为 nil 就使用系统的根证书
+// serverName 要和服务端证书里面的名字一致, cert 不为 nil 的时候会提供给服务端做双向认证
+func ClientTLSConfig(rootCAs *x509.CertPool, serverName string, cert *tls.Certificate) *tls.Config {
+	cfg := &tls.Config{
+		RootCAs:    rootCAs,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
@@ -0,0 +1,197 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "localhost", false)
+	clientCert := ca.issue(t, "client", true)
+	memory := NewMemory()
+
+	cases := []struct {
+		name   string
+		server Transport
+		client Transport
+		addr   string
+
+		wantErr bool
+	}{
+		{
+			name:   "tcp",
+			server: TCP{},
+			client: TCP{},
+			addr:   "127.0.0.1:0",
+		},
+		{
+			name:   "unix",
+			server: Unix{},
+			client: Unix{},
+			addr:   filepath.Join(t.TempDir(), "rpc.sock"),
+		},
+		{
+			name:   "memory",
+			server: memory,
+			client: memory,
+			addr:   "user-service",
+		},
+		{
+			name:   "tls",
+			server: NewTLS(ServerTLSConfig(serverCert, nil)),
+			client: NewTLS(ClientTLSConfig(ca.pool, "localhost", nil)),
+			addr:   "127.0.0.1:0",
+		},
+		{
+			name:   "mtls",
+			server: NewTLS(ServerTLSConfig(serverCert, ca.pool)),
+			client: NewTLS(ClientTLSConfig(ca.pool, "localhost", &clientCert)),
+			addr:   "127.0.0.1:0",
+		},
+		{
+			name:    "mtls without client cert",
+			server:  NewTLS(ServerTLSConfig(serverCert, ca.pool)),
+			client:  NewTLS(ClientTLSConfig(ca.pool, "localhost", nil)),
+			addr:    "127.0.0.1:0",
+			wantErr: true,
+		},
+		{
+			name:    "untrusted server",
+			server:  NewTLS(ServerTLSConfig(serverCert, nil)),
+			client:  NewTLS(ClientTLSConfig(x509.NewCertPool(), "localhost", nil)),
+			addr:    "127.0.0.1:0",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lis, err := c.server.Listen(c.addr)
+			require.NoError(t, err)
+			defer func() {
+				_ = lis.Close()
+			}()
+			// 把收到的数据原样写回去
+			go func() {
+				for {
+					conn, err := lis.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						_, _ = io.Copy(conn, conn)
+						_ = conn.Close()
+					}()
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			conn, err := c.client.Dial(ctx, lis.Addr().String())
+			if err == nil {
+				defer func() {
+					_ = conn.Close()
+				}()
+				// TLS 1.3 的客户端握手完成的时候服务端可能还没有验证客户端证书, 错误要读的时候才能发现
+				_, err = conn.Write([]byte("hello"))
+				if err == nil {
+					buf := make([]byte, 5)
+					_, err = io.ReadFull(conn, buf)
+					if err == nil {
+						assert.Equal(t, "hello", string(buf))
+					}
+				}
+			}
+			assert.Equal(t, c.wantErr, err != nil, "%v", err)
+		})
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	_, err := m.Dial(context.Background(), "user-service")
+	assert.ErrorIs(t, err, errNoListener)
+
+	lis, err := m.Listen("user-service")
+	require.NoError(t, err)
+	_, err = m.Listen("user-service")
+	assert.Equal(t, ErrAddrInUse, err)
+	assert.Equal(t, "memory", lis.Addr().Network())
+
+	// 服务端不 Accept 的时候 Dial 会一直等
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = m.Dial(ctx, "user-service")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, lis.Close())
+	_, err = lis.Accept()
+	assert.Equal(t, ErrListenerClosed, err)
+	// 关闭之后地址可以重新使用
+	lis, err = m.Listen("user-service")
+	require.NoError(t, err)
+	_ = lis.Close()
+}
+
+// testCA 测试用的证书, 每次运行都重新生成
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, client bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if client {
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,13 @@
+// Package transport rpc 使用的传输层, 客户端用 Dial 建立连接, 服务端用 Listen 监听
+package transport
+
+import (
+	"context"
+	"net"
+)
+
+type Transport interface {
+	// Dial 建立到 addr 的连接, ctx 控制建立连接的超时时间
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+	Listen(addr string) (net.Listener, error)
+}
@@ -4,28 +4,72 @@ import (
 	"context"
 	"github.com/startdusk/go-libs/micro/registry"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
+// Hook 启动或者退出的时候执行的回调
+type Hook func(ctx context.Context) error
+
 type Server struct {
 	*grpc.Server
 	name            string
 	registry        registry.Registry
 	registryTimeout time.Duration
+
+	// 下面这些都会原样注册到注册中心, 客户端可以根据它们来做负载均衡和路由
+	weight  int
+	group   string
+	version string
+	tags    map[string]string
+
+	// drainDelay 从注册中心注销之后, 等客户端感知到实例下线的时间
+	drainDelay time.Duration
+	// shutdownTimeout GracefulStop 的最长等待时间, 超过了就强制关闭
+	shutdownTimeout time.Duration
+
+	startHooks []Hook
+	stopHooks  []Hook
+
+	// grpcOptions 创建 grpc.Server 的时候使用, 比如说拦截器
+	grpcOptions []grpc.ServerOption
+
+	// health 标准的 gRPC 健康检查服务, 客户端用 ClientWithHealthCheck 开启检查
+	health *health.Server
+
+	mutex sync.Mutex
+	// si 已经注册到注册中心的实例, 退出的时候要注销
+	si *registry.ServiceInstance
+	// closed Close 已经开始了, Start 不能再注册
+	closed bool
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 type ServerOption func(s *Server)
 
 func NewServer(name string, opts ...ServerOption) *Server {
 	s := &Server{
-		name:   name,
-		Server: grpc.NewServer(),
+		name:            name,
+		registryTimeout: 10 * time.Second,
+		drainDelay:      time.Second,
+		shutdownTimeout: 10 * time.Second,
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
+	s.Server = grpc.NewServer(s.grpcOptions...)
+	// 整个服务器默认是 SERVING, name 对应的服务在 Start 执行完启动钩子之后才是 SERVING
+	s.health = health.NewServer()
+	healthpb.RegisterHealthServer(s.Server, s.health)
 	return s
 }
 
@@ -36,38 +80,181 @@ func (s *Server) Start(addr string) error {
 		return err
 	}
 
+	// 启动钩子在注册之前执行, 比如说预热缓存, 失败了就不注册
+	if err := s.runHooks(s.startHooks); err != nil {
+		_ = lis.Close()
+		return err
+	}
+
 	// 有注册中心，要注册
 	if s.registry != nil {
 		// 在这里注册
 		ctx, cancel := context.WithTimeout(context.Background(), s.registryTimeout)
 		defer cancel()
-		if err := s.registry.Register(ctx, registry.ServiceInstance{
+		si := registry.ServiceInstance{
 			Name:    s.name,
 			Address: lis.Addr().String(),
-		}); err != nil {
+			Weight:  s.weight,
+			Group:   s.group,
+			Version: s.version,
+			Tags:    s.tags,
+			Health:  registry.HealthStatusServing,
+		}
+		// 注册和记录 si 要在同一把锁里面完成, Close 拿到锁的时候要么还没开始注册, 要么已经记下来了, 不会漏掉注销
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			_ = lis.Close()
+			return grpc.ErrServerStopped
+		}
+		if err := s.registry.Register(ctx, si); err != nil {
+			s.mutex.Unlock()
+			_ = lis.Close()
 			return err
 		}
-		// 到这里已经注册成功了
-		// defer func() {
-		// 	// 忽略或者log一下错误
-		// 	_ = s.registry.Close()
-		// }()
+		// 到这里已经注册成功了, 记下来, 退出的时候要注销
+		s.si = &si
+		s.mutex.Unlock()
 	}
 
+	s.health.SetServingStatus(s.name, healthpb.HealthCheckResponse_SERVING)
 	return s.Serve(lis)
 }
 
-// 先关registry再关listener
-// 因为关registry的时候会有请求进来
+// SetServingStatus 修改健康状态, service 为空代表整个服务器
+// 修改服务器或者 name 对应的服务的状态的时候, 会同步更新注册中心里面的实例, 这样 grpcResolver 也会过滤掉不健康的实例
+// 比如说依赖的数据库连不上了, 可以先设置成 registry.HealthStatusNotServing, 恢复之后再改回来
+func (s *Server) SetServingStatus(ctx context.Context, service string, status registry.HealthStatus) error {
+	s.health.SetServingStatus(service, toServingStatus(status))
+	if service != "" && service != s.name {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.si == nil || s.si.Health == status {
+		return nil
+	}
+	si := *s.si
+	si.Health = status
+	// 同一个地址重复注册就是更新
+	if err := s.registry.Register(ctx, si); err != nil {
+		return err
+	}
+	s.si = &si
+	return nil
+}
+
+func toServingStatus(status registry.HealthStatus) healthpb.HealthCheckResponse_ServingStatus {
+	switch status {
+	case registry.HealthStatusServing:
+		return healthpb.HealthCheckResponse_SERVING
+	case registry.HealthStatusNotServing:
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	default:
+		return healthpb.HealthCheckResponse_UNKNOWN
+	}
+}
+
+// Run 启动服务, 并且在收到退出信号的时候优雅退出
+// 没有指定信号的话, 默认监听 SIGINT 和 SIGTERM
+func (s *Server) Run(addr string, signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	defer signal.Stop(ch)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start(addr)
+	}()
+	select {
+	case err := <-errCh:
+		// 启动失败了, 或者别人调用了 Close
+		_ = s.Close()
+		return err
+	case <-ch:
+		if err := s.Close(); err != nil {
+			return err
+		}
+		return <-errCh
+	}
+}
+
+// Close 按照顺序优雅退出:
+// 0. 健康检查全部变成 NOT_SERVING, 开启了健康检查的客户端马上就不会再选中它
+// 1. 从注册中心注销, 客户端不会再发新的请求过来
+// 2. 等待 drainDelay, 让客户端感知到实例下线
+// 3. GracefulStop, 等待已有的请求处理完, 超过 shutdownTimeout 就强制关闭
+// 4. 执行退出钩子
+// 5. 关闭注册中心
+// 多次调用 Close 只会执行一次
 func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.close()
+	})
+	return s.closeErr
+}
+
+func (s *Server) close() error {
+	var firstErr error
+	s.health.Shutdown()
+	// 正在注册的话会等注册完成
+	s.mutex.Lock()
+	s.closed = true
+	si := s.si
+	s.si = nil
+	s.mutex.Unlock()
+	if si != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.registryTimeout)
+		// 注销失败了也要继续关闭, 反正租约过期之后注册中心也会删掉
+		firstErr = s.registry.UnRegister(ctx, *si)
+		cancel()
+		if s.drainDelay > 0 {
+			time.Sleep(s.drainDelay)
+		}
+	}
+
+	// 会帮我们关闭listener
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	timer := time.NewTimer(s.shutdownTimeout)
+	select {
+	case <-done:
+		timer.Stop()
+	case <-timer.C:
+		// 还有请求没处理完, 不等了
+		s.Stop()
+		<-done
+	}
+
+	if err := s.runHooks(s.stopHooks); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
 	if s.registry != nil {
-		err := s.registry.Close()
-		if err != nil {
+		if err := s.registry.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Server) runHooks(hooks []Hook) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
 			return err
 		}
 	}
-	// 会帮我们关闭listener
-	s.GracefulStop()
 	return nil
 }
 
@@ -76,3 +263,71 @@ func ServerWithRegistry(r registry.Registry) ServerOption {
 		s.registry = r
 	}
 }
+
+// ServerWithTimeout 设置注册到注册中心的超时时间
+func ServerWithTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.registryTimeout = timeout
+	}
+}
+
+func ServerWithWeight(weight int) ServerOption {
+	return func(s *Server) {
+		s.weight = weight
+	}
+}
+
+// ServerWithGroup 设置分组, 比如说 canary
+func ServerWithGroup(group string) ServerOption {
+	return func(s *Server) {
+		s.group = group
+	}
+}
+
+func ServerWithVersion(version string) ServerOption {
+	return func(s *Server) {
+		s.version = version
+	}
+}
+
+func ServerWithTags(tags map[string]string) ServerOption {
+	return func(s *Server) {
+		s.tags = tags
+	}
+}
+
+// ServerWithDrainDelay 设置注销之后等待客户端感知的时间, 默认一秒
+func ServerWithDrainDelay(delay time.Duration) ServerOption {
+	return func(s *Server) {
+		s.drainDelay = delay
+	}
+}
+
+// ServerWithShutdownTimeout 设置等待已有请求处理完的最长时间, 默认十秒
+// 启动钩子和退出钩子也使用这个超时时间
+func ServerWithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+// ServerWithStartHooks 启动钩子在监听端口之后, 注册到注册中心之前执行
+func ServerWithStartHooks(hooks ...Hook) ServerOption {
+	return func(s *Server) {
+		s.startHooks = append(s.startHooks, hooks...)
+	}
+}
+
+// ServerWithStopHooks 退出钩子在服务停止之后, 关闭注册中心之前执行
+func ServerWithStopHooks(hooks ...Hook) ServerOption {
+	return func(s *Server) {
+		s.stopHooks = append(s.stopHooks, hooks...)
+	}
+}
+
+// ServerWithGRPCOptions 创建 grpc.Server 使用的选项, 比如说 grpc.ChainUnaryInterceptor
+func ServerWithGRPCOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(s *Server) {
+		s.grpcOptions = append(s.grpcOptions, opts...)
+	}
+}
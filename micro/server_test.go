@@ -0,0 +1,285 @@
+package micro
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/example/proto/gen"
+	"github.com/startdusk/go-libs/micro/registry"
+	"github.com/startdusk/go-libs/micro/registry/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestServer_Close(t *testing.T) {
+	cases := []struct {
+		name            string
+		sleep           time.Duration
+		shutdownTimeout time.Duration
+		wantErr         bool
+		wantSteps       []string
+	}{
+		{
+			// 正在处理的请求能正常处理完
+			name:            "graceful",
+			sleep:           300 * time.Millisecond,
+			shutdownTimeout: 3 * time.Second,
+			wantSteps:       []string{"unregister", "handled", "stop hook", "close registry"},
+		},
+		{
+			// 超过了 shutdownTimeout 强制关闭, 请求会失败
+			name:            "force stop",
+			sleep:           3 * time.Second,
+			shutdownTimeout: 300 * time.Millisecond,
+			wantErr:         true,
+			wantSteps:       []string{"unregister", "stop hook", "close registry"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := &recorder{}
+			r := &recordRegistry{Registry: memory.NewRegistry(), rec: rec}
+			server := NewServer("user-service",
+				ServerWithRegistry(r),
+				ServerWithDrainDelay(100*time.Millisecond),
+				ServerWithShutdownTimeout(c.shutdownTimeout),
+				ServerWithStopHooks(func(ctx context.Context) error {
+					rec.add("stop hook")
+					return nil
+				}))
+			us := &slowUserService{sleep: c.sleep, rec: rec, started: make(chan struct{})}
+			gen.RegisterUserServiceServer(server, us)
+			go func() {
+				_ = server.Start("127.0.0.1:0")
+			}()
+			addr := waitRegistered(t, r, "user-service")
+
+			conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer func() {
+				_ = conn.Close()
+			}()
+			var callErr error
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, callErr = gen.NewUserServiceClient(conn).GetById(context.Background(), &gen.GetByIdReq{Id: 12})
+			}()
+			<-us.started
+
+			require.NoError(t, server.Close())
+			wg.Wait()
+			assert.Equal(t, c.wantErr, callErr != nil)
+			assert.Equal(t, c.wantSteps, rec.get())
+
+			// 再次 Close 不会重复执行
+			require.NoError(t, server.Close())
+			assert.Equal(t, c.wantSteps, rec.get())
+		})
+	}
+}
+
+// TestServer_CloseWhileRegistering 注册过程中调用 Close, 注册成功的实例也要注销
+func TestServer_CloseWhileRegistering(t *testing.T) {
+	rec := &recorder{}
+	r := &blockingRegistry{
+		recordRegistry: recordRegistry{Registry: memory.NewRegistry(), rec: rec},
+		registering:    make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	server := NewServer("user-service", ServerWithRegistry(r), ServerWithDrainDelay(0))
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- server.Start("127.0.0.1:0")
+	}()
+	<-r.registering
+
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- server.Close()
+	}()
+	// 让 Close 先去抢锁
+	time.Sleep(100 * time.Millisecond)
+	close(r.release)
+
+	require.NoError(t, <-closeErr)
+	assert.Equal(t, []string{"unregister", "close registry"}, rec.get())
+	// Serve 和 GracefulStop 谁先谁后都有可能, 只要 Start 能返回就行
+	<-startErr
+
+	// Close 之后不能再注册
+	assert.Equal(t, grpc.ErrServerStopped, server.Start("127.0.0.1:0"))
+	assert.Equal(t, []string{"unregister", "close registry"}, rec.get())
+}
+
+func TestServer_StartHooks(t *testing.T) {
+	r := memory.NewRegistry()
+	hookErr := errors.New("hook error")
+	server := NewServer("user-service",
+		ServerWithRegistry(r),
+		ServerWithStartHooks(func(ctx context.Context) error {
+			return hookErr
+		}))
+	err := server.Start("127.0.0.1:0")
+	assert.Equal(t, hookErr, err)
+	// 启动钩子失败了就不会注册
+	instances, err := r.ListServices(context.Background(), "user-service")
+	require.NoError(t, err)
+	assert.Len(t, instances, 0)
+}
+
+func TestServer_Health(t *testing.T) {
+	r := memory.NewRegistry()
+	server := NewServer("user-service", ServerWithRegistry(r), ServerWithDrainDelay(0))
+	go func() {
+		_ = server.Start("127.0.0.1:0")
+	}()
+	addr := waitRegistered(t, r, "user-service")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	hc := healthpb.NewHealthClient(conn)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := hc.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	instanceHealth := func() registry.HealthStatus {
+		instances, err := r.ListServices(ctx, "user-service")
+		require.NoError(t, err)
+		require.Len(t, instances, 1)
+		return instances[0].Health
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("user-service"))
+	assert.Equal(t, registry.HealthStatusServing, instanceHealth())
+
+	// 服务的状态会同步到注册中心
+	require.NoError(t, server.SetServingStatus(ctx, "user-service", registry.HealthStatusNotServing))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("user-service"))
+	assert.Equal(t, registry.HealthStatusNotServing, instanceHealth())
+	require.NoError(t, server.SetServingStatus(ctx, "user-service", registry.HealthStatusServing))
+	assert.Equal(t, registry.HealthStatusServing, instanceHealth())
+
+	// 别的服务只改健康检查的状态
+	require.NoError(t, server.SetServingStatus(ctx, "order-service", registry.HealthStatusNotServing))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("order-service"))
+	assert.Equal(t, registry.HealthStatusServing, instanceHealth())
+
+	require.NoError(t, server.Close())
+}
+
+func TestServer_Run(t *testing.T) {
+	r := memory.NewRegistry()
+	server := NewServer("user-service",
+		ServerWithRegistry(r),
+		ServerWithDrainDelay(0))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Run("127.0.0.1:0", os.Interrupt)
+	}()
+	waitRegistered(t, r, "user-service")
+
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, p.Signal(os.Interrupt))
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("收到信号之后没有退出")
+	}
+}
+
+func waitRegistered(t *testing.T, r registry.Registry, name string) string {
+	var addr string
+	require.Eventually(t, func() bool {
+		instances, _ := r.ListServices(context.Background(), name)
+		if len(instances) == 0 {
+			return false
+		}
+		addr = instances[0].Address
+		return true
+	}, 3*time.Second, 10*time.Millisecond)
+	return addr
+}
+
+type recorder struct {
+	mutex sync.Mutex
+	steps []string
+}
+
+func (r *recorder) add(step string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+func (r *recorder) get() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.steps...)
+}
+
+type recordRegistry struct {
+	registry.Registry
+	rec *recorder
+}
+
+func (r *recordRegistry) UnRegister(ctx context.Context, si registry.ServiceInstance) error {
+	r.rec.add("unregister")
+	return r.Registry.UnRegister(ctx, si)
+}
+
+func (r *recordRegistry) Close() error {
+	r.rec.add("close registry")
+	return r.Registry.Close()
+}
+
+// blockingRegistry 注册的时候卡住, 直到 release 被关闭
+type blockingRegistry struct {
+	recordRegistry
+	registering chan struct{}
+	release     chan struct{}
+	once        sync.Once
+}
+
+func (r *blockingRegistry) Register(ctx context.Context, si registry.ServiceInstance) error {
+	r.once.Do(func() {
+		close(r.registering)
+	})
+	<-r.release
+	return r.recordRegistry.Register(ctx, si)
+}
+
+type slowUserService struct {
+	gen.UnimplementedUserServiceServer
+	sleep   time.Duration
+	rec     *recorder
+	started chan struct{}
+}
+
+func (s *slowUserService) GetById(ctx context.Context, req *gen.GetByIdReq) (*gen.GetByIdResp, error) {
+	close(s.started)
+	select {
+	case <-time.After(s.sleep):
+		s.rec.add("handled")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &gen.GetByIdResp{User: &gen.User{Id: req.Id}}, nil
+}
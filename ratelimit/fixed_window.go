@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FixedWindow 固定窗口, 每个窗口最多放过去 limit 个请求
+// 实现最简单, 但是两个窗口交界的地方可能会放过去 2 * limit 个请求
+type FixedWindow struct {
+	window time.Duration
+	limit  int
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	windows *store[fixedWindow]
+}
+
+type fixedWindow struct {
+	start time.Time
+	cnt   int
+}
+
+func NewFixedWindow(window time.Duration, limit int) *FixedWindow {
+	return &FixedWindow{
+		window:  window,
+		limit:   limit,
+		now:     time.Now,
+		windows: newStore[fixedWindow](window),
+	}
+}
+
+func (f *FixedWindow) Allow(ctx context.Context, key string) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	now := f.now()
+	w, _ := f.windows.get(key, now)
+	if now.Sub(w.start) >= f.window {
+		w.start = now
+		w.cnt = 0
+	}
+	if w.cnt >= f.limit {
+		return false, nil
+	}
+	w.cnt++
+	return true, nil
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RPCKeyFunc 从 rpc 请求里面取出限流的 key
+type RPCKeyFunc func(ctx context.Context, req *message.Request) string
+
+// Interceptor rpc 服务端的限流, 被限流的请求返回 CodeResourceExhausted
+func Interceptor(l Limiter, keyFunc RPCKeyFunc) rpc.Interceptor {
+	return func(next rpc.Handler) rpc.Handler {
+		return func(ctx context.Context, req *message.Request) (*message.Response, error) {
+			key := keyFunc(ctx, req)
+			if key != "" {
+				ok, err := l.Allow(ctx, key)
+				if err == nil && !ok {
+					return nil, rpc.NewStatus(rpc.CodeResourceExhausted, ErrLimited.Error())
+				}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// RPCMethodKey 按照服务和方法限流
+func RPCMethodKey(ctx context.Context, req *message.Request) string {
+	return "method:" + req.ServiceName + "/" + req.MethodName
+}
+
+// RPCPeerKey 按照客户端 IP 限流, IP 是连接的对端地址
+func RPCPeerKey(ctx context.Context, req *message.Request) string {
+	p, ok := rpc.PeerFromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return "ip:" + hostOf(p.Addr)
+}
+
+// RPCMetaKey 按照请求的 Meta 限流, 没有这个 Meta 的不限流
+func RPCMetaKey(name string) RPCKeyFunc {
+	return func(ctx context.Context, req *message.Request) string {
+		val := req.Meta[name]
+		if val == "" {
+			return ""
+		}
+		return "meta:" + name + ":" + val
+	}
+}
+
+// GRPCKeyFunc 从 gRPC 请求里面取出限流的 key
+type GRPCKeyFunc func(ctx context.Context, info *grpc.UnaryServerInfo) string
+
+// UnaryServerInterceptor gRPC 服务端的限流, 被限流的请求返回 codes.ResourceExhausted
+func UnaryServerInterceptor(l Limiter, keyFunc GRPCKeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		key := keyFunc(ctx, info)
+		if key != "" {
+			ok, err := l.Allow(ctx, key)
+			if err == nil && !ok {
+				return nil, status.Error(codes.ResourceExhausted, ErrLimited.Error())
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCMethodKey 按照方法限流
+func GRPCMethodKey(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	return "method:" + info.FullMethod
+}
+
+// GRPCPeerKey 按照客户端 IP 限流
+func GRPCPeerKey(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return "ip:" + hostOf(p.Addr)
+}
+
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// GRPCMetadataKey 按照 metadata 限流, 没有这个 metadata 的不限流
+func GRPCMetadataKey(name string) GRPCKeyFunc {
+	return func(ctx context.Context, info *grpc.UnaryServerInfo) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+		vals := md.Get(name)
+		if len(vals) == 0 || vals[0] == "" {
+			return ""
+		}
+		return "metadata:" + name + ":" + vals[0]
+	}
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/micro/rpc"
+	"github.com/startdusk/go-libs/micro/rpc/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptor(t *testing.T) {
+	var keys []string
+	l := limiterFunc(func(ctx context.Context, key string) (bool, error) {
+		keys = append(keys, key)
+		return key != "meta:app:limited", nil
+	})
+	handler := Interceptor(l, RPCMetaKey("app"))(func(ctx context.Context, req *message.Request) (*message.Response, error) {
+		return &message.Response{}, nil
+	})
+
+	resp, err := handler(context.Background(), &message.Request{Meta: map[string]string{"app": "app1"}})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	_, err = handler(context.Background(), &message.Request{Meta: map[string]string{"app": "limited"}})
+	assert.Equal(t, rpc.NewStatus(rpc.CodeResourceExhausted, ErrLimited.Error()), err)
+
+	// 没有 Meta 的不限流
+	_, err = handler(context.Background(), &message.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"meta:app:app1", "meta:app:limited"}, keys)
+
+	assert.Equal(t, "method:user-service/GetByID",
+		RPCMethodKey(context.Background(), &message.Request{ServiceName: "user-service", MethodName: "GetByID"}))
+}
+
+func TestRPCPeerKey(t *testing.T) {
+	keys := make(chan string, 1)
+	l := limiterFunc(func(ctx context.Context, key string) (bool, error) {
+		keys <- key
+		return false, nil
+	})
+	server := rpc.NewServer(rpc.ServerWithInterceptors(Interceptor(l, RPCPeerKey)))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = lis.Close()
+	}()
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	client, err := rpc.NewClient(lis.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	req := &message.Request{ServiceName: "user-service", MethodName: "GetByID", Serializer: 1}
+	req.CalculateHeaderLength()
+	req.CalculateBodyLength()
+	resp, err := client.Invoke(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.CodeResourceExhausted, rpc.StatusFromResponse(resp).Code)
+	assert.Equal(t, "ip:127.0.0.1", <-keys)
+
+	// 没有连接信息的不限流
+	assert.Equal(t, "", RPCPeerKey(context.Background(), &message.Request{}))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l := NewFixedWindow(time.Hour, 1)
+	interceptor := UnaryServerInterceptor(l, GRPCPeerKey)
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 12345},
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.UserService/GetById"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	resp, err := interceptor(ctx, nil, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestGRPCKeyFunc(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.UserService/GetById"}
+	assert.Equal(t, "method:/test.UserService/GetById", GRPCMethodKey(context.Background(), info))
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 12345},
+	})
+	assert.Equal(t, "ip:10.0.0.1", GRPCPeerKey(ctx, info))
+	assert.Equal(t, "", GRPCPeerKey(context.Background(), info))
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("app", "app1"))
+	assert.Equal(t, "metadata:app:app1", GRPCMetadataKey("app")(ctx, info))
+	assert.Equal(t, "", GRPCMetadataKey("app")(context.Background(), info))
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LeakyBucket 漏桶, 请求按照固定的速率流出, 也就是每 1/rate 秒放过去一个
+// 来不及处理的请求在桶里排队(Allow 会阻塞), 排队的请求超过 capacity 个就直接拒绝
+// 和令牌桶不一样, 漏桶会把突发流量削平
+type LeakyBucket struct {
+	interval time.Duration
+	capacity int
+	now      func() time.Time
+
+	mutex sync.Mutex
+	// next key 下一个请求可以流出的时间
+	next *store[time.Time]
+}
+
+// NewLeakyBucket rate 必须大于 0, capacity 至少是 1
+func NewLeakyBucket(rate float64, capacity int) (*LeakyBucket, error) {
+	if !(rate > 0) {
+		return nil, errInvalidRate
+	}
+	if capacity < 1 {
+		return nil, errInvalidCapacity
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	return &LeakyBucket{
+		interval: interval,
+		capacity: capacity,
+		now:      time.Now,
+		next:     newStore[time.Time](interval * time.Duration(capacity+1)),
+	}, nil
+}
+
+func (l *LeakyBucket) Allow(ctx context.Context, key string) (bool, error) {
+	wait, ok := l.reserve(key)
+	if !ok {
+		return false, nil
+	}
+	ok, err := sleep(ctx, wait)
+	if err != nil {
+		// 放弃排队了, 占的位置要还回去, 不然后面的请求要白白多等一个间隔
+		l.unreserve(key)
+	}
+	return ok, err
+}
+
+// reserve 占一个位置, 返回需要排队的时间
+func (l *LeakyBucket) reserve(key string) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	next, _ := l.next.get(key, now)
+	if next.Before(now) {
+		*next = now
+	}
+	wait := next.Sub(now)
+	if wait > l.interval*time.Duration(l.capacity) {
+		return 0, false
+	}
+	*next = next.Add(l.interval)
+	return wait, true
+}
+
+// unreserve 还回 reserve 占的位置
+func (l *LeakyBucket) unreserve(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	next, _ := l.next.get(key, l.now())
+	*next = next.Add(-l.interval)
+}
+
+// sleep 排队, ctx 结束了就放弃
+func sleep(ctx context.Context, wait time.Duration) (bool, error) {
+	if wait <= 0 {
+		return true, nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,246 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNow 测试用的时钟, 只有调用 advance 时间才会往前走
+type fakeNow struct {
+	now time.Time
+}
+
+func newFakeNow() *fakeNow {
+	return &fakeNow{now: time.Unix(1700000000, 0)}
+}
+
+func (f *fakeNow) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeNow) advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+// step 在 after 时间之后对 key 发起一次请求, 期望结果是 want
+type step struct {
+	after time.Duration
+	key   string
+	want  bool
+}
+
+func runSteps(t *testing.T, l Limiter, clock *fakeNow, steps []step) {
+	for i, s := range steps {
+		clock.advance(s.after)
+		ok, err := l.Allow(context.Background(), s.key)
+		require.NoError(t, err)
+		assert.Equal(t, s.want, ok, "第 %d 个请求", i)
+	}
+}
+
+// mustNew 测试里面的参数都是合法的
+func mustNew[T any](l T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func TestNewLimiter_invalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		newFunc func() error
+		wantErr error
+	}{
+		{
+			name: "token bucket zero rate",
+			newFunc: func() error {
+				_, err := NewTokenBucket(0, 1)
+				return err
+			},
+			wantErr: errInvalidRate,
+		},
+		{
+			name: "token bucket zero burst",
+			newFunc: func() error {
+				_, err := NewTokenBucket(1, 0)
+				return err
+			},
+			wantErr: errInvalidBurst,
+		},
+		{
+			name: "leaky bucket negative rate",
+			newFunc: func() error {
+				_, err := NewLeakyBucket(-1, 1)
+				return err
+			},
+			wantErr: errInvalidRate,
+		},
+		{
+			name: "leaky bucket zero capacity",
+			newFunc: func() error {
+				_, err := NewLeakyBucket(1, 0)
+				return err
+			},
+			wantErr: errInvalidCapacity,
+		},
+		{
+			name: "redis token bucket zero rate",
+			newFunc: func() error {
+				_, err := NewRedisTokenBucket(nil, 0, 1)
+				return err
+			},
+			wantErr: errInvalidRate,
+		},
+		{
+			name: "redis leaky bucket zero capacity",
+			newFunc: func() error {
+				_, err := NewRedisLeakyBucket(nil, 1, 0)
+				return err
+			},
+			wantErr: errInvalidCapacity,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.wantErr, c.newFunc())
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeNow()
+	l := mustNew(NewTokenBucket(10, 2))
+	l.now = clock.Now
+	runSteps(t, l, clock, []step{
+		// 突发 2 个
+		{key: "a", want: true},
+		{key: "a", want: true},
+		{key: "a", want: false},
+		// 不同的 key 分开计数
+		{key: "b", want: true},
+		// 100ms 放一个令牌
+		{after: 100 * time.Millisecond, key: "a", want: true},
+		{key: "a", want: false},
+		// 最多攒 2 个
+		{after: time.Second, key: "a", want: true},
+		{key: "a", want: true},
+		{key: "a", want: false},
+	})
+}
+
+func TestLeakyBucket(t *testing.T) {
+	clock := newFakeNow()
+	l := mustNew(NewLeakyBucket(10, 2))
+	l.now = clock.Now
+	cases := []struct {
+		after    time.Duration
+		wantWait time.Duration
+		wantOK   bool
+	}{
+		{wantOK: true},
+		// 排队
+		{wantWait: 100 * time.Millisecond, wantOK: true},
+		{wantWait: 200 * time.Millisecond, wantOK: true},
+		// 排满了
+		{wantOK: false},
+		{after: 100 * time.Millisecond, wantWait: 200 * time.Millisecond, wantOK: true},
+		// 桶空了就不用排队
+		{after: time.Second, wantOK: true},
+	}
+	for i, c := range cases {
+		clock.advance(c.after)
+		wait, ok := l.reserve("a")
+		assert.Equal(t, c.wantOK, ok, "第 %d 个请求", i)
+		assert.Equal(t, c.wantWait, wait, "第 %d 个请求", i)
+	}
+}
+
+func TestLeakyBucket_unreserve(t *testing.T) {
+	clock := newFakeNow()
+	l := mustNew(NewLeakyBucket(10, 2))
+	l.now = clock.Now
+	_, ok := l.reserve("a")
+	require.True(t, ok)
+	wait, ok := l.reserve("a")
+	require.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+	// 第二个请求放弃排队了, 下一个请求接替它的位置
+	l.unreserve("a")
+	wait, ok = l.reserve("a")
+	require.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+}
+
+func TestLeakyBucket_Allow(t *testing.T) {
+	l := mustNew(NewLeakyBucket(100, 1))
+	start := time.Now()
+	ok, err := l.Allow(context.Background(), "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = l.Allow(context.Background(), "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	// 第二个请求排队了 10ms
+	assert.True(t, time.Since(start) >= 9*time.Millisecond)
+
+	// 排队的时候超时了
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	ok, err = l.Allow(ctx, "a")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, ok)
+}
+
+func TestFixedWindow(t *testing.T) {
+	clock := newFakeNow()
+	l := NewFixedWindow(time.Second, 2)
+	l.now = clock.Now
+	runSteps(t, l, clock, []step{
+		{key: "a", want: true},
+		{after: 500 * time.Millisecond, key: "a", want: true},
+		{key: "a", want: false},
+		{key: "b", want: true},
+		// 新的窗口
+		{after: 500 * time.Millisecond, key: "a", want: true},
+		{key: "a", want: true},
+		{key: "a", want: false},
+	})
+}
+
+func TestSlidingLog(t *testing.T) {
+	clock := newFakeNow()
+	l := NewSlidingLog(time.Second, 2)
+	l.now = clock.Now
+	runSteps(t, l, clock, []step{
+		{key: "a", want: true},
+		{after: 500 * time.Millisecond, key: "a", want: true},
+		{key: "a", want: false},
+		{key: "b", want: true},
+		// 第一个请求滑出去了, 第二个还在
+		{after: 500 * time.Millisecond, key: "a", want: true},
+		{key: "a", want: false},
+		{after: 500 * time.Millisecond, key: "a", want: true},
+	})
+}
+
+func TestStore_sweep(t *testing.T) {
+	clock := newFakeNow()
+	s := newStore[int](time.Second)
+	val, ok := s.get("a", clock.Now())
+	assert.False(t, ok)
+	*val = 1
+	clock.advance(500 * time.Millisecond)
+	_, ok = s.get("b", clock.Now())
+	assert.False(t, ok)
+	// a 闲置超过了 1s 被清理掉了, b 还在
+	clock.advance(700 * time.Millisecond)
+	val, ok = s.get("b", clock.Now())
+	assert.True(t, ok)
+	assert.Equal(t, 0, *val)
+	assert.Len(t, s.entries, 1)
+}
@@ -0,0 +1,15 @@
+-- 固定窗口
+-- KEYS[1] 限流的 key
+-- ARGV[1] 窗口大小, 单位毫秒
+-- ARGV[2] 一个窗口最多放过去多少个请求
+-- 返回 1 代表通过, 0 代表被限流
+
+local cnt = redis.call('INCR', KEYS[1])
+if cnt == 1 then
+    -- 第一个请求开启一个新的窗口
+    redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if cnt > tonumber(ARGV[2]) then
+    return 0
+end
+return 1
@@ -0,0 +1,23 @@
+-- 漏桶
+-- KEYS[1] 限流的 key
+-- ARGV[1] 每个请求流出的间隔, 单位毫秒
+-- ARGV[2] 最多排队的请求数量
+-- 返回需要排队的毫秒数, -1 代表被限流
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local interval = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+
+-- 下一个请求可以流出的时间
+local ready = tonumber(redis.call('GET', KEYS[1]))
+if ready == nil or ready < now then
+    ready = now
+end
+local wait = ready - now
+if wait > interval * capacity then
+    return -1
+end
+ready = ready + interval
+redis.call('SET', KEYS[1], ready, 'PX', math.ceil(ready - now))
+return math.ceil(wait)
@@ -0,0 +1,20 @@
+-- 滑动窗口日志, 使用 ZSET 记录每个请求的时间
+-- KEYS[1] 限流的 key
+-- ARGV[1] 窗口大小, 单位毫秒
+-- ARGV[2] 窗口内最多放过去多少个请求
+-- ARGV[3] 这个请求的唯一标识, 同一毫秒可能有多个请求
+-- 返回 1 代表通过, 0 代表被限流
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+-- 去掉滑出窗口的请求
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) >= limit then
+    return 0
+end
+redis.call('ZADD', KEYS[1], now, ARGV[3])
+redis.call('PEXPIRE', KEYS[1], window)
+return 1
@@ -0,0 +1,31 @@
+-- 令牌桶
+-- KEYS[1] 限流的 key
+-- ARGV[1] 每秒放多少个令牌
+-- ARGV[2] 桶的容量
+-- 返回 1 代表通过, 0 代表被限流
+
+-- 使用 Redis 的时间, 避免多个实例之间时钟不一致
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil then
+    -- 新的桶是满的
+    tokens = burst
+else
+    tokens = math.min(burst, tokens + (now - last) * rate / 1000)
+end
+
+local allowed = 0
+if tokens >= 1 then
+    tokens = tokens - 1
+    allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'last', now)
+-- 桶满了之后和新建的一样, 没必要再保存
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return allowed
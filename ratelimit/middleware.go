@@ -0,0 +1,124 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/startdusk/go-libs/web"
+)
+
+// WebKeyFunc 从 HTTP 请求里面取出限流的 key
+type WebKeyFunc func(ctx *web.Context) string
+
+// Middleware web 的限流, 被限流的请求返回 429
+func Middleware(l Limiter, keyFunc WebKeyFunc) web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			key := keyFunc(ctx)
+			if key != "" {
+				ok, err := l.Allow(ctx.Req.Context(), key)
+				if err == nil && !ok {
+					ctx.RespStatusCode = http.StatusTooManyRequests
+					ctx.RespData = []byte(http.StatusText(http.StatusTooManyRequests))
+					return
+				}
+			}
+			next(ctx)
+		}
+	}
+}
+
+// RouteKey 按照路由限流
+// 通过 HTTPServer.Use 注册的时候使用命中的路由, 比如说 GET /user/:id,
+// 全局注册的 Middleware 在路由匹配之前执行, 只能使用请求的路径
+func RouteKey(ctx *web.Context) string {
+	route := ctx.MatchedRoute
+	if route == "" {
+		route = ctx.Req.URL.Path
+	}
+	return "route:" + ctx.Req.Method + " " + route
+}
+
+// IPKey 按照连接的对端 IP 限流, 不看 X-Forwarded-For 和 X-Real-IP
+// 这两个请求头谁都可以随便填, 相信它们的话, 每次换一个值就能绕过限流
+// 服务部署在反向代理后面的时候用 ProxyIPKey
+func IPKey(ctx *web.Context) string {
+	return "ip:" + remoteIP(ctx.Req)
+}
+
+// ProxyIPKey 服务部署在反向代理后面的时候按照客户端 IP 限流, trusted 是代理的 IP 或者 CIDR, 比如说 10.0.0.0/8
+// 只有连接的对端是可信的代理的时候才会看 X-Forwarded-For 和 X-Real-IP,
+// X-Forwarded-For 从右往左找, 第一个不是可信代理的就是客户端, 再往左的部分是客户端自己填的, 不能相信
+func ProxyIPKey(trusted ...string) (WebKeyFunc, error) {
+	prefixes := make([]netip.Prefix, 0, len(trusted))
+	for _, t := range trusted {
+		if strings.IndexByte(t, '/') < 0 {
+			addr, err := netip.ParseAddr(t)
+			if err != nil {
+				return nil, fmt.Errorf("ratelimit: 可信代理的地址不对 %s: %w", t, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(t)
+		if err != nil {
+			return nil, fmt.Errorf("ratelimit: 可信代理的地址不对 %s: %w", t, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	isTrusted := func(ip string) bool {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return false
+		}
+		addr = addr.Unmap()
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(ctx *web.Context) string {
+		ip := remoteIP(ctx.Req)
+		if !isTrusted(ip) {
+			return "ip:" + ip
+		}
+		if forwarded := ctx.Req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				ip = strings.TrimSpace(hops[i])
+				if !isTrusted(ip) {
+					break
+				}
+			}
+			return "ip:" + ip
+		}
+		if realIP := ctx.Req.Header.Get("X-Real-IP"); realIP != "" {
+			return "ip:" + realIP
+		}
+		return "ip:" + ip
+	}, nil
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// HeaderKey 按照请求头限流, 比如说 HeaderKey("X-App-Id"), 没有这个请求头的不限流
+func HeaderKey(name string) WebKeyFunc {
+	return func(ctx *web.Context) string {
+		val := ctx.Req.Header.Get(name)
+		if val == "" {
+			return ""
+		}
+		return "header:" + name + ":" + val
+	}
+}
@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/startdusk/go-libs/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limiterFunc 测试用的限流器
+type limiterFunc func(ctx context.Context, key string) (bool, error)
+
+func (f limiterFunc) Allow(ctx context.Context, key string) (bool, error) {
+	return f(ctx, key)
+}
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		name    string
+		limiter Limiter
+		keyFunc WebKeyFunc
+
+		wantCode int
+	}{
+		{
+			name:     "allowed",
+			limiter:  NewFixedWindow(time.Minute, 1),
+			keyFunc:  RouteKey,
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "limited",
+			limiter: limiterFunc(func(ctx context.Context, key string) (bool, error) {
+				return false, nil
+			}),
+			keyFunc:  RouteKey,
+			wantCode: http.StatusTooManyRequests,
+		},
+		{
+			name: "limiter error",
+			limiter: limiterFunc(func(ctx context.Context, key string) (bool, error) {
+				return false, errors.New("redis down")
+			}),
+			keyFunc:  RouteKey,
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "empty key",
+			limiter: limiterFunc(func(ctx context.Context, key string) (bool, error) {
+				return false, nil
+			}),
+			keyFunc:  HeaderKey("X-App-Id"),
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hs := web.NewHTTPServer(web.ServerWithMiddleware(Middleware(c.limiter, c.keyFunc)))
+			hs.Get("/user", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			})
+			req, err := http.NewRequest(http.MethodGet, "/user", nil)
+			require.NoError(t, err)
+			resp := httptest.NewRecorder()
+			hs.ServeHTTP(resp, req)
+			assert.Equal(t, c.wantCode, resp.Code)
+		})
+	}
+}
+
+func TestWebKeyFunc(t *testing.T) {
+	proxyKey, err := ProxyIPKey("10.0.0.0/8", "192.168.1.1")
+	require.NoError(t, err)
+	cases := []struct {
+		name    string
+		keyFunc WebKeyFunc
+		req     func() *http.Request
+		route   string
+
+		wantKey string
+	}{
+		{
+			name:    "route",
+			keyFunc: RouteKey,
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/123", nil)
+			},
+			route:   "/user/:id",
+			wantKey: "route:GET /user/:id",
+		},
+		{
+			name:    "route not matched",
+			keyFunc: RouteKey,
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/123", nil)
+			},
+			wantKey: "route:GET /user/123",
+		},
+		{
+			name:    "remote addr",
+			keyFunc: IPKey,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.RemoteAddr = "10.0.0.1:12345"
+				return req
+			},
+			wantKey: "ip:10.0.0.1",
+		},
+		{
+			// 请求头谁都可以填, 默认不相信
+			name:    "forwarded for ignored",
+			keyFunc: IPKey,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.RemoteAddr = "10.0.0.1:12345"
+				req.Header.Set("X-Forwarded-For", "1.1.1.1")
+				req.Header.Set("X-Real-IP", "2.2.2.2")
+				return req
+			},
+			wantKey: "ip:10.0.0.1",
+		},
+		{
+			name:    "trusted proxy",
+			keyFunc: proxyKey,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.RemoteAddr = "10.0.0.1:12345"
+				// 3.3.3.3 是客户端自己填的, 10.0.0.2 是内部的代理
+				req.Header.Set("X-Forwarded-For", "3.3.3.3, 1.1.1.1, 10.0.0.2")
+				req.Header.Set("X-Real-IP", "2.2.2.2")
+				return req
+			},
+			wantKey: "ip:1.1.1.1",
+		},
+		{
+			name:    "trusted proxy real ip",
+			keyFunc: proxyKey,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.RemoteAddr = "192.168.1.1:12345"
+				req.Header.Set("X-Real-IP", "2.2.2.2")
+				return req
+			},
+			wantKey: "ip:2.2.2.2",
+		},
+		{
+			// 不是从代理过来的请求, 请求头是客户端伪造的
+			name:    "untrusted peer",
+			keyFunc: proxyKey,
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.RemoteAddr = "5.5.5.5:12345"
+				req.Header.Set("X-Forwarded-For", "1.1.1.1")
+				req.Header.Set("X-Real-IP", "2.2.2.2")
+				return req
+			},
+			wantKey: "ip:5.5.5.5",
+		},
+		{
+			name:    "header",
+			keyFunc: HeaderKey("X-App-Id"),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.Header.Set("X-App-Id", "app1")
+				return req
+			},
+			wantKey: "header:X-App-Id:app1",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := &web.Context{Req: c.req(), MatchedRoute: c.route}
+			assert.Equal(t, c.wantKey, c.keyFunc(ctx))
+		})
+	}
+}
+
+func TestProxyIPKey_invalid(t *testing.T) {
+	_, err := ProxyIPKey("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = ProxyIPKey("localhost")
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"context"
+	_ "embed"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+)
+
+// Redis 的实现都是用 lua 脚本保证读取和更新状态是原子的, 多个实例共享同一个限流的额度
+
+//go:embed lua/token_bucket.lua
+var luaTokenBucket string
+
+//go:embed lua/leaky_bucket.lua
+var luaLeakyBucket string
+
+//go:embed lua/fixed_window.lua
+var luaFixedWindow string
+
+//go:embed lua/sliding_log.lua
+var luaSlidingLog string
+
+// RedisTokenBucket 基于 Redis 的令牌桶, 参数的含义和 TokenBucket 一样
+type RedisTokenBucket struct {
+	client redis.Cmdable
+	rate   float64
+	burst  int
+}
+
+func NewRedisTokenBucket(client redis.Cmdable, rate float64, burst int) (*RedisTokenBucket, error) {
+	if !(rate > 0) {
+		return nil, errInvalidRate
+	}
+	if burst < 1 {
+		return nil, errInvalidBurst
+	}
+	return &RedisTokenBucket{
+		client: client,
+		rate:   rate,
+		burst:  burst,
+	}, nil
+}
+
+func (r *RedisTokenBucket) Allow(ctx context.Context, key string) (bool, error) {
+	res, err := r.client.Eval(ctx, luaTokenBucket, []string{key}, r.rate, r.burst).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// RedisLeakyBucket 基于 Redis 的漏桶, 参数的含义和 LeakyBucket 一样
+// 排队是在本地 sleep, Redis 里面只记录下一个请求可以流出的时间
+type RedisLeakyBucket struct {
+	client   redis.Cmdable
+	interval time.Duration
+	capacity int
+}
+
+func NewRedisLeakyBucket(client redis.Cmdable, rate float64, capacity int) (*RedisLeakyBucket, error) {
+	if !(rate > 0) {
+		return nil, errInvalidRate
+	}
+	if capacity < 1 {
+		return nil, errInvalidCapacity
+	}
+	return &RedisLeakyBucket{
+		client:   client,
+		interval: time.Duration(float64(time.Second) / rate),
+		capacity: capacity,
+	}, nil
+}
+
+func (r *RedisLeakyBucket) Allow(ctx context.Context, key string) (bool, error) {
+	interval := float64(r.interval) / float64(time.Millisecond)
+	wait, err := r.client.Eval(ctx, luaLeakyBucket, []string{key}, interval, r.capacity).Int64()
+	if err != nil {
+		return false, err
+	}
+	if wait < 0 {
+		return false, nil
+	}
+	return sleep(ctx, time.Duration(wait)*time.Millisecond)
+}
+
+// RedisFixedWindow 基于 Redis 的固定窗口, 参数的含义和 FixedWindow 一样
+type RedisFixedWindow struct {
+	client redis.Cmdable
+	window time.Duration
+	limit  int
+}
+
+func NewRedisFixedWindow(client redis.Cmdable, window time.Duration, limit int) *RedisFixedWindow {
+	return &RedisFixedWindow{
+		client: client,
+		window: window,
+		limit:  limit,
+	}
+}
+
+func (r *RedisFixedWindow) Allow(ctx context.Context, key string) (bool, error) {
+	res, err := r.client.Eval(ctx, luaFixedWindow, []string{key}, r.window.Milliseconds(), r.limit).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// RedisSlidingLog 基于 Redis 的滑动窗口日志, 参数的含义和 SlidingLog 一样
+type RedisSlidingLog struct {
+	client redis.Cmdable
+	window time.Duration
+	limit  int
+}
+
+func NewRedisSlidingLog(client redis.Cmdable, window time.Duration, limit int) *RedisSlidingLog {
+	return &RedisSlidingLog{
+		client: client,
+		window: window,
+		limit:  limit,
+	}
+}
+
+func (r *RedisSlidingLog) Allow(ctx context.Context, key string) (bool, error) {
+	res, err := r.client.Eval(ctx, luaSlidingLog, []string{key},
+		r.window.Milliseconds(), r.limit, uuid.New().String()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
@@ -0,0 +1,63 @@
+//go:build integration
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisLimiter_e2e(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	cases := []struct {
+		name    string
+		limiter Limiter
+		// wantOK 连续发起请求的结果
+		wantOK []bool
+	}{
+		{
+			name:    "token bucket",
+			limiter: mustNew(NewRedisTokenBucket(rdb, 1, 2)),
+			wantOK:  []bool{true, true, false},
+		},
+		{
+			name: "leaky bucket",
+			// 第二个请求排队 1 秒, 第三个排不上
+			limiter: mustNew(NewRedisLeakyBucket(rdb, 1, 1)),
+			wantOK:  []bool{true, true, false},
+		},
+		{
+			name:    "fixed window",
+			limiter: NewRedisFixedWindow(rdb, time.Minute, 2),
+			wantOK:  []bool{true, true, false},
+		},
+		{
+			name:    "sliding log",
+			limiter: NewRedisSlidingLog(rdb, time.Minute, 2),
+			wantOK:  []bool{true, true, false},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			key := "ratelimit:" + c.name
+			require.NoError(t, rdb.Del(ctx, key).Err())
+			defer func() {
+				_ = rdb.Del(ctx, key).Err()
+			}()
+			for i, want := range c.wantOK {
+				ok, err := c.limiter.Allow(ctx, key)
+				require.NoError(t, err)
+				assert.Equal(t, want, ok, "第 %d 个请求", i)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/startdusk/go-libs/cache/mocks"
+	"github.com/stretchr/testify/assert"
+	gomock "go.uber.org/mock/gomock"
+)
+
+func TestRedisLimiter_Allow(t *testing.T) {
+	cases := []struct {
+		name    string
+		limiter func(client redis.Cmdable) Limiter
+		mock    func(cmd *mocks.MockCmdable)
+
+		wantOK  bool
+		wantErr error
+	}{
+		{
+			name: "token bucket allowed",
+			limiter: func(client redis.Cmdable) Limiter {
+				return mustNew(NewRedisTokenBucket(client, 10, 2))
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaTokenBucket, []string{"key1"}, []any{float64(10), 2}).
+					Return(redis.NewCmdResult(int64(1), nil))
+			},
+			wantOK: true,
+		},
+		{
+			name: "token bucket limited",
+			limiter: func(client redis.Cmdable) Limiter {
+				return mustNew(NewRedisTokenBucket(client, 10, 2))
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaTokenBucket, []string{"key1"}, []any{float64(10), 2}).
+					Return(redis.NewCmdResult(int64(0), nil))
+			},
+			wantOK: false,
+		},
+		{
+			name: "eval error",
+			limiter: func(client redis.Cmdable) Limiter {
+				return mustNew(NewRedisTokenBucket(client, 10, 2))
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaTokenBucket, []string{"key1"}, []any{float64(10), 2}).
+					Return(redis.NewCmdResult(nil, errors.New("redis down")))
+			},
+			wantErr: errors.New("redis down"),
+		},
+		{
+			name: "leaky bucket",
+			limiter: func(client redis.Cmdable) Limiter {
+				return mustNew(NewRedisLeakyBucket(client, 100, 2))
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaLeakyBucket, []string{"key1"}, []any{float64(10), 2}).
+					Return(redis.NewCmdResult(int64(5), nil))
+			},
+			wantOK: true,
+		},
+		{
+			name: "leaky bucket full",
+			limiter: func(client redis.Cmdable) Limiter {
+				return mustNew(NewRedisLeakyBucket(client, 100, 2))
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaLeakyBucket, []string{"key1"}, []any{float64(10), 2}).
+					Return(redis.NewCmdResult(int64(-1), nil))
+			},
+			wantOK: false,
+		},
+		{
+			name: "fixed window",
+			limiter: func(client redis.Cmdable) Limiter {
+				return NewRedisFixedWindow(client, time.Second, 2)
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaFixedWindow, []string{"key1"}, []any{int64(1000), 2}).
+					Return(redis.NewCmdResult(int64(1), nil))
+			},
+			wantOK: true,
+		},
+		{
+			name: "sliding log",
+			limiter: func(client redis.Cmdable) Limiter {
+				return NewRedisSlidingLog(client, time.Second, 2)
+			},
+			mock: func(cmd *mocks.MockCmdable) {
+				cmd.EXPECT().Eval(context.Background(), luaSlidingLog, []string{"key1"}, gomock.Any()).
+					DoAndReturn(func(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+						assert.Equal(t, int64(1000), args[0])
+						assert.Equal(t, 2, args[1])
+						// 每个请求都有唯一的标识
+						assert.NotEmpty(t, args[2])
+						return redis.NewCmdResult(int64(0), nil)
+					})
+			},
+			wantOK: false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			cmd := mocks.NewMockCmdable(ctrl)
+			c.mock(cmd)
+			ok, err := c.limiter(cmd).Allow(context.Background(), "key1")
+			assert.Equal(t, c.wantErr, err)
+			assert.Equal(t, c.wantOK, ok)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SlidingLog 滑动窗口日志, 记录每个请求的时间, 任意 window 长度的时间段内最多放过去 limit 个请求
+// 最精确, 代价是每个 key 要保存 limit 个时间戳
+type SlidingLog struct {
+	window time.Duration
+	limit  int
+	now    func() time.Time
+
+	mutex sync.Mutex
+	logs  *store[[]time.Time]
+}
+
+func NewSlidingLog(window time.Duration, limit int) *SlidingLog {
+	return &SlidingLog{
+		window: window,
+		limit:  limit,
+		now:    time.Now,
+		logs:   newStore[[]time.Time](window),
+	}
+}
+
+func (s *SlidingLog) Allow(ctx context.Context, key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	log, _ := s.logs.get(key, now)
+	// 时间戳是有序的, 去掉已经滑出窗口的
+	boundary := now.Add(-s.window)
+	i := 0
+	for i < len(*log) && !(*log)[i].After(boundary) {
+		i++
+	}
+	*log = (*log)[i:]
+	if len(*log) >= s.limit {
+		return false, nil
+	}
+	*log = append(*log, now)
+	return true, nil
+}
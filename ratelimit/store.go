@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// store 单机限流器保存每个 key 的状态, 很久没有访问过的 key 会被清理掉, 不然按照 IP 限流的时候内存会一直涨
+// store 不是并发安全的, 由使用者加锁
+type store[T any] struct {
+	entries map[string]*storeEntry[T]
+	// idle 超过这个时间没有访问的 key 会被清理掉, 应该大于状态恢复到初始值需要的时间
+	idle      time.Duration
+	lastSweep time.Time
+}
+
+type storeEntry[T any] struct {
+	val     T
+	touched time.Time
+}
+
+func newStore[T any](idle time.Duration) *store[T] {
+	return &store[T]{
+		entries: make(map[string]*storeEntry[T], 64),
+		idle:    idle,
+	}
+}
+
+// get 返回 key 对应的状态, 第二个返回值为 false 代表是新创建的
+func (s *store[T]) get(key string, now time.Time) (*T, bool) {
+	s.sweep(now)
+	e, ok := s.entries[key]
+	if !ok {
+		e = &storeEntry[T]{}
+		s.entries[key] = e
+	}
+	e.touched = now
+	return &e.val, ok
+}
+
+// sweep 每隔 idle 清理一次, 不需要后台 goroutine
+func (s *store[T]) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.idle {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if now.Sub(e.touched) >= s.idle {
+			delete(s.entries, key)
+		}
+	}
+}
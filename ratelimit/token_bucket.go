@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶, 每秒往桶里放 rate 个令牌, 桶里最多有 burst 个令牌, 拿到令牌的请求才能通过
+// 允许一定程度的突发流量
+type TokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mutex   sync.Mutex
+	buckets *store[tokenBucket]
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket rate 必须大于 0, burst 至少是 1, 不然一个请求都放不过去
+func NewTokenBucket(rate float64, burst int) (*TokenBucket, error) {
+	if !(rate > 0) {
+		return nil, errInvalidRate
+	}
+	if burst < 1 {
+		return nil, errInvalidBurst
+	}
+	return &TokenBucket{
+		rate:  rate,
+		burst: float64(burst),
+		now:   time.Now,
+		// 桶从空到满需要的时间, 过了这个时间状态和新建的一样
+		buckets: newStore[tokenBucket](time.Duration(float64(burst) / rate * float64(time.Second))),
+	}, nil
+}
+
+func (t *TokenBucket) Allow(ctx context.Context, key string) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	b, ok := t.buckets.get(key, now)
+	if !ok {
+		b.tokens = t.burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * t.rate
+		if b.tokens > t.burst {
+			b.tokens = t.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
@@ -0,0 +1,28 @@
+// Package ratelimit 限流, web, rpc 和 gRPC 共用
+// 算法有令牌桶, 漏桶, 固定窗口和滑动窗口日志, 每一种都有单机(内存)和基于 Redis 的分布式实现
+//
+// 三种框架的适配都遵循同样的规则:
+// key 为空字符串的请求不限流, 限流器出错(比如说 Redis 挂了)的时候放行, 不能因为限流把整个服务拖垮
+package ratelimit
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrLimited 请求被限流了
+var ErrLimited = errors.New("ratelimit: 请求太频繁")
+
+var (
+	// errInvalidRate rate 为 0 的时候间隔是无穷大, 转成 time.Duration 的结果是未定义的, 负数更没有意义
+	errInvalidRate     = errors.New("ratelimit: rate 必须大于 0")
+	errInvalidBurst    = errors.New("ratelimit: burst 至少是 1")
+	errInvalidCapacity = errors.New("ratelimit: capacity 至少是 1")
+)
+
+// Limiter 限流器, 不同的 key 分开计数, 比如说按照路由或者客户端 IP 限流
+type Limiter interface {
+	// Allow 返回 false 代表这个请求应该被拒绝
+	// error 代表限流器本身出了问题, 比如说连不上 Redis, 这时候要不要放行由调用方决定
+	Allow(ctx context.Context, key string) (bool, error)
+}